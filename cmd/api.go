@@ -27,6 +27,13 @@ func main() {
 		return
 	}
 
+	err = api.CreateDatabaseTables(database)
+
+	if err != nil {
+		api.Logger.Fatalf("Failed to create API database tables: %s", err)
+		return
+	}
+
 	server := api.Server{
 		Router:           mux.NewRouter(),
 		Database:         database,
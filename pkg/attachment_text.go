@@ -0,0 +1,396 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// AttachmentStoragePath defines the storage path of attachments, formatted with the project UUID and attachment UUID.
+var AttachmentStoragePath string
+
+func init() {
+	if !viper.IsSet("attachment_storage_path") {
+		Logger.Fatalf("unset attachment_storage_path configuration variable")
+	}
+
+	AttachmentStoragePath = viper.GetString("attachment_storage_path")
+}
+
+// Constants defining the text extraction status of an attachment.
+const (
+	AttachmentTextStatusExtracted   = "EXTRACTED"
+	AttachmentTextStatusEmpty       = "EMPTY"
+	AttachmentTextStatusUnsupported = "UNSUPPORTED"
+	AttachmentTextStatusEncrypted   = "ENCRYPTED"
+	AttachmentTextStatusTooLarge    = "TOO_LARGE"
+	AttachmentTextStatusFailed      = "FAILED"
+)
+
+// AttachmentText represents the text extracted from an attachment.
+type AttachmentText struct {
+	AttachmentUUID string `json:"attachmentUUID"`
+	MessageUUID    string `json:"messageUUID"`
+	EvidenceUUID   string `json:"evidenceUUID"`
+	ProjectUUID    string `json:"projectUUID"`
+	FileName       string `json:"fileName"`
+	Status         string `json:"status"`
+	// Error describes why no text was extracted.
+	Error          string `json:"error"`
+	Text           string `json:"text,omitempty"`
+	TextLength     int    `json:"textLength"`
+	ExtractionDate int    `json:"extractionDate"`
+}
+
+// AttachmentTextReport represents the text extraction results of the attachments of a project.
+type AttachmentTextReport struct {
+	StatusCounts map[string]int   `json:"statusCounts"`
+	Attachments  []AttachmentText `json:"attachments"`
+}
+
+// handleAttachmentTexts handles the attachment texts endpoint, reporting the text extraction of each attachment.
+// Accepts the "status" and "evidenceUUID" query parameters, the extracted text is not included.
+func (server *Server) handleAttachmentTexts() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			attachmentTexts, err := GetAttachmentTextsByProject(project.UUID, request.URL.Query().Get("evidenceUUID"), server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get attachment texts: %s", err)
+				http.Error(responseWriter, "Failed to get attachment texts.", http.StatusInternalServerError)
+				return
+			}
+
+			attachmentTextReport := AttachmentTextReport{
+				StatusCounts: make(map[string]int),
+				Attachments:  []AttachmentText{},
+			}
+
+			status := request.URL.Query().Get("status")
+
+			for _, attachmentText := range attachmentTexts {
+				attachmentTextReport.StatusCounts[attachmentText.Status]++
+
+				if status == "" || attachmentText.Status == status {
+					attachmentTextReport.Attachments = append(attachmentTextReport.Attachments, attachmentText)
+				}
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&attachmentTextReport); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleAttachmentText handles the attachment text endpoint.
+func (server *Server) handleAttachmentText() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			attachmentText, err := GetAttachmentText(mux.Vars(request)["attachmentUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get attachment text: %s", err)
+				http.Error(responseWriter, "Failed to get attachment text.", http.StatusNotFound)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&attachmentText); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// limitedBuffer is a buffer which fails when the limit is exceeded.
+type limitedBuffer struct {
+	bytes.Buffer
+	Limit int
+}
+
+// Write writes to the buffer, returns ErrTextExtractionTooLarge if the limit is exceeded.
+func (buffer *limitedBuffer) Write(data []byte) (int, error) {
+	if buffer.Len()+len(data) > buffer.Limit {
+		return 0, ErrTextExtractionTooLarge
+	}
+
+	return buffer.Buffer.Write(data)
+}
+
+// WriteAttachmentData writes the attachment from the storage to the writer.
+func WriteAttachmentData(attachmentUUID string, projectUUID string, writer io.Writer) error {
+	return core.WriteFileToWriter(fmt.Sprintf(AttachmentStoragePath, projectUUID, attachmentUUID), writer)
+}
+
+// ReadAttachmentData reads the attachment from the storage.
+func ReadAttachmentData(attachmentUUID string, projectUUID string) ([]byte, error) {
+	buffer := &limitedBuffer{Limit: MaxTextExtractionFileSize}
+
+	if err := WriteAttachmentData(attachmentUUID, projectUUID, buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// NewAttachmentText extracts the text of the attachment, the status reports why no text was extracted.
+func NewAttachmentText(attachment core.Attachment, message core.Message, projectUUID string) AttachmentText {
+	attachmentText := AttachmentText{
+		AttachmentUUID: attachment.UUID,
+		MessageUUID:    message.UUID,
+		EvidenceUUID:   message.EvidenceUUID,
+		ProjectUUID:    projectUUID,
+		FileName:       attachment.FileName,
+		ExtractionDate: int(time.Now().Unix()),
+	}
+
+	if attachment.Size > MaxTextExtractionFileSize {
+		attachmentText.Status = AttachmentTextStatusTooLarge
+		attachmentText.Error = ErrTextExtractionTooLarge.Error()
+		return attachmentText
+	}
+
+	data, err := ReadAttachmentData(attachment.UUID, projectUUID)
+
+	if err == nil {
+		attachmentText.Text, err = ExtractText(attachment.FileName, attachment.MimeType, data)
+	}
+
+	switch {
+	case errors.Is(err, ErrTextExtractionUnsupported):
+		attachmentText.Status = AttachmentTextStatusUnsupported
+	case errors.Is(err, ErrTextExtractionEncrypted):
+		attachmentText.Status = AttachmentTextStatusEncrypted
+		attachmentText.Error = err.Error()
+	case errors.Is(err, ErrTextExtractionTooLarge):
+		attachmentText.Status = AttachmentTextStatusTooLarge
+		attachmentText.Error = err.Error()
+	case err != nil:
+		attachmentText.Status = AttachmentTextStatusFailed
+		attachmentText.Error = err.Error()
+	case attachmentText.Text == "":
+		attachmentText.Status = AttachmentTextStatusEmpty
+	default:
+		attachmentText.Status = AttachmentTextStatusExtracted
+		attachmentText.TextLength = utf8.RuneCountInString(attachmentText.Text)
+	}
+
+	return attachmentText
+}
+
+// ExtractEvidenceAttachmentTexts extracts the text of the attachments in the evidence, stores it and indexes it.
+// Failures of single attachments are stored as their status and do not stop the extraction.
+func (server *Server) ExtractEvidenceAttachmentTexts(projectUUID string, evidenceUUID string) error {
+	elasticsearchQuery := NewProjectQuery(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{MessageFieldEvidenceUUID: evidenceUUID}},
+				map[string]interface{}{"exists": map[string]interface{}{"field": MessageFieldAttachmentFileName}},
+			},
+		},
+	}, projectUUID)
+
+	statusCounts := make(map[string]int)
+
+	var updates []MessageScriptUpdate
+
+	updateSize := 0
+
+	indexContents := func() error {
+		err := UpdateMessages(indexAttachmentContentsScript, updates)
+
+		updates = nil
+		updateSize = 0
+
+		return err
+	}
+
+	err := WalkHits(context.Background(), elasticsearchQuery, true, func(hit ElasticsearchHit) error {
+		var message core.Message
+
+		if err := json.Unmarshal(hit.Source, &message); err != nil {
+			return err
+		}
+
+		contents := make(map[string]string)
+
+		for _, attachment := range message.Attachments {
+			attachmentText := NewAttachmentText(attachment, message, projectUUID)
+
+			if attachmentText.EvidenceUUID == "" {
+				attachmentText.EvidenceUUID = evidenceUUID
+			}
+
+			if err := attachmentText.Save(server.Database); err != nil {
+				return err
+			}
+
+			if attachmentText.Status == AttachmentTextStatusExtracted {
+				contents[attachment.UUID] = attachmentText.Text
+				updateSize += len(attachmentText.Text)
+			}
+
+			statusCounts[attachmentText.Status]++
+		}
+
+		if len(contents) > 0 {
+			updates = append(updates, MessageScriptUpdate{
+				DocumentID: hit.ID,
+				Params:     map[string]interface{}{"contents": contents},
+			})
+		}
+
+		if len(updates) < indexAttachmentContentsBatchSize && updateSize < indexAttachmentContentsBatchBytes {
+			return nil
+		}
+
+		return indexContents()
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := indexContents(); err != nil {
+		return err
+	}
+
+	Logger.Infof("Extracted attachment texts of evidence %s: %v", evidenceUUID, statusCounts)
+
+	return nil
+}
+
+// Constants defining the bulk requests indexing the attachment contents, a request is sent when either is reached.
+const (
+	// indexAttachmentContentsBatchSize is the maximum amount of messages updated per bulk request.
+	indexAttachmentContentsBatchSize = 100
+	// indexAttachmentContentsBatchBytes is the maximum size of the texts sent per bulk request.
+	indexAttachmentContentsBatchBytes = 20 * 1024 * 1024
+)
+
+// indexAttachmentContentsScript sets the content of the attachments of the message document.
+const indexAttachmentContentsScript = `
+for (attachment in ctx._source.attachments) {
+	if (params.contents.containsKey(attachment.uuid)) {
+		attachment.content = params.contents.get(attachment.uuid);
+	}
+}`
+
+// Save saves the attachment text to the database.
+func (attachmentText *AttachmentText) Save(database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), `
+		INSERT INTO attachment_texts (attachment_uuid, message_uuid, evidence_uuid, project_uuid, file_name, status, error, text, extraction_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (attachment_uuid) DO UPDATE SET status = EXCLUDED.status, error = EXCLUDED.error, text = EXCLUDED.text, extraction_date = EXCLUDED.extraction_date`,
+		attachmentText.AttachmentUUID, attachmentText.MessageUUID, attachmentText.EvidenceUUID, attachmentText.ProjectUUID,
+		attachmentText.FileName, attachmentText.Status, attachmentText.Error, attachmentText.Text, attachmentText.ExtractionDate,
+	)
+
+	return err
+}
+
+// GetAttachmentText returns the attachment text including the extracted text.
+func GetAttachmentText(attachmentUUID string, projectUUID string, database *pgx.Conn) (AttachmentText, error) {
+	var attachmentText AttachmentText
+
+	err := database.QueryRow(context.Background(), `
+		SELECT attachment_uuid, message_uuid, evidence_uuid, project_uuid, file_name, status, error, text, LENGTH(text), extraction_date
+		FROM attachment_texts WHERE attachment_uuid = $1 AND project_uuid = $2`, attachmentUUID, projectUUID).Scan(
+		&attachmentText.AttachmentUUID, &attachmentText.MessageUUID, &attachmentText.EvidenceUUID, &attachmentText.ProjectUUID,
+		&attachmentText.FileName, &attachmentText.Status, &attachmentText.Error, &attachmentText.Text, &attachmentText.TextLength,
+		&attachmentText.ExtractionDate,
+	)
+
+	return attachmentText, err
+}
+
+// GetAttachmentTextsByMessage returns the extracted text by attachment UUID of the attachments of the message.
+func GetAttachmentTextsByMessage(messageUUID string, projectUUID string, database *pgx.Conn) (map[string]string, error) {
+	rows, err := database.Query(context.Background(), "SELECT attachment_uuid, text FROM attachment_texts WHERE message_uuid = $1 AND project_uuid = $2 AND status = $3", messageUUID, projectUUID, AttachmentTextStatusExtracted)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	attachmentTexts := make(map[string]string)
+
+	for rows.Next() {
+		var attachmentUUID string
+		var text string
+
+		if err := rows.Scan(&attachmentUUID, &text); err != nil {
+			return nil, err
+		}
+
+		attachmentTexts[attachmentUUID] = text
+	}
+
+	return attachmentTexts, rows.Err()
+}
+
+// GetAttachmentTextsByProject returns the attachment texts of the project without the extracted text.
+// All evidence items are included if the evidence UUID is empty.
+func GetAttachmentTextsByProject(projectUUID string, evidenceUUID string, database *pgx.Conn) ([]AttachmentText, error) {
+	rows, err := database.Query(context.Background(), `
+		SELECT attachment_uuid, message_uuid, evidence_uuid, project_uuid, file_name, status, error, LENGTH(text), extraction_date
+		FROM attachment_texts WHERE project_uuid = $1 AND ($2 = '' OR evidence_uuid = $2)
+		ORDER BY file_name`, projectUUID, evidenceUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var attachmentTexts []AttachmentText
+
+	for rows.Next() {
+		var attachmentText AttachmentText
+
+		if err := rows.Scan(
+			&attachmentText.AttachmentUUID, &attachmentText.MessageUUID, &attachmentText.EvidenceUUID, &attachmentText.ProjectUUID,
+			&attachmentText.FileName, &attachmentText.Status, &attachmentText.Error, &attachmentText.TextLength, &attachmentText.ExtractionDate,
+		); err != nil {
+			return nil, err
+		}
+
+		attachmentTexts = append(attachmentTexts, attachmentText)
+	}
+
+	return attachmentTexts, rows.Err()
+}
@@ -0,0 +1,894 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants defining the coding field types.
+const (
+	CodingFieldSingleChoice = "single_choice"
+	CodingFieldMultiChoice  = "multi_choice"
+	CodingFieldBoolean      = "boolean"
+	CodingFieldText         = "text"
+)
+
+// Constants defining the coding limits.
+const (
+	MaxCodingFields     = 100
+	MaxCodingChoices    = 100
+	MaxCodingTextLength = 10000
+	MaxCodingBatchSize  = 1000
+)
+
+// CodingSchema represents a version of the coding fields of a project.
+// A new version is saved for every change, the codings keep the version they were made with.
+type CodingSchema struct {
+	ProjectUUID  string        `json:"projectUUID"`
+	Version      int           `json:"version"`
+	Fields       []CodingField `json:"fields"`
+	CreatorID    string        `json:"creatorID"`
+	CreationDate int           `json:"creationDate"`
+}
+
+// CodingField represents a coding field such as Responsive, Privileged or Hot.
+// The ID stays the same across schema versions, the name may change.
+type CodingField struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Choices are the choices of single and multiple choice fields.
+	Choices []CodingChoice `json:"choices"`
+}
+
+// CodingChoice represents a choice of a coding field, the ID stays the same across schema versions.
+type CodingChoice struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// CodingRequest represents a request to code messages.
+// The values map field IDs to the choice ID (single choice), choice IDs (multiple choice), boolean or text.
+// A null value clears the field, fields which are left out are unchanged.
+type CodingRequest struct {
+	MessageUUIDs []string                   `json:"messageUUIDs"`
+	Values       map[string]json.RawMessage `json:"values"`
+}
+
+// CodedValue represents the current value of a coding field of a message.
+type CodedValue struct {
+	FieldID   string `json:"fieldID"`
+	FieldName string `json:"fieldName"`
+	Type      string `json:"type"`
+	// Value is the choice ID, choice IDs, boolean or text.
+	Value interface{} `json:"value"`
+	// Labels are the labels of the chosen choices in the schema version of the coding.
+	Labels        []string `json:"labels,omitempty"`
+	SchemaVersion int      `json:"schemaVersion"`
+	CoderID       string   `json:"coderID"`
+	CodingDate    int      `json:"codingDate"`
+}
+
+// CodingHistoryEntry represents a change of a coding field of a message, a null value means the field was cleared.
+type CodingHistoryEntry struct {
+	FieldID       string      `json:"fieldID"`
+	Value         interface{} `json:"value"`
+	SchemaVersion int         `json:"schemaVersion"`
+	CoderID       string      `json:"coderID"`
+	CodingDate    int         `json:"codingDate"`
+}
+
+// CodingUpdate represents a validated change of a coding field.
+// The values are stored as choice IDs, "true", "false" or the text, no values clear the field.
+type CodingUpdate struct {
+	Field  CodingField
+	Value  interface{}
+	Values []string
+}
+
+// handleCodingSchemas handles the coding schemas endpoint.
+// GET returns all versions (newest first), POST saves the fields as a new version.
+func (server *Server) handleCodingSchemas() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		if request.Method == "GET" {
+			codingSchemas, err := GetCodingSchemas(project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get coding schemas: %s", err)
+				http.Error(responseWriter, "Failed to get coding schemas.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&codingSchemas); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			var requestSchema CodingSchema
+
+			if err := json.NewDecoder(request.Body).Decode(&requestSchema); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			previousSchema, err := GetCodingSchema(project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get coding schema: %s", err)
+				http.Error(responseWriter, "Failed to get coding schema.", http.StatusInternalServerError)
+				return
+			}
+
+			codingSchema := CodingSchema{
+				ProjectUUID:  project.UUID,
+				Version:      previousSchema.Version + 1,
+				Fields:       requestSchema.Fields,
+				CreatorID:    user.Id,
+				CreationDate: int(time.Now().Unix()),
+			}
+
+			if err := codingSchema.Validate(previousSchema); err != nil {
+				Logger.Errorf("Invalid coding schema: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid coding schema: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			if err := codingSchema.Save(server.Database); err != nil {
+				Logger.Errorf("Failed to save coding schema: %s", err)
+				http.Error(responseWriter, "Failed to save coding schema.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&codingSchema); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleCodingSchema handles the coding schema endpoint, returning a version of the coding schema.
+func (server *Server) handleCodingSchema() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			version, err := strconv.Atoi(mux.Vars(request)["version"])
+
+			if err != nil {
+				Logger.Errorf("Invalid coding schema version: %s", err)
+				http.Error(responseWriter, "Invalid coding schema version.", http.StatusBadRequest)
+				return
+			}
+
+			codingSchema, err := GetCodingSchemaByVersion(project.UUID, version, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get coding schema: %s", err)
+				http.Error(responseWriter, "Failed to get coding schema.", http.StatusNotFound)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&codingSchema); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleMessageCoding handles the message coding endpoint.
+// GET returns the coded values of the message, POST codes the message.
+func (server *Server) handleMessageCoding() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to get message: %s", err)
+			http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+			return
+		}
+
+		if request.Method == "GET" {
+			server.writeCodedValues(responseWriter, message.UUID, project.UUID)
+		} else if request.Method == "POST" {
+			var codingRequest CodingRequest
+
+			if err := json.NewDecoder(request.Body).Decode(&codingRequest); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			codingRequest.MessageUUIDs = []string{message.UUID}
+
+			if !server.codeMessages(responseWriter, codingRequest, user.Id, project.UUID) {
+				return
+			}
+
+			server.writeCodedValues(responseWriter, message.UUID, project.UUID)
+		}
+	}
+}
+
+// writeCodedValues writes the coded values of the message as the response.
+func (server *Server) writeCodedValues(responseWriter http.ResponseWriter, messageUUID string, projectUUID string) {
+	codedValues, err := GetCodedValues(messageUUID, projectUUID, server.Database)
+
+	if err != nil {
+		Logger.Errorf("Failed to get coded values: %s", err)
+		http.Error(responseWriter, "Failed to get coded values.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(responseWriter).Encode(&codedValues); err != nil {
+		Logger.Errorf("Failed to encode response: %s", err)
+		http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleMessageCodingHistory handles the message coding history endpoint, returning the coding changes oldest first.
+func (server *Server) handleMessageCodingHistory() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			codingHistory, err := GetCodingHistory(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get coding history: %s", err)
+				http.Error(responseWriter, "Failed to get coding history.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&codingHistory); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleCoding handles the coding endpoint, coding a batch of messages with the same values.
+func (server *Server) handleCoding() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			user, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			var codingRequest CodingRequest
+
+			if err := json.NewDecoder(request.Body).Decode(&codingRequest); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			if len(codingRequest.MessageUUIDs) == 0 || len(codingRequest.MessageUUIDs) > MaxCodingBatchSize {
+				Logger.Errorf("Invalid amount of messages to code: %d", len(codingRequest.MessageUUIDs))
+				http.Error(responseWriter, fmt.Sprintf("Expected between 1 and %d messageUUIDs.", MaxCodingBatchSize), http.StatusBadRequest)
+				return
+			}
+
+			for _, messageUUID := range codingRequest.MessageUUIDs {
+				if _, err := core.GetMessageByUUID(messageUUID, project.UUID, server.Database); err != nil {
+					Logger.Errorf("Failed to get message: %s", err)
+					http.Error(responseWriter, fmt.Sprintf("Failed to get message %s.", messageUUID), http.StatusNotFound)
+					return
+				}
+			}
+
+			if !server.codeMessages(responseWriter, codingRequest, user.Id, project.UUID) {
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// codeMessages validates the coding request against the current coding schema and saves it.
+// Returns false if an error response was written.
+func (server *Server) codeMessages(responseWriter http.ResponseWriter, codingRequest CodingRequest, coderID string, projectUUID string) bool {
+	codingSchema, err := GetCodingSchema(projectUUID, server.Database)
+
+	if err != nil {
+		Logger.Errorf("Failed to get coding schema: %s", err)
+		http.Error(responseWriter, "Failed to get coding schema.", http.StatusInternalServerError)
+		return false
+	}
+
+	codingUpdates, err := codingSchema.NewCodingUpdates(codingRequest.Values)
+
+	if err != nil {
+		Logger.Errorf("Invalid coding: %s", err)
+		http.Error(responseWriter, fmt.Sprintf("Invalid coding: %s.", err), http.StatusBadRequest)
+		return false
+	}
+
+	if err := SaveCodings(codingRequest.MessageUUIDs, codingUpdates, codingSchema.Version, coderID, projectUUID, server.Database); err != nil {
+		Logger.Errorf("Failed to save coding: %s", err)
+		http.Error(responseWriter, "Failed to save coding.", http.StatusInternalServerError)
+		return false
+	}
+
+	// The codings are saved, so failing to update the review progress doesn't fail the request.
+	if err := MarkReviewBatchItemsReviewed(codingRequest.MessageUUIDs, coderID, projectUUID, server.Database); err != nil {
+		Logger.Errorf("Failed to mark review batch items as reviewed: %s", err)
+	}
+
+	return true
+}
+
+// Validate validates the fields of the new schema version and assigns IDs to the new fields and choices.
+// Fields keep their type across versions so the earlier codings stay valid.
+func (codingSchema *CodingSchema) Validate(previousSchema CodingSchema) error {
+	if len(codingSchema.Fields) > MaxCodingFields {
+		return fmt.Errorf("at most %d fields are allowed", MaxCodingFields)
+	}
+
+	previousFields := make(map[string]CodingField)
+
+	for _, previousField := range previousSchema.Fields {
+		previousFields[previousField.ID] = previousField
+	}
+
+	isFieldID := make(map[string]bool)
+	isFieldName := make(map[string]bool)
+
+	for i := range codingSchema.Fields {
+		codingField := &codingSchema.Fields[i]
+
+		codingField.Name = strings.TrimSpace(codingField.Name)
+
+		if codingField.Name == "" {
+			return fmt.Errorf("field %d has no name", i+1)
+		}
+
+		if isFieldName[strings.ToLower(codingField.Name)] {
+			return fmt.Errorf("duplicate field name \"%s\"", codingField.Name)
+		}
+
+		isFieldName[strings.ToLower(codingField.Name)] = true
+
+		previousField, isPreviousField := previousFields[codingField.ID]
+
+		if codingField.ID == "" {
+			codingField.ID = core.NewUUID()
+		} else if !isPreviousField {
+			return fmt.Errorf("unknown field ID \"%s\", leave the ID empty for a new field", codingField.ID)
+		} else if isFieldID[codingField.ID] {
+			return fmt.Errorf("duplicate field ID \"%s\"", codingField.ID)
+		} else if previousField.Type != codingField.Type {
+			return fmt.Errorf("the type of field \"%s\" can not be changed, add a new field instead", codingField.Name)
+		}
+
+		isFieldID[codingField.ID] = true
+
+		switch codingField.Type {
+		case CodingFieldSingleChoice, CodingFieldMultiChoice:
+			if err := codingField.validateChoices(previousField); err != nil {
+				return err
+			}
+		case CodingFieldBoolean, CodingFieldText:
+			if len(codingField.Choices) > 0 {
+				return fmt.Errorf("field \"%s\" of type %s can not have choices", codingField.Name, codingField.Type)
+			}
+
+			codingField.Choices = []CodingChoice{}
+		default:
+			return fmt.Errorf("invalid type \"%s\" of field \"%s\", expected %s, %s, %s or %s", codingField.Type, codingField.Name,
+				CodingFieldSingleChoice, CodingFieldMultiChoice, CodingFieldBoolean, CodingFieldText)
+		}
+	}
+
+	return nil
+}
+
+// validateChoices validates the choices of a choice field and assigns IDs to the new choices.
+func (codingField *CodingField) validateChoices(previousField CodingField) error {
+	if len(codingField.Choices) == 0 || len(codingField.Choices) > MaxCodingChoices {
+		return fmt.Errorf("field \"%s\" must have between 1 and %d choices", codingField.Name, MaxCodingChoices)
+	}
+
+	isPreviousChoice := make(map[string]bool)
+
+	for _, previousChoice := range previousField.Choices {
+		isPreviousChoice[previousChoice.ID] = true
+	}
+
+	isChoiceID := make(map[string]bool)
+	isChoiceLabel := make(map[string]bool)
+
+	for i := range codingField.Choices {
+		codingChoice := &codingField.Choices[i]
+
+		codingChoice.Label = strings.TrimSpace(codingChoice.Label)
+
+		if codingChoice.Label == "" {
+			return fmt.Errorf("choice %d of field \"%s\" has no label", i+1, codingField.Name)
+		}
+
+		if isChoiceLabel[strings.ToLower(codingChoice.Label)] {
+			return fmt.Errorf("duplicate choice \"%s\" of field \"%s\"", codingChoice.Label, codingField.Name)
+		}
+
+		isChoiceLabel[strings.ToLower(codingChoice.Label)] = true
+
+		if codingChoice.ID == "" {
+			codingChoice.ID = core.NewUUID()
+		} else if !isPreviousChoice[codingChoice.ID] || isChoiceID[codingChoice.ID] {
+			return fmt.Errorf("unknown or duplicate choice ID \"%s\" of field \"%s\"", codingChoice.ID, codingField.Name)
+		}
+
+		isChoiceID[codingChoice.ID] = true
+	}
+
+	return nil
+}
+
+// GetField returns the field with the ID.
+func (codingSchema *CodingSchema) GetField(fieldID string) (CodingField, bool) {
+	for _, codingField := range codingSchema.Fields {
+		if codingField.ID == fieldID {
+			return codingField, true
+		}
+	}
+
+	return CodingField{}, false
+}
+
+// GetChoiceLabel returns the label of the choice with the ID.
+func (codingField *CodingField) GetChoiceLabel(choiceID string) (string, bool) {
+	for _, codingChoice := range codingField.Choices {
+		if codingChoice.ID == choiceID {
+			return codingChoice.Label, true
+		}
+	}
+
+	return "", false
+}
+
+// NewCodingUpdates validates the requested values (by field ID) against the schema.
+func (codingSchema *CodingSchema) NewCodingUpdates(requestValues map[string]json.RawMessage) ([]CodingUpdate, error) {
+	if len(requestValues) == 0 {
+		return nil, errors.New("no values")
+	}
+
+	var codingUpdates []CodingUpdate
+
+	for fieldID, requestValue := range requestValues {
+		codingField, ok := codingSchema.GetField(fieldID)
+
+		if !ok {
+			return nil, fmt.Errorf("unknown field ID \"%s\"", fieldID)
+		}
+
+		codingUpdate := CodingUpdate{Field: codingField}
+
+		if string(requestValue) == "null" {
+			codingUpdates = append(codingUpdates, codingUpdate)
+			continue
+		}
+
+		switch codingField.Type {
+		case CodingFieldSingleChoice:
+			var choiceID string
+
+			if err := json.Unmarshal(requestValue, &choiceID); err != nil {
+				return nil, fmt.Errorf("field \"%s\" expects a choice ID", codingField.Name)
+			}
+
+			if _, ok := codingField.GetChoiceLabel(choiceID); !ok {
+				return nil, fmt.Errorf("unknown choice ID \"%s\" of field \"%s\"", choiceID, codingField.Name)
+			}
+
+			codingUpdate.Value = choiceID
+			codingUpdate.Values = []string{choiceID}
+		case CodingFieldMultiChoice:
+			var choiceIDs []string
+
+			if err := json.Unmarshal(requestValue, &choiceIDs); err != nil {
+				return nil, fmt.Errorf("field \"%s\" expects a list of choice IDs", codingField.Name)
+			}
+
+			isChosen := make(map[string]bool)
+
+			for _, choiceID := range choiceIDs {
+				if _, ok := codingField.GetChoiceLabel(choiceID); !ok {
+					return nil, fmt.Errorf("unknown choice ID \"%s\" of field \"%s\"", choiceID, codingField.Name)
+				}
+
+				if !isChosen[choiceID] {
+					isChosen[choiceID] = true
+					codingUpdate.Values = append(codingUpdate.Values, choiceID)
+				}
+			}
+
+			if len(codingUpdate.Values) > 0 {
+				codingUpdate.Value = codingUpdate.Values
+			}
+		case CodingFieldBoolean:
+			var boolean bool
+
+			if err := json.Unmarshal(requestValue, &boolean); err != nil {
+				return nil, fmt.Errorf("field \"%s\" expects true or false", codingField.Name)
+			}
+
+			codingUpdate.Value = boolean
+			codingUpdate.Values = []string{strconv.FormatBool(boolean)}
+		case CodingFieldText:
+			var text string
+
+			if err := json.Unmarshal(requestValue, &text); err != nil {
+				return nil, fmt.Errorf("field \"%s\" expects text", codingField.Name)
+			}
+
+			text = strings.TrimSpace(text)
+
+			if len(text) > MaxCodingTextLength {
+				return nil, fmt.Errorf("the text of field \"%s\" is longer than %d bytes", codingField.Name, MaxCodingTextLength)
+			}
+
+			if text != "" {
+				codingUpdate.Value = text
+				codingUpdate.Values = []string{text}
+			}
+		}
+
+		codingUpdates = append(codingUpdates, codingUpdate)
+	}
+
+	return codingUpdates, nil
+}
+
+// Save saves the coding schema version to the database.
+func (codingSchema *CodingSchema) Save(database *pgx.Conn) error {
+	fields, err := json.Marshal(codingSchema.Fields)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Exec(context.Background(), `
+		INSERT INTO coding_schemas (project_uuid, version, fields, creator_id, creation_date)
+		VALUES ($1, $2, $3, $4, $5)`,
+		codingSchema.ProjectUUID, codingSchema.Version, string(fields), codingSchema.CreatorID, codingSchema.CreationDate,
+	)
+
+	return err
+}
+
+// scanCodingSchema scans the coding schema from the row.
+func scanCodingSchema(row pgx.Row) (CodingSchema, error) {
+	var codingSchema CodingSchema
+	var fields string
+
+	if err := row.Scan(&codingSchema.ProjectUUID, &codingSchema.Version, &fields, &codingSchema.CreatorID, &codingSchema.CreationDate); err != nil {
+		return CodingSchema{}, err
+	}
+
+	if err := json.Unmarshal([]byte(fields), &codingSchema.Fields); err != nil {
+		return CodingSchema{}, err
+	}
+
+	return codingSchema, nil
+}
+
+// GetCodingSchema returns the current coding schema of the project.
+// Returns an empty schema (version 0) if the project has no coding schema.
+func GetCodingSchema(projectUUID string, database *pgx.Conn) (CodingSchema, error) {
+	codingSchema, err := scanCodingSchema(database.QueryRow(context.Background(), "SELECT project_uuid, version, fields, creator_id, creation_date FROM coding_schemas WHERE project_uuid = $1 ORDER BY version DESC LIMIT 1", projectUUID))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return CodingSchema{ProjectUUID: projectUUID, Fields: []CodingField{}}, nil
+	}
+
+	return codingSchema, err
+}
+
+// GetCodingSchemaByVersion returns the version of the coding schema of the project.
+func GetCodingSchemaByVersion(projectUUID string, version int, database *pgx.Conn) (CodingSchema, error) {
+	return scanCodingSchema(database.QueryRow(context.Background(), "SELECT project_uuid, version, fields, creator_id, creation_date FROM coding_schemas WHERE project_uuid = $1 AND version = $2", projectUUID, version))
+}
+
+// GetCodingSchemas returns all versions of the coding schema of the project, newest first.
+func GetCodingSchemas(projectUUID string, database *pgx.Conn) ([]CodingSchema, error) {
+	rows, err := database.Query(context.Background(), "SELECT project_uuid, version, fields, creator_id, creation_date FROM coding_schemas WHERE project_uuid = $1 ORDER BY version DESC", projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	codingSchemas := []CodingSchema{}
+
+	for rows.Next() {
+		codingSchema, err := scanCodingSchema(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		codingSchemas = append(codingSchemas, codingSchema)
+	}
+
+	return codingSchemas, rows.Err()
+}
+
+// SaveCodings replaces the values of the coded fields of the messages and adds the changes to the coding history.
+func SaveCodings(messageUUIDs []string, codingUpdates []CodingUpdate, schemaVersion int, coderID string, projectUUID string, database *pgx.Conn) error {
+	codingDate := int(time.Now().Unix())
+
+	batch := &pgx.Batch{}
+
+	for _, messageUUID := range messageUUIDs {
+		for _, codingUpdate := range codingUpdates {
+			historyValue, err := json.Marshal(codingUpdate.Value)
+
+			if err != nil {
+				return err
+			}
+
+			batch.Queue("DELETE FROM codings WHERE project_uuid = $1 AND message_uuid = $2 AND field_id = $3", projectUUID, messageUUID, codingUpdate.Field.ID)
+
+			for _, value := range codingUpdate.Values {
+				batch.Queue(`
+					INSERT INTO codings (project_uuid, message_uuid, field_id, value, schema_version, coder_id, coding_date)
+					VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+					projectUUID, messageUUID, codingUpdate.Field.ID, value, schemaVersion, coderID, codingDate,
+				)
+			}
+
+			batch.Queue(`
+				INSERT INTO coding_history (project_uuid, message_uuid, field_id, value, schema_version, coder_id, coding_date)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				projectUUID, messageUUID, codingUpdate.Field.ID, string(historyValue), schemaVersion, coderID, codingDate,
+			)
+		}
+	}
+
+	batchResults := database.SendBatch(context.Background(), batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			_ = batchResults.Close()
+			return err
+		}
+	}
+
+	return batchResults.Close()
+}
+
+// GetCodedValues returns the current coded values of the message.
+// The field names and choice labels are taken from the schema version of each coding.
+func GetCodedValues(messageUUID string, projectUUID string, database *pgx.Conn) ([]CodedValue, error) {
+	codingSchemas, err := GetCodingSchemas(projectUUID, database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	codingSchemasByVersion := make(map[int]CodingSchema)
+
+	for _, codingSchema := range codingSchemas {
+		codingSchemasByVersion[codingSchema.Version] = codingSchema
+	}
+
+	rows, err := database.Query(context.Background(), "SELECT field_id, value, schema_version, coder_id, coding_date FROM codings WHERE project_uuid = $1 AND message_uuid = $2 ORDER BY field_id, value", projectUUID, messageUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	codedValues := []CodedValue{}
+	codedValueIndexes := make(map[string]int)
+
+	for rows.Next() {
+		var fieldID string
+		var value string
+		var schemaVersion int
+		var coderID string
+		var codingDate int
+
+		if err := rows.Scan(&fieldID, &value, &schemaVersion, &coderID, &codingDate); err != nil {
+			return nil, err
+		}
+
+		codingSchema := codingSchemasByVersion[schemaVersion]
+		codingField, _ := codingSchema.GetField(fieldID)
+
+		index, ok := codedValueIndexes[fieldID]
+
+		if !ok {
+			index = len(codedValues)
+			codedValueIndexes[fieldID] = index
+
+			codedValues = append(codedValues, CodedValue{
+				FieldID:       fieldID,
+				FieldName:     codingField.Name,
+				Type:          codingField.Type,
+				SchemaVersion: schemaVersion,
+				CoderID:       coderID,
+				CodingDate:    codingDate,
+			})
+		}
+
+		codedValue := &codedValues[index]
+
+		switch codingField.Type {
+		case CodingFieldMultiChoice:
+			choiceIDs, _ := codedValue.Value.([]string)
+			codedValue.Value = append(choiceIDs, value)
+		case CodingFieldBoolean:
+			codedValue.Value = value == "true"
+		default:
+			codedValue.Value = value
+		}
+
+		if label, ok := codingField.GetChoiceLabel(value); ok {
+			codedValue.Labels = append(codedValue.Labels, label)
+		}
+	}
+
+	return codedValues, rows.Err()
+}
+
+// GetCodingHistory returns the coding changes of the message, oldest first.
+func GetCodingHistory(messageUUID string, projectUUID string, database *pgx.Conn) ([]CodingHistoryEntry, error) {
+	rows, err := database.Query(context.Background(), "SELECT field_id, value, schema_version, coder_id, coding_date FROM coding_history WHERE project_uuid = $1 AND message_uuid = $2 ORDER BY id", projectUUID, messageUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	codingHistory := []CodingHistoryEntry{}
+
+	for rows.Next() {
+		var codingHistoryEntry CodingHistoryEntry
+		var value string
+
+		if err := rows.Scan(&codingHistoryEntry.FieldID, &value, &codingHistoryEntry.SchemaVersion, &codingHistoryEntry.CoderID, &codingHistoryEntry.CodingDate); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(value), &codingHistoryEntry.Value); err != nil {
+			return nil, err
+		}
+
+		codingHistory = append(codingHistory, codingHistoryEntry)
+	}
+
+	return codingHistory, rows.Err()
+}
+
+// findCodingField returns the field matched by name (case-insensitive) or ID in the coding schemas (newest first).
+// The newest schema version which has the field decides the type.
+func findCodingField(field string, codingSchemas []CodingSchema) (CodingField, bool) {
+	for _, codingSchema := range codingSchemas {
+		for _, schemaField := range codingSchema.Fields {
+			if schemaField.ID == field || strings.EqualFold(schemaField.Name, field) {
+				return schemaField, true
+			}
+		}
+	}
+
+	return CodingField{}, false
+}
+
+// GetCodingMessageUUIDs returns the UUIDs of the messages coded with the value, any value if the value is empty.
+// The field is matched by name or ID and choices by label or ID, in any version of the coding schema.
+// Text fields match values containing the text (case-insensitive).
+func GetCodingMessageUUIDs(field string, value string, projectUUID string, database *pgx.Conn) ([]string, error) {
+	codingSchemas, err := GetCodingSchemas(projectUUID, database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	codingField, isField := findCodingField(field, codingSchemas)
+
+	if !isField {
+		return nil, newQueryParseError(0, "unknown coding field \"%s\"", field)
+	}
+
+	databaseQueryResolver := NewDatabaseQueryResolver(projectUUID, database)
+
+	if value == "" {
+		return databaseQueryResolver.queryStrings("SELECT DISTINCT message_uuid FROM codings WHERE project_uuid = $1 AND field_id = $2", projectUUID, codingField.ID)
+	}
+
+	switch codingField.Type {
+	case CodingFieldSingleChoice, CodingFieldMultiChoice:
+		var choiceIDs []string
+
+		for _, codingSchema := range codingSchemas {
+			schemaField, _ := codingSchema.GetField(codingField.ID)
+
+			for _, codingChoice := range schemaField.Choices {
+				if codingChoice.ID == value || strings.EqualFold(codingChoice.Label, value) {
+					choiceIDs = append(choiceIDs, codingChoice.ID)
+				}
+			}
+		}
+
+		if len(choiceIDs) == 0 {
+			return nil, newQueryParseError(0, "unknown choice \"%s\" of coding field \"%s\"", value, codingField.Name)
+		}
+
+		return databaseQueryResolver.queryStrings("SELECT DISTINCT message_uuid FROM codings WHERE project_uuid = $1 AND field_id = $2 AND value = ANY($3)", projectUUID, codingField.ID, choiceIDs)
+	case CodingFieldBoolean:
+		switch strings.ToLower(value) {
+		case "true", "yes":
+			value = "true"
+		case "false", "no":
+			value = "false"
+		default:
+			return nil, newQueryParseError(0, "invalid value \"%s\" for coding field \"%s\", expected true or false", value, codingField.Name)
+		}
+
+		return databaseQueryResolver.queryStrings("SELECT DISTINCT message_uuid FROM codings WHERE project_uuid = $1 AND field_id = $2 AND value = $3", projectUUID, codingField.ID, value)
+	default:
+		return databaseQueryResolver.queryStrings("SELECT DISTINCT message_uuid FROM codings WHERE project_uuid = $1 AND field_id = $2 AND STRPOS(LOWER(value), LOWER($3)) > 0", projectUUID, codingField.ID, value)
+	}
+}
@@ -0,0 +1,193 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+)
+
+// databaseTables defines the tables owned by the API.
+// The core tables (projects, evidence, messages, etc.) are created by core.CreateDatabaseTables.
+var databaseTables = []string{
+	`CREATE TABLE IF NOT EXISTS evidence_metadata (
+		evidence_uuid TEXT PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		custodian TEXT NOT NULL DEFAULT '',
+		parse_date INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS saved_searches (
+		uuid TEXT PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		query TEXT NOT NULL,
+		filters TEXT NOT NULL,
+		sort TEXT NOT NULL,
+		sort_order TEXT NOT NULL,
+		is_shared BOOLEAN NOT NULL,
+		creation_date INTEGER NOT NULL,
+		last_run_date INTEGER NOT NULL,
+		hit_count INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS search_term_reports (
+		uuid TEXT PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		creation_date INTEGER NOT NULL,
+		report TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS attachment_texts (
+		attachment_uuid TEXT PRIMARY KEY,
+		message_uuid TEXT NOT NULL,
+		evidence_uuid TEXT NOT NULL,
+		project_uuid TEXT NOT NULL,
+		file_name TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL,
+		text TEXT NOT NULL,
+		extraction_date INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS entities (
+		project_uuid TEXT NOT NULL,
+		evidence_uuid TEXT NOT NULL,
+		message_uuid TEXT NOT NULL,
+		entity_type TEXT NOT NULL,
+		value TEXT NOT NULL,
+		occurrences INTEGER NOT NULL,
+		PRIMARY KEY (project_uuid, message_uuid, entity_type, value)
+	)`,
+	`CREATE INDEX IF NOT EXISTS entities_value_index ON entities (project_uuid, entity_type, value)`,
+	`CREATE TABLE IF NOT EXISTS dkim_keys (
+		uuid TEXT PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		domain TEXT NOT NULL,
+		selector TEXT NOT NULL,
+		record TEXT NOT NULL,
+		valid_from BIGINT NOT NULL,
+		valid_to BIGINT NOT NULL,
+		source TEXT NOT NULL,
+		creation_date INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS notes (
+		uuid TEXT PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		message_uuid TEXT NOT NULL,
+		parent_uuid TEXT NOT NULL,
+		author_id TEXT NOT NULL,
+		author_email TEXT NOT NULL,
+		body TEXT NOT NULL,
+		mentions TEXT[] NOT NULL,
+		creation_date INTEGER NOT NULL,
+		modification_date INTEGER NOT NULL,
+		is_deleted BOOLEAN NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS notes_message_index ON notes (project_uuid, message_uuid)`,
+	`CREATE TABLE IF NOT EXISTS note_revisions (
+		id BIGSERIAL PRIMARY KEY,
+		note_uuid TEXT NOT NULL,
+		project_uuid TEXT NOT NULL,
+		body TEXT NOT NULL,
+		mentions TEXT[] NOT NULL,
+		editor_id TEXT NOT NULL,
+		revision_date INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS coding_schemas (
+		project_uuid TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		fields TEXT NOT NULL,
+		creator_id TEXT NOT NULL,
+		creation_date INTEGER NOT NULL,
+		PRIMARY KEY (project_uuid, version)
+	)`,
+	`CREATE TABLE IF NOT EXISTS codings (
+		project_uuid TEXT NOT NULL,
+		message_uuid TEXT NOT NULL,
+		field_id TEXT NOT NULL,
+		value TEXT NOT NULL,
+		schema_version INTEGER NOT NULL,
+		coder_id TEXT NOT NULL,
+		coding_date INTEGER NOT NULL,
+		PRIMARY KEY (project_uuid, message_uuid, field_id, value)
+	)`,
+	`CREATE INDEX IF NOT EXISTS codings_value_index ON codings (project_uuid, field_id, value)`,
+	`CREATE TABLE IF NOT EXISTS coding_history (
+		id BIGSERIAL PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		message_uuid TEXT NOT NULL,
+		field_id TEXT NOT NULL,
+		value TEXT NOT NULL,
+		schema_version INTEGER NOT NULL,
+		coder_id TEXT NOT NULL,
+		coding_date INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS review_batches (
+		uuid TEXT PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		name TEXT NOT NULL,
+		query TEXT NOT NULL,
+		assignee_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		checked_out_by TEXT NOT NULL,
+		check_out_date INTEGER NOT NULL,
+		check_in_date INTEGER NOT NULL,
+		creator_id TEXT NOT NULL,
+		creation_date INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS review_batch_items (
+		batch_uuid TEXT NOT NULL,
+		project_uuid TEXT NOT NULL,
+		message_uuid TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		reviewer_id TEXT NOT NULL,
+		review_date INTEGER NOT NULL,
+		PRIMARY KEY (batch_uuid, message_uuid)
+	)`,
+	`CREATE INDEX IF NOT EXISTS review_batch_items_message_index ON review_batch_items (project_uuid, message_uuid)`,
+	`CREATE TABLE IF NOT EXISTS privilege_log_overrides (
+		project_uuid TEXT NOT NULL,
+		message_uuid TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		privilege_type TEXT NOT NULL,
+		description TEXT NOT NULL,
+		editor_id TEXT NOT NULL,
+		modification_date INTEGER NOT NULL,
+		PRIMARY KEY (project_uuid, message_uuid)
+	)`,
+	`CREATE TABLE IF NOT EXISTS redactions (
+		uuid TEXT PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		message_uuid TEXT NOT NULL,
+		attachment_uuid TEXT NOT NULL,
+		type TEXT NOT NULL,
+		start_offset INTEGER NOT NULL,
+		end_offset INTEGER NOT NULL,
+		pattern TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		creator_id TEXT NOT NULL,
+		creation_date INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS redactions_message_index ON redactions (project_uuid, message_uuid)`,
+	`CREATE TABLE IF NOT EXISTS redaction_log (
+		id BIGSERIAL PRIMARY KEY,
+		project_uuid TEXT NOT NULL,
+		message_uuid TEXT NOT NULL,
+		redaction_uuid TEXT NOT NULL,
+		action TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		user_email TEXT NOT NULL,
+		log_date INTEGER NOT NULL,
+		redaction TEXT NOT NULL
+	)`,
+}
+
+// CreateDatabaseTables creates the database tables used by the API.
+func CreateDatabaseTables(database *pgx.Conn) error {
+	for _, databaseTable := range databaseTables {
+		if _, err := database.Exec(context.Background(), databaseTable); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,879 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"github.com/spf13/viper"
+	"hash"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DKIMDNSLookup defines if DKIM keys may be resolved via live DNS.
+// Lookups reveal the investigation to the DNS operator of the signing domain, so they are also opt-in per request.
+var DKIMDNSLookup bool
+
+func init() {
+	DKIMDNSLookup = viper.GetBool("dkim_dns_lookup")
+}
+
+// Constants defining the DKIM verification results.
+const (
+	DKIMResultPass = "PASS"
+	DKIMResultFail = "FAIL"
+	// DKIMResultUnverifiable means the original body is not available, such as for messages parsed from a PST.
+	DKIMResultUnverifiable = "UNVERIFIABLE"
+	DKIMResultNoKey        = "NO_KEY"
+	DKIMResultKeyRevoked   = "KEY_REVOKED"
+	// DKIMResultTemporaryError means the key could not be resolved, retrying may succeed.
+	DKIMResultTemporaryError = "TEMPERROR"
+	// DKIMResultPermanentError means the signature or key is malformed or unsupported.
+	DKIMResultPermanentError = "PERMERROR"
+)
+
+// DKIMKeySourceDNS is the source of keys resolved via live DNS.
+const DKIMKeySourceDNS = "dns"
+
+// MaxDKIMMessageSize defines the maximum size of an uploaded message to verify.
+const MaxDKIMMessageSize = 64 * 1024 * 1024
+
+// ErrDKIMKeyNotFound is returned by a DKIMKeyResolver which has no key for the selector and domain.
+var ErrDKIMKeyNotFound = errors.New("DKIM key not found")
+
+// DKIMKeyResolver resolves the DKIM key record (RFC 6376 section 3.6.1) of a selector and domain.
+// The signing time (unix seconds, zero if unknown) allows resolving the key in force when the message was sent.
+// Returns ErrDKIMKeyNotFound if there is no key.
+type DKIMKeyResolver interface {
+	ResolveDKIMKey(ctx context.Context, selector string, domain string, signingTime int64) (DKIMKeyRecord, error)
+}
+
+// DKIMKeyRecord represents a DKIM key record, for example from an archive of historical DNS records.
+type DKIMKeyRecord struct {
+	UUID        string `json:"uuid"`
+	ProjectUUID string `json:"projectUUID"`
+	Domain      string `json:"domain"`
+	Selector    string `json:"selector"`
+	// Record is the TXT record, for example "v=DKIM1; k=rsa; p=MIGfMA0G...".
+	Record string `json:"record"`
+	// ValidFrom and ValidTo (unix seconds, exclusive) define when the record was published, zero if unknown.
+	ValidFrom int64 `json:"validFrom"`
+	ValidTo   int64 `json:"validTo"`
+	// Source describes where the record comes from, such as a passive DNS provider.
+	Source       string `json:"source"`
+	CreationDate int    `json:"creationDate"`
+}
+
+// DKIMKeyArchive resolves DKIM keys from records supplied by the examiner.
+type DKIMKeyArchive []DKIMKeyRecord
+
+// DNSDKIMKeyResolver resolves DKIM keys via live DNS.
+type DNSDKIMKeyResolver struct {
+	Resolver *net.Resolver
+}
+
+// DKIMKeyResolvers resolves DKIM keys from the first resolver which has the key.
+type DKIMKeyResolvers []DKIMKeyResolver
+
+// DKIMVerification represents the verification of a DKIM-Signature header.
+// The body hash and header signature are verified separately, a passing header signature proves the signed
+// headers and the body hash (bh=) are authentic, a passing body hash proves the body is unchanged.
+type DKIMVerification struct {
+	Domain           string   `json:"domain"`
+	Selector         string   `json:"selector"`
+	Algorithm        string   `json:"algorithm"`
+	Canonicalization string   `json:"canonicalization"`
+	SignedHeaders    []string `json:"signedHeaders"`
+	SigningTime      int64    `json:"signingTime"`
+	BodyHashResult   string   `json:"bodyHashResult"`
+	ExpectedBodyHash string   `json:"expectedBodyHash"`
+	ComputedBodyHash string   `json:"computedBodyHash"`
+	// PartialBody is true if the signature only covers the first bytes of the body (l= tag).
+	PartialBody     bool   `json:"partialBody"`
+	SignatureResult string `json:"signatureResult"`
+	KeySource       string `json:"keySource"`
+	Error           string `json:"error,omitempty"`
+}
+
+// DKIMVerificationResponse represents the DKIM verification of a message.
+type DKIMVerificationResponse struct {
+	MessageUUID string `json:"messageUUID,omitempty"`
+	// Reconstructed is true if the body is not the original, the body hash can then only pass or be unverifiable.
+	Reconstructed bool               `json:"reconstructed"`
+	Signatures    []DKIMVerification `json:"signatures"`
+}
+
+// handleMessageDKIM handles the message DKIM endpoint, verifying the DKIM signatures of a message.
+// Accepts the "dns" (true, false) query parameter to also resolve keys via live DNS, if allowed by the configuration.
+func (server *Server) handleMessageDKIM() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			keyResolver, err := server.NewDKIMKeyResolver(request, project.UUID)
+
+			if err != nil {
+				Logger.Errorf("Failed to create DKIM key resolver: %s", err)
+				http.Error(responseWriter, "Failed to create DKIM key resolver.", http.StatusInternalServerError)
+				return
+			}
+
+			messageHeaders := ParseTransportHeaders(message.TransportHeaders)
+			body, hasBody := getOriginalMessageBody(messageHeaders, message)
+
+			dkimVerificationResponse := DKIMVerificationResponse{
+				MessageUUID:   message.UUID,
+				Reconstructed: true,
+				Signatures:    VerifyDKIMSignatures(request.Context(), messageHeaders, body, hasBody, int64(message.Date), keyResolver),
+			}
+
+			// A different PST body is expected, only an equal body proves anything.
+			for i := range dkimVerificationResponse.Signatures {
+				if dkimVerificationResponse.Signatures[i].BodyHashResult == DKIMResultFail {
+					dkimVerificationResponse.Signatures[i].BodyHashResult = DKIMResultUnverifiable
+				}
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&dkimVerificationResponse); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleVerifyDKIM handles the verify DKIM endpoint, verifying the DKIM signatures of an uploaded message (message/rfc822).
+// Accepts the "dns" (true, false) query parameter to also resolve keys via live DNS, if allowed by the configuration.
+func (server *Server) handleVerifyDKIM() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			rawMessage, err := io.ReadAll(http.MaxBytesReader(responseWriter, request.Body, MaxDKIMMessageSize))
+
+			if err != nil {
+				Logger.Errorf("Failed to read request body: %s", err)
+				http.Error(responseWriter, "Failed to read request body.", http.StatusBadRequest)
+				return
+			}
+
+			keyResolver, err := server.NewDKIMKeyResolver(request, project.UUID)
+
+			if err != nil {
+				Logger.Errorf("Failed to create DKIM key resolver: %s", err)
+				http.Error(responseWriter, "Failed to create DKIM key resolver.", http.StatusInternalServerError)
+				return
+			}
+
+			dkimVerificationResponse := DKIMVerificationResponse{
+				Signatures: VerifyDKIM(request.Context(), rawMessage, keyResolver),
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&dkimVerificationResponse); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleDKIMKeys handles the DKIM keys endpoint, listing and importing the archived key records of the project.
+func (server *Server) handleDKIMKeys() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		_, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		if request.Method == "GET" {
+			dkimKeyRecords, err := GetDKIMKeyRecordsByProject(project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get DKIM key records: %s", err)
+				http.Error(responseWriter, "Failed to get DKIM key records.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&dkimKeyRecords); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			// Import the key records, all or none are saved.
+			var dkimKeyRecords []DKIMKeyRecord
+
+			if err := json.NewDecoder(request.Body).Decode(&dkimKeyRecords); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			for i := range dkimKeyRecords {
+				dkimKeyRecords[i].UUID = core.NewUUID()
+				dkimKeyRecords[i].ProjectUUID = project.UUID
+				dkimKeyRecords[i].Domain = strings.ToLower(strings.TrimSuffix(dkimKeyRecords[i].Domain, "."))
+				dkimKeyRecords[i].CreationDate = int(time.Now().Unix())
+
+				if err := dkimKeyRecords[i].Validate(); err != nil {
+					Logger.Errorf("Invalid DKIM key record: %s", err)
+					http.Error(responseWriter, fmt.Sprintf("Invalid DKIM key record %d: %s.", i+1, err), http.StatusBadRequest)
+					return
+				}
+			}
+
+			if err := SaveDKIMKeyRecords(dkimKeyRecords, server.Database); err != nil {
+				Logger.Errorf("Failed to save DKIM key records: %s", err)
+				http.Error(responseWriter, "Failed to save DKIM key records.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&dkimKeyRecords); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleDKIMKey handles the DKIM key endpoint, deleting an archived key record.
+func (server *Server) handleDKIMKey() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "DELETE" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			if err := DeleteDKIMKeyRecord(mux.Vars(request)["uuid"], project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to delete DKIM key record: %s", err)
+				http.Error(responseWriter, "Failed to delete DKIM key record.", http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// NewDKIMKeyResolver creates the key resolver of the request, the archived key records of the project are tried
+// before live DNS. Live DNS is only used if allowed by the configuration and the "dns" query parameter is true.
+func (server *Server) NewDKIMKeyResolver(request *http.Request, projectUUID string) (DKIMKeyResolver, error) {
+	dkimKeyRecords, err := GetDKIMKeyRecordsByProject(projectUUID, server.Database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyResolvers := DKIMKeyResolvers{DKIMKeyArchive(dkimKeyRecords)}
+
+	if DKIMDNSLookup && request.URL.Query().Get("dns") == "true" {
+		keyResolvers = append(keyResolvers, &DNSDKIMKeyResolver{Resolver: net.DefaultResolver})
+	}
+
+	return keyResolvers, nil
+}
+
+// ResolveDKIMKey returns the record of the selector and domain valid at the signing time.
+// If several records are valid (or the signing time is unknown) the most recently published record is returned.
+func (dkimKeyArchive DKIMKeyArchive) ResolveDKIMKey(ctx context.Context, selector string, domain string, signingTime int64) (DKIMKeyRecord, error) {
+	var dkimKeyRecord DKIMKeyRecord
+
+	found := false
+
+	for _, candidate := range dkimKeyArchive {
+		if !strings.EqualFold(candidate.Selector, selector) || !strings.EqualFold(strings.TrimSuffix(candidate.Domain, "."), domain) {
+			continue
+		}
+
+		if signingTime != 0 && ((candidate.ValidFrom != 0 && signingTime < candidate.ValidFrom) || (candidate.ValidTo != 0 && signingTime >= candidate.ValidTo)) {
+			continue
+		}
+
+		if !found || candidate.ValidFrom > dkimKeyRecord.ValidFrom {
+			dkimKeyRecord = candidate
+			found = true
+		}
+	}
+
+	if !found {
+		return DKIMKeyRecord{}, ErrDKIMKeyNotFound
+	}
+
+	return dkimKeyRecord, nil
+}
+
+// ResolveDKIMKey returns the current TXT record of the selector and domain, the signing time is ignored.
+func (dnsKeyResolver *DNSDKIMKeyResolver) ResolveDKIMKey(ctx context.Context, selector string, domain string, signingTime int64) (DKIMKeyRecord, error) {
+	records, err := dnsKeyResolver.Resolver.LookupTXT(ctx, fmt.Sprintf("%s._domainkey.%s", selector, domain))
+
+	var dnsError *net.DNSError
+
+	if errors.As(err, &dnsError) && dnsError.IsNotFound {
+		return DKIMKeyRecord{}, ErrDKIMKeyNotFound
+	} else if err != nil {
+		return DKIMKeyRecord{}, err
+	}
+
+	for _, record := range records {
+		if _, ok := ParseDKIMTags(record)["p"]; ok {
+			return DKIMKeyRecord{
+				Domain:   domain,
+				Selector: selector,
+				Record:   record,
+				Source:   DKIMKeySourceDNS,
+			}, nil
+		}
+	}
+
+	return DKIMKeyRecord{}, ErrDKIMKeyNotFound
+}
+
+// ResolveDKIMKey returns the key of the first resolver which has the key.
+func (keyResolvers DKIMKeyResolvers) ResolveDKIMKey(ctx context.Context, selector string, domain string, signingTime int64) (DKIMKeyRecord, error) {
+	for _, keyResolver := range keyResolvers {
+		dkimKeyRecord, err := keyResolver.ResolveDKIMKey(ctx, selector, domain, signingTime)
+
+		if errors.Is(err, ErrDKIMKeyNotFound) {
+			continue
+		}
+
+		return dkimKeyRecord, err
+	}
+
+	return DKIMKeyRecord{}, ErrDKIMKeyNotFound
+}
+
+// Validate returns an error if the domain, selector or record is invalid.
+func (dkimKeyRecord *DKIMKeyRecord) Validate() error {
+	if dkimKeyRecord.Domain == "" {
+		return errors.New("missing domain")
+	}
+
+	if dkimKeyRecord.Selector == "" {
+		return errors.New("missing selector")
+	}
+
+	if dkimKeyRecord.ValidFrom != 0 && dkimKeyRecord.ValidTo != 0 && dkimKeyRecord.ValidTo <= dkimKeyRecord.ValidFrom {
+		return errors.New("validTo must be after validFrom")
+	}
+
+	// A revoked key (empty p=) is a valid record.
+	if _, err := ParseDKIMPublicKey(dkimKeyRecord.Record); err != nil && !errors.Is(err, errDKIMKeyRevoked) {
+		return err
+	}
+
+	return nil
+}
+
+// errDKIMKeyRevoked is returned by ParseDKIMPublicKey if the key record has an empty public key.
+var errDKIMKeyRevoked = errors.New("the key is revoked")
+
+// ParseDKIMPublicKey parses the public key of a DKIM key record (RSA or Ed25519).
+func ParseDKIMPublicKey(record string) (crypto.PublicKey, error) {
+	tags := ParseDKIMTags(record)
+
+	if version, ok := tags["v"]; ok && version != "DKIM1" {
+		return nil, fmt.Errorf("unsupported key record version: %s", version)
+	}
+
+	publicKeyData, ok := tags["p"]
+
+	if !ok {
+		return nil, errors.New("missing public key (p=)")
+	} else if publicKeyData == "" {
+		return nil, errDKIMKeyRevoked
+	}
+
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(publicKeyData)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %s", err)
+	}
+
+	switch keyType := tags["k"]; keyType {
+	case "", "rsa":
+		publicKey, err := x509.ParsePKIXPublicKey(publicKeyBytes)
+
+		if err != nil {
+			// Some signers publish the PKCS #1 key instead of the SubjectPublicKeyInfo.
+			return x509.ParsePKCS1PublicKey(publicKeyBytes)
+		}
+
+		if _, ok := publicKey.(*rsa.PublicKey); !ok {
+			return nil, errors.New("the public key is not an RSA key")
+		}
+
+		return publicKey, nil
+	case "ed25519":
+		if len(publicKeyBytes) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key size")
+		}
+
+		return ed25519.PublicKey(publicKeyBytes), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// getOriginalMessageBody returns the body as it was signed, if the parsed message body can still be the original.
+// This is only the case for single part, unencoded text messages.
+func getOriginalMessageBody(messageHeaders []MessageHeader, message core.Message) ([]byte, bool) {
+	if contentType := GetHeaderValues(messageHeaders, "Content-Type"); len(contentType) > 0 {
+		mediaType, _, err := mime.ParseMediaType(contentType[0])
+
+		if err != nil || mediaType != MIMETypeTextPlain {
+			return nil, false
+		}
+	}
+
+	if contentTransferEncoding := GetHeaderValues(messageHeaders, "Content-Transfer-Encoding"); len(contentTransferEncoding) > 0 {
+		switch strings.ToLower(strings.TrimSpace(contentTransferEncoding[0])) {
+		case "7bit", "8bit", "binary":
+		default:
+			return nil, false
+		}
+	}
+
+	return normalizeLineEndings([]byte(message.Body)), true
+}
+
+// normalizeLineEndings replaces the line endings by CRLF.
+func normalizeLineEndings(data []byte) []byte {
+	return bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// VerifyDKIM verifies the DKIM signatures of the raw message (RFC 5322), line endings may be LF or CRLF.
+func VerifyDKIM(ctx context.Context, rawMessage []byte, keyResolver DKIMKeyResolver) []DKIMVerification {
+	rawMessage = normalizeLineEndings(rawMessage)
+
+	headerData := rawMessage
+	var body []byte
+
+	if separatorIndex := bytes.Index(rawMessage, []byte("\r\n\r\n")); separatorIndex != -1 {
+		headerData = rawMessage[:separatorIndex+2]
+		body = rawMessage[separatorIndex+4:]
+	}
+
+	messageHeaders := ParseTransportHeaders(string(headerData))
+
+	var messageDate int64
+
+	if date := GetHeaderValues(messageHeaders, "Date"); len(date) > 0 {
+		if parsedDate, err := parseHeaderDate(date[0]); err == nil {
+			messageDate = parsedDate.Unix()
+		}
+	}
+
+	return VerifyDKIMSignatures(ctx, messageHeaders, body, true, messageDate, keyResolver)
+}
+
+// VerifyDKIMSignatures verifies each DKIM-Signature header (RFC 6376 section 6.1).
+// The body hash is not verified if hasBody is false. The message date is used to resolve the key if the signature
+// has no timestamp.
+func VerifyDKIMSignatures(ctx context.Context, messageHeaders []MessageHeader, body []byte, hasBody bool, messageDate int64, keyResolver DKIMKeyResolver) []DKIMVerification {
+	dkimVerifications := []DKIMVerification{}
+
+	for i, messageHeader := range messageHeaders {
+		if !strings.EqualFold(messageHeader.Name, "DKIM-Signature") {
+			continue
+		}
+
+		dkimVerifications = append(dkimVerifications, verifyDKIMSignature(ctx, messageHeaders, i, body, hasBody, messageDate, keyResolver))
+	}
+
+	return dkimVerifications
+}
+
+// verifyDKIMSignature verifies the DKIM-Signature header at the index.
+func verifyDKIMSignature(ctx context.Context, messageHeaders []MessageHeader, signatureIndex int, body []byte, hasBody bool, messageDate int64, keyResolver DKIMKeyResolver) DKIMVerification {
+	signatureHeader := messageHeaders[signatureIndex]
+	dkimSignature := ParseDKIMSignature(signatureHeader.Value)
+
+	dkimVerification := DKIMVerification{
+		Domain:           dkimSignature.Domain,
+		Selector:         dkimSignature.Selector,
+		Algorithm:        dkimSignature.Algorithm,
+		Canonicalization: dkimSignature.Canonicalization,
+		SignedHeaders:    dkimSignature.SignedHeaders,
+		SigningTime:      dkimSignature.Timestamp,
+		ExpectedBodyHash: dkimSignature.BodyHash,
+		PartialBody:      dkimSignature.BodyLength >= 0,
+		BodyHashResult:   DKIMResultPermanentError,
+		SignatureResult:  DKIMResultPermanentError,
+	}
+
+	fail := func(err error) DKIMVerification {
+		dkimVerification.Error = err.Error()
+
+		return dkimVerification
+	}
+
+	if dkimSignature.Version != "1" {
+		return fail(fmt.Errorf("unsupported signature version: %s", dkimSignature.Version))
+	}
+
+	if dkimSignature.Domain == "" || dkimSignature.Selector == "" || dkimSignature.BodyHash == "" || dkimSignature.Signature == "" {
+		return fail(errors.New("missing required tag (d=, s=, bh= or b=)"))
+	}
+
+	if !containsFold(dkimSignature.SignedHeaders, "From") {
+		return fail(errors.New("the From header is not signed"))
+	}
+
+	var hashFunction crypto.Hash
+	var newHash func() hash.Hash
+
+	switch dkimSignature.Algorithm {
+	case "rsa-sha256", "ed25519-sha256":
+		hashFunction, newHash = crypto.SHA256, sha256.New
+	case "rsa-sha1":
+		hashFunction, newHash = crypto.SHA1, sha1.New
+	default:
+		return fail(fmt.Errorf("unsupported algorithm: %s", dkimSignature.Algorithm))
+	}
+
+	canonicalization := strings.SplitN(strings.ToLower(dkimSignature.Canonicalization), "/", 2)
+	headerCanonicalization, bodyCanonicalization := canonicalization[0], "simple"
+
+	if len(canonicalization) == 2 {
+		bodyCanonicalization = canonicalization[1]
+	}
+
+	if (headerCanonicalization != "simple" && headerCanonicalization != "relaxed") || (bodyCanonicalization != "simple" && bodyCanonicalization != "relaxed") {
+		return fail(fmt.Errorf("unsupported canonicalization: %s", dkimSignature.Canonicalization))
+	}
+
+	// Verify the body hash.
+	if hasBody {
+		canonicalBody := CanonicalizeDKIMBody(body, bodyCanonicalization)
+
+		if dkimSignature.BodyLength >= 0 && dkimSignature.BodyLength < int64(len(canonicalBody)) {
+			canonicalBody = canonicalBody[:dkimSignature.BodyLength]
+		}
+
+		bodyHash := newHash()
+		bodyHash.Write(canonicalBody)
+		dkimVerification.ComputedBodyHash = base64.StdEncoding.EncodeToString(bodyHash.Sum(nil))
+
+		if dkimVerification.ComputedBodyHash == dkimSignature.BodyHash {
+			dkimVerification.BodyHashResult = DKIMResultPass
+		} else {
+			dkimVerification.BodyHashResult = DKIMResultFail
+		}
+	} else {
+		dkimVerification.BodyHashResult = DKIMResultUnverifiable
+	}
+
+	// Verify the header signature.
+	signingTime := dkimSignature.Timestamp
+
+	if signingTime == 0 {
+		signingTime = messageDate
+	}
+
+	dkimKeyRecord, err := keyResolver.ResolveDKIMKey(ctx, dkimSignature.Selector, dkimSignature.Domain, signingTime)
+
+	if errors.Is(err, ErrDKIMKeyNotFound) {
+		dkimVerification.SignatureResult = DKIMResultNoKey
+		return fail(err)
+	} else if err != nil {
+		dkimVerification.SignatureResult = DKIMResultTemporaryError
+		return fail(err)
+	}
+
+	dkimVerification.KeySource = dkimKeyRecord.Source
+
+	publicKey, err := ParseDKIMPublicKey(dkimKeyRecord.Record)
+
+	if errors.Is(err, errDKIMKeyRevoked) {
+		dkimVerification.SignatureResult = DKIMResultKeyRevoked
+		return fail(err)
+	} else if err != nil {
+		return fail(err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(dkimSignature.Signature)
+
+	if err != nil {
+		return fail(fmt.Errorf("invalid signature encoding: %s", err))
+	}
+
+	headerHash := newHash()
+	headerHash.Write(newDKIMSignedHeaderData(messageHeaders, signatureIndex, dkimSignature.SignedHeaders, headerCanonicalization))
+	headerDigest := headerHash.Sum(nil)
+
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(dkimSignature.Algorithm, "ed25519") {
+			return fail(errors.New("the key type does not match the algorithm"))
+		}
+
+		err = rsa.VerifyPKCS1v15(publicKey, hashFunction, headerDigest, signature)
+	case ed25519.PublicKey:
+		if !strings.HasPrefix(dkimSignature.Algorithm, "ed25519") {
+			return fail(errors.New("the key type does not match the algorithm"))
+		}
+
+		// Ed25519 signs the hash of the headers (RFC 8463 section 3).
+		if !ed25519.Verify(publicKey, headerDigest, signature) {
+			err = errors.New("invalid signature")
+		}
+	}
+
+	if err != nil {
+		dkimVerification.SignatureResult = DKIMResultFail
+		return fail(err)
+	}
+
+	dkimVerification.SignatureResult = DKIMResultPass
+
+	return dkimVerification
+}
+
+// containsFold returns true if the values contain the value, case-insensitive.
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(strings.TrimSpace(candidate), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newDKIMSignedHeaderData returns the canonicalized signed headers followed by the signature header without the
+// signature (RFC 6376 section 3.7). Headers occurring several times are signed from the bottom up.
+func newDKIMSignedHeaderData(messageHeaders []MessageHeader, signatureIndex int, signedHeaders []string, canonicalization string) []byte {
+	var headerData bytes.Buffer
+
+	usedCounts := make(map[string]int)
+
+	for _, signedHeader := range signedHeaders {
+		name := strings.ToLower(strings.TrimSpace(signedHeader))
+		skip := usedCounts[name]
+
+		for i := len(messageHeaders) - 1; i >= 0; i-- {
+			if !strings.EqualFold(messageHeaders[i].Name, name) {
+				continue
+			}
+
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			headerData.WriteString(CanonicalizeDKIMHeader(getRawHeader(messageHeaders[i]), canonicalization))
+			headerData.WriteString("\r\n")
+			break
+		}
+
+		// A header which does not exist (any longer) is signed as empty.
+		usedCounts[name]++
+	}
+
+	headerData.WriteString(CanonicalizeDKIMHeader(removeDKIMSignatureValue(getRawHeader(messageHeaders[signatureIndex])), canonicalization))
+
+	return headerData.Bytes()
+}
+
+// getRawHeader returns the original header lines, or the header created from the name and value.
+func getRawHeader(messageHeader MessageHeader) string {
+	if messageHeader.Raw != "" {
+		return messageHeader.Raw
+	}
+
+	return messageHeader.Name + ": " + messageHeader.Value
+}
+
+// removeDKIMSignatureValue removes the value of the b= tag from the raw DKIM-Signature header.
+func removeDKIMSignatureValue(rawHeader string) string {
+	separatorIndex := strings.Index(rawHeader, ":")
+
+	if separatorIndex == -1 {
+		return rawHeader
+	}
+
+	tags := strings.Split(rawHeader[separatorIndex+1:], ";")
+
+	for i, tag := range tags {
+		equalsIndex := strings.Index(tag, "=")
+
+		if equalsIndex != -1 && strings.TrimSpace(tag[:equalsIndex]) == "b" {
+			tags[i] = tag[:equalsIndex+1]
+		}
+	}
+
+	return rawHeader[:separatorIndex+1] + strings.Join(tags, ";")
+}
+
+// CanonicalizeDKIMHeader canonicalizes the raw header without the trailing CRLF (RFC 6376 section 3.4.1 and 3.4.2).
+func CanonicalizeDKIMHeader(rawHeader string, canonicalization string) string {
+	if canonicalization != "relaxed" {
+		return rawHeader
+	}
+
+	separatorIndex := strings.Index(rawHeader, ":")
+
+	if separatorIndex == -1 {
+		return rawHeader
+	}
+
+	name := strings.ToLower(strings.TrimRight(rawHeader[:separatorIndex], " \t"))
+	value := strings.Join(strings.FieldsFunc(unfoldHeader(rawHeader[separatorIndex+1:]), isDKIMWhitespace), " ")
+
+	return name + ":" + value
+}
+
+// CanonicalizeDKIMBody canonicalizes the body with CRLF line endings (RFC 6376 section 3.4.3 and 3.4.4).
+func CanonicalizeDKIMBody(body []byte, canonicalization string) []byte {
+	if canonicalization == "relaxed" {
+		lines := bytes.Split(body, []byte("\r\n"))
+
+		for i, line := range lines {
+			lines[i] = bytes.Join(bytes.FieldsFunc(line, isDKIMWhitespace), []byte(" "))
+
+			// Whitespace at the start of a line is reduced to a single space, not removed.
+			if len(line) > 0 && isDKIMWhitespace(rune(line[0])) && len(lines[i]) > 0 {
+				lines[i] = append([]byte(" "), lines[i]...)
+			}
+		}
+
+		body = bytes.Join(lines, []byte("\r\n"))
+	}
+
+	// Remove the empty lines at the end of the body.
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+
+	if len(body) == 0 && canonicalization == "relaxed" {
+		return []byte{}
+	}
+
+	return append(body, '\r', '\n')
+}
+
+// isDKIMWhitespace returns true for the whitespace (WSP) characters.
+func isDKIMWhitespace(character rune) bool {
+	return character == ' ' || character == '\t'
+}
+
+// SaveDKIMKeyRecords saves the key records to the database, the batch is saved in a single transaction.
+func SaveDKIMKeyRecords(dkimKeyRecords []DKIMKeyRecord, database *pgx.Conn) error {
+	batch := &pgx.Batch{}
+
+	for _, dkimKeyRecord := range dkimKeyRecords {
+		batch.Queue(`
+			INSERT INTO dkim_keys (uuid, project_uuid, domain, selector, record, valid_from, valid_to, source, creation_date)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			dkimKeyRecord.UUID, dkimKeyRecord.ProjectUUID, dkimKeyRecord.Domain, dkimKeyRecord.Selector, dkimKeyRecord.Record,
+			dkimKeyRecord.ValidFrom, dkimKeyRecord.ValidTo, dkimKeyRecord.Source, dkimKeyRecord.CreationDate,
+		)
+	}
+
+	batchResults := database.SendBatch(context.Background(), batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			_ = batchResults.Close()
+			return err
+		}
+	}
+
+	return batchResults.Close()
+}
+
+// GetDKIMKeyRecordsByProject returns the archived key records of the project.
+func GetDKIMKeyRecordsByProject(projectUUID string, database *pgx.Conn) ([]DKIMKeyRecord, error) {
+	rows, err := database.Query(context.Background(), "SELECT uuid, project_uuid, domain, selector, record, valid_from, valid_to, source, creation_date FROM dkim_keys WHERE project_uuid = $1 ORDER BY domain, selector, valid_from", projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	dkimKeyRecords := []DKIMKeyRecord{}
+
+	for rows.Next() {
+		var dkimKeyRecord DKIMKeyRecord
+
+		if err := rows.Scan(
+			&dkimKeyRecord.UUID,
+			&dkimKeyRecord.ProjectUUID,
+			&dkimKeyRecord.Domain,
+			&dkimKeyRecord.Selector,
+			&dkimKeyRecord.Record,
+			&dkimKeyRecord.ValidFrom,
+			&dkimKeyRecord.ValidTo,
+			&dkimKeyRecord.Source,
+			&dkimKeyRecord.CreationDate,
+		); err != nil {
+			return nil, err
+		}
+
+		dkimKeyRecords = append(dkimKeyRecords, dkimKeyRecord)
+	}
+
+	return dkimKeyRecords, rows.Err()
+}
+
+// DeleteDKIMKeyRecord deletes the archived key record of the project.
+func DeleteDKIMKeyRecord(dkimKeyRecordUUID string, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), "DELETE FROM dkim_keys WHERE uuid = $1 AND project_uuid = $2", dkimKeyRecordUUID, projectUUID)
+
+	return err
+}
@@ -0,0 +1,273 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testDKIMHeaders defines the headers of the signed test message.
+const testDKIMHeaders = "From: Alice <alice@example.com>\r\n" +
+	"To: Bob <bob@example.org>\r\n" +
+	"Subject:  Quarterly   results\r\n" +
+	"Date: Mon, 7 Mar 2022 10:00:00 +0100\r\n"
+
+// testDKIMBody defines the body of the signed test message.
+const testDKIMBody = "Hello Bob,  \r\n\r\nThe results are attached.\r\n\r\n"
+
+// testDKIMSigningTime defines the signature timestamp (t=) of the test message.
+const testDKIMSigningTime = 1646643600
+
+// newTestDKIMKey returns an RSA key and its DKIM key record.
+func newTestDKIMKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %s", err)
+	}
+
+	return privateKey, "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(publicKeyBytes)
+}
+
+// signTestDKIMMessage returns the test message with a DKIM-Signature header prepended.
+// A negative body length signs the whole body, otherwise the l= tag is added.
+func signTestDKIMMessage(t *testing.T, privateKey *rsa.PrivateKey, canonicalization string, bodyLength int) string {
+	t.Helper()
+
+	canonicalizations := strings.SplitN(canonicalization, "/", 2)
+	canonicalBody := CanonicalizeDKIMBody([]byte(testDKIMBody), canonicalizations[1])
+	lengthTag := ""
+
+	if bodyLength >= 0 {
+		canonicalBody = canonicalBody[:bodyLength]
+		lengthTag = fmt.Sprintf(" l=%d;", bodyLength)
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody)
+	signatureHeader := fmt.Sprintf("DKIM-Signature: v=1; a=rsa-sha256; c=%s; d=example.com; s=selector1;%s t=%d;\r\n\th=from:to:subject:date; bh=%s;\r\n\tb=",
+		canonicalization, lengthTag, testDKIMSigningTime, base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	messageHeaders := ParseTransportHeaders(signatureHeader + "\r\n" + testDKIMHeaders)
+	dkimSignature := ParseDKIMSignature(messageHeaders[0].Value)
+	headerDigest := sha256.Sum256(newDKIMSignedHeaderData(messageHeaders, 0, dkimSignature.SignedHeaders, canonicalizations[0]))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, headerDigest[:])
+
+	if err != nil {
+		t.Fatalf("Failed to sign message: %s", err)
+	}
+
+	return signatureHeader + base64.StdEncoding.EncodeToString(signature) + "\r\n" + testDKIMHeaders + "\r\n" + testDKIMBody
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	privateKey, keyRecord := newTestDKIMKey(t)
+
+	keyArchive := DKIMKeyArchive{
+		{Domain: "example.com", Selector: "selector1", Record: keyRecord, Source: "test archive"},
+	}
+
+	relaxedMessage := signTestDKIMMessage(t, privateKey, "relaxed/relaxed", -1)
+	simpleMessage := signTestDKIMMessage(t, privateKey, "simple/simple", -1)
+	partialMessage := signTestDKIMMessage(t, privateKey, "relaxed/relaxed", len("Hello Bob,\r\n"))
+
+	tests := []struct {
+		Name                    string
+		Message                 string
+		KeyResolver             DKIMKeyResolver
+		ExpectedBodyHashResult  string
+		ExpectedSignatureResult string
+		ExpectedPartialBody     bool
+	}{
+		{
+			Name:                    "relaxed/relaxed",
+			Message:                 relaxedMessage,
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultPass,
+		},
+		{
+			// Relaxed canonicalization ignores whitespace changes made in transit.
+			Name:                    "relaxed/relaxed with changed whitespace",
+			Message:                 strings.Replace(strings.Replace(relaxedMessage, "Subject:  Quarterly", "Subject: Quarterly", 1), "Bob,  ", "Bob,", 1),
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultPass,
+		},
+		{
+			Name:                    "simple/simple",
+			Message:                 simpleMessage,
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultPass,
+		},
+		{
+			Name:                    "simple/simple with changed whitespace",
+			Message:                 strings.Replace(simpleMessage, "Subject:  Quarterly", "Subject: Quarterly", 1),
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultFail,
+		},
+		{
+			// The signed headers (including bh=) are authentic, only the body changed.
+			Name:                    "tampered body",
+			Message:                 strings.Replace(relaxedMessage, "results are attached", "results are lost", 1),
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultFail,
+			ExpectedSignatureResult: DKIMResultPass,
+		},
+		{
+			Name:                    "tampered signed header",
+			Message:                 strings.Replace(relaxedMessage, "To: Bob <bob@example.org>", "To: Mallory <mallory@example.org>", 1),
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultFail,
+		},
+		{
+			Name:                    "unsigned header added",
+			Message:                 "X-Spam-Score: 0\r\n" + relaxedMessage,
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultPass,
+		},
+		{
+			Name:                    "length tag",
+			Message:                 partialMessage,
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultPass,
+			ExpectedPartialBody:     true,
+		},
+		{
+			// Content appended after the signed length is not covered by the signature.
+			Name:                    "length tag with appended content",
+			Message:                 partialMessage + "Appended after signing.\r\n",
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultPass,
+			ExpectedPartialBody:     true,
+		},
+		{
+			Name:                    "length tag with tampered signed content",
+			Message:                 strings.Replace(partialMessage, "Hello Bob", "Hello Eve", 1),
+			KeyResolver:             keyArchive,
+			ExpectedBodyHashResult:  DKIMResultFail,
+			ExpectedSignatureResult: DKIMResultPass,
+			ExpectedPartialBody:     true,
+		},
+		{
+			Name:                    "missing key",
+			Message:                 relaxedMessage,
+			KeyResolver:             DKIMKeyArchive{},
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultNoKey,
+		},
+		{
+			Name:    "key not valid at the signing time",
+			Message: relaxedMessage,
+			KeyResolver: DKIMKeyArchive{
+				{Domain: "example.com", Selector: "selector1", Record: keyRecord, ValidFrom: testDKIMSigningTime + 1},
+			},
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultNoKey,
+		},
+		{
+			Name:    "revoked key",
+			Message: relaxedMessage,
+			KeyResolver: DKIMKeyArchive{
+				{Domain: "example.com", Selector: "selector1", Record: "v=DKIM1; k=rsa; p="},
+			},
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultKeyRevoked,
+		},
+		{
+			Name:    "different key",
+			Message: relaxedMessage,
+			KeyResolver: DKIMKeyArchive{
+				{Domain: "example.com", Selector: "selector1", Record: func() string { _, record := newTestDKIMKey(t); return record }()},
+			},
+			ExpectedBodyHashResult:  DKIMResultPass,
+			ExpectedSignatureResult: DKIMResultFail,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dkimVerifications := VerifyDKIM(context.Background(), []byte(test.Message), test.KeyResolver)
+
+			if len(dkimVerifications) != 1 {
+				t.Fatalf("Expected 1 verification, got %d", len(dkimVerifications))
+			}
+
+			dkimVerification := dkimVerifications[0]
+
+			if dkimVerification.BodyHashResult != test.ExpectedBodyHashResult {
+				t.Errorf("Expected body hash result %s, got %s (%s)", test.ExpectedBodyHashResult, dkimVerification.BodyHashResult, dkimVerification.Error)
+			}
+
+			if dkimVerification.SignatureResult != test.ExpectedSignatureResult {
+				t.Errorf("Expected signature result %s, got %s (%s)", test.ExpectedSignatureResult, dkimVerification.SignatureResult, dkimVerification.Error)
+			}
+
+			if dkimVerification.PartialBody != test.ExpectedPartialBody {
+				t.Errorf("Expected partial body %t, got %t", test.ExpectedPartialBody, dkimVerification.PartialBody)
+			}
+
+			if dkimVerification.Domain != "example.com" || dkimVerification.Selector != "selector1" || dkimVerification.SigningTime != testDKIMSigningTime {
+				t.Errorf("Unexpected signature tags: %s, %s, %d", dkimVerification.Domain, dkimVerification.Selector, dkimVerification.SigningTime)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeDKIMBody(t *testing.T) {
+	tests := []struct {
+		Name             string
+		Body             string
+		Canonicalization string
+		Expected         string
+	}{
+		{Name: "simple", Body: "a  b \r\n\r\n\r\n", Canonicalization: "simple", Expected: "a  b \r\n"},
+		{Name: "simple empty", Body: "", Canonicalization: "simple", Expected: "\r\n"},
+		{Name: "relaxed", Body: " a \t b \r\n\r\n", Canonicalization: "relaxed", Expected: " a b\r\n"},
+		{Name: "relaxed empty", Body: "\r\n\r\n", Canonicalization: "relaxed", Expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if canonicalBody := string(CanonicalizeDKIMBody([]byte(test.Body), test.Canonicalization)); canonicalBody != test.Expected {
+				t.Errorf("Expected %q, got %q", test.Expected, canonicalBody)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeDKIMHeader(t *testing.T) {
+	rawHeader := "Subject :  Quarterly \r\n\t results "
+
+	if canonicalHeader := CanonicalizeDKIMHeader(rawHeader, "simple"); canonicalHeader != rawHeader {
+		t.Errorf("Expected %q, got %q", rawHeader, canonicalHeader)
+	}
+
+	if canonicalHeader := CanonicalizeDKIMHeader(rawHeader, "relaxed"); canonicalHeader != "subject:Quarterly results" {
+		t.Errorf("Expected %q, got %q", "subject:Quarterly results", canonicalHeader)
+	}
+}
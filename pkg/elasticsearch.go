@@ -0,0 +1,447 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+)
+
+// ElasticsearchClient defines the Elasticsearch client used to search the message index.
+var ElasticsearchClient *elasticsearch.Client
+
+// ElasticsearchIndex defines the Elasticsearch index containing the messages.
+var ElasticsearchIndex string
+
+// init initializes the ElasticsearchClient and ElasticsearchIndex.
+func init() {
+	for _, configurationVariable := range []string{"elasticsearch_addresses", "elasticsearch_index"} {
+		if !viper.IsSet(configurationVariable) {
+			Logger.Fatalf("unset %s configuration variable", configurationVariable)
+		}
+	}
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: viper.GetStringSlice("elasticsearch_addresses"),
+	})
+
+	if err != nil {
+		Logger.Fatalf("Failed to create Elasticsearch client: %s", err)
+	}
+
+	ElasticsearchClient = client
+	ElasticsearchIndex = viper.GetString("elasticsearch_index")
+}
+
+// Constants defining the fields of the Elasticsearch message index.
+// The core indexes messages with a dynamic mapping, exact matches use the keyword sub-fields.
+const (
+	MessageFieldUUID                      = "uuid.keyword"
+	MessageFieldProjectUUID               = "projectUUID.keyword"
+	MessageFieldFolderUUID                = "folderUUID.keyword"
+	MessageFieldEvidenceUUID              = "evidenceUUID.keyword"
+	MessageFieldMessageID                 = "messageID.keyword"
+	MessageFieldInReplyTo                 = "inReplyTo.keyword"
+	MessageFieldReferences                = "references"
+	MessageFieldConversationTopic         = "conversationTopic.keyword"
+	MessageFieldFrom                      = "from"
+	MessageFieldFromKeyword               = "from.keyword"
+	MessageFieldTo                        = "to"
+	MessageFieldToKeyword                 = "to.keyword"
+	MessageFieldRecipients                = "recipients.keyword"
+	MessageFieldCC                        = "cc"
+	MessageFieldCCKeyword                 = "cc.keyword"
+	MessageFieldSubject                   = "subject"
+	MessageFieldSubjectKeyword            = "subject.keyword"
+	MessageFieldBody                      = "body"
+	MessageFieldDate                      = "date"
+	MessageFieldSize                      = "size"
+	MessageFieldAttachmentUUID            = "attachments.uuid.keyword"
+	MessageFieldAttachmentFileName        = "attachments.fileName"
+	MessageFieldAttachmentFileNameKeyword = "attachments.fileName.keyword"
+	MessageFieldAttachmentMimeType        = "attachments.mimeType.keyword"
+	MessageFieldAttachmentContent         = "attachments.content"
+)
+
+// MessageTextFields defines the fields searched by terms without a field.
+var MessageTextFields = []string{
+	MessageFieldSubject,
+	MessageFieldBody,
+	MessageFieldFrom,
+	MessageFieldTo,
+	MessageFieldCC,
+	MessageFieldAttachmentFileName,
+	MessageFieldAttachmentContent,
+}
+
+// ElasticsearchSearchResponse represents the response of an Elasticsearch search request.
+type ElasticsearchSearchResponse struct {
+	Took int `json:"took"`
+	Hits struct {
+		Total struct {
+			Value    int    `json:"value"`
+			Relation string `json:"relation"`
+		} `json:"total"`
+		Hits []ElasticsearchHit `json:"hits"`
+	} `json:"hits"`
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
+	// PointInTimeID is the (updated) ID of the point in time used by the search.
+	PointInTimeID string `json:"pit_id"`
+}
+
+// ElasticsearchHit represents a single hit of an Elasticsearch search response.
+type ElasticsearchHit struct {
+	ID        string              `json:"_id"`
+	Score     float64             `json:"_score"`
+	Source    json.RawMessage     `json:"_source"`
+	Sort      []interface{}       `json:"sort"`
+	Highlight map[string][]string `json:"highlight"`
+	// MatchedQueries are the names of the named queries (_name) matching the hit.
+	MatchedQueries []string `json:"matched_queries"`
+}
+
+// ElasticsearchError represents an error returned by Elasticsearch.
+type ElasticsearchError struct {
+	StatusCode int
+	Type       string
+	Reason     string
+}
+
+// Error returns the error message.
+func (elasticsearchError *ElasticsearchError) Error() string {
+	return fmt.Sprintf("elasticsearch returned status %d (%s): %s", elasticsearchError.StatusCode, elasticsearchError.Type, elasticsearchError.Reason)
+}
+
+// IsQueryRejected returns true if Elasticsearch rejected the query, for example a regular expression which is too
+// complex or a wildcard which expands to too many terms.
+func (elasticsearchError *ElasticsearchError) IsQueryRejected() bool {
+	return elasticsearchError.StatusCode == http.StatusBadRequest
+}
+
+// SearchElasticsearch performs the search request on the message index.
+func SearchElasticsearch(ctx context.Context, searchBody map[string]interface{}) (ElasticsearchSearchResponse, error) {
+	var requestBody bytes.Buffer
+
+	if err := json.NewEncoder(&requestBody).Encode(searchBody); err != nil {
+		return ElasticsearchSearchResponse{}, err
+	}
+
+	searchOptions := []func(*esapi.SearchRequest){
+		ElasticsearchClient.Search.WithContext(ctx),
+		ElasticsearchClient.Search.WithBody(&requestBody),
+	}
+
+	// A search on a point in time must not specify the index.
+	if _, ok := searchBody["pit"]; !ok {
+		searchOptions = append(searchOptions, ElasticsearchClient.Search.WithIndex(ElasticsearchIndex))
+	}
+
+	response, err := ElasticsearchClient.Search(searchOptions...)
+
+	if err != nil {
+		return ElasticsearchSearchResponse{}, err
+	}
+
+	defer func() {
+		err := response.Body.Close()
+
+		if err != nil {
+			Logger.Errorf("Failed to close response body: %s", err)
+		}
+	}()
+
+	if response.IsError() {
+		return ElasticsearchSearchResponse{}, NewElasticsearchError(response.StatusCode, response.Body)
+	}
+
+	var searchResponse ElasticsearchSearchResponse
+
+	decoder := json.NewDecoder(response.Body)
+
+	// Keep the exact sort values of the hits, these are passed back as search_after.
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&searchResponse); err != nil {
+		return ElasticsearchSearchResponse{}, err
+	}
+
+	return searchResponse, nil
+}
+
+// UpdateMessagesByQuery runs the painless script on the message documents matching the Elasticsearch query.
+func UpdateMessagesByQuery(elasticsearchQuery map[string]interface{}, script string, params map[string]interface{}) error {
+	var requestBody bytes.Buffer
+
+	if err := json.NewEncoder(&requestBody).Encode(map[string]interface{}{
+		"query": elasticsearchQuery,
+		"script": map[string]interface{}{
+			"source": script,
+			"lang":   "painless",
+			"params": params,
+		},
+	}); err != nil {
+		return err
+	}
+
+	response, err := ElasticsearchClient.UpdateByQuery(
+		[]string{ElasticsearchIndex},
+		ElasticsearchClient.UpdateByQuery.WithBody(&requestBody),
+		ElasticsearchClient.UpdateByQuery.WithConflicts("proceed"),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := response.Body.Close()
+
+		if err != nil {
+			Logger.Errorf("Failed to close response body: %s", err)
+		}
+	}()
+
+	if response.IsError() {
+		return NewElasticsearchError(response.StatusCode, response.Body)
+	}
+
+	return nil
+}
+
+// MessageScriptUpdate represents the update of a message document by a painless script, used by UpdateMessages.
+type MessageScriptUpdate struct {
+	// DocumentID is the Elasticsearch document ID (ElasticsearchHit.ID) of the message.
+	DocumentID string
+	Params     map[string]interface{}
+}
+
+// UpdateMessages runs the painless script on each message document with the parameters of the update,
+// in a single bulk request. Returns the first failed update.
+func UpdateMessages(script string, updates []MessageScriptUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	var requestBody bytes.Buffer
+
+	encoder := json.NewEncoder(&requestBody)
+
+	for _, update := range updates {
+		if err := encoder.Encode(map[string]interface{}{
+			"update": map[string]interface{}{"_id": update.DocumentID, "retry_on_conflict": 3},
+		}); err != nil {
+			return err
+		}
+
+		if err := encoder.Encode(map[string]interface{}{
+			"script": map[string]interface{}{
+				"source": script,
+				"lang":   "painless",
+				"params": update.Params,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	response, err := ElasticsearchClient.Bulk(&requestBody, ElasticsearchClient.Bulk.WithIndex(ElasticsearchIndex))
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := response.Body.Close()
+
+		if err != nil {
+			Logger.Errorf("Failed to close response body: %s", err)
+		}
+	}()
+
+	if response.IsError() {
+		return NewElasticsearchError(response.StatusCode, response.Body)
+	}
+
+	var bulkResponse struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&bulkResponse); err != nil {
+		return err
+	}
+
+	if !bulkResponse.Errors {
+		return nil
+	}
+
+	for _, item := range bulkResponse.Items {
+		for _, result := range item {
+			if result.Status >= http.StatusMultipleChoices {
+				return fmt.Errorf("failed to update message document %s: %w", result.ID, &ElasticsearchError{
+					StatusCode: result.Status,
+					Type:       result.Error.Type,
+					Reason:     result.Error.Reason,
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewElasticsearchError creates an ElasticsearchError from the error response body.
+func NewElasticsearchError(statusCode int, responseBody io.Reader) error {
+	var errorResponse struct {
+		Error struct {
+			Type     string `json:"type"`
+			Reason   string `json:"reason"`
+			CausedBy struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"caused_by"`
+		} `json:"error"`
+	}
+
+	if err := json.NewDecoder(responseBody).Decode(&errorResponse); err != nil {
+		return &ElasticsearchError{StatusCode: statusCode, Type: "unknown", Reason: err.Error()}
+	}
+
+	elasticsearchError := &ElasticsearchError{
+		StatusCode: statusCode,
+		Type:       errorResponse.Error.Type,
+		Reason:     errorResponse.Error.Reason,
+	}
+
+	// The root cause is more descriptive than "all shards failed".
+	if errorResponse.Error.CausedBy.Reason != "" {
+		elasticsearchError.Type = errorResponse.Error.CausedBy.Type
+		elasticsearchError.Reason = errorResponse.Error.CausedBy.Reason
+	}
+
+	return elasticsearchError
+}
+
+// NewProjectQuery restricts the Elasticsearch query to the messages of the project.
+func NewProjectQuery(query map[string]interface{}, projectUUID string) map[string]interface{} {
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": []interface{}{query},
+			"filter": []interface{}{
+				map[string]interface{}{
+					"term": map[string]interface{}{
+						MessageFieldProjectUUID: projectUUID,
+					},
+				},
+			},
+		},
+	}
+}
+
+// GetMessagesFromHits decodes the messages from the Elasticsearch hits.
+func GetMessagesFromHits(hits []ElasticsearchHit) ([]core.Message, error) {
+	messages := make([]core.Message, 0, len(hits))
+
+	for _, hit := range hits {
+		var message core.Message
+
+		if err := json.Unmarshal(hit.Source, &message); err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// walkMessagesPageSize defines the amount of messages read per search by WalkHits and WalkMessages.
+const walkMessagesPageSize = 500
+
+// WalkMessages calls the walk function for every message matching the Elasticsearch query, stops at the first error.
+func WalkMessages(ctx context.Context, elasticsearchQuery map[string]interface{}, walkFunc func(message core.Message) error) error {
+	return WalkHits(ctx, elasticsearchQuery, true, func(hit ElasticsearchHit) error {
+		var message core.Message
+
+		if err := json.Unmarshal(hit.Source, &message); err != nil {
+			return err
+		}
+
+		return walkFunc(message)
+	})
+}
+
+// WalkMessageFields calls the walk function for every message matching the Elasticsearch query, stops at the first error.
+// Only the source fields are read, the other fields of the messages are empty.
+func WalkMessageFields(ctx context.Context, elasticsearchQuery map[string]interface{}, sourceFields []string, walkFunc func(message core.Message) error) error {
+	return walkHits(ctx, elasticsearchQuery, map[string]interface{}{"includes": sourceFields}, func(hit ElasticsearchHit) error {
+		var message core.Message
+
+		if err := json.Unmarshal(hit.Source, &message); err != nil {
+			return err
+		}
+
+		return walkFunc(message)
+	})
+}
+
+// WalkHits calls the walk function for every hit of the Elasticsearch query, stops at the first error.
+// The source of the hits is only read if includeSource is true.
+func WalkHits(ctx context.Context, elasticsearchQuery map[string]interface{}, includeSource bool, walkFunc func(hit ElasticsearchHit) error) error {
+	if includeSource {
+		return walkHits(ctx, elasticsearchQuery, nil, walkFunc)
+	}
+
+	return walkHits(ctx, elasticsearchQuery, false, walkFunc)
+}
+
+// walkHits calls the walk function for every hit of the Elasticsearch query, stops at the first error.
+// The source filter replaces the default one of NewSearchBody if it isn't nil.
+func walkHits(ctx context.Context, elasticsearchQuery map[string]interface{}, sourceFilter interface{}, walkFunc func(hit ElasticsearchHit) error) error {
+	pageRequest := SearchPageRequest{
+		PageSize: walkMessagesPageSize,
+		Sort:     SearchSortDate,
+	}
+
+	for {
+		searchBody := NewSearchBody(elasticsearchQuery, pageRequest)
+
+		if sourceFilter != nil {
+			searchBody["_source"] = sourceFilter
+		}
+
+		searchResponse, err := SearchElasticsearch(ctx, searchBody)
+
+		if err != nil {
+			return err
+		}
+
+		for _, hit := range searchResponse.Hits.Hits {
+			if err := walkFunc(hit); err != nil {
+				return err
+			}
+		}
+
+		if len(searchResponse.Hits.Hits) < pageRequest.PageSize {
+			return nil
+		}
+
+		pageRequest.SearchAfter = searchResponse.Hits.Hits[len(searchResponse.Hits.Hits)-1].Sort
+	}
+}
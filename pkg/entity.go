@@ -0,0 +1,776 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Constants defining the entity types.
+const (
+	EntityTypeEmail    = "email"
+	EntityTypePhone    = "phone"
+	EntityTypeIBAN     = "iban"
+	EntityTypeBIC      = "bic"
+	EntityTypeCard     = "card"
+	EntityTypeURL      = "url"
+	EntityTypeDomain   = "domain"
+	EntityTypeIP       = "ip"
+	EntityTypeBitcoin  = "bitcoin"
+	EntityTypeEthereum = "ethereum"
+)
+
+// EntityTypes defines the extracted entity types.
+var EntityTypes = []string{
+	EntityTypeEmail,
+	EntityTypePhone,
+	EntityTypeIBAN,
+	EntityTypeBIC,
+	EntityTypeCard,
+	EntityTypeURL,
+	EntityTypeDomain,
+	EntityTypeIP,
+	EntityTypeBitcoin,
+	EntityTypeEthereum,
+}
+
+// Constants defining the entity browser page sizes.
+const (
+	DefaultEntityPageSize = 100
+	MaxEntityPageSize     = 1000
+)
+
+// Regular expressions matching the entity candidates, the candidates are validated before they are extracted.
+var (
+	entityURLRegexp      = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'()\[\]{}]+`)
+	entityEmailRegexp    = regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@(?:[a-z0-9-]+\.)+[a-z]{2,}\b`)
+	entityIBANRegexp     = regexp.MustCompile(`\b[A-Z]{2}[0-9]{2}(?: ?[A-Z0-9]){11,30}`)
+	entityBICRegexp      = regexp.MustCompile(`\b[A-Z]{4}[A-Z]{2}[A-Z0-9]{2}(?:[A-Z0-9]{3})?\b`)
+	entityCardRegexp     = regexp.MustCompile(`\b(?:[0-9][ -]?){12,18}[0-9]\b`)
+	entityBitcoinRegexp  = regexp.MustCompile(`\b(?:[13][1-9A-HJ-NP-Za-km-z]{25,34}|(?i:bc1[02-9ac-hj-np-z]{11,71}))\b`)
+	entityEthereumRegexp = regexp.MustCompile(`\b0x[0-9a-fA-F]{40}\b`)
+	entityIPv4Regexp     = regexp.MustCompile(`\b(?:[0-9]{1,3}\.){3}[0-9]{1,3}\b`)
+	entityIPv6Regexp     = regexp.MustCompile(`(?i)(?:[0-9a-f]{0,4}:){2,7}[0-9a-f]{0,4}`)
+	entityPhoneRegexp    = regexp.MustCompile(`(?:\+|\()?[0-9][0-9 ().-]{6,22}[0-9]`)
+	entityDateRegexp     = regexp.MustCompile(`^[0-9]{1,4}[-./][0-9]{1,2}[-./][0-9]{1,4}`)
+	entityNANPRegexp     = regexp.MustCompile(`^\(?[0-9]{3}\)?[-. ][0-9]{3}[-. ][0-9]{4}$`)
+	entityBICContext     = regexp.MustCompile(`(?i)\b(?:bic|swift)\b`)
+)
+
+// ibanLengths maps the country codes to the length of their IBANs.
+var ibanLengths = map[string]int{
+	"AD": 24, "AE": 23, "AL": 28, "AT": 20, "AZ": 28, "BA": 20, "BE": 16, "BG": 22, "BH": 22, "BR": 29,
+	"BY": 28, "CH": 21, "CR": 22, "CY": 28, "CZ": 24, "DE": 22, "DK": 18, "DO": 28, "EE": 20, "EG": 29,
+	"ES": 24, "FI": 18, "FO": 18, "FR": 27, "GB": 22, "GE": 22, "GI": 23, "GL": 18, "GR": 27, "GT": 28,
+	"HR": 21, "HU": 28, "IE": 22, "IL": 23, "IQ": 23, "IS": 26, "IT": 27, "JO": 30, "KW": 30, "KZ": 20,
+	"LB": 28, "LC": 32, "LI": 21, "LT": 20, "LU": 20, "LV": 21, "MC": 27, "MD": 24, "ME": 22, "MK": 19,
+	"MR": 27, "MT": 31, "MU": 30, "NL": 18, "NO": 15, "PK": 24, "PL": 28, "PS": 29, "PT": 25, "QA": 29,
+	"RO": 24, "RS": 22, "SA": 24, "SC": 31, "SE": 24, "SI": 19, "SK": 24, "SM": 27, "ST": 25, "SV": 28,
+	"TL": 23, "TN": 24, "TR": 26, "UA": 29, "VA": 22, "VG": 24, "XK": 20,
+}
+
+// bicCountryCodes contains the country codes (besides the IBAN countries) accepted in BICs.
+var bicCountryCodes = map[string]bool{
+	"AR": true, "AU": true, "CA": true, "CL": true, "CN": true, "CO": true, "HK": true, "ID": true, "IN": true,
+	"JP": true, "KR": true, "MX": true, "MY": true, "NG": true, "NZ": true, "PH": true, "RU": true, "SG": true,
+	"TH": true, "TW": true, "US": true, "VN": true, "ZA": true,
+}
+
+// base58Alphabet is the alphabet of Bitcoin addresses.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// bech32Alphabet is the alphabet of SegWit (bc1) Bitcoin addresses.
+const bech32Alphabet = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Entity represents an entity extracted from a message.
+type Entity struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// EntitySummary represents an entity with the amount of messages and evidence items it occurs in.
+type EntitySummary struct {
+	Type          string `json:"type"`
+	Value         string `json:"value"`
+	MessageCount  int    `json:"messageCount"`
+	EvidenceCount int    `json:"evidenceCount"`
+	Occurrences   int    `json:"occurrences"`
+}
+
+// EntityBrowserResponse represents the response of the entity browser.
+type EntityBrowserResponse struct {
+	// TypeCounts contains the amount of distinct values per entity type.
+	TypeCounts map[string]int  `json:"typeCounts"`
+	Total      int             `json:"total"`
+	Entities   []EntitySummary `json:"entities"`
+}
+
+// entitySpan represents the byte range of an extracted entity in the text.
+type entitySpan struct {
+	Start int
+	End   int
+}
+
+// handleEntities handles the entity browser endpoint.
+// Accepts the "type", "search", "offset" and "limit" query parameters, sorted by the amount of messages.
+func (server *Server) handleEntities() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			entityType := strings.ToLower(request.URL.Query().Get("type"))
+
+			if entityType != "" && !isEntityType(entityType) {
+				Logger.Errorf("Invalid entity type: %s", entityType)
+				http.Error(responseWriter, fmt.Sprintf("The type must be one of: %s.", strings.Join(EntityTypes, ", ")), http.StatusBadRequest)
+				return
+			}
+
+			offset, err := getQueryParameterInt(request, "offset", 0, 0, -1)
+
+			if err != nil {
+				Logger.Errorf("Invalid offset: %s", err)
+				http.Error(responseWriter, "The offset must be a positive number.", http.StatusBadRequest)
+				return
+			}
+
+			limit, err := getQueryParameterInt(request, "limit", DefaultEntityPageSize, 1, MaxEntityPageSize)
+
+			if err != nil {
+				Logger.Errorf("Invalid limit: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("The limit must be a number between 1 and %d.", MaxEntityPageSize), http.StatusBadRequest)
+				return
+			}
+
+			entityBrowserResponse, err := GetEntitySummaries(project.UUID, entityType, request.URL.Query().Get("search"), offset, limit, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get entities: %s", err)
+				http.Error(responseWriter, "Failed to get entities.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&entityBrowserResponse); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// getQueryParameterInt returns the integer query parameter, the default value if it is unset.
+// The maximum is ignored if it is negative.
+func getQueryParameterInt(request *http.Request, name string, defaultValue int, minimum int, maximum int) (int, error) {
+	parameter := request.URL.Query().Get(name)
+
+	if parameter == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(parameter)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if value < minimum || (maximum >= 0 && value > maximum) {
+		return 0, fmt.Errorf("%s out of range: %d", name, value)
+	}
+
+	return value, nil
+}
+
+// isEntityType returns true if the entity type is extracted.
+func isEntityType(entityType string) bool {
+	for _, extractedEntityType := range EntityTypes {
+		if extractedEntityType == entityType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExtractEntities returns the entities in the text with the amount of occurrences.
+// Each part of the text is extracted as a single entity, URLs are extracted before the email addresses they contain
+// and payment cards before phone numbers.
+func ExtractEntities(text string) map[Entity]int {
+	entities := make(map[Entity]int)
+	var spans []entitySpan
+
+	extract := func(candidateRegexp *regexp.Regexp, extractFunc func(candidate string, start int, end int) (string, string, int)) {
+		for _, match := range candidateRegexp.FindAllStringIndex(text, -1) {
+			if isEntitySpanUsed(spans, match[0], match[1]) {
+				continue
+			}
+
+			entityType, value, length := extractFunc(text[match[0]:match[1]], match[0], match[1])
+
+			if entityType == "" {
+				continue
+			}
+
+			entities[Entity{Type: entityType, Value: value}]++
+			spans = append(spans, entitySpan{Start: match[0], End: match[0] + length})
+		}
+	}
+
+	extract(entityURLRegexp, func(candidate string, start int, end int) (string, string, int) {
+		candidate = strings.TrimRight(candidate, ".,;:!?")
+
+		if domain := getURLDomain(candidate); domain != "" {
+			entities[Entity{Type: EntityTypeDomain, Value: domain}]++
+		}
+
+		return EntityTypeURL, candidate, len(candidate)
+	})
+
+	extract(entityEmailRegexp, func(candidate string, start int, end int) (string, string, int) {
+		return EntityTypeEmail, strings.ToLower(candidate), len(candidate)
+	})
+
+	extract(entityIBANRegexp, func(candidate string, start int, end int) (string, string, int) {
+		iban, length := getIBANPrefix(candidate)
+
+		if iban == "" {
+			return "", "", 0
+		}
+
+		return EntityTypeIBAN, iban, length
+	})
+
+	extract(entityBICRegexp, func(candidate string, start int, end int) (string, string, int) {
+		if !IsValidBIC(candidate) {
+			return "", "", 0
+		}
+
+		// Without a digit in the location or branch code the BIC is likely an uppercase word.
+		if !strings.ContainsAny(candidate[6:], "0123456789") && !entityBICContext.MatchString(text[maxInt(0, start-30):start]) {
+			return "", "", 0
+		}
+
+		return EntityTypeBIC, candidate, len(candidate)
+	})
+
+	extract(entityCardRegexp, func(candidate string, start int, end int) (string, string, int) {
+		cardNumber := getDigits(candidate)
+
+		if !IsValidCardNumber(cardNumber) {
+			return "", "", 0
+		}
+
+		return EntityTypeCard, cardNumber, len(candidate)
+	})
+
+	extract(entityBitcoinRegexp, func(candidate string, start int, end int) (string, string, int) {
+		if !IsValidBitcoinAddress(candidate) {
+			return "", "", 0
+		}
+
+		return EntityTypeBitcoin, NormalizeEntityValue(EntityTypeBitcoin, candidate), len(candidate)
+	})
+
+	extract(entityEthereumRegexp, func(candidate string, start int, end int) (string, string, int) {
+		return EntityTypeEthereum, strings.ToLower(candidate), len(candidate)
+	})
+
+	extract(entityIPv4Regexp, func(candidate string, start int, end int) (string, string, int) {
+		ip := net.ParseIP(candidate)
+
+		if ip == nil || ip.To4() == nil {
+			return "", "", 0
+		}
+
+		return EntityTypeIP, ip.String(), len(candidate)
+	})
+
+	extract(entityIPv6Regexp, func(candidate string, start int, end int) (string, string, int) {
+		// Times (10:30:00) and MAC addresses are not valid IPv6 addresses.
+		ip := net.ParseIP(candidate)
+
+		if ip == nil || ip.To4() != nil || (start > 0 && isASCIIWordByte(text[start-1])) || (end < len(text) && isASCIIWordByte(text[end])) {
+			return "", "", 0
+		}
+
+		return EntityTypeIP, ip.String(), len(candidate)
+	})
+
+	extract(entityPhoneRegexp, func(candidate string, start int, end int) (string, string, int) {
+		if (start > 0 && (isASCIIWordByte(text[start-1]) || text[start-1] == '+')) || (end < len(text) && isASCIIWordByte(text[end])) {
+			return "", "", 0
+		}
+
+		phoneNumber := NormalizePhoneNumber(candidate)
+
+		if phoneNumber == "" {
+			return "", "", 0
+		}
+
+		return EntityTypePhone, phoneNumber, len(candidate)
+	})
+
+	return entities
+}
+
+// isEntitySpanUsed returns true if the byte range overlaps an extracted entity.
+func isEntitySpanUsed(spans []entitySpan, start int, end int) bool {
+	for _, span := range spans {
+		if start < span.End && end > span.Start {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxInt returns the larger of the two integers.
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// getDigits returns the digits in the text.
+func getDigits(text string) string {
+	var digits strings.Builder
+
+	for _, character := range text {
+		if character >= '0' && character <= '9' {
+			digits.WriteRune(character)
+		}
+	}
+
+	return digits.String()
+}
+
+// getURLDomain returns the lowercase host of the URL without the "www." prefix.
+func getURLDomain(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.ToLower(parsedURL.Hostname()), "www.")
+}
+
+// getIBANPrefix returns the valid IBAN at the start of the candidate and its length in the candidate.
+// The candidate may continue after the IBAN, for example with a word in uppercase.
+func getIBANPrefix(candidate string) (string, int) {
+	expectedLength, ok := ibanLengths[candidate[:2]]
+
+	if !ok {
+		return "", 0
+	}
+
+	var iban strings.Builder
+
+	for i := 0; i < len(candidate); i++ {
+		if candidate[i] == ' ' {
+			continue
+		}
+
+		iban.WriteByte(candidate[i])
+
+		if iban.Len() == expectedLength {
+			if !IsValidIBAN(iban.String()) {
+				return "", 0
+			}
+
+			return iban.String(), i + 1
+		}
+	}
+
+	return "", 0
+}
+
+// IsValidIBAN returns true if the IBAN (without spaces) has the length of the country and a valid checksum (ISO 13616).
+func IsValidIBAN(iban string) bool {
+	if len(iban) < 5 || ibanLengths[iban[:2]] != len(iban) {
+		return false
+	}
+
+	remainder := 0
+
+	for _, character := range iban[4:] + iban[:4] {
+		switch {
+		case character >= '0' && character <= '9':
+			remainder = (remainder*10 + int(character-'0')) % 97
+		case character >= 'A' && character <= 'Z':
+			remainder = (remainder*100 + int(character-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+
+	return remainder == 1
+}
+
+// IsValidBIC returns true if the BIC (ISO 9362) has a valid structure and country code.
+// BICs do not have a checksum.
+func IsValidBIC(bic string) bool {
+	if len(bic) != 8 && len(bic) != 11 {
+		return false
+	}
+
+	countryCode := bic[4:6]
+
+	if _, ok := ibanLengths[countryCode]; !ok && !bicCountryCodes[countryCode] {
+		return false
+	}
+
+	// The location code "0" in the second character is used for test BICs.
+	return bic[7] != '0'
+}
+
+// IsValidCardNumber returns true if the payment card number has a known issuer prefix and a valid Luhn checksum.
+func IsValidCardNumber(cardNumber string) bool {
+	if len(cardNumber) < 13 || len(cardNumber) > 19 || strings.Count(cardNumber, cardNumber[:1]) == len(cardNumber) {
+		return false
+	}
+
+	prefix, _ := strconv.Atoi(cardNumber[:4])
+
+	switch {
+	case cardNumber[0] == '4': // Visa
+	case prefix >= 5100 && prefix <= 5599, prefix >= 2221 && prefix <= 2720: // Mastercard
+	case prefix/100 == 34 || prefix/100 == 37: // American Express
+	case prefix == 6011 || prefix/100 == 65 || prefix/100 == 64: // Discover
+	case prefix/100 == 35: // JCB
+	case prefix/100 == 36 || prefix/100 == 38 || prefix/100 == 30: // Diners Club
+	case prefix/100 == 62: // UnionPay
+	case cardNumber[0] == '6' || cardNumber[:2] == "50" || (prefix/100 >= 56 && prefix/100 <= 58): // Maestro
+	default:
+		return false
+	}
+
+	sum := 0
+
+	for i := 0; i < len(cardNumber); i++ {
+		digit := int(cardNumber[len(cardNumber)-1-i] - '0')
+
+		if i%2 == 1 {
+			digit *= 2
+
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+	}
+
+	return sum%10 == 0
+}
+
+// IsValidBitcoinAddress returns true if the legacy (base58check) or SegWit (bech32) address has a valid checksum.
+func IsValidBitcoinAddress(address string) bool {
+	if strings.HasPrefix(strings.ToLower(address), "bc1") {
+		return isValidBech32Address(address)
+	}
+
+	decoded := new(big.Int)
+
+	for _, character := range address {
+		index := strings.IndexRune(base58Alphabet, character)
+
+		if index < 0 {
+			return false
+		}
+
+		decoded.Mul(decoded, big.NewInt(58))
+		decoded.Add(decoded, big.NewInt(int64(index)))
+	}
+
+	// The version byte of addresses starting with "1" is zero, which is lost in the big integer.
+	decodedBytes := decoded.Bytes()
+
+	if len(decodedBytes) > 25 {
+		return false
+	}
+
+	payload := make([]byte, 25)
+	copy(payload[25-len(decodedBytes):], decodedBytes)
+
+	checksum := sha256.Sum256(payload[:21])
+	checksum = sha256.Sum256(checksum[:])
+
+	return string(checksum[:4]) == string(payload[21:])
+}
+
+// isValidBech32Address returns true if the SegWit address has a valid bech32 or bech32m checksum (BIP 173, BIP 350).
+func isValidBech32Address(address string) bool {
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return false
+	}
+
+	address = strings.ToLower(address)
+	separatorIndex := strings.LastIndex(address, "1")
+
+	if separatorIndex < 1 || len(address)-separatorIndex < 7 {
+		return false
+	}
+
+	values := make([]int, 0, len(address)*2)
+
+	for _, character := range address[:separatorIndex] {
+		values = append(values, int(character)>>5)
+	}
+
+	values = append(values, 0)
+
+	for _, character := range address[:separatorIndex] {
+		values = append(values, int(character)&31)
+	}
+
+	for _, character := range address[separatorIndex+1:] {
+		index := strings.IndexRune(bech32Alphabet, character)
+
+		if index < 0 {
+			return false
+		}
+
+		values = append(values, index)
+	}
+
+	generators := []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := 1
+
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ value
+
+		for i, generator := range generators {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator
+			}
+		}
+	}
+
+	return checksum == 1 || checksum == 0x2bc830a3
+}
+
+// NormalizePhoneNumber returns the phone number as digits (with a leading + for international numbers).
+// Returns an empty string if the text does not look like a phone number, such as dates and amounts.
+func NormalizePhoneNumber(text string) string {
+	text = strings.TrimSpace(text)
+	digits := getDigits(text)
+
+	if len(digits) < 9 || len(digits) > 15 || entityDateRegexp.MatchString(text) {
+		return ""
+	}
+
+	switch {
+	case strings.HasPrefix(text, "+"):
+		return "+" + digits
+	case strings.HasPrefix(digits, "00"):
+		return "+" + digits[2:]
+	case strings.HasPrefix(digits, "0"), strings.HasPrefix(text, "("), entityNANPRegexp.MatchString(text):
+		return digits
+	default:
+		return ""
+	}
+}
+
+// NormalizeEntityValue normalizes the value the way it is extracted, so a searched value matches the extracted value.
+func NormalizeEntityValue(entityType string, value string) string {
+	value = strings.TrimSpace(value)
+
+	switch entityType {
+	case EntityTypeEmail, EntityTypeEthereum:
+		return strings.ToLower(value)
+	case EntityTypeDomain:
+		return strings.TrimPrefix(strings.ToLower(value), "www.")
+	case EntityTypeIBAN, EntityTypeBIC:
+		return strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+	case EntityTypeCard:
+		return getDigits(value)
+	case EntityTypePhone:
+		if phoneNumber := NormalizePhoneNumber(value); phoneNumber != "" {
+			return phoneNumber
+		}
+
+		return getDigits(value)
+	case EntityTypeIP:
+		if ip := net.ParseIP(value); ip != nil {
+			return ip.String()
+		}
+	case EntityTypeBitcoin:
+		// SegWit addresses are case-insensitive, legacy addresses are not.
+		if strings.HasPrefix(strings.ToLower(value), "bc1") {
+			return strings.ToLower(value)
+		}
+	}
+
+	return value
+}
+
+// ExtractMessageEntities returns the entities in the headers, subject, body and attachment texts of the message.
+func ExtractMessageEntities(message core.Message, attachmentTexts map[string]string) map[Entity]int {
+	entities := make(map[Entity]int)
+
+	texts := []string{message.From, message.To, message.CC, message.Subject, message.Body}
+
+	for _, attachmentText := range attachmentTexts {
+		texts = append(texts, attachmentText)
+	}
+
+	for _, text := range texts {
+		for entity, occurrences := range ExtractEntities(text) {
+			entities[entity] += occurrences
+		}
+	}
+
+	return entities
+}
+
+// ExtractEvidenceEntities extracts the entities of the messages in the evidence and stores them with their message.
+// The attachment texts must be extracted first.
+func (server *Server) ExtractEvidenceEntities(projectUUID string, evidenceUUID string) error {
+	elasticsearchQuery := NewProjectQuery(map[string]interface{}{
+		"term": map[string]interface{}{MessageFieldEvidenceUUID: evidenceUUID},
+	}, projectUUID)
+
+	entityCount := 0
+
+	err := WalkMessages(context.Background(), elasticsearchQuery, func(message core.Message) error {
+		var attachmentTexts map[string]string
+
+		if len(message.Attachments) > 0 {
+			var err error
+
+			attachmentTexts, err = GetAttachmentTextsByMessage(message.UUID, projectUUID, server.Database)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		entities := ExtractMessageEntities(message, attachmentTexts)
+		entityCount += len(entities)
+
+		return SaveMessageEntities(message.UUID, evidenceUUID, projectUUID, entities, server.Database)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	Logger.Infof("Extracted %d entities of evidence %s", entityCount, evidenceUUID)
+
+	return nil
+}
+
+// SaveMessageEntities replaces the entities of the message in the database.
+func SaveMessageEntities(messageUUID string, evidenceUUID string, projectUUID string, entities map[Entity]int, database *pgx.Conn) error {
+	batch := &pgx.Batch{}
+
+	batch.Queue("DELETE FROM entities WHERE message_uuid = $1 AND project_uuid = $2", messageUUID, projectUUID)
+
+	for entity, occurrences := range entities {
+		batch.Queue(
+			"INSERT INTO entities (project_uuid, evidence_uuid, message_uuid, entity_type, value, occurrences) VALUES ($1, $2, $3, $4, $5, $6)",
+			projectUUID, evidenceUUID, messageUUID, entity.Type, entity.Value, occurrences,
+		)
+	}
+
+	batchResults := database.SendBatch(context.Background(), batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			_ = batchResults.Close()
+			return err
+		}
+	}
+
+	return batchResults.Close()
+}
+
+// GetEntitySummaries returns a page of the entities of the project, optionally filtered by type and (partial) value.
+func GetEntitySummaries(projectUUID string, entityType string, search string, offset int, limit int, database *pgx.Conn) (EntityBrowserResponse, error) {
+	entityBrowserResponse := EntityBrowserResponse{
+		TypeCounts: make(map[string]int),
+		Entities:   []EntitySummary{},
+	}
+
+	rows, err := database.Query(context.Background(), "SELECT entity_type, COUNT(DISTINCT value) FROM entities WHERE project_uuid = $1 GROUP BY entity_type", projectUUID)
+
+	if err != nil {
+		return entityBrowserResponse, err
+	}
+
+	for rows.Next() {
+		var countedEntityType string
+		var count int
+
+		if err := rows.Scan(&countedEntityType, &count); err != nil {
+			rows.Close()
+			return entityBrowserResponse, err
+		}
+
+		entityBrowserResponse.TypeCounts[countedEntityType] = count
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return entityBrowserResponse, err
+	}
+
+	rows, err = database.Query(context.Background(), `
+		SELECT entity_type, value, COUNT(DISTINCT message_uuid), COUNT(DISTINCT evidence_uuid), SUM(occurrences), COUNT(*) OVER ()
+		FROM entities
+		WHERE project_uuid = $1 AND ($2 = '' OR entity_type = $2) AND ($3 = '' OR value ILIKE '%' || $3 || '%')
+		GROUP BY entity_type, value
+		ORDER BY COUNT(DISTINCT message_uuid) DESC, entity_type, value
+		OFFSET $4 LIMIT $5`, projectUUID, entityType, escapeLikePattern(search), offset, limit)
+
+	if err != nil {
+		return entityBrowserResponse, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var entitySummary EntitySummary
+
+		if err := rows.Scan(&entitySummary.Type, &entitySummary.Value, &entitySummary.MessageCount, &entitySummary.EvidenceCount, &entitySummary.Occurrences, &entityBrowserResponse.Total); err != nil {
+			return entityBrowserResponse, err
+		}
+
+		entityBrowserResponse.Entities = append(entityBrowserResponse.Entities, entitySummary)
+	}
+
+	return entityBrowserResponse, rows.Err()
+}
+
+// escapeLikePattern escapes the wildcards of a SQL LIKE pattern.
+func escapeLikePattern(pattern string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(pattern)
+}
@@ -4,11 +4,24 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
 	core "github.com/mooijtech/goforensics-core/pkg"
 	"net/http"
+	"time"
 )
 
+// EvidenceItem represents an evidence item of a project with its custodian and parse date.
+type EvidenceItem struct {
+	UUID      string `json:"uuid"`
+	FileName  string `json:"fileName"`
+	FileHash  string `json:"fileHash"`
+	Custodian string `json:"custodian"`
+	ParseDate int    `json:"parseDate"`
+}
+
 // handleEvidence handles the evidence endpoint.
 func (server *Server) handleEvidence() http.HandlerFunc {
 	return func(responseWriter http.ResponseWriter, request *http.Request) {
@@ -45,6 +58,9 @@ func (server *Server) handleEvidence() http.HandlerFunc {
 				return
 			}
 
+			// The custodian is optional and can be assigned later.
+			custodian := requestMap["custodian"]
+
 			var evidence core.Evidence
 
 			evidence.UUID = core.NewUUID()
@@ -64,6 +80,12 @@ func (server *Server) handleEvidence() http.HandlerFunc {
 				return
 			}
 
+			if err := SetEvidenceCustodian(evidence.UUID, custodian, project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to set evidence custodian: %s", err)
+				http.Error(responseWriter, "Failed to set evidence custodian.", http.StatusInternalServerError)
+				return
+			}
+
 			Logger.Infof("Indexing evidence (%s): %s...", evidence.FileHash, evidence.FileName)
 
 			if err := evidence.Parse(project, server.Database); err != nil {
@@ -72,6 +94,15 @@ func (server *Server) handleEvidence() http.HandlerFunc {
 				return
 			}
 
+			if err := SetEvidenceParseDate(evidence.UUID, int(time.Now().Unix()), project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to set evidence parse date: %s", err)
+				http.Error(responseWriter, "Failed to set evidence parse date.", http.StatusInternalServerError)
+				return
+			}
+
+			// The messages are searchable now, the rest of the processing should not hold up the upload.
+			go server.ProcessEvidence(project, evidence.UUID)
+
 			if _, err := responseWriter.Write([]byte("\"status\": \"OK\"}")); err != nil {
 				Logger.Errorf("Failed to write response: %s", err)
 				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
@@ -80,3 +111,138 @@ func (server *Server) handleEvidence() http.HandlerFunc {
 		}
 	}
 }
+
+// ProcessEvidence indexes the recipients, extracts the attachment texts and entities of the parsed evidence and notifies
+// the saved searches. Runs in the background after the evidence is parsed, failures are logged.
+func (server *Server) ProcessEvidence(project core.Project, evidenceUUID string) {
+	Logger.Infof("Processing evidence %s...", evidenceUUID)
+
+	// The connection of the server is used by the requests and is not safe for concurrent use,
+	// the processing uses its own connection.
+	database, err := core.NewDatabase()
+
+	if err != nil {
+		Logger.Errorf("Failed to connect to the database: %s", err)
+		return
+	}
+
+	defer func() {
+		if err := database.Close(context.Background()); err != nil {
+			Logger.Errorf("Failed to close database connection: %s", err)
+		}
+	}()
+
+	processServer := *server
+	processServer.Database = database
+
+	if err := IndexEvidenceRecipients(project.UUID, evidenceUUID); err != nil {
+		Logger.Errorf("Failed to index recipients: %s", err)
+	}
+
+	// Attachments without extracted text are still searchable by their metadata.
+	if err := processServer.ExtractEvidenceAttachmentTexts(project.UUID, evidenceUUID); err != nil {
+		Logger.Errorf("Failed to extract attachment texts: %s", err)
+	}
+
+	if err := processServer.ExtractEvidenceEntities(project.UUID, evidenceUUID); err != nil {
+		Logger.Errorf("Failed to extract entities: %s", err)
+	}
+
+	if err := processServer.NotifySavedSearches(project, evidenceUUID); err != nil {
+		Logger.Errorf("Failed to notify saved searches: %s", err)
+	}
+
+	Logger.Infof("Processed evidence %s", evidenceUUID)
+}
+
+// handleEvidenceCustodian handles the evidence custodian endpoint.
+func (server *Server) handleEvidenceCustodian() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			var requestMap map[string]string
+
+			if err := json.NewDecoder(request.Body).Decode(&requestMap); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			custodian, ok := requestMap["custodian"]
+
+			if !ok {
+				Logger.Errorf("Failed to get custodian.")
+				http.Error(responseWriter, "Failed to get custodian.", http.StatusBadRequest)
+				return
+			}
+
+			if err := SetEvidenceCustodian(mux.Vars(request)["evidenceUUID"], custodian, project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to set evidence custodian: %s", err)
+				http.Error(responseWriter, "Failed to set evidence custodian.", http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// SetEvidenceCustodian sets the custodian of the evidence item.
+func SetEvidenceCustodian(evidenceUUID string, custodian string, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), `
+		INSERT INTO evidence_metadata (evidence_uuid, project_uuid, custodian) VALUES ($1, $2, $3)
+		ON CONFLICT (evidence_uuid) DO UPDATE SET custodian = EXCLUDED.custodian`, evidenceUUID, projectUUID, custodian)
+
+	return err
+}
+
+// SetEvidenceParseDate sets the date (unix timestamp) the evidence item finished parsing.
+func SetEvidenceParseDate(evidenceUUID string, parseDate int, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), `
+		INSERT INTO evidence_metadata (evidence_uuid, project_uuid, parse_date) VALUES ($1, $2, $3)
+		ON CONFLICT (evidence_uuid) DO UPDATE SET parse_date = EXCLUDED.parse_date`, evidenceUUID, projectUUID, parseDate)
+
+	return err
+}
+
+// GetEvidenceItemsByProject returns the evidence items of the project.
+func GetEvidenceItemsByProject(projectUUID string, database *pgx.Conn) ([]EvidenceItem, error) {
+	rows, err := database.Query(context.Background(), `
+		SELECT evidence.uuid, evidence.file_name, evidence.file_hash, COALESCE(evidence_metadata.custodian, ''), COALESCE(evidence_metadata.parse_date, 0)
+		FROM evidence
+		JOIN project_evidence ON project_evidence.evidence_uuid = evidence.uuid
+		LEFT JOIN evidence_metadata ON evidence_metadata.evidence_uuid = evidence.uuid
+		WHERE project_evidence.project_uuid = $1
+		ORDER BY evidence.file_name`, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var evidenceItems []EvidenceItem
+
+	for rows.Next() {
+		var evidenceItem EvidenceItem
+
+		if err := rows.Scan(&evidenceItem.UUID, &evidenceItem.FileName, &evidenceItem.FileHash, &evidenceItem.Custodian, &evidenceItem.ParseDate); err != nil {
+			return nil, err
+		}
+
+		evidenceItems = append(evidenceItems, evidenceItem)
+	}
+
+	return evidenceItems, rows.Err()
+}
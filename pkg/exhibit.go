@@ -0,0 +1,508 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants defining the exhibit defaults and limits.
+const (
+	DefaultExhibitPrefix      = "EX-"
+	DefaultExhibitStartNumber = 1
+	DefaultExhibitTimezone    = "UTC"
+	MaxExhibitMessages        = 500
+)
+
+// exhibitPrefixRegexp defines the allowed exhibit prefixes, the prefix is also used in the file name.
+var exhibitPrefixRegexp = regexp.MustCompile(`^[A-Za-z0-9 ._-]{0,16}$`)
+
+// Constants defining the exhibit layout in points.
+const (
+	exhibitMargin       = 56.0
+	exhibitFooterHeight = 40.0
+	exhibitLabelWidth   = 90.0
+	exhibitContentWidth = PDFPageWidth - 2*exhibitMargin
+)
+
+// ExhibitRequest represents a request to render messages as exhibits.
+type ExhibitRequest struct {
+	MessageUUIDs []string `json:"messageUUIDs"`
+	Prefix       string   `json:"prefix"`
+	StartNumber  int      `json:"startNumber"`
+	Timezone     string   `json:"timezone"`
+}
+
+// Exhibit represents a message rendered as an exhibit.
+type Exhibit struct {
+	Number       string
+	Message      core.Message
+	EvidenceItem EvidenceItem
+	FolderPath   []string
+	// SourceHash is the SHA-256 hash of the message source (the .eml file of /message/{messageUUID}/source).
+	SourceHash string
+	// AttachmentHashes are the SHA-256 hashes of the attachments by attachment UUID.
+	AttachmentHashes map[string]string
+	// Body is the body text laid out by writeRedactedText, with the redaction markers of the redactions.
+	Body string
+	// IsRedacted is true if the message has redactions, the redacted text is covered and never written to the PDF.
+	IsRedacted bool
+	// AttachmentTexts are the extracted texts of the attachments with the redactions applied.
+	AttachmentTexts []RedactedAttachment
+}
+
+// handleMessageExhibit handles the message exhibit endpoint, rendering the message as an exhibit PDF.
+// Accepts the "prefix", "startNumber" and "timezone" (IANA name) query parameters.
+func (server *Server) handleMessageExhibit() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			startNumber, err := getQueryParameterInt(request, "startNumber", DefaultExhibitStartNumber, 1, 999999)
+
+			if err != nil {
+				Logger.Errorf("Invalid exhibit request: %s", err)
+				http.Error(responseWriter, "Invalid exhibit request: the startNumber must be a number between 1 and 999999.", http.StatusBadRequest)
+				return
+			}
+
+			exhibitRequest := ExhibitRequest{
+				MessageUUIDs: []string{mux.Vars(request)["messageUUID"]},
+				StartNumber:  startNumber,
+			}
+
+			// Unlike the JSON body, an empty prefix query parameter means no prefix.
+			if prefix, ok := request.URL.Query()["prefix"]; ok {
+				exhibitRequest.Prefix = prefix[0]
+			} else {
+				exhibitRequest.Prefix = DefaultExhibitPrefix
+			}
+
+			exhibitRequest.Timezone = request.URL.Query().Get("timezone")
+
+			server.writeExhibits(responseWriter, exhibitRequest, project.UUID)
+		}
+	}
+}
+
+// handleExhibits handles the exhibits endpoint, rendering the messages as exhibit PDF.
+func (server *Server) handleExhibits() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			exhibitRequest := ExhibitRequest{
+				Prefix: DefaultExhibitPrefix,
+			}
+
+			if err := json.NewDecoder(request.Body).Decode(&exhibitRequest); err != nil {
+				Logger.Errorf("Failed to decode request: %s", err)
+				http.Error(responseWriter, "Failed to decode request.", http.StatusBadRequest)
+				return
+			}
+
+			server.writeExhibits(responseWriter, exhibitRequest, project.UUID)
+		}
+	}
+}
+
+// writeExhibits validates the exhibit request and writes the exhibits PDF response.
+func (server *Server) writeExhibits(responseWriter http.ResponseWriter, exhibitRequest ExhibitRequest, projectUUID string) {
+	location, err := ValidateExhibitRequest(&exhibitRequest)
+
+	if err != nil {
+		Logger.Errorf("Invalid exhibit request: %s", err)
+		http.Error(responseWriter, fmt.Sprintf("Invalid exhibit request: %s.", err), http.StatusBadRequest)
+		return
+	}
+
+	exhibits, err := server.NewExhibits(exhibitRequest, projectUUID)
+
+	if err != nil {
+		Logger.Errorf("Failed to create exhibits: %s", err)
+		http.Error(responseWriter, "Failed to create exhibits.", http.StatusInternalServerError)
+		return
+	}
+
+	document := NewExhibitsPDF(exhibits, location)
+
+	fileName := fmt.Sprintf("exhibit-%s.pdf", exhibits[0].Number)
+
+	if len(exhibits) > 1 {
+		fileName = fmt.Sprintf("exhibits-%s-%s.pdf", exhibits[0].Number, exhibits[len(exhibits)-1].Number)
+	}
+
+	responseWriter.Header().Set("Content-Type", "application/pdf")
+	responseWriter.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+
+	// The headers are sent, errors can only be logged.
+	if _, err := document.WriteTo(responseWriter); err != nil {
+		Logger.Errorf("Failed to write exhibits: %s", err)
+	}
+}
+
+// ValidateExhibitRequest validates the exhibit request, setting the defaults, and returns the timezone location.
+func ValidateExhibitRequest(exhibitRequest *ExhibitRequest) (*time.Location, error) {
+	if len(exhibitRequest.MessageUUIDs) == 0 {
+		return nil, fmt.Errorf("no messageUUIDs")
+	}
+
+	if len(exhibitRequest.MessageUUIDs) > MaxExhibitMessages {
+		return nil, fmt.Errorf("at most %d messages can be rendered at once", MaxExhibitMessages)
+	}
+
+	if !exhibitPrefixRegexp.MatchString(exhibitRequest.Prefix) {
+		return nil, fmt.Errorf("the prefix must be at most 16 letters, digits, spaces, dots, underscores or dashes")
+	}
+
+	if exhibitRequest.StartNumber == 0 {
+		exhibitRequest.StartNumber = DefaultExhibitStartNumber
+	}
+
+	if exhibitRequest.StartNumber < 0 {
+		return nil, fmt.Errorf("the startNumber must be positive")
+	}
+
+	if exhibitRequest.Timezone == "" {
+		exhibitRequest.Timezone = DefaultExhibitTimezone
+	}
+
+	location, err := time.LoadLocation(exhibitRequest.Timezone)
+
+	// The local timezone of the server is not meaningful to the client.
+	if err != nil || exhibitRequest.Timezone == "Local" {
+		return nil, fmt.Errorf("unknown timezone \"%s\", expected an IANA timezone such as Europe/Amsterdam", exhibitRequest.Timezone)
+	}
+
+	return location, nil
+}
+
+// NewExhibits creates the exhibits of the messages, numbered in the order of the request.
+func (server *Server) NewExhibits(exhibitRequest ExhibitRequest, projectUUID string) ([]Exhibit, error) {
+	evidenceItems, err := GetEvidenceItemsByProject(projectUUID, server.Database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	evidenceItemsByUUID := make(map[string]EvidenceItem)
+
+	for _, evidenceItem := range evidenceItems {
+		evidenceItemsByUUID[evidenceItem.UUID] = evidenceItem
+	}
+
+	var exhibits []Exhibit
+
+	for i, messageUUID := range exhibitRequest.MessageUUIDs {
+		message, err := core.GetMessageByUUID(messageUUID, projectUUID, server.Database)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message %s: %s", messageUUID, err)
+		}
+
+		folderPath, err := GetTreeNodePath(message.FolderUUID, projectUUID, server.Database)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sourceHash, err := GetMessageSourceHash(message, projectUUID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		attachmentHashes := make(map[string]string)
+
+		for _, attachment := range message.Attachments {
+			attachmentHash := sha256.New()
+
+			if err := WriteAttachmentData(attachment.UUID, projectUUID, attachmentHash); err != nil {
+				return nil, err
+			}
+
+			attachmentHashes[attachment.UUID] = hex.EncodeToString(attachmentHash.Sum(nil))
+		}
+
+		// Exhibits are produced, so the redactions are always applied.
+		redactedMessage, err := NewRedactedMessage(message, redactPDFText, projectUUID, server.Database)
+
+		if err != nil {
+			return nil, err
+		}
+
+		exhibits = append(exhibits, Exhibit{
+			Number:           fmt.Sprintf("%s%03d", exhibitRequest.Prefix, exhibitRequest.StartNumber+i),
+			Message:          message,
+			EvidenceItem:     evidenceItemsByUUID[message.EvidenceUUID],
+			FolderPath:       folderPath,
+			SourceHash:       sourceHash,
+			AttachmentHashes: attachmentHashes,
+			Body:             redactedMessage.Body,
+			IsRedacted:       redactedMessage.RedactionCount > 0,
+			AttachmentTexts:  redactedMessage.Attachments,
+		})
+	}
+
+	return exhibits, nil
+}
+
+// exhibitLayout lays out an exhibit from the top of the page to the bottom, adding pages when needed.
+type exhibitLayout struct {
+	document *PDFDocument
+	exhibit  Exhibit
+	pages    []*PDFPage
+	page     *PDFPage
+	y        float64
+}
+
+// NewExhibitsPDF renders the exhibits, each exhibit starts on a new page.
+// Dates are written in the timezone location.
+func NewExhibitsPDF(exhibits []Exhibit, location *time.Location) *PDFDocument {
+	document := &PDFDocument{
+		Title:        fmt.Sprintf("Exhibit %s", exhibits[0].Number),
+		CreationDate: time.Now(),
+	}
+
+	if len(exhibits) > 1 {
+		document.Title = fmt.Sprintf("Exhibits %s to %s", exhibits[0].Number, exhibits[len(exhibits)-1].Number)
+	}
+
+	for _, exhibit := range exhibits {
+		layout := &exhibitLayout{
+			document: document,
+			exhibit:  exhibit,
+		}
+
+		layout.addPage()
+		layout.writeExhibit(location)
+		layout.writeFooters()
+	}
+
+	return document
+}
+
+// writeExhibit writes the headers, source, body and attachments of the exhibit.
+func (layout *exhibitLayout) writeExhibit(location *time.Location) {
+	message := layout.exhibit.Message
+
+	layout.writeText(PDFFontBold, 16, 0, fmt.Sprintf("Exhibit %s", layout.exhibit.Number))
+
+	if layout.exhibit.IsRedacted {
+		layout.writeText(PDFFontRegular, 9, 0, "Redacted for production. The redacted text is covered and marked with the reason.")
+	}
+
+	if !layout.exhibit.isPDFTextEncodable() {
+		layout.writeText(PDFFontBold, 9, 0, "Some characters could not be rendered in this PDF and are printed as \"?\". The original text is in the message source.")
+	}
+
+	layout.y -= 6
+
+	layout.writeHeading("Message")
+	layout.writeField("From", message.From)
+	layout.writeField("To", message.To)
+	layout.writeField("CC", message.CC)
+	layout.writeField("BCC", message.BCC)
+	layout.writeField("Subject", message.Subject)
+	layout.writeField("Date", formatExhibitDate(message.Date, location))
+	layout.writeField("Message-ID", message.MessageID)
+
+	layout.writeHeading("Source")
+	layout.writeField("Evidence", layout.exhibit.EvidenceItem.FileName)
+	layout.writeField("Evidence hash", layout.exhibit.EvidenceItem.FileHash)
+	layout.writeField("Custodian", layout.exhibit.EvidenceItem.Custodian)
+	layout.writeField("Folder", strings.Join(layout.exhibit.FolderPath, " / "))
+	layout.writeField("Item UUID", message.UUID)
+	layout.writeField("Reconstructed source SHA-256", layout.exhibit.SourceHash)
+
+	layout.writeHeading(fmt.Sprintf("Attachments (%d)", len(message.Attachments)))
+
+	if len(message.Attachments) == 0 {
+		layout.writeText(PDFFontRegular, 9, 0, "None")
+	}
+
+	for i, attachment := range message.Attachments {
+		layout.writeText(PDFFontBold, 9, 0, fmt.Sprintf("%d. %s", i+1, attachment.FileName))
+		layout.writeText(PDFFontRegular, 9, 12, fmt.Sprintf("%s, %s bytes", attachment.MimeType, formatExhibitNumber(attachment.Size)))
+		layout.writeText(PDFFontMonospace, 8, 12, fmt.Sprintf("SHA-256 %s", layout.exhibit.AttachmentHashes[attachment.UUID]))
+	}
+
+	layout.writeHeading("Body")
+
+	body := layout.exhibit.Body
+
+	if strings.TrimSpace(body) == "" {
+		body = "(empty)"
+	}
+
+	layout.writeRedactedText(PDFFontRegular, 10, 0, strings.TrimRight(body, " \t\r\n"))
+
+	for _, attachmentText := range layout.exhibit.AttachmentTexts {
+		layout.writeHeading(fmt.Sprintf("Attachment text: %s", attachmentText.FileName))
+		layout.writeRedactedText(PDFFontRegular, 9, 0, strings.TrimRight(attachmentText.Text, " \t\r\n"))
+	}
+}
+
+// isPDFTextEncodable returns true if all text of the exhibit can be rendered with the standard PDF fonts.
+func (exhibit Exhibit) isPDFTextEncodable() bool {
+	message := exhibit.Message
+	texts := []string{message.From, message.To, message.CC, message.BCC, message.Subject, message.MessageID, exhibit.Body,
+		exhibit.EvidenceItem.FileName, exhibit.EvidenceItem.Custodian, strings.Join(exhibit.FolderPath, " / ")}
+
+	for _, attachment := range message.Attachments {
+		texts = append(texts, attachment.FileName)
+	}
+
+	for _, attachmentText := range exhibit.AttachmentTexts {
+		texts = append(texts, attachmentText.FileName, attachmentText.Text)
+	}
+
+	for _, text := range texts {
+		if !IsPDFTextEncodable(text) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// addPage adds a page to the exhibit.
+func (layout *exhibitLayout) addPage() {
+	layout.page = layout.document.AddPage()
+	layout.pages = append(layout.pages, layout.page)
+	layout.y = PDFPageHeight - exhibitMargin
+}
+
+// reserve adds a page when the height doesn't fit on the current page.
+func (layout *exhibitLayout) reserve(height float64) {
+	if layout.y-height < exhibitMargin+exhibitFooterHeight {
+		layout.addPage()
+	}
+}
+
+// writeText writes the wrapped text, indented from the left margin.
+func (layout *exhibitLayout) writeText(font string, size float64, indent float64, text string) {
+	lineHeight := size * 1.3
+
+	for _, line := range WrapPDFText(font, size, text, exhibitContentWidth-indent) {
+		layout.reserve(lineHeight)
+		layout.y -= lineHeight
+		layout.page.Text(exhibitMargin+indent, layout.y+size*0.3, font, size, line)
+	}
+}
+
+// writeRedactedText writes the wrapped text, drawing the redaction markers as black boxes.
+// A marker wider than a line is broken by WrapPDFText, the continuation lines are drawn as part of the marker.
+func (layout *exhibitLayout) writeRedactedText(font string, size float64, indent float64, text string) {
+	lineHeight := size * 1.3
+	isInMarker := false
+
+	for _, line := range WrapPDFText(font, size, text, exhibitContentWidth-indent) {
+		if isInMarker {
+			line = pdfRedactionStart + line
+		}
+
+		isInMarker = strings.LastIndex(line, pdfRedactionStart) > strings.LastIndex(line, pdfRedactionEnd)
+
+		layout.reserve(lineHeight)
+		layout.y -= lineHeight
+		writeRedactedLine(layout.page, exhibitMargin+indent, layout.y+size*0.3, font, size, line)
+	}
+}
+
+// writeHeading writes a section heading with a line underneath.
+func (layout *exhibitLayout) writeHeading(heading string) {
+	// Keep the heading together with the first lines of the section.
+	layout.reserve(60)
+	layout.y -= 12
+
+	layout.writeText(PDFFontBold, 11, 0, heading)
+	layout.page.Line(exhibitMargin, layout.y-2, PDFPageWidth-exhibitMargin, layout.y-2, 0.5)
+	layout.y -= 6
+}
+
+// writeField writes the label and the wrapped value, empty values are skipped.
+// A label wider than the label column is written on its own line.
+func (layout *exhibitLayout) writeField(label string, value string) {
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+
+	lineHeight := 9 * 1.3
+
+	if PDFTextWidth(PDFFontBold, 9, label) > exhibitLabelWidth-6 {
+		layout.writeText(PDFFontBold, 9, 0, label)
+		label = ""
+	}
+
+	for i, line := range WrapPDFText(PDFFontRegular, 9, value, exhibitContentWidth-exhibitLabelWidth) {
+		layout.reserve(lineHeight)
+		layout.y -= lineHeight
+
+		if i == 0 && label != "" {
+			layout.page.Text(exhibitMargin, layout.y+9*0.3, PDFFontBold, 9, label)
+		}
+
+		layout.page.Text(exhibitMargin+exhibitLabelWidth, layout.y+9*0.3, PDFFontRegular, 9, line)
+	}
+}
+
+// writeFooters writes the exhibit number and the page number of the exhibit on each page of the exhibit.
+func (layout *exhibitLayout) writeFooters() {
+	footerY := exhibitMargin - 20
+
+	for i, page := range layout.pages {
+		page.Line(exhibitMargin, footerY+14, PDFPageWidth-exhibitMargin, footerY+14, 0.5)
+		page.Text(exhibitMargin, footerY, PDFFontBold, 8, fmt.Sprintf("Exhibit %s", layout.exhibit.Number))
+		page.TextRight(PDFPageWidth-exhibitMargin, footerY, PDFFontRegular, 8, fmt.Sprintf("Page %d of %d", i+1, len(layout.pages)))
+	}
+}
+
+// formatExhibitDate formats the date (Unix seconds) in the timezone location.
+func formatExhibitDate(date int, location *time.Location) string {
+	if date == 0 {
+		return ""
+	}
+
+	return time.Unix(int64(date), 0).In(location).Format("Monday, 2 January 2006 15:04:05 -0700 (MST)")
+}
+
+// formatExhibitNumber formats the number with thousands separators.
+func formatExhibitNumber(number int) string {
+	digits := strconv.Itoa(number)
+
+	var formatted strings.Builder
+
+	for i, digit := range digits {
+		if i > 0 && digit != '-' && (len(digits)-i)%3 == 0 && digits[i-1] != '-' {
+			formatted.WriteByte(',')
+		}
+
+		formatted.WriteRune(digit)
+	}
+
+	return formatted.String()
+}
@@ -0,0 +1,459 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"sort"
+)
+
+// Constants defining the search facets.
+const (
+	FacetSenders         = "senders"
+	FacetRecipients      = "recipients"
+	FacetSenderDomains   = "senderDomains"
+	FacetDates           = "dates"
+	FacetAttachmentTypes = "attachmentTypes"
+	FacetTags            = "tags"
+	FacetFolders         = "folders"
+	FacetEvidence        = "evidence"
+)
+
+// Facets defines all search facets.
+var Facets = []string{
+	FacetSenders,
+	FacetRecipients,
+	FacetSenderDomains,
+	FacetDates,
+	FacetAttachmentTypes,
+	FacetTags,
+	FacetFolders,
+	FacetEvidence,
+}
+
+// Constants defining the facet sizes.
+const (
+	DefaultFacetSize = 10
+	MaxFacetSize     = 100
+)
+
+// facetDateIntervals maps the date facet intervals to the Elasticsearch date format of the bucket.
+// The formats are accepted by the date: query field, so a bucket can be used as a filter.
+var facetDateIntervals = map[string]string{
+	"day":   "yyyy-MM-dd",
+	"month": "yyyy-MM",
+	"year":  "yyyy",
+}
+
+// senderDomainScript sets domain to the lowercase domain of the sender ("Name <user@domain>" or "user@domain").
+const senderDomainScript = `
+	String domain = null;
+	if (doc['` + MessageFieldFromKeyword + `'].size() > 0) {
+		String from = doc['` + MessageFieldFromKeyword + `'].value;
+		int at = from.lastIndexOf('@');
+		if (at != -1) {
+			domain = from.substring(at + 1);
+			int end = domain.indexOf('>');
+			if (end != -1) { domain = domain.substring(0, end); }
+			domain = domain.trim().toLowerCase();
+		}
+	}
+`
+
+// FacetFilter represents a selected facet value used to narrow the next search.
+type FacetFilter struct {
+	Facet string `json:"facet"`
+	Value string `json:"value"`
+}
+
+// FacetRequest represents the facets and facet filters of a search request.
+type FacetRequest struct {
+	Facets       []string
+	Size         int
+	DateInterval string
+	Filters      []FacetFilter
+}
+
+// FacetBucket represents a facet value with the amount of matching messages.
+type FacetBucket struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// NewFacetRequest creates the FacetRequest from the request body.
+// Accepts "facets" (list of facet names), "facetSize", "facetDateInterval" (day, month, year)
+// and "filters" (list of {"facet": ..., "value": ...} taken from the buckets of a previous response).
+func NewFacetRequest(requestBody map[string]interface{}) (FacetRequest, error) {
+	facetRequest := FacetRequest{
+		Size:         DefaultFacetSize,
+		DateInterval: "month",
+	}
+
+	if requestFacets, ok := requestBody["facets"]; ok {
+		facets, ok := requestFacets.([]interface{})
+
+		if !ok {
+			return FacetRequest{}, errors.New("facets must be a list")
+		}
+
+		for _, requestFacet := range facets {
+			facet, ok := requestFacet.(string)
+
+			if !ok || !isFacet(facet) {
+				return FacetRequest{}, fmt.Errorf("unknown facet: %v", requestFacet)
+			}
+
+			facetRequest.Facets = append(facetRequest.Facets, facet)
+		}
+	}
+
+	if requestFacetSize, ok := requestBody["facetSize"]; ok {
+		facetSize, ok := requestFacetSize.(float64)
+
+		if !ok || facetSize < 1 || facetSize > MaxFacetSize || facetSize != float64(int(facetSize)) {
+			return FacetRequest{}, fmt.Errorf("facetSize must be a number between 1 and %d", MaxFacetSize)
+		}
+
+		facetRequest.Size = int(facetSize)
+	}
+
+	if requestDateInterval, ok := requestBody["facetDateInterval"]; ok {
+		dateInterval, ok := requestDateInterval.(string)
+
+		if _, isDateInterval := facetDateIntervals[dateInterval]; !ok || !isDateInterval {
+			return FacetRequest{}, errors.New("facetDateInterval must be day, month or year")
+		}
+
+		facetRequest.DateInterval = dateInterval
+	}
+
+	if requestFilters, ok := requestBody["filters"]; ok {
+		filters, ok := requestFilters.([]interface{})
+
+		if !ok {
+			return FacetRequest{}, errors.New("filters must be a list")
+		}
+
+		for _, requestFilter := range filters {
+			filter, ok := requestFilter.(map[string]interface{})
+
+			if !ok {
+				return FacetRequest{}, errors.New("filter must be an object with a facet and value")
+			}
+
+			facet, facetOK := filter["facet"].(string)
+			value, valueOK := filter["value"].(string)
+
+			if !facetOK || !valueOK || !isFacet(facet) {
+				return FacetRequest{}, fmt.Errorf("invalid filter: %v", requestFilter)
+			}
+
+			if facet == FacetDates {
+				if _, _, err := parseQueryDate(value, 0); err != nil {
+					return FacetRequest{}, fmt.Errorf("invalid date filter: %s", value)
+				}
+			}
+
+			facetRequest.Filters = append(facetRequest.Filters, FacetFilter{Facet: facet, Value: value})
+		}
+	}
+
+	return facetRequest, nil
+}
+
+// isFacet returns true if the facet exists.
+func isFacet(facet string) bool {
+	for _, existingFacet := range Facets {
+		if existingFacet == facet {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ApplyFacetFilters narrows the Elasticsearch query with the facet filters.
+// Values of the same facet are combined with OR, different facets with AND.
+func ApplyFacetFilters(query map[string]interface{}, facetFilters []FacetFilter, queryResolver QueryResolver) (map[string]interface{}, error) {
+	if len(facetFilters) == 0 {
+		return query, nil
+	}
+
+	facetFilterQueries := make(map[string][]interface{})
+
+	for _, facetFilter := range facetFilters {
+		facetFilterQuery, err := newFacetFilterQuery(facetFilter, queryResolver)
+
+		if err != nil {
+			return nil, err
+		}
+
+		facetFilterQueries[facetFilter.Facet] = append(facetFilterQueries[facetFilter.Facet], facetFilterQuery)
+	}
+
+	var filters []interface{}
+
+	for _, facet := range Facets {
+		if queries, ok := facetFilterQueries[facet]; ok {
+			filters = append(filters, map[string]interface{}{
+				"bool": map[string]interface{}{
+					"should":               queries,
+					"minimum_should_match": 1,
+				},
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must":   []interface{}{query},
+			"filter": filters,
+		},
+	}, nil
+}
+
+// newFacetFilterQuery creates the Elasticsearch query matching the facet value.
+func newFacetFilterQuery(facetFilter FacetFilter, queryResolver QueryResolver) (map[string]interface{}, error) {
+	switch facetFilter.Facet {
+	case FacetSenders:
+		return newTermsQuery(MessageFieldFromKeyword, []string{facetFilter.Value}), nil
+	case FacetRecipients:
+		return newTermsQuery(MessageFieldRecipients, []string{facetFilter.Value}), nil
+	case FacetSenderDomains:
+		return map[string]interface{}{
+			"script": map[string]interface{}{
+				"script": map[string]interface{}{
+					"source": senderDomainScript + "return domain != null && domain == params.domain;",
+					"params": map[string]interface{}{"domain": facetFilter.Value},
+				},
+			},
+		}, nil
+	case FacetDates:
+		start, end, err := parseQueryDate(facetFilter.Value, 0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"range": map[string]interface{}{
+				MessageFieldDate: map[string]interface{}{"gte": start, "lt": end},
+			},
+		}, nil
+	case FacetAttachmentTypes:
+		return newTermsQuery(MessageFieldAttachmentMimeType, []string{facetFilter.Value}), nil
+	case FacetTags:
+		messageUUIDs, err := queryResolver.GetTagMessageUUIDs(facetFilter.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tagQuery, err := newMessageUUIDsQuery(messageUUIDs)
+
+		if err != nil {
+			return nil, fmt.Errorf("tag \"%s\": %w", facetFilter.Value, err)
+		}
+
+		return tagQuery, nil
+	case FacetFolders:
+		return newTermsQuery(MessageFieldFolderUUID, []string{facetFilter.Value}), nil
+	case FacetEvidence:
+		return newTermsQuery(MessageFieldEvidenceUUID, []string{facetFilter.Value}), nil
+	default:
+		return nil, fmt.Errorf("unknown facet: %s", facetFilter.Facet)
+	}
+}
+
+// NewFacetAggregations creates the Elasticsearch aggregations of the requested facets.
+func NewFacetAggregations(facetRequest FacetRequest, projectUUID string, database *pgx.Conn) (map[string]interface{}, error) {
+	aggregations := make(map[string]interface{})
+
+	newTermsAggregation := func(field string) map[string]interface{} {
+		return map[string]interface{}{
+			"terms": map[string]interface{}{
+				"field": field,
+				"size":  facetRequest.Size,
+			},
+		}
+	}
+
+	for _, facet := range facetRequest.Facets {
+		switch facet {
+		case FacetSenders:
+			aggregations[facet] = newTermsAggregation(MessageFieldFromKeyword)
+		case FacetRecipients:
+			aggregations[facet] = newTermsAggregation(MessageFieldRecipients)
+		case FacetSenderDomains:
+			aggregations[facet] = map[string]interface{}{
+				"terms": map[string]interface{}{
+					"script": map[string]interface{}{"source": senderDomainScript + "return domain;"},
+					"size":   facetRequest.Size,
+				},
+			}
+		case FacetDates:
+			aggregations[facet] = map[string]interface{}{
+				"date_histogram": map[string]interface{}{
+					"script":            map[string]interface{}{"source": dateMillisecondsScript},
+					"calendar_interval": facetRequest.DateInterval,
+					"format":            facetDateIntervals[facetRequest.DateInterval],
+					"min_doc_count":     1,
+				},
+			}
+		case FacetAttachmentTypes:
+			aggregations[facet] = newTermsAggregation(MessageFieldAttachmentMimeType)
+		case FacetTags:
+			tags, err := GetTagsByProject(projectUUID, database)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(tags) == 0 {
+				continue
+			}
+
+			tagFilters := make(map[string]interface{})
+			tagMessageCount := 0
+
+			// Each tag is sent as a list of message UUIDs, the total is limited to keep the request small.
+			for tag, messageUUIDs := range tags {
+				tagMessageCount += len(messageUUIDs)
+
+				if tagMessageCount > MaxQueryMessageUUIDs {
+					return nil, fmt.Errorf("tags facet: %w", errTooManyQueryMessages)
+				}
+
+				tagFilters[tag] = newTermsQuery(MessageFieldUUID, messageUUIDs)
+			}
+
+			aggregations[facet] = map[string]interface{}{
+				"filters": map[string]interface{}{"filters": tagFilters},
+			}
+		case FacetFolders:
+			aggregations[facet] = newTermsAggregation(MessageFieldFolderUUID)
+		case FacetEvidence:
+			aggregations[facet] = newTermsAggregation(MessageFieldEvidenceUUID)
+		}
+	}
+
+	return aggregations, nil
+}
+
+// NewFacets creates the facet buckets from the Elasticsearch aggregations.
+func NewFacets(aggregations map[string]json.RawMessage, facetRequest FacetRequest, projectUUID string, database *pgx.Conn) (map[string][]FacetBucket, error) {
+	facets := make(map[string][]FacetBucket)
+
+	for _, facet := range facetRequest.Facets {
+		facets[facet] = []FacetBucket{}
+
+		aggregation, ok := aggregations[facet]
+
+		if !ok {
+			continue
+		}
+
+		if facet == FacetTags {
+			var filtersAggregation struct {
+				Buckets map[string]struct {
+					DocCount int `json:"doc_count"`
+				} `json:"buckets"`
+			}
+
+			if err := json.Unmarshal(aggregation, &filtersAggregation); err != nil {
+				return nil, err
+			}
+
+			for tag, bucket := range filtersAggregation.Buckets {
+				if bucket.DocCount > 0 {
+					facets[facet] = append(facets[facet], FacetBucket{Value: tag, Label: tag, Count: bucket.DocCount})
+				}
+			}
+
+			sort.Slice(facets[facet], func(i, j int) bool {
+				if facets[facet][i].Count == facets[facet][j].Count {
+					return facets[facet][i].Value < facets[facet][j].Value
+				}
+
+				return facets[facet][i].Count > facets[facet][j].Count
+			})
+
+			if len(facets[facet]) > facetRequest.Size {
+				facets[facet] = facets[facet][:facetRequest.Size]
+			}
+
+			continue
+		}
+
+		// Terms aggregations have string keys, date histograms have numeric keys with a formatted key_as_string.
+		var bucketAggregation struct {
+			Buckets []struct {
+				Key         interface{} `json:"key"`
+				KeyAsString string      `json:"key_as_string"`
+				DocCount    int         `json:"doc_count"`
+			} `json:"buckets"`
+		}
+
+		if err := json.Unmarshal(aggregation, &bucketAggregation); err != nil {
+			return nil, err
+		}
+
+		for _, bucket := range bucketAggregation.Buckets {
+			value := bucket.KeyAsString
+
+			if value == "" {
+				value = fmt.Sprint(bucket.Key)
+			}
+
+			facets[facet] = append(facets[facet], FacetBucket{Value: value, Label: value, Count: bucket.DocCount})
+		}
+	}
+
+	// Folders and evidence items are aggregated by UUID, label them with their names.
+	if folderBuckets := facets[FacetFolders]; len(folderBuckets) > 0 {
+		var folderUUIDs []string
+
+		for _, folderBucket := range folderBuckets {
+			folderUUIDs = append(folderUUIDs, folderBucket.Value)
+		}
+
+		folderTitles, err := GetTreeNodeTitles(folderUUIDs, projectUUID, database)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i, folderBucket := range folderBuckets {
+			if title, ok := folderTitles[folderBucket.Value]; ok {
+				folderBuckets[i].Label = title
+			}
+		}
+	}
+
+	if evidenceBuckets := facets[FacetEvidence]; len(evidenceBuckets) > 0 {
+		evidenceItems, err := GetEvidenceItemsByProject(projectUUID, database)
+
+		if err != nil {
+			return nil, err
+		}
+
+		evidenceFileNames := make(map[string]string)
+
+		for _, evidenceItem := range evidenceItems {
+			evidenceFileNames[evidenceItem.UUID] = evidenceItem.FileName
+		}
+
+		for i, evidenceBucket := range evidenceBuckets {
+			if fileName, ok := evidenceFileNames[evidenceBucket.Value]; ok {
+				evidenceBuckets[i].Label = fileName
+			}
+		}
+	}
+
+	return facets, nil
+}
@@ -0,0 +1,846 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants defining the header warning types.
+const (
+	HeaderWarningClockBackwards     = "CLOCK_BACKWARDS"
+	HeaderWarningDateAfterReceived  = "DATE_AFTER_RECEIVED"
+	HeaderWarningDelay              = "DELAY"
+	HeaderWarningMissingTimestamp   = "MISSING_TIMESTAMP"
+	HeaderWarningEnvelopeMismatch   = "ENVELOPE_MISMATCH"
+	HeaderWarningDKIMMismatch       = "DKIM_MISMATCH"
+	HeaderWarningReplyToMismatch    = "REPLY_TO_MISMATCH"
+	HeaderWarningAuthenticationFail = "AUTHENTICATION_FAIL"
+	HeaderWarningARCFail            = "ARC_FAIL"
+)
+
+// Constants defining the clock anomaly thresholds in seconds.
+const (
+	// headerClockTolerance is the clock difference between servers which is not reported.
+	headerClockTolerance = 5 * 60
+	// headerDelayThreshold is the time between two hops which is reported as a delay.
+	headerDelayThreshold = 60 * 60
+)
+
+// receivedClauses are the clauses of a Received header (RFC 5321 section 4.4).
+var receivedClauses = map[string]bool{
+	"from": true,
+	"by":   true,
+	"via":  true,
+	"with": true,
+	"id":   true,
+	"for":  true,
+}
+
+// receivedIPRegexp matches the IP address in the comment of the from clause, for example "(mail.example.com [192.0.2.1])".
+var receivedIPRegexp = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f:.]+)\]|\b(\d{1,3}(?:\.\d{1,3}){3})\b`)
+
+// ReceivedHop represents a Received header, the hops are ordered from the sender to the recipient.
+type ReceivedHop struct {
+	From   string `json:"from"`
+	FromIP string `json:"fromIP"`
+	// FromComment is the reverse DNS and HELO information added by the receiving server.
+	FromComment string `json:"fromComment"`
+	By          string `json:"by"`
+	With        string `json:"with"`
+	ID          string `json:"id"`
+	For         string `json:"for"`
+	// Timestamp is the unix timestamp in seconds, zero if missing or invalid.
+	Timestamp int64 `json:"timestamp"`
+	// Delay is the amount of seconds since the previous hop.
+	Delay int64  `json:"delay"`
+	Raw   string `json:"raw"`
+}
+
+// AuthenticationResult represents a method result of an Authentication-Results header (RFC 8601).
+type AuthenticationResult struct {
+	AuthServID string `json:"authServID"`
+	Method     string `json:"method"`
+	Result     string `json:"result"`
+	Reason     string `json:"reason,omitempty"`
+	// Properties contains the properties such as "smtp.mailfrom" and "header.d".
+	Properties map[string]string `json:"properties"`
+}
+
+// ReceivedSPF represents a Received-SPF header (RFC 7208 section 9.1).
+type ReceivedSPF struct {
+	Result       string `json:"result"`
+	Comment      string `json:"comment"`
+	ClientIP     string `json:"clientIP"`
+	EnvelopeFrom string `json:"envelopeFrom"`
+	HELO         string `json:"helo"`
+	Receiver     string `json:"receiver"`
+}
+
+// DKIMSignature represents a DKIM-Signature header (RFC 6376 section 3.5).
+type DKIMSignature struct {
+	Version          string   `json:"version"`
+	Algorithm        string   `json:"algorithm"`
+	Domain           string   `json:"domain"`
+	Selector         string   `json:"selector"`
+	Canonicalization string   `json:"canonicalization"`
+	SignedHeaders    []string `json:"signedHeaders"`
+	BodyHash         string   `json:"bodyHash"`
+	Signature        string   `json:"signature"`
+	Identity         string   `json:"identity,omitempty"`
+	// BodyLength is the amount of signed body bytes, -1 if the whole body is signed.
+	BodyLength int64 `json:"bodyLength"`
+	Timestamp  int64 `json:"timestamp,omitempty"`
+	Expiration int64 `json:"expiration,omitempty"`
+}
+
+// ARCSet represents the ARC headers with the same instance (RFC 8617).
+type ARCSet struct {
+	Instance int `json:"instance"`
+	// ChainValidation is the cv= value of the ARC-Seal (none, pass or fail).
+	ChainValidation       string                 `json:"chainValidation"`
+	SealDomain            string                 `json:"sealDomain"`
+	SealSelector          string                 `json:"sealSelector"`
+	SignatureDomain       string                 `json:"signatureDomain"`
+	SignatureSelector     string                 `json:"signatureSelector"`
+	AuthenticationResults []AuthenticationResult `json:"authenticationResults"`
+}
+
+// HeaderWarning represents an anomaly found in the transport headers.
+type HeaderWarning struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// HeaderAnalysis represents the analysis of the transport headers of a message.
+type HeaderAnalysis struct {
+	MessageUUID           string                 `json:"messageUUID"`
+	FromAddress           string                 `json:"fromAddress"`
+	ReplyToAddress        string                 `json:"replyToAddress"`
+	EnvelopeFrom          string                 `json:"envelopeFrom"`
+	Date                  int64                  `json:"date"`
+	Hops                  []ReceivedHop          `json:"hops"`
+	AuthenticationResults []AuthenticationResult `json:"authenticationResults"`
+	ReceivedSPF           []ReceivedSPF          `json:"receivedSPF"`
+	DKIMSignatures        []DKIMSignature        `json:"dkimSignatures"`
+	ARCSets               []ARCSet               `json:"arcSets"`
+	Warnings              []HeaderWarning        `json:"warnings"`
+}
+
+// handleHeaderAnalysis handles the header analysis endpoint.
+func (server *Server) handleHeaderAnalysis() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			headerAnalysis := NewHeaderAnalysis(message)
+
+			if err := json.NewEncoder(responseWriter).Encode(&headerAnalysis); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// NewHeaderAnalysis analyzes the transport headers of the message.
+// Messages without transport headers (such as drafts) only have the From address.
+func NewHeaderAnalysis(message core.Message) HeaderAnalysis {
+	messageHeaders := ParseTransportHeaders(message.TransportHeaders)
+
+	headerAnalysis := HeaderAnalysis{
+		MessageUUID:           message.UUID,
+		Date:                  int64(message.Date),
+		Hops:                  []ReceivedHop{},
+		AuthenticationResults: []AuthenticationResult{},
+		ReceivedSPF:           []ReceivedSPF{},
+		DKIMSignatures:        []DKIMSignature{},
+		ARCSets:               []ARCSet{},
+		Warnings:              []HeaderWarning{},
+	}
+
+	if from := GetHeaderValues(messageHeaders, "From"); len(from) > 0 {
+		headerAnalysis.FromAddress = getHeaderAddress(from[0])
+	} else {
+		headerAnalysis.FromAddress = getHeaderAddress(message.From)
+	}
+
+	if replyTo := GetHeaderValues(messageHeaders, "Reply-To"); len(replyTo) > 0 {
+		headerAnalysis.ReplyToAddress = getHeaderAddress(replyTo[0])
+	}
+
+	if returnPath := GetHeaderValues(messageHeaders, "Return-Path"); len(returnPath) > 0 {
+		headerAnalysis.EnvelopeFrom = getHeaderAddress(returnPath[0])
+	}
+
+	if date := GetHeaderValues(messageHeaders, "Date"); len(date) > 0 {
+		if parsedDate, err := parseHeaderDate(date[0]); err == nil {
+			headerAnalysis.Date = parsedDate.Unix()
+		}
+	}
+
+	received := GetHeaderValues(messageHeaders, "Received")
+
+	// Each server prepends its Received header, the last header is the first hop.
+	for i := len(received) - 1; i >= 0; i-- {
+		headerAnalysis.Hops = append(headerAnalysis.Hops, ParseReceivedHeader(received[i]))
+	}
+
+	for _, value := range GetHeaderValues(messageHeaders, "Authentication-Results") {
+		headerAnalysis.AuthenticationResults = append(headerAnalysis.AuthenticationResults, ParseAuthenticationResults(value)...)
+	}
+
+	for _, value := range GetHeaderValues(messageHeaders, "Received-SPF") {
+		headerAnalysis.ReceivedSPF = append(headerAnalysis.ReceivedSPF, ParseReceivedSPF(value))
+	}
+
+	for _, value := range GetHeaderValues(messageHeaders, "DKIM-Signature") {
+		headerAnalysis.DKIMSignatures = append(headerAnalysis.DKIMSignatures, ParseDKIMSignature(value))
+	}
+
+	headerAnalysis.ARCSets = ParseARCSets(messageHeaders)
+
+	if headerAnalysis.EnvelopeFrom == "" {
+		headerAnalysis.EnvelopeFrom = headerAnalysis.getEnvelopeFrom()
+	}
+
+	headerAnalysis.setClockWarnings()
+	headerAnalysis.setMismatchWarnings()
+
+	return headerAnalysis
+}
+
+// getEnvelopeFrom returns the envelope sender reported by the receiving servers, used if there is no Return-Path.
+func (headerAnalysis *HeaderAnalysis) getEnvelopeFrom() string {
+	for _, receivedSPF := range headerAnalysis.ReceivedSPF {
+		if receivedSPF.EnvelopeFrom != "" {
+			return receivedSPF.EnvelopeFrom
+		}
+	}
+
+	for _, authenticationResult := range headerAnalysis.AuthenticationResults {
+		if mailFrom := authenticationResult.Properties["smtp.mailfrom"]; mailFrom != "" {
+			return mailFrom
+		}
+	}
+
+	return ""
+}
+
+// addWarning adds a warning to the analysis.
+func (headerAnalysis *HeaderAnalysis) addWarning(warningType string, format string, arguments ...interface{}) {
+	headerAnalysis.Warnings = append(headerAnalysis.Warnings, HeaderWarning{
+		Type:    warningType,
+		Message: fmt.Sprintf(format, arguments...),
+	})
+}
+
+// setClockWarnings sets the hop delays and warns about timestamps going backwards, long delays and a Date
+// header after the first hop.
+func (headerAnalysis *HeaderAnalysis) setClockWarnings() {
+	var previousTimestamp int64
+
+	for i := range headerAnalysis.Hops {
+		hop := &headerAnalysis.Hops[i]
+
+		if hop.Timestamp == 0 {
+			headerAnalysis.addWarning(HeaderWarningMissingTimestamp, "Hop %d (by %s) has no valid timestamp.", i+1, hop.By)
+			continue
+		}
+
+		if previousTimestamp != 0 {
+			hop.Delay = hop.Timestamp - previousTimestamp
+
+			if hop.Delay < -headerClockTolerance {
+				headerAnalysis.addWarning(HeaderWarningClockBackwards, "Hop %d (by %s) is %s earlier than the previous hop.", i+1, hop.By, formatHeaderDuration(-hop.Delay))
+			} else if hop.Delay > headerDelayThreshold {
+				headerAnalysis.addWarning(HeaderWarningDelay, "Hop %d (by %s) is %s after the previous hop.", i+1, hop.By, formatHeaderDuration(hop.Delay))
+			}
+		} else if headerAnalysis.Date != 0 && headerAnalysis.Date-hop.Timestamp > headerClockTolerance {
+			headerAnalysis.addWarning(HeaderWarningDateAfterReceived, "The Date header is %s after the first hop (by %s).", formatHeaderDuration(headerAnalysis.Date-hop.Timestamp), hop.By)
+		}
+
+		previousTimestamp = hop.Timestamp
+	}
+}
+
+// formatHeaderDuration formats the amount of seconds.
+func formatHeaderDuration(seconds int64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// setMismatchWarnings warns about a From domain which differs from the envelope, DKIM or Reply-To domain and about
+// failed authentication.
+func (headerAnalysis *HeaderAnalysis) setMismatchWarnings() {
+	fromDomain := getAddressDomain(headerAnalysis.FromAddress)
+
+	if fromDomain == "" {
+		return
+	}
+
+	if envelopeDomain := getAddressDomain(headerAnalysis.EnvelopeFrom); envelopeDomain != "" && !isAlignedDomain(fromDomain, envelopeDomain) {
+		headerAnalysis.addWarning(HeaderWarningEnvelopeMismatch, "The From domain %s differs from the envelope domain %s.", fromDomain, envelopeDomain)
+	}
+
+	if replyToDomain := getAddressDomain(headerAnalysis.ReplyToAddress); replyToDomain != "" && !isAlignedDomain(fromDomain, replyToDomain) {
+		headerAnalysis.addWarning(HeaderWarningReplyToMismatch, "The From domain %s differs from the Reply-To domain %s.", fromDomain, replyToDomain)
+	}
+
+	if len(headerAnalysis.DKIMSignatures) > 0 {
+		var signingDomains []string
+
+		for _, dkimSignature := range headerAnalysis.DKIMSignatures {
+			if isAlignedDomain(fromDomain, dkimSignature.Domain) {
+				signingDomains = nil
+				break
+			}
+
+			signingDomains = append(signingDomains, dkimSignature.Domain)
+		}
+
+		if len(signingDomains) > 0 {
+			headerAnalysis.addWarning(HeaderWarningDKIMMismatch, "The From domain %s differs from the DKIM signing domain %s.", fromDomain, strings.Join(signingDomains, ", "))
+		}
+	}
+
+	for _, authenticationResult := range headerAnalysis.AuthenticationResults {
+		switch authenticationResult.Result {
+		case "fail", "softfail", "permerror":
+			headerAnalysis.addWarning(HeaderWarningAuthenticationFail, "%s reported %s=%s.", authenticationResult.AuthServID, authenticationResult.Method, authenticationResult.Result)
+		}
+	}
+
+	for _, receivedSPF := range headerAnalysis.ReceivedSPF {
+		switch receivedSPF.Result {
+		case "fail", "softfail", "permerror":
+			headerAnalysis.addWarning(HeaderWarningAuthenticationFail, "%s reported Received-SPF %s.", receivedSPF.Receiver, receivedSPF.Result)
+		}
+	}
+
+	for _, arcSet := range headerAnalysis.ARCSets {
+		if arcSet.ChainValidation == "fail" {
+			headerAnalysis.addWarning(HeaderWarningARCFail, "ARC set %d (sealed by %s) reports a failed chain.", arcSet.Instance, arcSet.SealDomain)
+		}
+	}
+}
+
+// getHeaderAddress returns the lowercase email address of an address header.
+func getHeaderAddress(value string) string {
+	value = strings.TrimSpace(value)
+
+	if value == "" || value == "<>" {
+		return ""
+	}
+
+	if address, err := mail.ParseAddress(value); err == nil {
+		return strings.ToLower(address.Address)
+	}
+
+	return strings.ToLower(entityEmailRegexp.FindString(value))
+}
+
+// getAddressDomain returns the domain of the email address.
+func getAddressDomain(address string) string {
+	if separatorIndex := strings.LastIndex(address, "@"); separatorIndex != -1 {
+		address = address[separatorIndex+1:]
+	}
+
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(address, "<> ")), ".")
+}
+
+// isAlignedDomain returns true if the domains are equal or one is a subdomain of the other (relaxed alignment).
+func isAlignedDomain(a string, b string) bool {
+	a = strings.TrimSuffix(strings.ToLower(a), ".")
+	b = strings.TrimSuffix(strings.ToLower(b), ".")
+
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
+
+// parseHeaderDate parses the date of a header, ignoring trailing comments such as "(UTC)".
+func parseHeaderDate(value string) (time.Time, error) {
+	value, _ = splitHeaderComments(value)
+
+	return mail.ParseDate(strings.Join(strings.Fields(value), " "))
+}
+
+// splitHeaderComments removes the (nested) comments of a header value, returning the value and the comments.
+func splitHeaderComments(value string) (string, []string) {
+	var text strings.Builder
+	var comment strings.Builder
+	var comments []string
+
+	depth := 0
+	isQuoted := false
+
+	for i := 0; i < len(value); i++ {
+		character := value[i]
+
+		switch {
+		case character == '\\' && i+1 < len(value) && (depth > 0 || isQuoted):
+			if depth > 0 {
+				comment.WriteByte(value[i+1])
+			} else {
+				text.WriteByte(character)
+				text.WriteByte(value[i+1])
+			}
+
+			i++
+		case character == '"' && depth == 0:
+			isQuoted = !isQuoted
+			text.WriteByte(character)
+		case character == '(' && !isQuoted:
+			if depth > 0 {
+				comment.WriteByte(character)
+			}
+
+			depth++
+		case character == ')' && depth > 0:
+			depth--
+
+			if depth > 0 {
+				comment.WriteByte(character)
+			} else {
+				comments = append(comments, strings.TrimSpace(comment.String()))
+				comment.Reset()
+				// The comment separates the surrounding words.
+				text.WriteByte(' ')
+			}
+		case depth > 0:
+			comment.WriteByte(character)
+		default:
+			text.WriteByte(character)
+		}
+	}
+
+	return text.String(), comments
+}
+
+// ParseReceivedHeader parses the clauses and timestamp of a Received header.
+func ParseReceivedHeader(value string) ReceivedHop {
+	receivedHop := ReceivedHop{Raw: value}
+
+	// The timestamp follows the last semicolon.
+	if separatorIndex := strings.LastIndex(value, ";"); separatorIndex != -1 {
+		if timestamp, err := parseHeaderDate(value[separatorIndex+1:]); err == nil {
+			receivedHop.Timestamp = timestamp.Unix()
+		}
+
+		value = value[:separatorIndex]
+	}
+
+	clause := ""
+	var fromComments []string
+
+	// The clauses are keyword and value pairs, comments belong to the preceding clause.
+	for len(value) > 0 {
+		value = strings.TrimLeft(value, " \t\r\n")
+
+		if value == "" {
+			break
+		}
+
+		if value[0] == '(' {
+			commentEnd := getCommentEnd(value)
+			_, comments := splitHeaderComments(value[:commentEnd])
+
+			if clause == "from" {
+				fromComments = append(fromComments, comments...)
+			}
+
+			value = value[commentEnd:]
+			continue
+		}
+
+		wordEnd := strings.IndexAny(value, " \t\r\n(")
+
+		if wordEnd == -1 {
+			wordEnd = len(value)
+		}
+
+		word := value[:wordEnd]
+		value = value[wordEnd:]
+
+		if receivedClauses[strings.ToLower(word)] {
+			clause = strings.ToLower(word)
+			continue
+		}
+
+		switch clause {
+		case "from":
+			if receivedHop.From == "" {
+				receivedHop.From = word
+			}
+		case "by":
+			if receivedHop.By == "" {
+				receivedHop.By = word
+			}
+		case "with":
+			if receivedHop.With == "" {
+				receivedHop.With = word
+			}
+		case "id":
+			if receivedHop.ID == "" {
+				receivedHop.ID = word
+			}
+		case "for":
+			if receivedHop.For == "" {
+				receivedHop.For = strings.Trim(word, "<>")
+			}
+		}
+	}
+
+	receivedHop.FromComment = strings.Join(fromComments, " ")
+
+	// The IP address the receiving server saw is in the comment, the host is the (unverified) HELO name.
+	for _, candidate := range append(fromComments, receivedHop.From) {
+		for _, match := range receivedIPRegexp.FindAllStringSubmatch(candidate, -1) {
+			ip := match[1]
+
+			if ip == "" {
+				ip = match[2]
+			}
+
+			if net.ParseIP(ip) != nil {
+				receivedHop.FromIP = ip
+				break
+			}
+		}
+
+		if receivedHop.FromIP != "" {
+			break
+		}
+	}
+
+	return receivedHop
+}
+
+// getCommentEnd returns the index after the comment at the start of the value.
+func getCommentEnd(value string) int {
+	depth := 0
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return len(value)
+}
+
+// splitHeaderValue splits the value by the separator, outside of quoted strings.
+func splitHeaderValue(value string, separator byte) []string {
+	var parts []string
+
+	isQuoted := false
+	start := 0
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			isQuoted = !isQuoted
+		case separator:
+			if !isQuoted {
+				parts = append(parts, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, value[start:])
+}
+
+// parseHeaderParameters parses the key=value pairs separated by whitespace, quoted values are unquoted.
+func parseHeaderParameters(value string) map[string]string {
+	parameters := make(map[string]string)
+
+	for _, field := range splitHeaderFields(value) {
+		separatorIndex := strings.Index(field, "=")
+
+		if separatorIndex <= 0 {
+			continue
+		}
+
+		parameterValue := field[separatorIndex+1:]
+
+		if unquoted, err := strconv.Unquote(parameterValue); err == nil && strings.HasPrefix(parameterValue, `"`) {
+			parameterValue = unquoted
+		}
+
+		parameters[strings.ToLower(field[:separatorIndex])] = parameterValue
+	}
+
+	return parameters
+}
+
+// splitHeaderFields splits the value by whitespace, outside of quoted strings.
+func splitHeaderFields(value string) []string {
+	var fields []string
+	var field strings.Builder
+
+	isQuoted := false
+
+	for i := 0; i < len(value); i++ {
+		character := value[i]
+
+		switch {
+		case character == '\\' && isQuoted && i+1 < len(value):
+			field.WriteByte(character)
+			field.WriteByte(value[i+1])
+			i++
+		case character == '"':
+			isQuoted = !isQuoted
+			field.WriteByte(character)
+		case !isQuoted && (character == ' ' || character == '\t' || character == '\r' || character == '\n'):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteByte(character)
+		}
+	}
+
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
+
+// ParseAuthenticationResults parses the method results of an Authentication-Results header.
+func ParseAuthenticationResults(value string) []AuthenticationResult {
+	var authenticationResults []AuthenticationResult
+
+	value, _ = splitHeaderComments(value)
+	parts := splitHeaderValue(value, ';')
+
+	// The authserv-id may be followed by a version.
+	authServIDFields := strings.Fields(parts[0])
+
+	if len(authServIDFields) == 0 {
+		return nil
+	}
+
+	authServID := authServIDFields[0]
+
+	for _, part := range parts[1:] {
+		fields := splitHeaderFields(part)
+
+		if len(fields) == 0 || strings.EqualFold(fields[0], "none") {
+			continue
+		}
+
+		separatorIndex := strings.Index(fields[0], "=")
+
+		if separatorIndex <= 0 {
+			continue
+		}
+
+		authenticationResult := AuthenticationResult{
+			AuthServID: authServID,
+			// The method may be followed by a version (dkim/1).
+			Method:     strings.ToLower(strings.Split(fields[0][:separatorIndex], "/")[0]),
+			Result:     strings.ToLower(fields[0][separatorIndex+1:]),
+			Properties: parseHeaderParameters(strings.Join(fields[1:], " ")),
+		}
+
+		if reason, ok := authenticationResult.Properties["reason"]; ok {
+			authenticationResult.Reason = reason
+			delete(authenticationResult.Properties, "reason")
+		}
+
+		authenticationResults = append(authenticationResults, authenticationResult)
+	}
+
+	return authenticationResults
+}
+
+// ParseReceivedSPF parses a Received-SPF header.
+func ParseReceivedSPF(value string) ReceivedSPF {
+	value, comments := splitHeaderComments(value)
+	fields := strings.Fields(value)
+
+	receivedSPF := ReceivedSPF{
+		Comment: strings.Join(comments, " "),
+	}
+
+	if len(fields) == 0 {
+		return receivedSPF
+	}
+
+	receivedSPF.Result = strings.ToLower(fields[0])
+	parameters := parseHeaderParameters(strings.ReplaceAll(strings.Join(fields[1:], " "), ";", " "))
+
+	receivedSPF.ClientIP = parameters["client-ip"]
+	receivedSPF.EnvelopeFrom = getHeaderAddress(parameters["envelope-from"])
+	receivedSPF.HELO = parameters["helo"]
+	receivedSPF.Receiver = parameters["receiver"]
+
+	return receivedSPF
+}
+
+// ParseDKIMTags parses a DKIM tag list (RFC 6376 section 3.2), whitespace is removed from the values.
+func ParseDKIMTags(value string) map[string]string {
+	tags := make(map[string]string)
+
+	for _, tag := range strings.Split(value, ";") {
+		separatorIndex := strings.Index(tag, "=")
+
+		if separatorIndex <= 0 {
+			continue
+		}
+
+		tags[strings.TrimSpace(tag[:separatorIndex])] = strings.Join(strings.Fields(tag[separatorIndex+1:]), "")
+	}
+
+	return tags
+}
+
+// ParseDKIMSignature parses a DKIM-Signature header.
+func ParseDKIMSignature(value string) DKIMSignature {
+	tags := ParseDKIMTags(value)
+
+	dkimSignature := DKIMSignature{
+		Version:          tags["v"],
+		Algorithm:        tags["a"],
+		Domain:           strings.ToLower(tags["d"]),
+		Selector:         tags["s"],
+		Canonicalization: tags["c"],
+		SignedHeaders:    []string{},
+		BodyHash:         tags["bh"],
+		Signature:        tags["b"],
+		Identity:         tags["i"],
+		BodyLength:       -1,
+	}
+
+	if dkimSignature.Canonicalization == "" {
+		dkimSignature.Canonicalization = "simple/simple"
+	}
+
+	for _, signedHeader := range strings.Split(tags["h"], ":") {
+		if signedHeader != "" {
+			dkimSignature.SignedHeaders = append(dkimSignature.SignedHeaders, signedHeader)
+		}
+	}
+
+	if bodyLength, err := strconv.ParseInt(tags["l"], 10, 64); err == nil {
+		dkimSignature.BodyLength = bodyLength
+	}
+
+	if timestamp, err := strconv.ParseInt(tags["t"], 10, 64); err == nil {
+		dkimSignature.Timestamp = timestamp
+	}
+
+	if expiration, err := strconv.ParseInt(tags["x"], 10, 64); err == nil {
+		dkimSignature.Expiration = expiration
+	}
+
+	return dkimSignature
+}
+
+// ParseARCSets parses the ARC-Seal, ARC-Message-Signature and ARC-Authentication-Results headers into sets,
+// ordered by instance.
+func ParseARCSets(messageHeaders []MessageHeader) []ARCSet {
+	arcSetsByInstance := make(map[int]*ARCSet)
+
+	getARCSet := func(instance string) *ARCSet {
+		instanceNumber, err := strconv.Atoi(strings.TrimSpace(instance))
+
+		if err != nil {
+			return nil
+		}
+
+		if _, ok := arcSetsByInstance[instanceNumber]; !ok {
+			arcSetsByInstance[instanceNumber] = &ARCSet{Instance: instanceNumber, AuthenticationResults: []AuthenticationResult{}}
+		}
+
+		return arcSetsByInstance[instanceNumber]
+	}
+
+	for _, messageHeader := range messageHeaders {
+		switch strings.ToLower(messageHeader.Name) {
+		case "arc-seal":
+			tags := ParseDKIMTags(messageHeader.Value)
+
+			if arcSet := getARCSet(tags["i"]); arcSet != nil {
+				arcSet.ChainValidation = strings.ToLower(tags["cv"])
+				arcSet.SealDomain = strings.ToLower(tags["d"])
+				arcSet.SealSelector = tags["s"]
+			}
+		case "arc-message-signature":
+			tags := ParseDKIMTags(messageHeader.Value)
+
+			if arcSet := getARCSet(tags["i"]); arcSet != nil {
+				arcSet.SignatureDomain = strings.ToLower(tags["d"])
+				arcSet.SignatureSelector = tags["s"]
+			}
+		case "arc-authentication-results":
+			// The instance tag precedes the Authentication-Results value: "i=1; mx.example.com; spf=pass".
+			separatorIndex := strings.Index(messageHeader.Value, ";")
+
+			if separatorIndex == -1 {
+				continue
+			}
+
+			instance := strings.TrimSpace(messageHeader.Value[:separatorIndex])
+
+			if !strings.HasPrefix(instance, "i=") {
+				continue
+			}
+
+			if arcSet := getARCSet(strings.TrimPrefix(instance, "i=")); arcSet != nil {
+				arcSet.AuthenticationResults = append(arcSet.AuthenticationResults, ParseAuthenticationResults(messageHeader.Value[separatorIndex+1:])...)
+			}
+		}
+	}
+
+	arcSets := []ARCSet{}
+
+	for _, arcSet := range arcSetsByInstance {
+		arcSets = append(arcSets, *arcSet)
+	}
+
+	sort.Slice(arcSets, func(i, j int) bool {
+		return arcSets[i].Instance < arcSets[j].Instance
+	})
+
+	return arcSets
+}
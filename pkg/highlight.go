@@ -0,0 +1,334 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Constants defining the highlight fragments.
+const (
+	DefaultHighlightFragmentSize  = 150
+	MinHighlightFragmentSize      = 20
+	MaxHighlightFragmentSize      = 1000
+	DefaultHighlightFragmentCount = 3
+	MaxHighlightFragmentCount     = 20
+)
+
+// Constants defining the highlight tags, the fragments are HTML encoded so only these tags are HTML.
+const (
+	HighlightPreTag  = "<mark>"
+	HighlightPostTag = "</mark>"
+)
+
+// HighlightFields defines the message fields which are highlighted.
+var HighlightFields = []string{
+	MessageFieldSubject,
+	MessageFieldBody,
+	MessageFieldAttachmentFileName,
+	MessageFieldAttachmentContent,
+}
+
+// HighlightRequest represents the highlight parameters of a search request.
+type HighlightRequest struct {
+	Enabled       bool
+	FragmentSize  int
+	FragmentCount int
+}
+
+// NewHighlightRequest creates the HighlightRequest from the request body.
+// Accepts "highlight", "highlightFragmentSize" and "highlightFragmentCount".
+func NewHighlightRequest(requestBody map[string]interface{}) (HighlightRequest, error) {
+	highlightRequest := HighlightRequest{
+		FragmentSize:  DefaultHighlightFragmentSize,
+		FragmentCount: DefaultHighlightFragmentCount,
+	}
+
+	if requestHighlight, ok := requestBody["highlight"]; ok {
+		highlight, ok := requestHighlight.(bool)
+
+		if !ok {
+			return HighlightRequest{}, errors.New("highlight must be a boolean")
+		}
+
+		highlightRequest.Enabled = highlight
+	}
+
+	if requestFragmentSize, ok := requestBody["highlightFragmentSize"]; ok {
+		fragmentSize, ok := requestFragmentSize.(float64)
+
+		if !ok || fragmentSize < MinHighlightFragmentSize || fragmentSize > MaxHighlightFragmentSize || fragmentSize != float64(int(fragmentSize)) {
+			return HighlightRequest{}, fmt.Errorf("highlightFragmentSize must be a number between %d and %d", MinHighlightFragmentSize, MaxHighlightFragmentSize)
+		}
+
+		highlightRequest.FragmentSize = int(fragmentSize)
+	}
+
+	if requestFragmentCount, ok := requestBody["highlightFragmentCount"]; ok {
+		fragmentCount, ok := requestFragmentCount.(float64)
+
+		if !ok || fragmentCount < 1 || fragmentCount > MaxHighlightFragmentCount || fragmentCount != float64(int(fragmentCount)) {
+			return HighlightRequest{}, fmt.Errorf("highlightFragmentCount must be a number between 1 and %d", MaxHighlightFragmentCount)
+		}
+
+		highlightRequest.FragmentCount = int(fragmentCount)
+	}
+
+	return highlightRequest, nil
+}
+
+// NewHighlight creates the Elasticsearch highlight of the search body.
+func NewHighlight(highlightRequest HighlightRequest) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+	for _, field := range HighlightFields {
+		fields[field] = map[string]interface{}{}
+	}
+
+	// The subject is short, highlight it as a whole.
+	fields[MessageFieldSubject] = map[string]interface{}{
+		"number_of_fragments": 0,
+	}
+
+	return map[string]interface{}{
+		"pre_tags":            []string{HighlightPreTag},
+		"post_tags":           []string{HighlightPostTag},
+		"encoder":             "html",
+		"fragment_size":       highlightRequest.FragmentSize,
+		"number_of_fragments": highlightRequest.FragmentCount,
+		// Extracted attachment text can exceed the default analyzed length (1,000,000 characters).
+		"max_analyzed_offset": MaxExtractedTextSize,
+		"fields":              fields,
+	}
+}
+
+// NewHighlights creates the highlighted fragments by message UUID from the hits of the search page.
+func NewHighlights(hits []ElasticsearchHit, messages []core.Message) map[string]map[string][]string {
+	highlights := make(map[string]map[string][]string)
+
+	for i, hit := range hits {
+		if len(hit.Highlight) > 0 && i < len(messages) {
+			highlights[messages[i].UUID] = hit.Highlight
+		}
+	}
+
+	return highlights
+}
+
+// Constants defining the keyword-in-context limits.
+const (
+	DefaultKeywordInContextSize    = 60
+	MaxKeywordInContextSize        = 500
+	MaxKeywordInContextOccurrences = 1000
+)
+
+// KeywordInContext represents an occurrence of a term in a message with its surrounding text.
+type KeywordInContext struct {
+	// Field is the message field (subject, body, attachments.fileName or attachments.content).
+	Field string `json:"field"`
+	// AttachmentUUID is set if the occurrence is in an attachment.
+	AttachmentUUID string `json:"attachmentUUID,omitempty"`
+	// Offset is the offset in characters of the occurrence in the field.
+	Offset int    `json:"offset"`
+	Before string `json:"before"`
+	Match  string `json:"match"`
+	After  string `json:"after"`
+}
+
+// KeywordInContextResponse represents the keyword-in-context response.
+type KeywordInContextResponse struct {
+	MessageUUID string             `json:"messageUUID"`
+	Term        string             `json:"term"`
+	Occurrences []KeywordInContext `json:"occurrences"`
+	// Truncated is true if there are more than MaxKeywordInContextOccurrences occurrences.
+	Truncated bool `json:"truncated"`
+}
+
+// handleKeywordInContext handles the keyword-in-context endpoint.
+// Requires the "term" query parameter, accepts "contextSize" (characters on each side of the occurrence).
+func (server *Server) handleKeywordInContext() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			term := strings.TrimSpace(request.URL.Query().Get("term"))
+
+			if term == "" {
+				Logger.Errorf("Failed to get request term.")
+				http.Error(responseWriter, "Failed to get request term.", http.StatusBadRequest)
+				return
+			}
+
+			contextSize := DefaultKeywordInContextSize
+
+			if requestContextSize := request.URL.Query().Get("contextSize"); requestContextSize != "" {
+				contextSize, err = strconv.Atoi(requestContextSize)
+
+				if err != nil || contextSize < 0 || contextSize > MaxKeywordInContextSize {
+					Logger.Errorf("Invalid context size: %s", requestContextSize)
+					http.Error(responseWriter, fmt.Sprintf("The contextSize must be a number between 0 and %d.", MaxKeywordInContextSize), http.StatusBadRequest)
+					return
+				}
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			var attachmentTexts map[string]string
+
+			if len(message.Attachments) > 0 {
+				attachmentTexts, err = GetAttachmentTextsByMessage(message.UUID, project.UUID, server.Database)
+
+				if err != nil {
+					Logger.Errorf("Failed to get attachment texts: %s", err)
+					http.Error(responseWriter, "Failed to get attachment texts.", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			occurrences, truncated := GetKeywordsInContext(message, attachmentTexts, term, contextSize)
+
+			keywordInContextResponse := KeywordInContextResponse{
+				MessageUUID: message.UUID,
+				Term:        term,
+				Occurrences: occurrences,
+				Truncated:   truncated,
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&keywordInContextResponse); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// NewKeywordRegexp creates the case-insensitive regular expression matching the term as whole words.
+// Whitespace in the term matches any whitespace so phrases match across line breaks.
+func NewKeywordRegexp(term string) *regexp.Regexp {
+	words := strings.Fields(term)
+
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+
+	pattern := strings.Join(words, `\s+`)
+
+	if isASCIIWordByte(term[0]) {
+		pattern = `\b` + pattern
+	}
+
+	if isASCIIWordByte(term[len(term)-1]) {
+		pattern = pattern + `\b`
+	}
+
+	return regexp.MustCompile(`(?i)` + pattern)
+}
+
+// isASCIIWordByte returns true if the byte is matched by \w.
+func isASCIIWordByte(character byte) bool {
+	return character == '_' || (character >= '0' && character <= '9') || (character >= 'a' && character <= 'z') || (character >= 'A' && character <= 'Z')
+}
+
+// GetKeywordsInContext returns every occurrence of the term in the subject, body, attachment file names and extracted
+// attachment texts (by attachment UUID) of the message.
+// Returns true if the occurrences are truncated at MaxKeywordInContextOccurrences.
+func GetKeywordsInContext(message core.Message, attachmentTexts map[string]string, term string, contextSize int) ([]KeywordInContext, bool) {
+	keywordRegexp := NewKeywordRegexp(term)
+	occurrences := []KeywordInContext{}
+
+	addOccurrences := func(field string, attachmentUUID string, text string) bool {
+		runeOffset := 0
+		lastByteOffset := 0
+
+		for _, match := range keywordRegexp.FindAllStringIndex(text, -1) {
+			if len(occurrences) == MaxKeywordInContextOccurrences {
+				return false
+			}
+
+			runeOffset += utf8.RuneCountInString(text[lastByteOffset:match[0]])
+			lastByteOffset = match[0]
+
+			occurrences = append(occurrences, KeywordInContext{
+				Field:          field,
+				AttachmentUUID: attachmentUUID,
+				Offset:         runeOffset,
+				Before:         getContextBefore(text[:match[0]], contextSize),
+				Match:          text[match[0]:match[1]],
+				After:          getContextAfter(text[match[1]:], contextSize),
+			})
+		}
+
+		return true
+	}
+
+	if !addOccurrences(MessageFieldSubject, "", message.Subject) || !addOccurrences(MessageFieldBody, "", message.Body) {
+		return occurrences, true
+	}
+
+	for _, attachment := range message.Attachments {
+		if !addOccurrences(MessageFieldAttachmentFileName, attachment.UUID, attachment.FileName) {
+			return occurrences, true
+		}
+
+		if !addOccurrences(MessageFieldAttachmentContent, attachment.UUID, attachmentTexts[attachment.UUID]) {
+			return occurrences, true
+		}
+	}
+
+	return occurrences, false
+}
+
+// getContextBefore returns the last contextSize characters of the text with collapsed whitespace.
+func getContextBefore(text string, contextSize int) string {
+	start := len(text)
+
+	for i := 0; i < contextSize && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+
+	return collapseWhitespace(text[start:])
+}
+
+// getContextAfter returns the first contextSize characters of the text with collapsed whitespace.
+func getContextAfter(text string, contextSize int) string {
+	end := 0
+
+	for i := 0; i < contextSize && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+
+	return collapseWhitespace(text[:end])
+}
+
+// whitespaceRegexp matches whitespace including line breaks.
+var whitespaceRegexp = regexp.MustCompile(`\s+`)
+
+// collapseWhitespace replaces whitespace (line breaks) by a single space so the context is shown on one line.
+func collapseWhitespace(text string) string {
+	return whitespaceRegexp.ReplaceAllString(text, " ")
+}
@@ -0,0 +1,831 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"golang.org/x/net/html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// Constants defining how remote resources (images loaded from the internet) are rendered.
+const (
+	// RemoteContentBlock removes remote resources, nothing is loaded.
+	RemoteContentBlock = "block"
+	// RemoteContentProxy loads remote images via the API, so the reviewer's browser never contacts the sender.
+	RemoteContentProxy = "proxy"
+)
+
+// Constants defining the remote content proxy.
+const (
+	MaxRemoteContentSize   = 5 * 1024 * 1024
+	remoteContentTimeout   = 10 * time.Second
+	remoteContentRedirects = 3
+)
+
+// sanitizedElements defines the elements kept by the HTML sanitizer.
+var sanitizedElements = map[string]bool{
+	"a": true, "abbr": true, "address": true, "b": true, "big": true, "blockquote": true, "br": true, "caption": true,
+	"center": true, "cite": true, "code": true, "col": true, "colgroup": true, "dd": true, "del": true, "div": true,
+	"dl": true, "dt": true, "em": true, "font": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "hr": true, "i": true, "img": true, "ins": true, "kbd": true, "li": true, "ol": true, "p": true,
+	"pre": true, "q": true, "s": true, "samp": true, "small": true, "span": true, "strike": true, "strong": true,
+	"sub": true, "sup": true, "table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"tr": true, "tt": true, "u": true, "ul": true,
+}
+
+// droppedElements defines the elements removed including their content, void elements (such as input) are removed
+// by not being sanitized elements.
+var droppedElements = map[string]bool{
+	"applet": true, "audio": true, "button": true, "frameset": true, "head": true, "iframe": true, "math": true,
+	"noscript": true, "object": true, "script": true, "select": true, "style": true, "svg": true, "template": true,
+	"textarea": true, "title": true, "video": true,
+}
+
+// voidElements defines the sanitized elements without an end tag.
+var voidElements = map[string]bool{
+	"br": true, "col": true, "hr": true, "img": true,
+}
+
+// impliedEndElements defines the open elements closed by opening the element, for example "<li>one<li>two".
+var impliedEndElements = map[string][]string{
+	"dd": {"dd", "dt"},
+	"dt": {"dd", "dt"},
+	"li": {"li"},
+	"p":  {"p"},
+	"td": {"td", "th"},
+	"th": {"td", "th"},
+	"tr": {"tr"},
+}
+
+// impliedEndBoundaries defines the elements whose content is not closed by impliedEndElements.
+var impliedEndBoundaries = map[string]bool{
+	"dl": true, "ol": true, "table": true, "ul": true,
+}
+
+// sanitizedAttributes defines the attributes kept per element, "*" applies to every element.
+var sanitizedAttributes = map[string]map[string]bool{
+	"*":        {"align": true, "bgcolor": true, "border": true, "color": true, "dir": true, "height": true, "lang": true, "style": true, "title": true, "valign": true, "width": true},
+	"a":        {"href": true},
+	"col":      {"span": true},
+	"colgroup": {"span": true},
+	"font":     {"face": true, "size": true},
+	"img":      {"alt": true, "src": true},
+	"ol":       {"start": true, "type": true},
+	"table":    {"background": true, "cellpadding": true, "cellspacing": true},
+	"td":       {"background": true, "colspan": true, "rowspan": true, "nowrap": true},
+	"th":       {"background": true, "colspan": true, "rowspan": true, "nowrap": true},
+}
+
+// resourceAttributes defines the attributes which load a resource when rendered.
+var resourceAttributes = map[string]bool{
+	"src":        true,
+	"background": true,
+}
+
+// dataImageRegexp matches the inline (data:) images which can not contain scripts.
+var dataImageRegexp = regexp.MustCompile(`^data:image/(png|gif|jpeg|jpg|webp|bmp);base64,[A-Za-z0-9+/=\s]+$`)
+
+// unsafeStyleRegexp matches the CSS values which load resources or run code, quoted strings and escapes are
+// rejected since they can hide these.
+var unsafeStyleRegexp = regexp.MustCompile(`(?i)url\s*\(|image-set\s*\(|src\s*\(|image\s*\(|element\s*\(|expression\s*\(|javascript:|behavior|binding|@import|["'\\]`)
+
+// allowedStyleProperties defines the CSS properties kept by sanitizeStyle, other properties are removed.
+var allowedStyleProperties = map[string]bool{
+	"background": true, "background-color": true, "border": true, "border-bottom": true, "border-collapse": true,
+	"border-color": true, "border-left": true, "border-radius": true, "border-right": true, "border-spacing": true,
+	"border-style": true, "border-top": true, "border-width": true, "clear": true, "color": true, "direction": true,
+	"display": true, "float": true, "font": true, "font-family": true, "font-size": true, "font-style": true,
+	"font-variant": true, "font-weight": true, "height": true, "letter-spacing": true, "line-height": true,
+	"list-style-type": true, "margin": true, "margin-bottom": true, "margin-left": true, "margin-right": true,
+	"margin-top": true, "max-height": true, "max-width": true, "min-height": true, "min-width": true,
+	"padding": true, "padding-bottom": true, "padding-left": true, "padding-right": true, "padding-top": true,
+	"table-layout": true, "text-align": true, "text-decoration": true, "text-indent": true, "text-transform": true,
+	"vertical-align": true, "white-space": true, "width": true, "word-break": true, "word-spacing": true,
+	"word-wrap": true,
+}
+
+// textBlockElements defines the elements rendered on their own lines in the plain text.
+var textBlockElements = map[string]bool{
+	"address": true, "blockquote": true, "center": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true, "li": true, "ol": true,
+	"p": true, "pre": true, "table": true, "tr": true, "ul": true,
+}
+
+// RenderedHTML represents the sanitized HTML body and the plain text of a message.
+type RenderedHTML struct {
+	MessageUUID   string `json:"messageUUID"`
+	RemoteContent string `json:"remoteContent"`
+	HTML          string `json:"html"`
+	Text          string `json:"text"`
+	// IsRedacted is true if the body has redactions, the redacted plain text body is rendered instead of the HTML body.
+	IsRedacted bool `json:"isRedacted"`
+	// BlockedResources are the remote resources removed, ProxiedResources the remote resources loaded via the API.
+	BlockedResources []string `json:"blockedResources"`
+	ProxiedResources []string `json:"proxiedResources"`
+}
+
+// htmlSanitizer sanitizes the HTML body of a message.
+type htmlSanitizer struct {
+	messageUUID   string
+	remoteContent string
+	// inlineAttachments maps the content IDs to the attachment UUIDs.
+	inlineAttachments map[string]string
+	renderedHTML      *RenderedHTML
+}
+
+// handleMessageHTML handles the message HTML endpoint, rendering the sanitized HTML body.
+// A message with body redactions is rendered as the redacted plain text body.
+// Accepts the "remoteContent" (block, proxy) and "format" (json, html) query parameters.
+func (server *Server) handleMessageHTML() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			remoteContent := request.URL.Query().Get("remoteContent")
+
+			if remoteContent == "" {
+				remoteContent = RemoteContentBlock
+			} else if remoteContent != RemoteContentBlock && remoteContent != RemoteContentProxy {
+				Logger.Errorf("Invalid remote content: %s", remoteContent)
+				http.Error(responseWriter, "Invalid message HTML request: remoteContent must be block or proxy.", http.StatusBadRequest)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			redactions, err := GetRedactionsByMessage(message.UUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get redactions: %s", err)
+				http.Error(responseWriter, "Failed to get redactions.", http.StatusInternalServerError)
+				return
+			}
+
+			redactedMessage := RedactMessage(message, redactions)
+			renderedHTML := RenderMessageHTML(redactedMessage, remoteContent)
+			renderedHTML.IsRedacted = len(getTargetRedactions(redactions, "")) > 0
+
+			if request.URL.Query().Get("format") == "html" {
+				// The sanitized HTML may only load the inline attachments and proxied images from the API.
+				responseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+				responseWriter.Header().Set("Content-Security-Policy", fmt.Sprintf("default-src 'none'; img-src %s data:; style-src 'unsafe-inline'; base-uri 'none'; form-action 'none'", GoForensicsAPIURL))
+				responseWriter.Header().Set("X-Content-Type-Options", "nosniff")
+				responseWriter.Header().Set("Referrer-Policy", "no-referrer")
+
+				if _, err := fmt.Fprintf(responseWriter, "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><base target=\"_blank\"></head><body>%s</body></html>", renderedHTML.HTML); err != nil {
+					Logger.Errorf("Failed to write response: %s", err)
+				}
+
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&renderedHTML); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleMessageInlineAttachment handles the message inline attachment endpoint, used by the cid: references of the
+// rendered HTML. Only images are displayed inline, other attachments are downloaded. Attachments with redactions are refused.
+func (server *Server) handleMessageInlineAttachment() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			attachmentUUID := mux.Vars(request)["attachmentUUID"]
+
+			var attachment *core.Attachment
+
+			for i := range message.Attachments {
+				if message.Attachments[i].UUID == attachmentUUID {
+					attachment = &message.Attachments[i]
+					break
+				}
+			}
+
+			if attachment == nil {
+				Logger.Errorf("Failed to find attachment %s of message %s", attachmentUUID, message.UUID)
+				http.Error(responseWriter, "Failed to find attachment.", http.StatusNotFound)
+				return
+			}
+
+			redactions, err := GetRedactionsByMessage(message.UUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get redactions: %s", err)
+				http.Error(responseWriter, "Failed to get redactions.", http.StatusInternalServerError)
+				return
+			}
+
+			// The attachment file is the original, which can't be redacted.
+			if len(getTargetRedactions(redactions, attachment.UUID)) > 0 {
+				Logger.Errorf("Refused to download the redacted attachment %s of message %s", attachment.UUID, message.UUID)
+				http.Error(responseWriter, "The attachment has redactions, produce it as a redacted exhibit or via /redacted instead.", http.StatusConflict)
+				return
+			}
+
+			responseWriter.Header().Set("X-Content-Type-Options", "nosniff")
+			responseWriter.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+
+			if isSafeImageType(attachment.MimeType) {
+				responseWriter.Header().Set("Content-Type", attachment.MimeType)
+			} else {
+				responseWriter.Header().Set("Content-Type", MIMETypeOctetStream)
+				responseWriter.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+			}
+
+			if err := WriteAttachmentData(attachment.UUID, project.UUID, responseWriter); err != nil {
+				Logger.Errorf("Failed to write attachment: %s", err)
+			}
+		}
+	}
+}
+
+// handleRemoteContent handles the remote content endpoint, proxying a remote image of a rendered message.
+// Accepts the "url" query parameter.
+func (server *Server) handleRemoteContent() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, _, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			contentType, content, err := GetRemoteContent(request.URL.Query().Get("url"))
+
+			if err != nil {
+				Logger.Errorf("Failed to get remote content: %s", err)
+				http.Error(responseWriter, "Failed to get remote content.", http.StatusBadGateway)
+				return
+			}
+
+			responseWriter.Header().Set("Content-Type", contentType)
+			responseWriter.Header().Set("Cache-Control", "private, max-age=3600")
+			responseWriter.Header().Set("X-Content-Type-Options", "nosniff")
+			responseWriter.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+
+			if _, err := responseWriter.Write(content); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+			}
+		}
+	}
+}
+
+// isSafeImageType returns true for the image types which can be displayed without running scripts (not SVG).
+func isSafeImageType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	return err == nil && strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml"
+}
+
+// remoteContentClient fetches remote images, only from public addresses so the proxy can not reach internal services.
+var remoteContentClient = &http.Client{
+	Timeout: remoteContentTimeout,
+	Transport: &http.Transport{
+		// Environment proxies would bypass the address check.
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: remoteContentTimeout,
+			Control: checkRemoteContentAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:   remoteContentTimeout,
+		ResponseHeaderTimeout: remoteContentTimeout,
+	},
+	CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		if len(via) >= remoteContentRedirects {
+			return errors.New("too many redirects")
+		}
+
+		if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+			return fmt.Errorf("unsupported redirect scheme: %s", request.URL.Scheme)
+		}
+
+		return nil
+	},
+}
+
+// carrierGradeNAT is the shared address space (RFC 6598) which is not reachable from the internet.
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkRemoteContentAddress returns an error if the dialed address is not a public address.
+// Runs for every connection after DNS resolution, including redirects.
+func checkRemoteContentAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || carrierGradeNAT.Contains(ip) {
+		return fmt.Errorf("remote content address is not public: %s", host)
+	}
+
+	return nil
+}
+
+// GetRemoteContent fetches the remote image, returning the content type and content.
+// No cookies or referrer are sent.
+func GetRemoteContent(rawURL string) (string, []byte, error) {
+	remoteURL, err := url.Parse(rawURL)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if remoteURL.Scheme != "http" && remoteURL.Scheme != "https" {
+		return "", nil, fmt.Errorf("unsupported scheme: %s", remoteURL.Scheme)
+	}
+
+	request, err := http.NewRequest("GET", remoteURL.String(), nil)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	request.Header.Set("User-Agent", "Mozilla/5.0")
+	request.Header.Set("Accept", "image/*")
+
+	response, err := remoteContentClient.Do(request)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			Logger.Errorf("Failed to close response body: %s", err)
+		}
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("unexpected status: %s", response.Status)
+	}
+
+	contentType := response.Header.Get("Content-Type")
+
+	if !isSafeImageType(contentType) {
+		return "", nil, fmt.Errorf("unsupported content type: %s", contentType)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(response.Body, MaxRemoteContentSize+1))
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(content) > MaxRemoteContentSize {
+		return "", nil, fmt.Errorf("remote content exceeds %d bytes", MaxRemoteContentSize)
+	}
+
+	return contentType, content, nil
+}
+
+// RenderMessageHTML sanitizes the HTML body of the message and creates the plain text fallback.
+// Scripts, forms, frames, event handlers and style sheets are removed, cid: references are rewritten to the inline
+// attachment endpoint and remote resources are blocked or rewritten to the remote content endpoint.
+// The plain text is created from the HTML body, or is the text body if there is no HTML body.
+func RenderMessageHTML(message core.Message, remoteContent string) RenderedHTML {
+	renderedHTML := RenderedHTML{
+		MessageUUID:      message.UUID,
+		RemoteContent:    remoteContent,
+		BlockedResources: []string{},
+		ProxiedResources: []string{},
+	}
+
+	if message.BodyHTML == "" {
+		renderedHTML.HTML = "<pre>" + html.EscapeString(message.Body) + "</pre>"
+		renderedHTML.Text = message.Body
+
+		return renderedHTML
+	}
+
+	sanitizer := htmlSanitizer{
+		messageUUID:       message.UUID,
+		remoteContent:     remoteContent,
+		inlineAttachments: make(map[string]string),
+		renderedHTML:      &renderedHTML,
+	}
+
+	for _, attachment := range message.Attachments {
+		if attachment.ContentID != "" {
+			sanitizer.inlineAttachments[normalizeContentID(attachment.ContentID)] = attachment.UUID
+		}
+	}
+
+	renderedHTML.HTML = sanitizer.Sanitize(message.BodyHTML)
+	renderedHTML.Text = HTMLToText(message.BodyHTML)
+
+	return renderedHTML
+}
+
+// normalizeContentID returns the content ID without angle brackets, lowercase.
+func normalizeContentID(contentID string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(contentID), "<>"))
+}
+
+// Sanitize returns the sanitized HTML, every opened element is closed.
+func (sanitizer *htmlSanitizer) Sanitize(bodyHTML string) string {
+	var output strings.Builder
+	var openElements []string
+
+	tokenizer := html.NewTokenizer(strings.NewReader(bodyHTML))
+	droppedElement := ""
+	droppedDepth := 0
+
+	for {
+		tokenType := tokenizer.Next()
+
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := tokenizer.Token()
+
+		if droppedDepth > 0 {
+			if token.Data == droppedElement {
+				if tokenType == html.StartTagToken {
+					droppedDepth++
+				} else if tokenType == html.EndTagToken {
+					droppedDepth--
+				}
+			}
+
+			continue
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			output.WriteString(html.EscapeString(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedElements[token.Data] {
+				if tokenType == html.StartTagToken {
+					droppedElement = token.Data
+					droppedDepth = 1
+				}
+
+				continue
+			}
+
+			if !sanitizedElements[token.Data] {
+				continue
+			}
+
+			openElements = closeImpliedElements(&output, openElements, token.Data)
+
+			output.WriteString("<" + token.Data)
+
+			for _, attribute := range sanitizer.sanitizeAttributes(token) {
+				output.WriteString(fmt.Sprintf(` %s="%s"`, attribute.Key, html.EscapeString(attribute.Val)))
+			}
+
+			output.WriteString(">")
+
+			if !voidElements[token.Data] && tokenType == html.StartTagToken {
+				openElements = append(openElements, token.Data)
+			}
+		case html.EndTagToken:
+			// Close the element and the elements opened within it, end tags of elements which are not open are ignored.
+			for i := len(openElements) - 1; i >= 0; i-- {
+				if openElements[i] == token.Data {
+					for j := len(openElements) - 1; j >= i; j-- {
+						output.WriteString("</" + openElements[j] + ">")
+					}
+
+					openElements = openElements[:i]
+					break
+				}
+			}
+		}
+	}
+
+	for i := len(openElements) - 1; i >= 0; i-- {
+		output.WriteString("</" + openElements[i] + ">")
+	}
+
+	return output.String()
+}
+
+// closeImpliedElements closes the open elements implicitly ended by opening the element.
+func closeImpliedElements(output *strings.Builder, openElements []string, element string) []string {
+	for i := len(openElements) - 1; i >= 0 && !impliedEndBoundaries[openElements[i]]; i-- {
+		for _, impliedEndElement := range impliedEndElements[element] {
+			if openElements[i] == impliedEndElement {
+				for j := len(openElements) - 1; j >= i; j-- {
+					output.WriteString("</" + openElements[j] + ">")
+				}
+
+				return openElements[:i]
+			}
+		}
+	}
+
+	return openElements
+}
+
+// sanitizeAttributes returns the allowed attributes of the element with the URLs rewritten.
+func (sanitizer *htmlSanitizer) sanitizeAttributes(token html.Token) []html.Attribute {
+	var attributes []html.Attribute
+
+	for _, attribute := range token.Attr {
+		if attribute.Namespace != "" || (!sanitizedAttributes["*"][attribute.Key] && !sanitizedAttributes[token.Data][attribute.Key]) {
+			continue
+		}
+
+		value := strings.TrimSpace(attribute.Val)
+
+		switch {
+		case attribute.Key == "style":
+			value = sanitizeStyle(value)
+		case attribute.Key == "href":
+			value = sanitizeLink(value)
+		case resourceAttributes[attribute.Key]:
+			value = sanitizer.sanitizeResource(value)
+		}
+
+		if value == "" {
+			continue
+		}
+
+		attributes = append(attributes, html.Attribute{Key: attribute.Key, Val: value})
+	}
+
+	if token.Data == "a" {
+		attributes = append(attributes,
+			html.Attribute{Key: "target", Val: "_blank"},
+			html.Attribute{Key: "rel", Val: "noopener noreferrer nofollow"},
+		)
+	}
+
+	return attributes
+}
+
+// sanitizeResource returns the URL which may be loaded for the resource, empty if the resource is removed.
+func (sanitizer *htmlSanitizer) sanitizeResource(value string) string {
+	lowerValue := strings.ToLower(value)
+
+	switch {
+	case strings.HasPrefix(lowerValue, "cid:"):
+		contentID, err := url.PathUnescape(value[len("cid:"):])
+
+		if err != nil {
+			return ""
+		}
+
+		attachmentUUID, ok := sanitizer.inlineAttachments[normalizeContentID(contentID)]
+
+		if !ok {
+			return ""
+		}
+
+		return fmt.Sprintf("%s/message/%s/inline/%s", GoForensicsAPIURL, url.PathEscape(sanitizer.messageUUID), url.PathEscape(attachmentUUID))
+	case strings.HasPrefix(lowerValue, "data:"):
+		if dataImageRegexp.MatchString(value) {
+			return value
+		}
+
+		return ""
+	case strings.HasPrefix(lowerValue, "http://"), strings.HasPrefix(lowerValue, "https://"), strings.HasPrefix(lowerValue, "//"):
+		if strings.HasPrefix(lowerValue, "//") {
+			value = "https:" + value
+		}
+
+		if sanitizer.remoteContent == RemoteContentProxy {
+			sanitizer.renderedHTML.ProxiedResources = append(sanitizer.renderedHTML.ProxiedResources, value)
+
+			return fmt.Sprintf("%s/remoteContent?url=%s", GoForensicsAPIURL, url.QueryEscape(value))
+		}
+
+		sanitizer.renderedHTML.BlockedResources = append(sanitizer.renderedHTML.BlockedResources, value)
+
+		return ""
+	default:
+		// Relative URLs can not be resolved and other schemes are not loaded.
+		return ""
+	}
+}
+
+// sanitizeLink returns the link if it is a web or mail link, links are only followed when clicked.
+func sanitizeLink(value string) string {
+	linkURL, err := url.Parse(value)
+
+	if err != nil {
+		return ""
+	}
+
+	switch strings.ToLower(linkURL.Scheme) {
+	case "http", "https", "mailto":
+		return linkURL.String()
+	default:
+		return ""
+	}
+}
+
+// sanitizeStyle keeps the CSS declarations of the allowed properties which do not load resources or run code.
+// Positioning and generated content are not allowed, so content can not be placed over the page.
+func sanitizeStyle(value string) string {
+	var declarations []string
+
+	for _, declaration := range strings.Split(value, ";") {
+		separatorIndex := strings.Index(declaration, ":")
+
+		if separatorIndex == -1 || unsafeStyleRegexp.MatchString(declaration) {
+			continue
+		}
+
+		property := strings.ToLower(strings.TrimSpace(declaration[:separatorIndex]))
+
+		if !allowedStyleProperties[property] {
+			continue
+		}
+
+		declarations = append(declarations, property+": "+strings.TrimSpace(declaration[separatorIndex+1:]))
+	}
+
+	return strings.Join(declarations, "; ")
+}
+
+// HTMLToText converts the HTML to plain text, block elements are put on their own lines and link URLs follow the
+// link text.
+func HTMLToText(bodyHTML string) string {
+	var output strings.Builder
+
+	tokenizer := html.NewTokenizer(strings.NewReader(bodyHTML))
+	droppedElement := ""
+	droppedDepth := 0
+	preDepth := 0
+	linkURL := ""
+	linkStart := 0
+
+	newLine := func() {
+		if output.Len() > 0 && !strings.HasSuffix(output.String(), "\n") {
+			output.WriteString("\n")
+		}
+	}
+
+	for {
+		tokenType := tokenizer.Next()
+
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := tokenizer.Token()
+
+		if droppedDepth > 0 {
+			if token.Data == droppedElement {
+				if tokenType == html.StartTagToken {
+					droppedDepth++
+				} else if tokenType == html.EndTagToken {
+					droppedDepth--
+				}
+			}
+
+			continue
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			text := token.Data
+
+			if preDepth == 0 {
+				text = strings.Join(strings.Fields(text), " ")
+
+				if text == "" {
+					continue
+				}
+
+				// Keep the whitespace between inline elements.
+				if strings.TrimLeftFunc(token.Data, unicode.IsSpace) != token.Data && output.Len() > 0 && !strings.HasSuffix(output.String(), "\n") {
+					text = " " + text
+				}
+
+				if strings.TrimRightFunc(token.Data, unicode.IsSpace) != token.Data {
+					text += " "
+				}
+			}
+
+			output.WriteString(text)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch {
+			case droppedElements[token.Data]:
+				if tokenType == html.StartTagToken {
+					droppedElement = token.Data
+					droppedDepth = 1
+				}
+			case token.Data == "br":
+				output.WriteString("\n")
+			case token.Data == "li":
+				newLine()
+				output.WriteString("- ")
+			case token.Data == "td" || token.Data == "th":
+				if !strings.HasSuffix(output.String(), "\n") && output.Len() > 0 {
+					output.WriteString("\t")
+				}
+			case token.Data == "a":
+				linkURL = ""
+				linkStart = output.Len()
+
+				for _, attribute := range token.Attr {
+					if attribute.Key == "href" {
+						linkURL = sanitizeLink(strings.TrimSpace(attribute.Val))
+					}
+				}
+			case textBlockElements[token.Data]:
+				newLine()
+
+				if token.Data == "pre" {
+					preDepth++
+				}
+			}
+		case html.EndTagToken:
+			switch {
+			case token.Data == "a":
+				linkText := output.String()[linkStart:]
+
+				if linkURL != "" && !strings.Contains(linkText, strings.TrimPrefix(linkURL, "mailto:")) {
+					output.WriteString(" <" + linkURL + ">")
+				}
+
+				linkURL = ""
+			case textBlockElements[token.Data]:
+				newLine()
+
+				if token.Data == "pre" && preDepth > 0 {
+					preDepth--
+				}
+			}
+		}
+	}
+
+	// Remove the trailing whitespace of the lines and the blank lines exceeding one.
+	var lines []string
+
+	blankLines := 0
+
+	for _, line := range strings.Split(output.String(), "\n") {
+		line = strings.TrimRight(line, " \t")
+
+		if line == "" {
+			blankLines++
+
+			if blankLines > 1 || len(lines) == 0 {
+				continue
+			}
+		} else {
+			blankLines = 0
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
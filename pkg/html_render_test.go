@@ -0,0 +1,241 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testAPIURL is the API URL used by the rewritten resource URLs in the tests.
+const testAPIURL = "https://api.example.com"
+
+// newTestHTMLMessage returns a message with the HTML body and an inline image attachment.
+func newTestHTMLMessage(bodyHTML string) core.Message {
+	return core.Message{
+		UUID:     "message-1",
+		BodyHTML: bodyHTML,
+		Attachments: []core.Attachment{
+			{UUID: "attachment-1", FileName: "logo.png", MimeType: "image/png", ContentID: "<Logo@Example>"},
+		},
+	}
+}
+
+func TestRenderMessageHTML(t *testing.T) {
+	apiURL := GoForensicsAPIURL
+	GoForensicsAPIURL = testAPIURL
+
+	defer func() {
+		GoForensicsAPIURL = apiURL
+	}()
+
+	tests := []struct {
+		Name          string
+		BodyHTML      string
+		RemoteContent string
+		Expected      string
+	}{
+		{
+			Name:     "script",
+			BodyHTML: `<p>Hello<script>alert(1)</script> world</p>`,
+			Expected: `<p>Hello world</p>`,
+		},
+		{
+			// A script ends at its first end tag, the rest is sanitized as HTML.
+			Name:     "nested script",
+			BodyHTML: `<script><script>alert(1)</script><img src=x onerror=alert(2)></script><b>ok</b>`,
+			Expected: `<img><b>ok</b>`,
+		},
+		{
+			Name:     "style sheet",
+			BodyHTML: `<style>body { background: url(https://tracker.example.com/pixel) }</style><p>Text</p>`,
+			Expected: `<p>Text</p>`,
+		},
+		{
+			Name:     "iframe",
+			BodyHTML: `<iframe src="https://evil.example.com/">fallback</iframe><p>Text</p>`,
+			Expected: `<p>Text</p>`,
+		},
+		{
+			Name:     "svg",
+			BodyHTML: `<svg onload="alert(1)"><script>alert(2)</script></svg><p>Text</p>`,
+			Expected: `<p>Text</p>`,
+		},
+		{
+			Name:     "form elements",
+			BodyHTML: `<form action="https://evil.example.com/"><input name="password"><button>Send</button></form><p>Text</p>`,
+			Expected: `<p>Text</p>`,
+		},
+		{
+			Name:     "event handlers",
+			BodyHTML: `<p onclick="alert(1)" onmouseover="alert(2)" title="Greeting">Hi</p><img src="data:image/png;base64,AAAA" onerror="alert(3)">`,
+			Expected: `<p title="Greeting">Hi</p><img src="data:image/png;base64,AAAA">`,
+		},
+		{
+			Name:     "javascript link",
+			BodyHTML: `<a href="javascript:alert(1)">one</a><a href=" JaVaScRiPt:alert(2)">two</a><a href="java&#x09;script:alert(3)">three</a>`,
+			Expected: `<a target="_blank" rel="noopener noreferrer nofollow">one</a><a target="_blank" rel="noopener noreferrer nofollow">two</a><a target="_blank" rel="noopener noreferrer nofollow">three</a>`,
+		},
+		{
+			Name:     "data and vbscript links",
+			BodyHTML: `<a href="data:text/html,<script>alert(1)</script>">one</a><a href="vbscript:msgbox(1)">two</a>`,
+			Expected: `<a target="_blank" rel="noopener noreferrer nofollow">one</a><a target="_blank" rel="noopener noreferrer nofollow">two</a>`,
+		},
+		{
+			Name:     "web and mail links",
+			BodyHTML: `<a href="https://www.example.com/page?a=1&amp;b=2">web</a> <a href="mailto:alice@example.com">mail</a>`,
+			Expected: `<a href="https://www.example.com/page?a=1&amp;b=2" target="_blank" rel="noopener noreferrer nofollow">web</a> <a href="mailto:alice@example.com" target="_blank" rel="noopener noreferrer nofollow">mail</a>`,
+		},
+		{
+			Name:     "style url",
+			BodyHTML: `<div style="color: red; background: url(https://tracker.example.com/pixel)">Text</div>`,
+			Expected: `<div style="color: red">Text</div>`,
+		},
+		{
+			Name:     "style image-set",
+			BodyHTML: `<div style="background-image: image-set(&#34;https://tracker.example.com/1x.png&#34; 1x); color: blue">Text</div>`,
+			Expected: `<div style="color: blue">Text</div>`,
+		},
+		{
+			Name:     "style webkit image-set",
+			BodyHTML: `<div style="background: -webkit-image-set(https://tracker.example.com/1x.png 1x)">Text</div>`,
+			Expected: `<div>Text</div>`,
+		},
+		{
+			Name:     "style src",
+			BodyHTML: `<div style="background: src(https://tracker.example.com/pixel); font-weight: bold">Text</div>`,
+			Expected: `<div style="font-weight: bold">Text</div>`,
+		},
+		{
+			Name:     "style escapes",
+			BodyHTML: `<div style="background: u\72l(https://tracker.example.com/pixel); width: 10px">Text</div>`,
+			Expected: `<div style="width: 10px">Text</div>`,
+		},
+		{
+			Name:     "style quoted strings",
+			BodyHTML: `<div style="font-family: 'Arial'; background: &#34;x&#34;; margin: 0">Text</div>`,
+			Expected: `<div style="margin: 0">Text</div>`,
+		},
+		{
+			Name:     "style expression and positioning",
+			BodyHTML: `<div style="width: expression(alert(1)); position: fixed; top: 0; z-index: 9; color: green">Text</div>`,
+			Expected: `<div style="color: green">Text</div>`,
+		},
+		{
+			Name:     "cid image",
+			BodyHTML: `<img src="cid:logo@example" alt="Logo"><img src="cid:missing@example">`,
+			Expected: `<img src="` + testAPIURL + `/message/message-1/inline/attachment-1" alt="Logo"><img>`,
+		},
+		{
+			Name:     "cid background",
+			BodyHTML: `<table background="CID:Logo@Example"><tr><td>Cell</td></tr></table>`,
+			Expected: `<table background="` + testAPIURL + `/message/message-1/inline/attachment-1"><tr><td>Cell</td></tr></table>`,
+		},
+		{
+			Name:     "unsafe data image",
+			BodyHTML: `<img src="data:image/svg+xml;base64,PHN2Zz4="><img src="data:text/html;base64,PHNjcmlwdD4=">`,
+			Expected: `<img><img>`,
+		},
+		{
+			Name:          "blocked remote image",
+			BodyHTML:      `<img src="https://tracker.example.com/pixel.gif" alt="pixel"><img src="//cdn.example.com/logo.png">`,
+			RemoteContent: RemoteContentBlock,
+			Expected:      `<img alt="pixel"><img>`,
+		},
+		{
+			Name:          "proxied remote image",
+			BodyHTML:      `<img src="https://cdn.example.com/logo.png?size=2&amp;v=1">`,
+			RemoteContent: RemoteContentProxy,
+			Expected:      `<img src="` + testAPIURL + `/remoteContent?url=https%3A%2F%2Fcdn.example.com%2Flogo.png%3Fsize%3D2%26v%3D1">`,
+		},
+		{
+			Name:          "relative and other scheme images",
+			BodyHTML:      `<img src="images/logo.png"><img src="file:///etc/passwd"><img src="ftp://example.com/logo.png">`,
+			RemoteContent: RemoteContentProxy,
+			Expected:      `<img><img><img>`,
+		},
+		{
+			Name:     "unclosed elements",
+			BodyHTML: `<div><b>bold<i>both</div><ul><li>one<li>two</ul>`,
+			Expected: `<div><b>bold<i>both</i></b></div><ul><li>one</li><li>two</li></ul>`,
+		},
+		{
+			Name:     "attribute escaping",
+			BodyHTML: `<p title="&quot;><script>alert(1)</script>">Text &lt;b&gt;</p>`,
+			Expected: `<p title="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;">Text &lt;b&gt;</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			remoteContent := test.RemoteContent
+
+			if remoteContent == "" {
+				remoteContent = RemoteContentBlock
+			}
+
+			renderedHTML := RenderMessageHTML(newTestHTMLMessage(test.BodyHTML), remoteContent)
+
+			if renderedHTML.HTML != test.Expected {
+				t.Errorf("RenderMessageHTML(%q) = %q, expected %q", test.BodyHTML, renderedHTML.HTML, test.Expected)
+			}
+		})
+	}
+}
+
+func TestRenderMessageHTMLRemoteResources(t *testing.T) {
+	bodyHTML := `<img src="https://tracker.example.com/pixel.gif"><td background="http://cdn.example.com/bg.png">Cell</td>`
+	expectedResources := []string{"https://tracker.example.com/pixel.gif", "http://cdn.example.com/bg.png"}
+
+	blockedHTML := RenderMessageHTML(newTestHTMLMessage(bodyHTML), RemoteContentBlock)
+
+	if !reflect.DeepEqual(blockedHTML.BlockedResources, expectedResources) || len(blockedHTML.ProxiedResources) != 0 {
+		t.Errorf("Blocked %v and proxied %v, expected blocked %v", blockedHTML.BlockedResources, blockedHTML.ProxiedResources, expectedResources)
+	}
+
+	if strings.Contains(blockedHTML.HTML, "example.com") {
+		t.Errorf("Blocked HTML %q contains a remote URL", blockedHTML.HTML)
+	}
+
+	proxiedHTML := RenderMessageHTML(newTestHTMLMessage(bodyHTML), RemoteContentProxy)
+
+	if !reflect.DeepEqual(proxiedHTML.ProxiedResources, expectedResources) || len(proxiedHTML.BlockedResources) != 0 {
+		t.Errorf("Proxied %v and blocked %v, expected proxied %v", proxiedHTML.ProxiedResources, proxiedHTML.BlockedResources, expectedResources)
+	}
+}
+
+func TestRenderMessageHTMLText(t *testing.T) {
+	renderedHTML := RenderMessageHTML(core.Message{UUID: "message-1", Body: "<b>not HTML</b>\nsecond line"}, RemoteContentBlock)
+
+	if expected := "<pre>&lt;b&gt;not HTML&lt;/b&gt;\nsecond line</pre>"; renderedHTML.HTML != expected {
+		t.Errorf("RenderMessageHTML() = %q, expected %q", renderedHTML.HTML, expected)
+	}
+}
+
+func TestSanitizeStyle(t *testing.T) {
+	tests := []struct {
+		Style    string
+		Expected string
+	}{
+		{Style: "COLOR: Red;  Font-Size : 12px ;", Expected: "color: Red; font-size: 12px"},
+		{Style: "background-image: url(x.png)", Expected: ""},
+		{Style: "background: URL ( x.png )", Expected: ""},
+		{Style: "background: image(x.png)", Expected: ""},
+		{Style: "background: element(#id)", Expected: ""},
+		{Style: "behavior: url(x.htc)", Expected: ""},
+		{Style: "-moz-binding: url(x.xml)", Expected: ""},
+		{Style: "color: red; @import x.css", Expected: "color: red"},
+		{Style: "content: 'x'; display: block", Expected: "display: block"},
+		{Style: "cursor: pointer", Expected: ""},
+		{Style: "no separator", Expected: ""},
+	}
+
+	for _, test := range tests {
+		if style := sanitizeStyle(test.Style); style != test.Expected {
+			t.Errorf("sanitizeStyle(%q) = %q, expected %q", test.Style, style, test.Expected)
+		}
+	}
+}
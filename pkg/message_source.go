@@ -0,0 +1,727 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants defining the MIME content types of the reconstructed message.
+const (
+	MIMETypeMultipartMixed       = "multipart/mixed"
+	MIMETypeMultipartAlternative = "multipart/alternative"
+	MIMETypeMultipartRelated     = "multipart/related"
+	MIMETypeTextPlain            = "text/plain"
+	MIMETypeTextHTML             = "text/html"
+	MIMETypeOctetStream          = "application/octet-stream"
+)
+
+// Constants defining the MIME content transfer encodings.
+const (
+	MIMEEncodingQuotedPrintable = "quoted-printable"
+	MIMEEncodingBase64          = "base64"
+)
+
+// mimeHeaders are the transport headers describing the original MIME structure, which is replaced by the
+// reconstructed structure.
+var mimeHeaders = map[string]bool{
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Disposition":       true,
+	"Content-Id":                true,
+}
+
+// base64LineLength is the maximum line length of base64 encoded parts (RFC 2045).
+const base64LineLength = 76
+
+// MessageHeader represents a transport header of a message.
+type MessageHeader struct {
+	Name string `json:"name"`
+	// Value is the unfolded value as it was sent, DecodedValue has the encoded words (RFC 2047) decoded.
+	Value        string `json:"value"`
+	DecodedValue string `json:"decodedValue,omitempty"`
+	// Raw contains the original (folded) header lines.
+	Raw string `json:"-"`
+}
+
+// MIMEPart represents a part of the MIME tree of a message.
+type MIMEPart struct {
+	// Path is the part number (for example 1.2), the multipart root has an empty path.
+	Path        string      `json:"path"`
+	ContentType string      `json:"contentType"`
+	Charset     string      `json:"charset,omitempty"`
+	Encoding    string      `json:"encoding,omitempty"`
+	Disposition string      `json:"disposition,omitempty"`
+	FileName    string      `json:"fileName,omitempty"`
+	ContentID   string      `json:"contentID,omitempty"`
+	Size        int         `json:"size"`
+	Children    []*MIMEPart `json:"children,omitempty"`
+
+	attachmentUUID string
+	text           string
+	boundary       string
+}
+
+// MessageSource represents the MIME structure and transport headers of a message.
+type MessageSource struct {
+	MessageUUID string `json:"messageUUID"`
+	// Reconstructed is true if the source is rebuilt from the parsed message (such as messages from a PST),
+	// the transport headers are original but the MIME structure is not.
+	Reconstructed bool            `json:"reconstructed"`
+	Headers       []MessageHeader `json:"headers"`
+	MIMETree      *MIMEPart       `json:"mimeTree"`
+}
+
+// handleMessageSource handles the message source endpoint, downloading the RFC 5322 source (message/rfc822).
+// The source of a message with redactions is refused.
+func (server *Server) handleMessageSource() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			redactions, err := GetRedactionsByMessage(message.UUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get redactions: %s", err)
+				http.Error(responseWriter, "Failed to get redactions.", http.StatusInternalServerError)
+				return
+			}
+
+			// The source contains the original body and attachments, which can't be redacted.
+			if len(redactions) > 0 {
+				Logger.Errorf("Refused to download the source of a redacted message: %s", message.UUID)
+				http.Error(responseWriter, "The message has redactions, produce it as a redacted exhibit or via /redacted instead.", http.StatusConflict)
+				return
+			}
+
+			responseWriter.Header().Set("Content-Type", "message/rfc822")
+			responseWriter.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.eml\"", message.UUID))
+
+			// The headers are sent, errors can only be logged.
+			if err := WriteMessageSource(responseWriter, message, project.UUID); err != nil {
+				Logger.Errorf("Failed to write message source: %s", err)
+			}
+		}
+	}
+}
+
+// handleMessageMIME handles the message MIME endpoint, returning the MIME tree and transport headers.
+func (server *Server) handleMessageMIME() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			messageSource := MessageSource{
+				MessageUUID:   message.UUID,
+				Reconstructed: true,
+				Headers:       ParseTransportHeaders(message.TransportHeaders),
+				MIMETree:      NewMIMETree(message),
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&messageSource); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleMessageMIMEPart handles the message MIME part endpoint, downloading the decoded content of the part.
+// The body parts are refused if the body has redactions, an attachment part if the attachment has redactions.
+func (server *Server) handleMessageMIMEPart() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			partPath := mux.Vars(request)["partPath"]
+			mimePart := NewMIMETree(message).GetPart(partPath)
+
+			if mimePart == nil {
+				Logger.Errorf("Failed to find MIME part: %s", partPath)
+				http.Error(responseWriter, "Failed to find MIME part.", http.StatusNotFound)
+				return
+			}
+
+			if len(mimePart.Children) > 0 {
+				Logger.Errorf("Requested multipart MIME part: %s", partPath)
+				http.Error(responseWriter, "The MIME part is a multipart, download its children instead.", http.StatusBadRequest)
+				return
+			}
+
+			redactions, err := GetRedactionsByMessage(message.UUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get redactions: %s", err)
+				http.Error(responseWriter, "Failed to get redactions.", http.StatusInternalServerError)
+				return
+			}
+
+			// The parts contain the original body or attachment file, which can't be redacted.
+			if len(getTargetRedactions(redactions, mimePart.attachmentUUID)) > 0 {
+				Logger.Errorf("Refused to download the redacted MIME part %s of message %s", partPath, message.UUID)
+				http.Error(responseWriter, "The MIME part has redactions, produce it as a redacted exhibit or via /redacted instead.", http.StatusConflict)
+				return
+			}
+
+			fileName := mimePart.FileName
+
+			if fileName == "" {
+				fileName = fmt.Sprintf("%s-part-%s.txt", message.UUID, partPath)
+
+				if mimePart.ContentType == MIMETypeTextHTML {
+					fileName = strings.TrimSuffix(fileName, ".txt") + ".html"
+				}
+			}
+
+			responseWriter.Header().Set("Content-Type", mimePart.GetMediaType())
+			responseWriter.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+			// Parts are evidence, the browser must not render them.
+			responseWriter.Header().Set("X-Content-Type-Options", "nosniff")
+
+			if mimePart.attachmentUUID == "" {
+				if _, err := io.WriteString(responseWriter, mimePart.text); err != nil {
+					Logger.Errorf("Failed to write MIME part: %s", err)
+				}
+
+				return
+			}
+
+			if err := WriteAttachmentData(mimePart.attachmentUUID, project.UUID, responseWriter); err != nil {
+				Logger.Errorf("Failed to write MIME part: %s", err)
+			}
+		}
+	}
+}
+
+// ParseTransportHeaders parses the transport headers in order, continuation lines are unfolded.
+func ParseTransportHeaders(transportHeaders string) []MessageHeader {
+	messageHeaders := []MessageHeader{}
+	wordDecoder := &mime.WordDecoder{}
+
+	addHeader := func(raw string) {
+		separatorIndex := strings.Index(raw, ":")
+
+		if separatorIndex <= 0 {
+			return
+		}
+
+		value := strings.TrimSpace(unfoldHeader(raw[separatorIndex+1:]))
+		messageHeader := MessageHeader{
+			Name:  strings.TrimSpace(raw[:separatorIndex]),
+			Value: value,
+			Raw:   raw,
+		}
+
+		if decodedValue, err := wordDecoder.DecodeHeader(value); err == nil && decodedValue != value {
+			messageHeader.DecodedValue = decodedValue
+		}
+
+		messageHeaders = append(messageHeaders, messageHeader)
+	}
+
+	var raw strings.Builder
+
+	for _, line := range strings.Split(strings.ReplaceAll(transportHeaders, "\r\n", "\n"), "\n") {
+		// The headers end at the first empty line.
+		if line == "" {
+			break
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && raw.Len() > 0 {
+			raw.WriteString("\r\n" + line)
+			continue
+		}
+
+		if raw.Len() > 0 {
+			addHeader(raw.String())
+			raw.Reset()
+		}
+
+		raw.WriteString(line)
+	}
+
+	if raw.Len() > 0 {
+		addHeader(raw.String())
+	}
+
+	return messageHeaders
+}
+
+// unfoldHeader removes the line breaks of a folded header value (RFC 5322 section 2.2.3).
+func unfoldHeader(value string) string {
+	return strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+}
+
+// GetHeaderValues returns the values of the headers with the (case-insensitive) name, in order.
+func GetHeaderValues(messageHeaders []MessageHeader, name string) []string {
+	var values []string
+
+	for _, messageHeader := range messageHeaders {
+		if strings.EqualFold(messageHeader.Name, name) {
+			values = append(values, messageHeader.Value)
+		}
+	}
+
+	return values
+}
+
+// NewMIMETree creates the MIME tree of the message.
+// The text and HTML bodies are alternatives, inline images (with a content ID) are related to the HTML body and
+// the other attachments are mixed with the body.
+func NewMIMETree(message core.Message) *MIMEPart {
+	var content *MIMEPart
+
+	textPart := &MIMEPart{
+		ContentType: MIMETypeTextPlain,
+		Charset:     "utf-8",
+		Encoding:    MIMEEncodingQuotedPrintable,
+		Size:        len(message.Body),
+		text:        message.Body,
+	}
+
+	var inlineParts []*MIMEPart
+	var attachmentParts []*MIMEPart
+
+	for _, attachment := range message.Attachments {
+		attachmentPart := &MIMEPart{
+			ContentType:    attachment.MimeType,
+			Encoding:       MIMEEncodingBase64,
+			Disposition:    "attachment",
+			FileName:       attachment.FileName,
+			ContentID:      attachment.ContentID,
+			Size:           attachment.Size,
+			attachmentUUID: attachment.UUID,
+		}
+
+		if attachmentPart.ContentType == "" {
+			attachmentPart.ContentType = MIMETypeOctetStream
+		}
+
+		if attachment.ContentID != "" && message.BodyHTML != "" {
+			attachmentPart.Disposition = "inline"
+			inlineParts = append(inlineParts, attachmentPart)
+		} else {
+			attachmentParts = append(attachmentParts, attachmentPart)
+		}
+	}
+
+	if message.BodyHTML != "" {
+		htmlPart := &MIMEPart{
+			ContentType: MIMETypeTextHTML,
+			Charset:     "utf-8",
+			Encoding:    MIMEEncodingQuotedPrintable,
+			Size:        len(message.BodyHTML),
+			text:        message.BodyHTML,
+		}
+
+		if len(inlineParts) > 0 {
+			htmlPart = &MIMEPart{
+				ContentType: MIMETypeMultipartRelated,
+				Children:    append([]*MIMEPart{htmlPart}, inlineParts...),
+			}
+		}
+
+		if message.Body != "" {
+			content = &MIMEPart{
+				ContentType: MIMETypeMultipartAlternative,
+				Children:    []*MIMEPart{textPart, htmlPart},
+			}
+		} else {
+			content = htmlPart
+		}
+	} else {
+		content = textPart
+	}
+
+	root := content
+
+	if len(attachmentParts) > 0 {
+		root = &MIMEPart{
+			ContentType: MIMETypeMultipartMixed,
+			Children:    append([]*MIMEPart{content}, attachmentParts...),
+		}
+	}
+
+	if len(root.Children) == 0 {
+		root.Path = "1"
+	} else {
+		root.setChildPaths()
+	}
+
+	root.setMultipartSizes()
+	root.setBoundaries(message.UUID)
+
+	return root
+}
+
+// setChildPaths numbers the children of the multipart (IMAP part numbers, RFC 3501 section 6.4.5).
+func (mimePart *MIMEPart) setChildPaths() {
+	for i, child := range mimePart.Children {
+		child.Path = strconv.Itoa(i + 1)
+
+		if mimePart.Path != "" {
+			child.Path = mimePart.Path + "." + child.Path
+		}
+
+		child.setChildPaths()
+	}
+}
+
+// setMultipartSizes sets the size of multiparts to the decoded size of their children.
+func (mimePart *MIMEPart) setMultipartSizes() int {
+	if len(mimePart.Children) == 0 {
+		return mimePart.Size
+	}
+
+	mimePart.Size = 0
+
+	for _, child := range mimePart.Children {
+		mimePart.Size += child.setMultipartSizes()
+	}
+
+	return mimePart.Size
+}
+
+// setBoundaries sets the boundaries of the multiparts, derived from the message UUID and part path so the source
+// of a message (and its hash) is always the same.
+func (mimePart *MIMEPart) setBoundaries(messageUUID string) {
+	if len(mimePart.Children) == 0 {
+		return
+	}
+
+	boundaryHash := sha256.Sum256([]byte(messageUUID + "/" + mimePart.Path))
+	mimePart.boundary = "=_" + hex.EncodeToString(boundaryHash[:16])
+
+	for _, child := range mimePart.Children {
+		child.setBoundaries(messageUUID)
+	}
+}
+
+// GetPart returns the part with the path, nil if it does not exist.
+func (mimePart *MIMEPart) GetPart(path string) *MIMEPart {
+	if mimePart.Path == path {
+		return mimePart
+	}
+
+	for _, child := range mimePart.Children {
+		if child.Path == path || strings.HasPrefix(path, child.Path+".") {
+			return child.GetPart(path)
+		}
+	}
+
+	return nil
+}
+
+// GetMediaType returns the content type with the charset parameter.
+func (mimePart *MIMEPart) GetMediaType() string {
+	if mimePart.Charset == "" {
+		return mimePart.ContentType
+	}
+
+	return mime.FormatMediaType(mimePart.ContentType, map[string]string{"charset": mimePart.Charset})
+}
+
+// WriteMessageSource writes the RFC 5322 source of the message.
+// The original transport headers are kept in order, the MIME structure is reconstructed from the parsed message.
+// The source is the same every time it is written, see GetMessageSourceHash.
+func WriteMessageSource(writer io.Writer, message core.Message, projectUUID string) error {
+	bufferedWriter := bufio.NewWriter(writer)
+	messageHeaders := ParseTransportHeaders(message.TransportHeaders)
+
+	if len(messageHeaders) == 0 {
+		messageHeaders = newMessageHeaders(message)
+	}
+
+	for _, messageHeader := range messageHeaders {
+		if mimeHeaders[textproto.CanonicalMIMEHeaderKey(messageHeader.Name)] {
+			continue
+		}
+
+		raw := messageHeader.Raw
+
+		if raw == "" {
+			raw = messageHeader.Name + ": " + messageHeader.Value
+		}
+
+		if _, err := bufferedWriter.WriteString(raw + "\r\n"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := bufferedWriter.WriteString("MIME-Version: 1.0\r\n"); err != nil {
+		return err
+	}
+
+	root := NewMIMETree(message)
+
+	if err := writeMIMEPart(bufferedWriter, root, projectUUID); err != nil {
+		return err
+	}
+
+	return bufferedWriter.Flush()
+}
+
+// GetMessageSourceHash returns the hex encoded SHA-256 hash of the message source (the downloaded .eml file).
+func GetMessageSourceHash(message core.Message, projectUUID string) (string, error) {
+	sourceHash := sha256.New()
+
+	if err := WriteMessageSource(sourceHash, message, projectUUID); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sourceHash.Sum(nil)), nil
+}
+
+// newMessageHeaders creates the headers of a message without transport headers (such as drafts in a PST).
+func newMessageHeaders(message core.Message) []MessageHeader {
+	var messageHeaders []MessageHeader
+
+	addHeader := func(name string, value string) {
+		if value != "" {
+			messageHeaders = append(messageHeaders, MessageHeader{Name: name, Value: mime.QEncoding.Encode("utf-8", value)})
+		}
+	}
+
+	addHeader("From", message.From)
+	addHeader("To", message.To)
+	addHeader("Cc", message.CC)
+	addHeader("Subject", message.Subject)
+
+	if message.Date > 0 {
+		addHeader("Date", time.Unix(int64(message.Date), 0).UTC().Format(time.RFC1123Z))
+	}
+
+	addHeader("Message-ID", message.MessageID)
+	addHeader("In-Reply-To", message.InReplyTo)
+	addHeader("References", message.References)
+
+	return messageHeaders
+}
+
+// newMIMEPartHeader creates the content headers of the part.
+func newMIMEPartHeader(mimePart *MIMEPart, boundary string) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	contentTypeParameters := make(map[string]string)
+
+	if mimePart.Charset != "" {
+		contentTypeParameters["charset"] = mimePart.Charset
+	}
+
+	if boundary != "" {
+		contentTypeParameters["boundary"] = boundary
+	}
+
+	if mimePart.FileName != "" {
+		contentTypeParameters["name"] = mimePart.FileName
+	}
+
+	header.Set("Content-Type", mime.FormatMediaType(mimePart.ContentType, contentTypeParameters))
+
+	if mimePart.Encoding != "" {
+		header.Set("Content-Transfer-Encoding", mimePart.Encoding)
+	}
+
+	if mimePart.Disposition != "" {
+		dispositionParameters := make(map[string]string)
+
+		if mimePart.FileName != "" {
+			dispositionParameters["filename"] = mimePart.FileName
+		}
+
+		header.Set("Content-Disposition", mime.FormatMediaType(mimePart.Disposition, dispositionParameters))
+	}
+
+	if mimePart.ContentID != "" {
+		header.Set("Content-ID", "<"+strings.Trim(mimePart.ContentID, "<>")+">")
+	}
+
+	return header
+}
+
+// writeMIMEPart writes the content headers and body of the top-level part.
+func writeMIMEPart(writer io.Writer, mimePart *MIMEPart, projectUUID string) error {
+	var multipartWriter *multipart.Writer
+	boundary := ""
+
+	if len(mimePart.Children) > 0 {
+		multipartWriter = multipart.NewWriter(writer)
+		boundary = mimePart.boundary
+
+		if err := multipartWriter.SetBoundary(boundary); err != nil {
+			return err
+		}
+	}
+
+	header := newMIMEPartHeader(mimePart, boundary)
+
+	for _, name := range []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-ID"} {
+		if value := header.Get(name); value != "" {
+			if _, err := fmt.Fprintf(writer, "%s: %s\r\n", name, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := io.WriteString(writer, "\r\n"); err != nil {
+		return err
+	}
+
+	return writeMIMEPartBody(writer, multipartWriter, mimePart, projectUUID)
+}
+
+// writeMIMEPartBody writes the (encoded) body of the part, the children of a multipart are written recursively.
+func writeMIMEPartBody(writer io.Writer, multipartWriter *multipart.Writer, mimePart *MIMEPart, projectUUID string) error {
+	if multipartWriter != nil {
+		for _, child := range mimePart.Children {
+			var childMultipartWriter *multipart.Writer
+
+			partWriter, err := multipartWriter.CreatePart(newMIMEPartHeader(child, child.boundary))
+
+			if err != nil {
+				return err
+			}
+
+			if child.boundary != "" {
+				childMultipartWriter = multipart.NewWriter(partWriter)
+
+				if err := childMultipartWriter.SetBoundary(child.boundary); err != nil {
+					return err
+				}
+			}
+
+			if err := writeMIMEPartBody(partWriter, childMultipartWriter, child, projectUUID); err != nil {
+				return err
+			}
+		}
+
+		return multipartWriter.Close()
+	}
+
+	switch mimePart.Encoding {
+	case MIMEEncodingBase64:
+		lineWriter := &base64LineWriter{Writer: writer}
+		encoder := base64.NewEncoder(base64.StdEncoding, lineWriter)
+
+		if err := WriteAttachmentData(mimePart.attachmentUUID, projectUUID, encoder); err != nil {
+			return err
+		}
+
+		if err := encoder.Close(); err != nil {
+			return err
+		}
+
+		_, err := io.WriteString(writer, "\r\n")
+
+		return err
+	default:
+		quotedPrintableWriter := quotedprintable.NewWriter(writer)
+
+		if _, err := io.WriteString(quotedPrintableWriter, mimePart.text); err != nil {
+			return err
+		}
+
+		if err := quotedPrintableWriter.Close(); err != nil {
+			return err
+		}
+
+		_, err := io.WriteString(writer, "\r\n")
+
+		return err
+	}
+}
+
+// base64LineWriter breaks the base64 output into lines of base64LineLength characters.
+type base64LineWriter struct {
+	Writer     io.Writer
+	lineLength int
+}
+
+// Write writes the data with a line break after every base64LineLength characters.
+func (lineWriter *base64LineWriter) Write(data []byte) (int, error) {
+	written := 0
+
+	for len(data) > 0 {
+		if lineWriter.lineLength == base64LineLength {
+			if _, err := io.WriteString(lineWriter.Writer, "\r\n"); err != nil {
+				return written, err
+			}
+
+			lineWriter.lineLength = 0
+		}
+
+		chunk := data[:minInt(len(data), base64LineLength-lineWriter.lineLength)]
+
+		n, err := lineWriter.Writer.Write(chunk)
+		written += n
+		lineWriter.lineLength += n
+
+		if err != nil {
+			return written, err
+		}
+
+		data = data[len(chunk):]
+	}
+
+	return written, nil
+}
@@ -0,0 +1,585 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Constants defining the note limits.
+const (
+	MaxNoteLength   = 20000
+	MaxNoteMentions = 50
+)
+
+// Note represents an examiner note on a message, or a comment replying to a note or comment (ParentUUID).
+type Note struct {
+	UUID        string `json:"uuid"`
+	ProjectUUID string `json:"projectUUID"`
+	MessageUUID string `json:"messageUUID"`
+	// ParentUUID is the note or comment replied to, empty for a note.
+	ParentUUID  string `json:"parentUUID"`
+	AuthorID    string `json:"authorID"`
+	AuthorEmail string `json:"authorEmail"`
+	Body        string `json:"body"`
+	// Mentions are the user IDs of the mentioned project members.
+	Mentions         []string `json:"mentions"`
+	CreationDate     int      `json:"creationDate"`
+	ModificationDate int      `json:"modificationDate"`
+	// IsDeleted is true when the author deleted the note, the body is kept in the revisions.
+	IsDeleted bool `json:"isDeleted"`
+	// Revisions are the previous versions of the note, only returned by the note endpoint.
+	Revisions []NoteRevision `json:"revisions,omitempty"`
+}
+
+// NoteRevision represents a previous version of a note, replaced on the revision date.
+type NoteRevision struct {
+	Body         string   `json:"body"`
+	Mentions     []string `json:"mentions"`
+	EditorID     string   `json:"editorID"`
+	RevisionDate int      `json:"revisionDate"`
+}
+
+// NoteFilter represents the filters of the notes endpoint, empty fields are not filtered on.
+type NoteFilter struct {
+	MessageUUID string
+	AuthorID    string
+	Mention     string
+}
+
+// handleMessageNotes handles the message notes endpoint, listing and adding the notes of the message.
+func (server *Server) handleMessageNotes() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to get message: %s", err)
+			http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+			return
+		}
+
+		if request.Method == "GET" {
+			notes, err := GetNotes(NoteFilter{MessageUUID: message.UUID}, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get notes: %s", err)
+				http.Error(responseWriter, "Failed to get notes.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&notes); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			var requestNote Note
+
+			if err := json.NewDecoder(request.Body).Decode(&requestNote); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			note := Note{
+				UUID:         core.NewUUID(),
+				ProjectUUID:  project.UUID,
+				MessageUUID:  message.UUID,
+				ParentUUID:   requestNote.ParentUUID,
+				AuthorID:     user.Id,
+				AuthorEmail:  getUserEmail(user),
+				Body:         requestNote.Body,
+				Mentions:     requestNote.Mentions,
+				CreationDate: int(time.Now().Unix()),
+			}
+
+			note.ModificationDate = note.CreationDate
+
+			if note.ParentUUID != "" {
+				parentNote, err := GetNoteByUUID(note.ParentUUID, project.UUID, server.Database)
+
+				if err != nil || parentNote.MessageUUID != message.UUID {
+					Logger.Errorf("Failed to get parent note: %v", err)
+					http.Error(responseWriter, "Invalid note: the parentUUID is not a note of this message.", http.StatusBadRequest)
+					return
+				}
+			}
+
+			if err := server.ValidateNote(&note); err != nil {
+				Logger.Errorf("Invalid note: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid note: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			if err := note.Save(server.Database); err != nil {
+				Logger.Errorf("Failed to save note: %s", err)
+				http.Error(responseWriter, "Failed to save note.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&note); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleNotes handles the notes endpoint, listing the notes of the project.
+// Accepts the "messageUUID", "author" and "mention" (user IDs, "me" is the current user) query parameters.
+func (server *Server) handleNotes() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			user, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			noteFilter := NoteFilter{
+				MessageUUID: request.URL.Query().Get("messageUUID"),
+				AuthorID:    request.URL.Query().Get("author"),
+				Mention:     request.URL.Query().Get("mention"),
+			}
+
+			if noteFilter.AuthorID == "me" {
+				noteFilter.AuthorID = user.Id
+			}
+
+			if noteFilter.Mention == "me" {
+				noteFilter.Mention = user.Id
+			}
+
+			notes, err := GetNotes(noteFilter, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get notes: %s", err)
+				http.Error(responseWriter, "Failed to get notes.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&notes); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleNote handles the note endpoint.
+// Only the author can edit or delete a note, the previous versions are kept as revisions.
+func (server *Server) handleNote() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		note, err := GetNoteByUUID(mux.Vars(request)["uuid"], project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to get note: %s", err)
+			http.Error(responseWriter, "Failed to get note.", http.StatusNotFound)
+			return
+		}
+
+		if request.Method == "GET" {
+			note.Revisions, err = GetNoteRevisions(note.UUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get note revisions: %s", err)
+				http.Error(responseWriter, "Failed to get note revisions.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&note); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			if note.AuthorID != user.Id || note.IsDeleted {
+				Logger.Errorf("User is not the author of the note or the note is deleted.")
+				http.Error(responseWriter, "Only the author can edit a note.", http.StatusForbidden)
+				return
+			}
+
+			var requestNote Note
+
+			if err := json.NewDecoder(request.Body).Decode(&requestNote); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			editedNote := note
+
+			editedNote.Body = requestNote.Body
+			editedNote.Mentions = requestNote.Mentions
+			editedNote.ModificationDate = int(time.Now().Unix())
+
+			if err := server.ValidateNote(&editedNote); err != nil {
+				Logger.Errorf("Invalid note: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid note: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			if err := UpdateNote(note, editedNote, user.Id, server.Database); err != nil {
+				Logger.Errorf("Failed to update note: %s", err)
+				http.Error(responseWriter, "Failed to update note.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&editedNote); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "DELETE" {
+			if note.AuthorID != user.Id {
+				Logger.Errorf("User is not the author of the note.")
+				http.Error(responseWriter, "Only the author can delete a note.", http.StatusForbidden)
+				return
+			}
+
+			// The note is kept so replies keep their thread and the deleted text stays in the revisions.
+			deletedNote := note
+
+			deletedNote.Body = ""
+			deletedNote.Mentions = []string{}
+			deletedNote.ModificationDate = int(time.Now().Unix())
+			deletedNote.IsDeleted = true
+
+			if err := UpdateNote(note, deletedNote, user.Id, server.Database); err != nil {
+				Logger.Errorf("Failed to delete note: %s", err)
+				http.Error(responseWriter, "Failed to delete note.", http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// getUserEmail returns the email address trait of the user.
+func getUserEmail(user core.User) string {
+	traits, ok := user.Identity.Traits.(map[string]interface{})
+
+	if !ok {
+		return ""
+	}
+
+	email, _ := traits["email"].(string)
+
+	return email
+}
+
+// ValidateNote validates the body and the mentions of the note, mentions must be members of the project.
+func (server *Server) ValidateNote(note *Note) error {
+	note.Body = strings.TrimSpace(note.Body)
+
+	if note.Body == "" {
+		return errors.New("missing body")
+	}
+
+	if len(note.Body) > MaxNoteLength {
+		return fmt.Errorf("the body is longer than %d bytes", MaxNoteLength)
+	}
+
+	mentions := []string{}
+	isMentioned := make(map[string]bool)
+
+	for _, mention := range note.Mentions {
+		if !isMentioned[mention] {
+			isMentioned[mention] = true
+			mentions = append(mentions, mention)
+		}
+	}
+
+	// The limit is checked first, each mention is a database query.
+	if len(mentions) > MaxNoteMentions {
+		return fmt.Errorf("at most %d users can be mentioned", MaxNoteMentions)
+	}
+
+	for _, mention := range mentions {
+		if !core.ProjectHasUser(note.ProjectUUID, mention, server.Database) {
+			return fmt.Errorf("the mentioned user %s is not a member of the project", mention)
+		}
+	}
+
+	note.Mentions = mentions
+
+	return nil
+}
+
+// Save saves the new note to the database.
+func (note *Note) Save(database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), `
+		INSERT INTO notes (uuid, project_uuid, message_uuid, parent_uuid, author_id, author_email, body, mentions, creation_date, modification_date, is_deleted)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		note.UUID, note.ProjectUUID, note.MessageUUID, note.ParentUUID, note.AuthorID, note.AuthorEmail, note.Body, note.Mentions,
+		note.CreationDate, note.ModificationDate, note.IsDeleted,
+	)
+
+	return err
+}
+
+// UpdateNote replaces the note by the edited note, keeping the note as a revision.
+func UpdateNote(note Note, editedNote Note, editorID string, database *pgx.Conn) error {
+	batch := &pgx.Batch{}
+
+	batch.Queue(`
+		INSERT INTO note_revisions (note_uuid, project_uuid, body, mentions, editor_id, revision_date)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		note.UUID, note.ProjectUUID, note.Body, note.Mentions, editorID, editedNote.ModificationDate,
+	)
+	batch.Queue(
+		"UPDATE notes SET body = $1, mentions = $2, modification_date = $3, is_deleted = $4 WHERE uuid = $5 AND project_uuid = $6",
+		editedNote.Body, editedNote.Mentions, editedNote.ModificationDate, editedNote.IsDeleted, note.UUID, note.ProjectUUID,
+	)
+
+	batchResults := database.SendBatch(context.Background(), batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			_ = batchResults.Close()
+			return err
+		}
+	}
+
+	return batchResults.Close()
+}
+
+// noteColumns defines the columns scanned by scanNote.
+const noteColumns = "uuid, project_uuid, message_uuid, parent_uuid, author_id, author_email, body, mentions, creation_date, modification_date, is_deleted"
+
+// scanNote scans the note from the row.
+func scanNote(row pgx.Row) (Note, error) {
+	var note Note
+
+	if err := row.Scan(
+		&note.UUID,
+		&note.ProjectUUID,
+		&note.MessageUUID,
+		&note.ParentUUID,
+		&note.AuthorID,
+		&note.AuthorEmail,
+		&note.Body,
+		&note.Mentions,
+		&note.CreationDate,
+		&note.ModificationDate,
+		&note.IsDeleted,
+	); err != nil {
+		return Note{}, err
+	}
+
+	if note.Mentions == nil {
+		note.Mentions = []string{}
+	}
+
+	return note, nil
+}
+
+// GetNoteByUUID returns the note of the project.
+func GetNoteByUUID(noteUUID string, projectUUID string, database *pgx.Conn) (Note, error) {
+	return scanNote(database.QueryRow(context.Background(), "SELECT "+noteColumns+" FROM notes WHERE uuid = $1 AND project_uuid = $2", noteUUID, projectUUID))
+}
+
+// GetNotes returns the notes of the project matching the filter, oldest first.
+func GetNotes(noteFilter NoteFilter, projectUUID string, database *pgx.Conn) ([]Note, error) {
+	rows, err := database.Query(context.Background(), `
+		SELECT `+noteColumns+` FROM notes
+		WHERE project_uuid = $1
+		AND ($2 = '' OR message_uuid = $2)
+		AND ($3 = '' OR author_id = $3)
+		AND ($4 = '' OR $4 = ANY(mentions))
+		ORDER BY creation_date, uuid`,
+		projectUUID, noteFilter.MessageUUID, noteFilter.AuthorID, noteFilter.Mention,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	notes := []Note{}
+
+	for rows.Next() {
+		note, err := scanNote(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		notes = append(notes, note)
+	}
+
+	return notes, rows.Err()
+}
+
+// GetNoteRevisions returns the previous versions of the note, oldest first.
+func GetNoteRevisions(noteUUID string, projectUUID string, database *pgx.Conn) ([]NoteRevision, error) {
+	rows, err := database.Query(context.Background(), "SELECT body, mentions, editor_id, revision_date FROM note_revisions WHERE note_uuid = $1 AND project_uuid = $2 ORDER BY revision_date, id", noteUUID, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	noteRevisions := []NoteRevision{}
+
+	for rows.Next() {
+		var noteRevision NoteRevision
+
+		if err := rows.Scan(&noteRevision.Body, &noteRevision.Mentions, &noteRevision.EditorID, &noteRevision.RevisionDate); err != nil {
+			return nil, err
+		}
+
+		if noteRevision.Mentions == nil {
+			noteRevision.Mentions = []string{}
+		}
+
+		noteRevisions = append(noteRevisions, noteRevision)
+	}
+
+	return noteRevisions, rows.Err()
+}
+
+// NoteThreadEntry represents a note in a thread, the depth is the amount of parents.
+type NoteThreadEntry struct {
+	Note  Note
+	Depth int
+}
+
+// NewNoteThread orders the notes of a message as threads, each reply follows its parent.
+// Replies to notes which aren't in the list are treated as notes.
+func NewNoteThread(notes []Note) []NoteThreadEntry {
+	isNote := make(map[string]bool)
+	replies := make(map[string][]Note)
+
+	for _, note := range notes {
+		isNote[note.UUID] = true
+	}
+
+	var rootNotes []Note
+
+	for _, note := range notes {
+		if note.ParentUUID != "" && isNote[note.ParentUUID] && note.ParentUUID != note.UUID {
+			replies[note.ParentUUID] = append(replies[note.ParentUUID], note)
+		} else {
+			rootNotes = append(rootNotes, note)
+		}
+	}
+
+	var noteThread []NoteThreadEntry
+
+	var addNotes func(notes []Note, depth int)
+
+	addNotes = func(notes []Note, depth int) {
+		for _, note := range notes {
+			noteThread = append(noteThread, NoteThreadEntry{Note: note, Depth: depth})
+			addNotes(replies[note.UUID], depth+1)
+		}
+	}
+
+	addNotes(rootNotes, 0)
+
+	return noteThread
+}
+
+// notesReportTemplate defines the HTML of the notes report section.
+var notesReportTemplate = template.Must(template.New("notesReport").Funcs(template.FuncMap{
+	"formatDate": formatNoteDate,
+	"indent": func(depth int) int {
+		return minInt(depth, 10) * 2
+	},
+}).Parse(`
+<p>The notes are listed per bookmarked message, in the order of the bookmarks in the report. Each entry refers to its bookmark by number and item UUID.</p>
+{{range .}}
+<article id="notes-{{.Message.UUID}}">
+	<h3>Bookmark {{.Number}}: {{.Message.Subject}}</h3>
+	<p>From {{.Message.From}}, {{formatDate .Message.Date}}, item UUID {{.Message.UUID}}</p>
+	{{range .Notes}}
+	<div style="margin-left: {{indent .Depth}}em">
+		<p><strong>{{if .Note.AuthorEmail}}{{.Note.AuthorEmail}}{{else}}{{.Note.AuthorID}}{{end}}</strong>, {{formatDate .Note.CreationDate}}{{if ne .Note.ModificationDate .Note.CreationDate}} (edited {{formatDate .Note.ModificationDate}}){{end}}</p>
+		{{if .Note.IsDeleted}}<p><em>Deleted</em></p>{{else}}<p style="white-space: pre-wrap">{{.Note.Body}}</p>{{end}}
+	</div>
+	{{end}}
+</article>
+{{end}}
+`))
+
+// formatNoteDate formats the date (Unix seconds) in UTC.
+func formatNoteDate(date int) string {
+	return time.Unix(int64(date), 0).UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+// NewNotesHTMLReportSection creates the report section listing the notes of the bookmarked messages.
+// The entries of the report are created by the core, so the notes are an appendix after the entries rather than
+// placed next to them; each message refers back to its entry by bookmark number (in report order) and item UUID.
+// Bookmarked messages without notes are left out.
+func NewNotesHTMLReportSection(bookmarks []core.Message, projectUUID string, database *pgx.Conn) (HTMLReportSection, error) {
+	notes, err := GetNotes(NoteFilter{}, projectUUID, database)
+
+	if err != nil {
+		return HTMLReportSection{}, err
+	}
+
+	notesByMessage := make(map[string][]Note)
+
+	for _, note := range notes {
+		notesByMessage[note.MessageUUID] = append(notesByMessage[note.MessageUUID], note)
+	}
+
+	type messageNotes struct {
+		Number  int
+		Message core.Message
+		Notes   []NoteThreadEntry
+	}
+
+	var bookmarkNotes []messageNotes
+
+	for i, bookmark := range bookmarks {
+		if len(notesByMessage[bookmark.UUID]) > 0 {
+			bookmarkNotes = append(bookmarkNotes, messageNotes{
+				Number:  i + 1,
+				Message: bookmark,
+				Notes:   NewNoteThread(notesByMessage[bookmark.UUID]),
+			})
+		}
+	}
+
+	return NewHTMLReportSection("Notes on bookmarked messages", notesReportTemplate, bookmarkNotes)
+}
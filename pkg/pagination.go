@@ -0,0 +1,243 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	core "github.com/mooijtech/goforensics-core/pkg"
+)
+
+// Constants defining the search page sizes.
+const (
+	DefaultSearchPageSize = 100
+	MaxSearchPageSize     = 1000
+)
+
+// Constants defining the search sort orders.
+const (
+	SearchSortDate      = "date"
+	SearchSortSender    = "sender"
+	SearchSortSubject   = "subject"
+	SearchSortSize      = "size"
+	SearchSortRelevance = "relevance"
+)
+
+// searchSortFields maps the search sort orders to the Elasticsearch fields.
+var searchSortFields = map[string]string{
+	SearchSortDate:      MessageFieldDate,
+	SearchSortSender:    MessageFieldFromKeyword,
+	SearchSortSubject:   MessageFieldSubjectKeyword,
+	SearchSortSize:      MessageFieldSize,
+	SearchSortRelevance: "_score",
+}
+
+// SearchPageRequest represents the pagination parameters of a search request.
+type SearchPageRequest struct {
+	PageSize   int
+	Sort       string
+	Descending bool
+	// SearchAfter contains the sort values of the last message of the previous page.
+	SearchAfter []interface{}
+	// ExactTotal counts all hits instead of stopping at the Elasticsearch default (10,000).
+	ExactTotal bool
+}
+
+// searchCursor represents the decoded (opaque) cursor returned with each page.
+type searchCursor struct {
+	Sort        string        `json:"sort"`
+	Descending  bool          `json:"descending"`
+	SearchAfter []interface{} `json:"searchAfter"`
+}
+
+// SearchPage represents a page of search results.
+type SearchPage struct {
+	Messages []core.Message `json:"messages"`
+	// Cursor is used to request the next page, empty if this is the last page.
+	Cursor         string `json:"cursor"`
+	TotalHits      int    `json:"totalHits"`
+	TotalHitsExact bool   `json:"totalHitsExact"`
+	// Facets are only set when requested, see NewFacetRequest.
+	Facets map[string][]FacetBucket `json:"facets,omitempty"`
+	// Highlights contains the highlighted fragments by message UUID and field, only set when requested.
+	Highlights map[string]map[string][]string `json:"highlights,omitempty"`
+}
+
+// NewSearchPageRequest creates the SearchPageRequest from the request body.
+// Accepts "pageSize", "sort" (date, sender, subject, size, relevance), "order" (asc, desc), "cursor" and "exactTotal".
+// The sort order of a cursor always takes precedence so pages stay consistent.
+func NewSearchPageRequest(requestBody map[string]interface{}, defaultSort string) (SearchPageRequest, error) {
+	pageRequest := SearchPageRequest{
+		PageSize: DefaultSearchPageSize,
+		Sort:     defaultSort,
+	}
+
+	if requestPageSize, ok := requestBody["pageSize"]; ok {
+		pageSize, ok := requestPageSize.(float64)
+
+		if !ok || pageSize < 1 || pageSize > MaxSearchPageSize || pageSize != float64(int(pageSize)) {
+			return SearchPageRequest{}, fmt.Errorf("pageSize must be a number between 1 and %d", MaxSearchPageSize)
+		}
+
+		pageRequest.PageSize = int(pageSize)
+	}
+
+	if requestExactTotal, ok := requestBody["exactTotal"]; ok {
+		exactTotal, ok := requestExactTotal.(bool)
+
+		if !ok {
+			return SearchPageRequest{}, errors.New("exactTotal must be a boolean")
+		}
+
+		pageRequest.ExactTotal = exactTotal
+	}
+
+	if requestCursor, ok := requestBody["cursor"]; ok && requestCursor != "" {
+		encodedCursor, ok := requestCursor.(string)
+
+		if !ok {
+			return SearchPageRequest{}, errors.New("cursor must be a string")
+		}
+
+		cursor, err := decodeSearchCursor(encodedCursor)
+
+		if err != nil {
+			return SearchPageRequest{}, err
+		}
+
+		pageRequest.Sort = cursor.Sort
+		pageRequest.Descending = cursor.Descending
+		pageRequest.SearchAfter = cursor.SearchAfter
+
+		return pageRequest, nil
+	}
+
+	if requestSort, ok := requestBody["sort"]; ok {
+		sort, ok := requestSort.(string)
+
+		if _, isSortField := searchSortFields[sort]; !ok || !isSortField {
+			return SearchPageRequest{}, errors.New("sort must be one of date, sender, subject, size or relevance")
+		}
+
+		pageRequest.Sort = sort
+	}
+
+	// Newest and most relevant first, alphabetical otherwise.
+	pageRequest.Descending = pageRequest.Sort == SearchSortDate || pageRequest.Sort == SearchSortRelevance
+
+	if requestOrder, ok := requestBody["order"]; ok {
+		switch requestOrder {
+		case "asc":
+			pageRequest.Descending = false
+		case "desc":
+			pageRequest.Descending = true
+		default:
+			return SearchPageRequest{}, errors.New("order must be asc or desc")
+		}
+	}
+
+	return pageRequest, nil
+}
+
+// decodeSearchCursor decodes the opaque cursor.
+func decodeSearchCursor(encodedCursor string) (searchCursor, error) {
+	cursorJSON, err := base64.RawURLEncoding.DecodeString(encodedCursor)
+
+	if err != nil {
+		return searchCursor{}, errors.New("invalid cursor")
+	}
+
+	var cursor searchCursor
+
+	decoder := json.NewDecoder(bytes.NewReader(cursorJSON))
+
+	// Keep the exact sort values (large numbers would lose precision as float64).
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&cursor); err != nil {
+		return searchCursor{}, errors.New("invalid cursor")
+	}
+
+	if _, ok := searchSortFields[cursor.Sort]; !ok || len(cursor.SearchAfter) == 0 {
+		return searchCursor{}, errors.New("invalid cursor")
+	}
+
+	return cursor, nil
+}
+
+// encodeSearchCursor encodes the opaque cursor.
+func encodeSearchCursor(cursor searchCursor) (string, error) {
+	cursorJSON, err := json.Marshal(cursor)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(cursorJSON), nil
+}
+
+// NewSearchBody creates the Elasticsearch search body for the page of the query.
+func NewSearchBody(query map[string]interface{}, pageRequest SearchPageRequest) map[string]interface{} {
+	order := "asc"
+
+	if pageRequest.Descending {
+		order = "desc"
+	}
+
+	searchBody := map[string]interface{}{
+		"query": query,
+		"size":  pageRequest.PageSize,
+		"sort": []interface{}{
+			map[string]interface{}{searchSortFields[pageRequest.Sort]: map[string]interface{}{"order": order}},
+			// The message UUID is the tiebreaker so the cursor is unique.
+			map[string]interface{}{MessageFieldUUID: map[string]interface{}{"order": "asc"}},
+		},
+		// The extracted attachment texts can be large, they are only searched and highlighted.
+		"_source": map[string]interface{}{"excludes": []string{MessageFieldAttachmentContent}},
+	}
+
+	if pageRequest.SearchAfter != nil {
+		searchBody["search_after"] = pageRequest.SearchAfter
+	}
+
+	if pageRequest.ExactTotal {
+		searchBody["track_total_hits"] = true
+	}
+
+	return searchBody
+}
+
+// NewSearchPage creates the page of messages from the Elasticsearch search response.
+func NewSearchPage(searchResponse ElasticsearchSearchResponse, pageRequest SearchPageRequest) (SearchPage, error) {
+	messages, err := GetMessagesFromHits(searchResponse.Hits.Hits)
+
+	if err != nil {
+		return SearchPage{}, err
+	}
+
+	searchPage := SearchPage{
+		Messages:       messages,
+		TotalHits:      searchResponse.Hits.Total.Value,
+		TotalHitsExact: searchResponse.Hits.Total.Relation == "eq",
+	}
+
+	if len(searchResponse.Hits.Hits) == pageRequest.PageSize {
+		cursor, err := encodeSearchCursor(searchCursor{
+			Sort:        pageRequest.Sort,
+			Descending:  pageRequest.Descending,
+			SearchAfter: searchResponse.Hits.Hits[len(searchResponse.Hits.Hits)-1].Sort,
+		})
+
+		if err != nil {
+			return SearchPage{}, err
+		}
+
+		searchPage.Cursor = cursor
+	}
+
+	return searchPage, nil
+}
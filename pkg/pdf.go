@@ -0,0 +1,382 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"golang.org/x/text/encoding/charmap"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Constants defining the PDF page size (A4) in points.
+const (
+	PDFPageWidth  = 595.28
+	PDFPageHeight = 841.89
+)
+
+// Constants defining the PDF fonts, the standard Type 1 fonts every PDF reader has so no font is embedded.
+// The fonts use the Windows-1252 encoding, other characters are written as "?".
+const (
+	PDFFontRegular   = "F1"
+	PDFFontBold      = "F2"
+	PDFFontMonospace = "F3"
+)
+
+// pdfBaseFonts defines the base font of each PDF font, in the order of the font objects.
+var pdfBaseFonts = []struct {
+	Name     string
+	BaseFont string
+}{
+	{PDFFontRegular, "Helvetica"},
+	{PDFFontBold, "Helvetica-Bold"},
+	{PDFFontMonospace, "Courier"},
+}
+
+// pdfFontWidths defines the character widths (in 1/1000 of the font size) of the characters 32 to 126.
+var pdfFontWidths = map[string][]int{
+	PDFFontRegular: {
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	},
+	PDFFontBold: {
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	},
+}
+
+// Constants defining the character widths not in pdfFontWidths.
+const (
+	// pdfWideCharacterWidth is used for the characters above 126 so the text is never wider than measured.
+	pdfWideCharacterWidth = 667
+	pdfMonospaceWidth     = 600
+)
+
+// PDFDocument represents a PDF document, written by WriteTo.
+type PDFDocument struct {
+	Title        string
+	CreationDate time.Time
+	Pages        []*PDFPage
+}
+
+// PDFPage represents a page of a PDF document, coordinates are in points from the bottom left corner.
+type PDFPage struct {
+	content bytes.Buffer
+}
+
+// pdfWriter writes the PDF objects, tracking the offsets for the cross-reference table.
+type pdfWriter struct {
+	writer  io.Writer
+	offset  int64
+	offsets []int64
+	err     error
+}
+
+// AddPage adds a page to the document.
+func (document *PDFDocument) AddPage() *PDFPage {
+	page := &PDFPage{}
+
+	document.Pages = append(document.Pages, page)
+
+	return page
+}
+
+// Text writes the text with the baseline starting at x, y.
+func (page *PDFPage) Text(x float64, y float64, font string, size float64, text string) {
+	fmt.Fprintf(&page.content, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscapeText(EncodePDFText(text)))
+}
+
+// TextRight writes the text ending at x.
+func (page *PDFPage) TextRight(x float64, y float64, font string, size float64, text string) {
+	page.Text(x-PDFTextWidth(font, size, text), y, font, size, text)
+}
+
+// Line draws a line.
+func (page *PDFPage) Line(x1 float64, y1 float64, x2 float64, y2 float64, width float64) {
+	fmt.Fprintf(&page.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// Rectangle fills a rectangle with the gray level (0 is black, 1 is white).
+func (page *PDFPage) Rectangle(x float64, y float64, width float64, height float64, gray float64) {
+	fmt.Fprintf(&page.content, "%.2f g %.2f %.2f %.2f %.2f re f 0 g\n", gray, x, y, width, height)
+}
+
+// Redaction fills a black box with the label in white, the baseline starting at x, y.
+// Returns the width of the box.
+func (page *PDFPage) Redaction(x float64, y float64, font string, size float64, label string) float64 {
+	width := PDFTextWidth(font, size, label) + size*0.4
+
+	page.Rectangle(x, y-size*0.25, width, size*1.1, 0)
+	fmt.Fprintf(&page.content, "1 g BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET 0 g\n", font, size, x+size*0.2, y, pdfEscapeText(EncodePDFText(label)))
+
+	return width
+}
+
+// EncodePDFText encodes the text in Windows-1252, tabs are replaced by spaces and other control characters removed.
+func EncodePDFText(text string) []byte {
+	var encoded []byte
+
+	for _, character := range text {
+		switch {
+		case character == '\t':
+			encoded = append(encoded, "    "...)
+		case character < ' ' || character == 0x7F:
+			continue
+		case character < 0x7F:
+			encoded = append(encoded, byte(character))
+		default:
+			if encodedCharacter, ok := charmap.Windows1252.EncodeRune(character); ok {
+				encoded = append(encoded, encodedCharacter)
+			} else {
+				encoded = append(encoded, '?')
+			}
+		}
+	}
+
+	return encoded
+}
+
+// IsPDFTextEncodable returns true if every printable character of the text can be encoded in Windows-1252,
+// otherwise EncodePDFText prints the other characters as "?".
+func IsPDFTextEncodable(text string) bool {
+	for _, character := range text {
+		if character < 0x7F {
+			continue
+		}
+
+		if _, ok := charmap.Windows1252.EncodeRune(character); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pdfEscapeText escapes the encoded text for a PDF string literal, characters above 126 are written in octal.
+func pdfEscapeText(encoded []byte) string {
+	var escaped strings.Builder
+
+	for _, character := range encoded {
+		switch {
+		case character == '(' || character == ')' || character == '\\':
+			escaped.WriteByte('\\')
+			escaped.WriteByte(character)
+		case character > 126:
+			fmt.Fprintf(&escaped, "\\%03o", character)
+		default:
+			escaped.WriteByte(character)
+		}
+	}
+
+	return escaped.String()
+}
+
+// pdfTextString returns the text as a PDF text string (UTF-16BE), used for the document information.
+func pdfTextString(text string) string {
+	var textString strings.Builder
+
+	textString.WriteString("<FEFF")
+
+	for _, unit := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&textString, "%04X", unit)
+	}
+
+	textString.WriteString(">")
+
+	return textString.String()
+}
+
+// PDFTextWidth returns the width of the text in points.
+func PDFTextWidth(font string, size float64, text string) float64 {
+	width := 0
+
+	for _, character := range EncodePDFText(text) {
+		switch {
+		case font == PDFFontMonospace:
+			width += pdfMonospaceWidth
+		case character >= 32 && character <= 126:
+			width += pdfFontWidths[font][character-32]
+		default:
+			width += pdfWideCharacterWidth
+		}
+	}
+
+	return float64(width) * size / 1000
+}
+
+// WrapPDFText splits the text into lines no wider than the width, at spaces where possible.
+// Line breaks in the text are kept.
+func WrapPDFText(font string, size float64, text string, width float64) []string {
+	var lines []string
+
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := ""
+		isLineStarted := false
+
+		for _, word := range strings.Split(paragraph, " ") {
+			candidate := word
+
+			if isLineStarted {
+				candidate = line + " " + word
+			}
+
+			if PDFTextWidth(font, size, candidate) <= width {
+				line = candidate
+				isLineStarted = true
+				continue
+			}
+
+			if isLineStarted {
+				lines = append(lines, line)
+			}
+
+			// Words wider than a line (such as URLs and hashes) are broken anywhere.
+			for PDFTextWidth(font, size, word) > width {
+				runes := []rune(word)
+				cut := 1
+
+				for cut < len(runes) && PDFTextWidth(font, size, string(runes[:cut+1])) <= width {
+					cut++
+				}
+
+				lines = append(lines, string(runes[:cut]))
+				word = string(runes[cut:])
+			}
+
+			line = word
+			isLineStarted = true
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// printf writes to the PDF, after an error nothing is written.
+func (writer *pdfWriter) printf(format string, arguments ...interface{}) {
+	if writer.err != nil {
+		return
+	}
+
+	written, err := fmt.Fprintf(writer.writer, format, arguments...)
+
+	writer.offset += int64(written)
+	writer.err = err
+}
+
+// write writes the data to the PDF.
+func (writer *pdfWriter) write(data []byte) {
+	if writer.err != nil {
+		return
+	}
+
+	written, err := writer.writer.Write(data)
+
+	writer.offset += int64(written)
+	writer.err = err
+}
+
+// beginObject starts the object with the number, objects must be written in order.
+func (writer *pdfWriter) beginObject(objectNumber int) {
+	writer.offsets = append(writer.offsets, writer.offset)
+	writer.printf("%d 0 obj\n", objectNumber)
+}
+
+// WriteTo writes the PDF document, the page contents are compressed.
+func (document *PDFDocument) WriteTo(writer io.Writer) (int64, error) {
+	if len(document.Pages) == 0 {
+		document.AddPage()
+	}
+
+	output := &pdfWriter{writer: writer}
+
+	// The objects are the catalog, the page tree, the fonts, the document information and a page and content
+	// object per page.
+	catalogObject := 1
+	pagesObject := 2
+	firstFontObject := 3
+	informationObject := firstFontObject + len(pdfBaseFonts)
+	firstPageObject := informationObject + 1
+
+	output.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	output.beginObject(catalogObject)
+	output.printf("<< /Type /Catalog /Pages %d 0 R >>\nendobj\n", pagesObject)
+
+	var pageReferences []string
+
+	for i := range document.Pages {
+		pageReferences = append(pageReferences, fmt.Sprintf("%d 0 R", firstPageObject+i*2))
+	}
+
+	output.beginObject(pagesObject)
+	output.printf("<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(pageReferences, " "), len(document.Pages))
+
+	var fontReferences []string
+
+	for i, pdfBaseFont := range pdfBaseFonts {
+		output.beginObject(firstFontObject + i)
+		output.printf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>\nendobj\n", pdfBaseFont.BaseFont)
+
+		fontReferences = append(fontReferences, fmt.Sprintf("/%s %d 0 R", pdfBaseFont.Name, firstFontObject+i))
+	}
+
+	creationDate := document.CreationDate
+
+	if creationDate.IsZero() {
+		creationDate = time.Now()
+	}
+
+	output.beginObject(informationObject)
+	output.printf("<< /Title %s /Producer %s /CreationDate (D:%s) >>\nendobj\n",
+		pdfTextString(document.Title), pdfTextString("Go Forensics"), creationDate.UTC().Format("20060102150405Z"))
+
+	for i, page := range document.Pages {
+		var content bytes.Buffer
+
+		zlibWriter := zlib.NewWriter(&content)
+
+		if _, err := zlibWriter.Write(page.content.Bytes()); err != nil {
+			return output.offset, err
+		}
+
+		if err := zlibWriter.Close(); err != nil {
+			return output.offset, err
+		}
+
+		output.beginObject(firstPageObject + i*2)
+		output.printf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s >> >> /Contents %d 0 R >>\nendobj\n",
+			pagesObject, PDFPageWidth, PDFPageHeight, strings.Join(fontReferences, " "), firstPageObject+i*2+1)
+
+		output.beginObject(firstPageObject + i*2 + 1)
+		output.printf("<< /Length %d /Filter /FlateDecode >>\nstream\n", content.Len())
+		output.write(content.Bytes())
+		output.printf("\nendstream\nendobj\n")
+	}
+
+	crossReferenceOffset := output.offset
+
+	output.printf("xref\n0 %d\n0000000000 65535 f \n", len(output.offsets)+1)
+
+	for _, offset := range output.offsets {
+		output.printf("%010d 00000 n \n", offset)
+	}
+
+	output.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(output.offsets)+1, catalogObject, informationObject, crossReferenceOffset)
+
+	return output.offset, output.err
+}
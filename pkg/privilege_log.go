@@ -0,0 +1,671 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants defining the privilege log limits.
+const (
+	MaxPrivilegeLogEntries        = 100000
+	MaxPrivilegeLogOverrideLength = 2000
+)
+
+// errTooManyPrivilegeLogEntries stops walking the messages when the privilege log would be too large.
+var errTooManyPrivilegeLogEntries = fmt.Errorf("more than %d privileged messages, narrow the query", MaxPrivilegeLogEntries)
+
+// errUnknownPrivilegeLogField is returned when the privilege type or description field is not in the coding schema.
+var errUnknownPrivilegeLogField = errors.New("unknown coding field")
+
+// privilegeLogHeader defines the columns of the privilege log exports.
+var privilegeLogHeader = []string{"Number", "Document ID", "Date", "Author", "Recipients", "CC", "Subject", "Privilege type", "Description"}
+
+// privilegeLogColumnWidths defines the column widths (in characters) of the XLSX export.
+var privilegeLogColumnWidths = []float64{8, 38, 22, 30, 40, 30, 50, 20, 60}
+
+// PrivilegeLogRequest represents a request for the privilege log of the messages coded or tagged as privileged.
+type PrivilegeLogRequest struct {
+	// Query selects the privileged messages, for example "coding:privileged=yes".
+	Query string `json:"query"`
+	// Tag selects the messages with the tag, in addition to the messages matching the query.
+	Tag string `json:"tag"`
+	// PrivilegeTypeField is the coding field (name or ID) of the privilege type, for example "Privilege type".
+	PrivilegeTypeField string `json:"privilegeTypeField"`
+	// DescriptionField is the coding field (name or ID) of the privilege description.
+	DescriptionField string `json:"descriptionField"`
+	// Timezone is the IANA timezone of the dates, defaults to UTC.
+	Timezone string `json:"timezone"`
+}
+
+// PrivilegeLog represents the log of the messages withheld as privileged.
+type PrivilegeLog struct {
+	Entries []PrivilegeLogEntry `json:"entries"`
+	// Timezone is the timezone of the formatted dates.
+	Timezone string `json:"timezone"`
+}
+
+// PrivilegeLogEntry represents a withheld message in the privilege log.
+type PrivilegeLogEntry struct {
+	Number      int    `json:"number"`
+	MessageUUID string `json:"messageUUID"`
+	Date        int    `json:"date"`
+	// FormattedDate is the date in the timezone of the privilege log.
+	FormattedDate string `json:"formattedDate"`
+	Author        string `json:"author"`
+	Recipients    string `json:"recipients"`
+	CC            string `json:"cc"`
+	// Subject is the subject of the message, or the override if the subject itself is privileged.
+	Subject           string `json:"subject"`
+	IsSubjectRedacted bool   `json:"isSubjectRedacted"`
+	PrivilegeType     string `json:"privilegeType"`
+	Description       string `json:"description"`
+}
+
+// PrivilegeLogOverride represents the values of a message entered for the privilege log.
+// Non-empty values take precedence over the message subject and the coded privilege type and description.
+type PrivilegeLogOverride struct {
+	MessageUUID      string `json:"messageUUID"`
+	ProjectUUID      string `json:"projectUUID"`
+	Subject          string `json:"subject"`
+	PrivilegeType    string `json:"privilegeType"`
+	Description      string `json:"description"`
+	EditorID         string `json:"editorID"`
+	ModificationDate int    `json:"modificationDate"`
+}
+
+// handlePrivilegeLog handles the privilege log endpoint.
+// Returns CSV or XLSX instead of JSON with the "format=csv" or "format=xlsx" query parameter.
+func (server *Server) handlePrivilegeLog() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			var privilegeLogRequest PrivilegeLogRequest
+
+			if err := json.NewDecoder(request.Body).Decode(&privilegeLogRequest); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			format := request.URL.Query().Get("format")
+
+			if format != "" && format != "json" && format != "csv" && format != "xlsx" {
+				Logger.Errorf("Invalid privilege log format: %s", format)
+				http.Error(responseWriter, "Invalid format, expected json, csv or xlsx.", http.StatusBadRequest)
+				return
+			}
+
+			privilegeLog, ok := server.newPrivilegeLog(request.Context(), responseWriter, privilegeLogRequest, project.UUID)
+
+			if !ok {
+				return
+			}
+
+			switch format {
+			case "csv":
+				responseWriter.Header().Set("Content-Type", "text/csv")
+				responseWriter.Header().Set("Content-Disposition", "attachment; filename=\"privilege-log.csv\"")
+
+				if err := privilegeLog.WriteCSV(responseWriter); err != nil {
+					Logger.Errorf("Failed to write CSV: %s", err)
+					http.Error(responseWriter, "Failed to write CSV.", http.StatusInternalServerError)
+					return
+				}
+			case "xlsx":
+				responseWriter.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+				responseWriter.Header().Set("Content-Disposition", "attachment; filename=\"privilege-log.xlsx\"")
+
+				if err := privilegeLog.WriteXLSX(responseWriter); err != nil {
+					Logger.Errorf("Failed to write XLSX: %s", err)
+					http.Error(responseWriter, "Failed to write XLSX.", http.StatusInternalServerError)
+					return
+				}
+			default:
+				if err := json.NewEncoder(responseWriter).Encode(&privilegeLog); err != nil {
+					Logger.Errorf("Failed to encode response: %s", err)
+					http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+	}
+}
+
+// newPrivilegeLog validates the request and creates the privilege log, writing the error response on failure.
+// Returns false if the privilege log could not be created.
+func (server *Server) newPrivilegeLog(ctx context.Context, responseWriter http.ResponseWriter, privilegeLogRequest PrivilegeLogRequest, projectUUID string) (PrivilegeLog, bool) {
+	location, err := ValidatePrivilegeLogRequest(&privilegeLogRequest)
+
+	if err != nil {
+		Logger.Errorf("Invalid privilege log request: %s", err)
+		http.Error(responseWriter, fmt.Sprintf("Invalid privilege log request: %s.", err), http.StatusBadRequest)
+		return PrivilegeLog{}, false
+	}
+
+	privilegeLog, err := NewPrivilegeLog(ctx, privilegeLogRequest, location, projectUUID, server.Database)
+
+	var queryParseError *QueryParseError
+
+	if errors.As(err, &queryParseError) {
+		Logger.Errorf("Failed to parse privilege log query: %s", err)
+		writeQueryParseError(responseWriter, queryParseError)
+		return PrivilegeLog{}, false
+	} else if errors.Is(err, errTooManyPrivilegeLogEntries) || errors.Is(err, errUnknownPrivilegeLogField) || errors.Is(err, errTooManyQueryMessages) {
+		Logger.Errorf("Invalid privilege log request: %s", err)
+		http.Error(responseWriter, fmt.Sprintf("Invalid privilege log request: %s.", err), http.StatusBadRequest)
+		return PrivilegeLog{}, false
+	} else if err != nil {
+		Logger.Errorf("Failed to create privilege log: %s", err)
+		http.Error(responseWriter, "Failed to create privilege log.", http.StatusInternalServerError)
+		return PrivilegeLog{}, false
+	}
+
+	return privilegeLog, true
+}
+
+// handleMessagePrivilegeLog handles the privilege log override of a message.
+// GET returns the override, POST sets it and DELETE removes it.
+func (server *Server) handleMessagePrivilegeLog() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to get message: %s", err)
+			http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+			return
+		}
+
+		if request.Method == "GET" {
+			privilegeLogOverride, err := GetPrivilegeLogOverride(message.UUID, project.UUID, server.Database)
+
+			if errors.Is(err, pgx.ErrNoRows) {
+				privilegeLogOverride = PrivilegeLogOverride{MessageUUID: message.UUID, ProjectUUID: project.UUID}
+			} else if err != nil {
+				Logger.Errorf("Failed to get privilege log override: %s", err)
+				http.Error(responseWriter, "Failed to get privilege log override.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&privilegeLogOverride); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			var privilegeLogOverride PrivilegeLogOverride
+
+			if err := json.NewDecoder(request.Body).Decode(&privilegeLogOverride); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			privilegeLogOverride.MessageUUID = message.UUID
+			privilegeLogOverride.ProjectUUID = project.UUID
+			privilegeLogOverride.EditorID = user.Id
+			privilegeLogOverride.ModificationDate = int(time.Now().Unix())
+
+			if err := privilegeLogOverride.Validate(); err != nil {
+				Logger.Errorf("Invalid privilege log override: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid privilege log override: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			if err := privilegeLogOverride.Save(server.Database); err != nil {
+				Logger.Errorf("Failed to save privilege log override: %s", err)
+				http.Error(responseWriter, "Failed to save privilege log override.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&privilegeLogOverride); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "DELETE" {
+			if err := DeletePrivilegeLogOverride(message.UUID, project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to delete privilege log override: %s", err)
+				http.Error(responseWriter, "Failed to delete privilege log override.", http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// ValidatePrivilegeLogRequest validates the privilege log request, setting the defaults, and returns the timezone location.
+func ValidatePrivilegeLogRequest(privilegeLogRequest *PrivilegeLogRequest) (*time.Location, error) {
+	privilegeLogRequest.Query = strings.TrimSpace(privilegeLogRequest.Query)
+
+	if privilegeLogRequest.Query == "" && privilegeLogRequest.Tag == "" {
+		return nil, errors.New("expected a query or tag selecting the privileged messages")
+	}
+
+	if privilegeLogRequest.Timezone == "" {
+		privilegeLogRequest.Timezone = DefaultExhibitTimezone
+	}
+
+	location, err := time.LoadLocation(privilegeLogRequest.Timezone)
+
+	// The local timezone of the server is not meaningful to the client.
+	if err != nil || privilegeLogRequest.Timezone == "Local" {
+		return nil, fmt.Errorf("unknown timezone \"%s\", expected an IANA timezone such as Europe/Amsterdam", privilegeLogRequest.Timezone)
+	}
+
+	return location, nil
+}
+
+// Validate validates the privilege log override.
+func (privilegeLogOverride *PrivilegeLogOverride) Validate() error {
+	privilegeLogOverride.Subject = strings.TrimSpace(privilegeLogOverride.Subject)
+	privilegeLogOverride.PrivilegeType = strings.TrimSpace(privilegeLogOverride.PrivilegeType)
+	privilegeLogOverride.Description = strings.TrimSpace(privilegeLogOverride.Description)
+
+	for name, value := range map[string]string{
+		"subject":       privilegeLogOverride.Subject,
+		"privilegeType": privilegeLogOverride.PrivilegeType,
+		"description":   privilegeLogOverride.Description,
+	} {
+		if len(value) > MaxPrivilegeLogOverrideLength {
+			return fmt.Errorf("the %s is longer than %d characters", name, MaxPrivilegeLogOverrideLength)
+		}
+	}
+
+	return nil
+}
+
+// NewPrivilegeLog creates the privilege log of the messages matching the query or with the tag, ordered by date.
+// The privilege type and description are taken from the coding fields, unless overridden for the message.
+func NewPrivilegeLog(ctx context.Context, privilegeLogRequest PrivilegeLogRequest, location *time.Location, projectUUID string, database *pgx.Conn) (PrivilegeLog, error) {
+	queryResolver := NewDatabaseQueryResolver(projectUUID, database)
+
+	var queries []interface{}
+
+	if privilegeLogRequest.Query != "" {
+		queryNode, err := ParseQuery(privilegeLogRequest.Query)
+
+		if err != nil {
+			return PrivilegeLog{}, err
+		}
+
+		elasticsearchQuery, err := CompileQuery(queryNode, queryResolver)
+
+		if err != nil {
+			return PrivilegeLog{}, err
+		}
+
+		queries = append(queries, elasticsearchQuery)
+	}
+
+	if privilegeLogRequest.Tag != "" {
+		tagMessageUUIDs, err := queryResolver.GetTagMessageUUIDs(privilegeLogRequest.Tag)
+
+		if err != nil {
+			return PrivilegeLog{}, err
+		}
+
+		tagQuery, err := newMessageUUIDsQuery(tagMessageUUIDs)
+
+		if err != nil {
+			return PrivilegeLog{}, fmt.Errorf("tag \"%s\": %w", privilegeLogRequest.Tag, err)
+		}
+
+		queries = append(queries, tagQuery)
+	}
+
+	privilegeTypes, err := getPrivilegeLogCodedValues(privilegeLogRequest.PrivilegeTypeField, projectUUID, database)
+
+	if err != nil {
+		return PrivilegeLog{}, err
+	}
+
+	descriptions, err := getPrivilegeLogCodedValues(privilegeLogRequest.DescriptionField, projectUUID, database)
+
+	if err != nil {
+		return PrivilegeLog{}, err
+	}
+
+	privilegeLogOverrides, err := GetPrivilegeLogOverrides(projectUUID, database)
+
+	if err != nil {
+		return PrivilegeLog{}, err
+	}
+
+	privilegeLog := PrivilegeLog{
+		Entries:  []PrivilegeLogEntry{},
+		Timezone: privilegeLogRequest.Timezone,
+	}
+
+	elasticsearchQuery := NewProjectQuery(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               queries,
+			"minimum_should_match": 1,
+		},
+	}, projectUUID)
+
+	err = WalkMessages(ctx, elasticsearchQuery, func(message core.Message) error {
+		if len(privilegeLog.Entries) == MaxPrivilegeLogEntries {
+			return errTooManyPrivilegeLogEntries
+		}
+
+		privilegeLogEntry := PrivilegeLogEntry{
+			Number:        len(privilegeLog.Entries) + 1,
+			MessageUUID:   message.UUID,
+			Date:          message.Date,
+			FormattedDate: formatPrivilegeLogDate(message.Date, location),
+			Author:        message.From,
+			Recipients:    message.To,
+			CC:            message.CC,
+			Subject:       message.Subject,
+			PrivilegeType: privilegeTypes[message.UUID],
+			Description:   descriptions[message.UUID],
+		}
+
+		if privilegeLogOverride, ok := privilegeLogOverrides[message.UUID]; ok {
+			if privilegeLogOverride.Subject != "" {
+				privilegeLogEntry.Subject = privilegeLogOverride.Subject
+				privilegeLogEntry.IsSubjectRedacted = true
+			}
+
+			if privilegeLogOverride.PrivilegeType != "" {
+				privilegeLogEntry.PrivilegeType = privilegeLogOverride.PrivilegeType
+			}
+
+			if privilegeLogOverride.Description != "" {
+				privilegeLogEntry.Description = privilegeLogOverride.Description
+			}
+		}
+
+		privilegeLog.Entries = append(privilegeLog.Entries, privilegeLogEntry)
+
+		return nil
+	})
+
+	if err != nil {
+		return PrivilegeLog{}, err
+	}
+
+	return privilegeLog, nil
+}
+
+// getPrivilegeLogCodedValues returns the coded values of the field (name or ID) by message UUID.
+// Choices are written as their labels (in the schema version of the coding) and multiple values are separated by "; ".
+// Returns an empty map if the field is empty.
+func getPrivilegeLogCodedValues(field string, projectUUID string, database *pgx.Conn) (map[string]string, error) {
+	codedValues := make(map[string]string)
+
+	if field == "" {
+		return codedValues, nil
+	}
+
+	codingSchemas, err := GetCodingSchemas(projectUUID, database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	codingField, isField := findCodingField(field, codingSchemas)
+
+	if !isField {
+		return nil, fmt.Errorf("%w \"%s\"", errUnknownPrivilegeLogField, field)
+	}
+
+	codingSchemasByVersion := make(map[int]CodingSchema)
+
+	for _, codingSchema := range codingSchemas {
+		codingSchemasByVersion[codingSchema.Version] = codingSchema
+	}
+
+	rows, err := database.Query(context.Background(), "SELECT message_uuid, value, schema_version FROM codings WHERE project_uuid = $1 AND field_id = $2 ORDER BY message_uuid, value", projectUUID, codingField.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageUUID string
+		var value string
+		var schemaVersion int
+
+		if err := rows.Scan(&messageUUID, &value, &schemaVersion); err != nil {
+			return nil, err
+		}
+
+		codingSchema := codingSchemasByVersion[schemaVersion]
+		schemaField, _ := codingSchema.GetField(codingField.ID)
+
+		if label, ok := schemaField.GetChoiceLabel(value); ok {
+			value = label
+		} else if schemaField.Type == CodingFieldBoolean {
+			value = map[bool]string{true: "Yes", false: "No"}[value == "true"]
+		}
+
+		if codedValues[messageUUID] != "" {
+			value = codedValues[messageUUID] + "; " + value
+		}
+
+		codedValues[messageUUID] = value
+	}
+
+	return codedValues, rows.Err()
+}
+
+// formatPrivilegeLogDate formats the date (Unix seconds) in the timezone location.
+func formatPrivilegeLogDate(date int, location *time.Location) string {
+	if date == 0 {
+		return ""
+	}
+
+	return time.Unix(int64(date), 0).In(location).Format("2006-01-02 15:04:05 MST")
+}
+
+// GetRecords returns the header and rows of the privilege log exports.
+func (privilegeLog *PrivilegeLog) GetRecords() [][]string {
+	records := [][]string{privilegeLogHeader}
+
+	for _, privilegeLogEntry := range privilegeLog.Entries {
+		records = append(records, []string{
+			strconv.Itoa(privilegeLogEntry.Number),
+			privilegeLogEntry.MessageUUID,
+			privilegeLogEntry.FormattedDate,
+			privilegeLogEntry.Author,
+			privilegeLogEntry.Recipients,
+			privilegeLogEntry.CC,
+			privilegeLogEntry.Subject,
+			privilegeLogEntry.PrivilegeType,
+			privilegeLogEntry.Description,
+		})
+	}
+
+	return records
+}
+
+// WriteCSV writes the privilege log as CSV, cells which spreadsheet applications would evaluate as formulas are escaped.
+func (privilegeLog *PrivilegeLog) WriteCSV(writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+	records := privilegeLog.GetRecords()
+
+	for i, record := range records {
+		records[i] = escapeCSVFormulas(record)
+	}
+
+	if err := csvWriter.WriteAll(records); err != nil {
+		return err
+	}
+
+	return csvWriter.Error()
+}
+
+// escapeCSVFormula prefixes cells starting with a formula character with a quote, so spreadsheet applications
+// opening the CSV show the text instead of evaluating it (subjects and addresses come from the messages).
+func escapeCSVFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+
+	return cell
+}
+
+// escapeCSVFormulas escapes each cell of the record with escapeCSVFormula.
+func escapeCSVFormulas(record []string) []string {
+	escapedRecord := make([]string, len(record))
+
+	for i, cell := range record {
+		escapedRecord[i] = escapeCSVFormula(cell)
+	}
+
+	return escapedRecord
+}
+
+// WriteXLSX writes the privilege log as an Excel workbook.
+func (privilegeLog *PrivilegeLog) WriteXLSX(writer io.Writer) error {
+	return WriteXLSX(writer, "Privilege log", privilegeLog.GetRecords(), privilegeLogColumnWidths)
+}
+
+// privilegeLogTemplate defines the HTML report section of the privilege log.
+var privilegeLogTemplate = template.Must(template.New("privilegeLog").Parse(`
+<p>{{len .Entries}} messages withheld as privileged. Dates are in {{.Timezone}}.</p>
+<table>
+	<thead>
+		<tr>
+			<th>Number</th>
+			<th>Date</th>
+			<th>Author</th>
+			<th>Recipients</th>
+			<th>CC</th>
+			<th>Subject</th>
+			<th>Privilege type</th>
+			<th>Description</th>
+		</tr>
+	</thead>
+	<tbody>
+		{{range .Entries}}
+		<tr>
+			<td>{{.Number}}</td>
+			<td>{{.FormattedDate}}</td>
+			<td>{{.Author}}</td>
+			<td>{{.Recipients}}</td>
+			<td>{{.CC}}</td>
+			<td>{{if .IsSubjectRedacted}}<em>{{.Subject}}</em>{{else}}{{.Subject}}{{end}}</td>
+			<td>{{.PrivilegeType}}</td>
+			<td>{{.Description}}</td>
+		</tr>
+		{{end}}
+	</tbody>
+</table>
+`))
+
+// NewHTMLReportSection creates the section of the privilege log in the HTML report.
+func (privilegeLog *PrivilegeLog) NewHTMLReportSection() (HTMLReportSection, error) {
+	return NewHTMLReportSection("Privilege log", privilegeLogTemplate, privilegeLog)
+}
+
+// Save saves the privilege log override to the database, replacing the previous override of the message.
+func (privilegeLogOverride *PrivilegeLogOverride) Save(database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), `
+		INSERT INTO privilege_log_overrides (project_uuid, message_uuid, subject, privilege_type, description, editor_id, modification_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (project_uuid, message_uuid) DO UPDATE SET subject = EXCLUDED.subject, privilege_type = EXCLUDED.privilege_type,
+			description = EXCLUDED.description, editor_id = EXCLUDED.editor_id, modification_date = EXCLUDED.modification_date`,
+		privilegeLogOverride.ProjectUUID, privilegeLogOverride.MessageUUID, privilegeLogOverride.Subject, privilegeLogOverride.PrivilegeType,
+		privilegeLogOverride.Description, privilegeLogOverride.EditorID, privilegeLogOverride.ModificationDate,
+	)
+
+	return err
+}
+
+// privilegeLogOverrideColumns defines the columns scanned by scanPrivilegeLogOverride.
+const privilegeLogOverrideColumns = "project_uuid, message_uuid, subject, privilege_type, description, editor_id, modification_date"
+
+// scanPrivilegeLogOverride scans the privilege log override from the row.
+func scanPrivilegeLogOverride(row pgx.Row) (PrivilegeLogOverride, error) {
+	var privilegeLogOverride PrivilegeLogOverride
+
+	if err := row.Scan(
+		&privilegeLogOverride.ProjectUUID,
+		&privilegeLogOverride.MessageUUID,
+		&privilegeLogOverride.Subject,
+		&privilegeLogOverride.PrivilegeType,
+		&privilegeLogOverride.Description,
+		&privilegeLogOverride.EditorID,
+		&privilegeLogOverride.ModificationDate,
+	); err != nil {
+		return PrivilegeLogOverride{}, err
+	}
+
+	return privilegeLogOverride, nil
+}
+
+// GetPrivilegeLogOverride returns the privilege log override of the message.
+func GetPrivilegeLogOverride(messageUUID string, projectUUID string, database *pgx.Conn) (PrivilegeLogOverride, error) {
+	return scanPrivilegeLogOverride(database.QueryRow(context.Background(), "SELECT "+privilegeLogOverrideColumns+" FROM privilege_log_overrides WHERE project_uuid = $1 AND message_uuid = $2", projectUUID, messageUUID))
+}
+
+// GetPrivilegeLogOverrides returns the privilege log overrides of the project by message UUID.
+func GetPrivilegeLogOverrides(projectUUID string, database *pgx.Conn) (map[string]PrivilegeLogOverride, error) {
+	rows, err := database.Query(context.Background(), "SELECT "+privilegeLogOverrideColumns+" FROM privilege_log_overrides WHERE project_uuid = $1", projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	privilegeLogOverrides := make(map[string]PrivilegeLogOverride)
+
+	for rows.Next() {
+		privilegeLogOverride, err := scanPrivilegeLogOverride(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		privilegeLogOverrides[privilegeLogOverride.MessageUUID] = privilegeLogOverride
+	}
+
+	return privilegeLogOverrides, rows.Err()
+}
+
+// DeletePrivilegeLogOverride deletes the privilege log override of the message.
+func DeletePrivilegeLogOverride(messageUUID string, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), "DELETE FROM privilege_log_overrides WHERE project_uuid = $1 AND message_uuid = $2", projectUUID, messageUUID)
+
+	return err
+}
@@ -0,0 +1,1169 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"fmt"
+	"math"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The query language used by SearchTypeQuery.
+//
+//	query      = or
+//	or         = and { "OR" and }
+//	and        = not { [ "AND" ] not }
+//	not        = ( "NOT" | "-" ) not | primary
+//	primary    = "(" or ")" | term
+//	term       = [ field ":" ] ( word | phrase | regexp )
+//	phrase     = '"' { character } '"' [ "~" distance ]
+//	regexp     = "/" { character } "/"
+//
+// Terms without a field search the subject, body, sender, recipients, attachment names and attachment contents.
+// Adjacent terms are combined with AND, the operators AND, OR and NOT must be written in uppercase.
+//
+// Fields:
+//
+//	from:, to:, cc:, subject:, body:, attachment:  Text search in the field.
+//	content:                                       Text search in the extracted text of the attachments.
+//	tag:name                                       Messages with the tag.
+//	bookmarked:true, bookmarked:false              Messages which are (not) bookmarked.
+//	folder:name                                    Messages in folders with the name (case-insensitive).
+//	evidence:name                                  Messages in the evidence item (UUID, file name, file hash or custodian).
+//	has:attachment                                 Messages with attachments.
+//	entity:iban=NL91ABNA0417164300, entity:iban    Messages with the extracted entity (value), see EntityTypes.
+//	coding:responsive=yes, coding:hot              Messages with the coded value (any value), see CodingSchema.
+//	coding:"privilege type=attorney client"        Use a phrase for field names or values with spaces.
+//	date:2021-03-01                                Messages on that day, also 2021, 2021-03 and 2021-03-01T10:30.
+//	date:2021-01-01..2021-06-30                    Date range, either end may be omitted.
+//	date:>2021-01-01, date:<=2021-06               Date comparisons (>, >=, <, <=).
+//	size:>1MB, size:10KB..2MB                      Size comparisons and ranges (B, KB, MB, GB).
+//
+// Dates are interpreted in UTC.
+//
+// Search modes (without a field or with the from, to, cc, subject, body, attachment and content fields):
+//
+//	invoice~, invoice~2                            Fuzzy, words within 1 or 2 edits (~ picks the distance by word length).
+//	"invoice payment"~5                            Proximity, the words within 5 positions of each other.
+//	invo*, inv?ice                                 Wildcards, * matches any characters and ? a single character.
+//	/inv[oa]ice[0-9]+/                             Regular expression matched against each word (use \/ for a slash).
+//	from.keyword:*@example.com                     Wildcards and regular expressions on the whole field value
+//	                                               (from, to, cc, subject and attachment) instead of each word.
+//
+// These modes are guarded against runaway queries: fuzzy words need at least 3 characters, wildcards need a prefix of
+// at least 2 characters (except on whole field values), regular expressions can not start with "." and a query can
+// contain at most 10 of these terms. Elasticsearch rejects regular expressions which are still too complex.
+//
+// Examples:
+//
+//	from:alice subject:"quarterly results" date:2021
+//	(invoice OR payment) AND NOT tag:reviewed
+//	has:attachment size:>5MB -folder:"Deleted Items"
+//	"wire transfer"~3 OR payme~ OR subject:/inv[0-9]+/
+
+// Constants defining the query fields.
+const (
+	QueryFieldFrom       = "from"
+	QueryFieldTo         = "to"
+	QueryFieldCC         = "cc"
+	QueryFieldSubject    = "subject"
+	QueryFieldBody       = "body"
+	QueryFieldAttachment = "attachment"
+	QueryFieldContent    = "content"
+	QueryFieldTag        = "tag"
+	QueryFieldBookmarked = "bookmarked"
+	QueryFieldFolder     = "folder"
+	QueryFieldEvidence   = "evidence"
+	QueryFieldEntity     = "entity"
+	QueryFieldCoding     = "coding"
+	QueryFieldHas        = "has"
+	QueryFieldDate       = "date"
+	QueryFieldSize       = "size"
+)
+
+// QuerySearchFields defines the query fields which accept the fuzzy, proximity, wildcard and regular expression modes.
+var QuerySearchFields = []string{
+	QueryFieldFrom,
+	QueryFieldTo,
+	QueryFieldCC,
+	QueryFieldSubject,
+	QueryFieldBody,
+	QueryFieldAttachment,
+	QueryFieldContent,
+}
+
+// QueryKeywordFieldSuffix is added to a search field to match the whole field value (for example from.keyword:).
+const QueryKeywordFieldSuffix = ".keyword"
+
+// Constants defining the guard rails of the fuzzy, proximity, wildcard and regular expression modes.
+const (
+	MaxQueryPatternTerms    = 10
+	MinQueryFuzzyTermLength = 3
+	MaxQueryFuzzyEdits      = 2
+	MaxQueryProximity       = 100
+	MinQueryWildcardPrefix  = 2
+	MaxQueryWildcards       = 5
+	MaxQueryRegexpLength    = 100
+)
+
+// QueryTextFields defines the query fields which accept words and phrases.
+var QueryTextFields = []string{
+	QueryFieldFrom,
+	QueryFieldTo,
+	QueryFieldCC,
+	QueryFieldSubject,
+	QueryFieldBody,
+	QueryFieldAttachment,
+	QueryFieldContent,
+	QueryFieldTag,
+	QueryFieldFolder,
+	QueryFieldEvidence,
+}
+
+// QueryNode represents a node of the parsed query.
+type QueryNode interface {
+	queryNode()
+}
+
+// AndQueryNode matches when all children match.
+type AndQueryNode struct {
+	Children []QueryNode
+}
+
+// OrQueryNode matches when any of the children match.
+type OrQueryNode struct {
+	Children []QueryNode
+}
+
+// NotQueryNode matches when the child does not match.
+type NotQueryNode struct {
+	Child QueryNode
+}
+
+// TermQueryNode matches a word or phrase, optionally restricted to a field.
+type TermQueryNode struct {
+	Field    string
+	Value    string
+	IsPhrase bool
+	Position int
+}
+
+// RangeQueryNode matches a numeric range of a field (dates are unix timestamps, sizes are bytes).
+// The lower bound is inclusive and the upper bound is exclusive.
+type RangeQueryNode struct {
+	Field    string
+	From     int64
+	HasFrom  bool
+	To       int64
+	HasTo    bool
+	Position int
+}
+
+// ExistsQueryNode matches messages which have the specified property (has:).
+type ExistsQueryNode struct {
+	Value    string
+	Position int
+}
+
+// EntityQueryNode matches messages with an extracted entity (entity:type or entity:type=value).
+type EntityQueryNode struct {
+	Type string
+	// Value is the normalized entity value, empty matches any value of the type.
+	Value    string
+	Position int
+}
+
+// CodingQueryNode matches messages with a coded value (coding:field or coding:field=value).
+type CodingQueryNode struct {
+	// Field is the name or ID of the coding field.
+	Field string
+	// Value is the choice label or ID, true or false, or text contained in the value. Empty matches any value.
+	Value    string
+	Position int
+}
+
+// FuzzyQueryNode matches words within an edit distance of the value (word~ or word~2).
+type FuzzyQueryNode struct {
+	Field string
+	Value string
+	// Fuzziness is the maximum edit distance (1 or 2) or AUTO (based on the length of the word).
+	Fuzziness string
+	Position  int
+}
+
+// ProximityQueryNode matches the words of the phrase within a distance of each other ("a b"~5).
+type ProximityQueryNode struct {
+	Field    string
+	Value    string
+	Slop     int
+	Position int
+}
+
+// WildcardQueryNode matches words with wildcards (* matches any characters, ? matches a single character).
+type WildcardQueryNode struct {
+	Field string
+	Value string
+	// IsKeyword matches the whole field value instead of each word (field.keyword:).
+	IsKeyword bool
+	Position  int
+}
+
+// RegexpQueryNode matches words with a regular expression (/pattern/).
+type RegexpQueryNode struct {
+	Field   string
+	Pattern string
+	// IsKeyword matches the whole field value instead of each word (field.keyword:).
+	IsKeyword bool
+	Position  int
+}
+
+func (*AndQueryNode) queryNode()       {}
+func (*OrQueryNode) queryNode()        {}
+func (*NotQueryNode) queryNode()       {}
+func (*TermQueryNode) queryNode()      {}
+func (*RangeQueryNode) queryNode()     {}
+func (*ExistsQueryNode) queryNode()    {}
+func (*EntityQueryNode) queryNode()    {}
+func (*CodingQueryNode) queryNode()    {}
+func (*FuzzyQueryNode) queryNode()     {}
+func (*ProximityQueryNode) queryNode() {}
+func (*WildcardQueryNode) queryNode()  {}
+func (*RegexpQueryNode) queryNode()    {}
+
+// QueryParseError represents an error in the query with its position (byte offset).
+type QueryParseError struct {
+	Position int    `json:"position"`
+	Message  string `json:"error"`
+}
+
+// Error returns the error message.
+func (queryParseError *QueryParseError) Error() string {
+	return fmt.Sprintf("query error at position %d: %s", queryParseError.Position, queryParseError.Message)
+}
+
+// newQueryParseError creates a new QueryParseError.
+func newQueryParseError(position int, format string, arguments ...interface{}) *QueryParseError {
+	return &QueryParseError{
+		Position: position,
+		Message:  fmt.Sprintf(format, arguments...),
+	}
+}
+
+// Constants defining the query token types.
+const (
+	queryTokenWord = iota
+	queryTokenPhrase
+	queryTokenRegexp
+	queryTokenLeftParenthesis
+	queryTokenRightParenthesis
+	queryTokenAnd
+	queryTokenOr
+	queryTokenNot
+	queryTokenEnd
+)
+
+// queryToken represents a token of the query.
+type queryToken struct {
+	Type     int
+	Value    string
+	Field    string // Only set for phrases and regular expressions with a field (field:"phrase").
+	Slop     string // Only set for phrases followed by a distance ("phrase"~5).
+	HasSlop  bool
+	Position int
+}
+
+// String returns the token as it should be shown in error messages.
+func (token queryToken) String() string {
+	switch token.Type {
+	case queryTokenPhrase:
+		return fmt.Sprintf("\"%s\"", token.Value)
+	case queryTokenRegexp:
+		return fmt.Sprintf("/%s/", token.Value)
+	case queryTokenEnd:
+		return "end of query"
+	default:
+		return token.Value
+	}
+}
+
+// isQueryDelimiter returns true if the character ends a word.
+func isQueryDelimiter(character rune) bool {
+	return unicode.IsSpace(character) || character == '(' || character == ')' || character == '"'
+}
+
+// tokenizeQuery splits the query into tokens.
+func tokenizeQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+
+	characters := []rune(query)
+	// Byte offsets of each character, so positions match the query string.
+	offsets := make([]int, len(characters)+1)
+
+	offset := 0
+
+	for i, character := range characters {
+		offsets[i] = offset
+		offset += len(string(character))
+	}
+
+	offsets[len(characters)] = offset
+
+	readPhrase := func(start int) (string, int, error) {
+		// start is the index of the opening quote.
+		var phrase strings.Builder
+
+		for i := start + 1; i < len(characters); i++ {
+			if characters[i] == '\\' && i+1 < len(characters) && (characters[i+1] == '"' || characters[i+1] == '\\') {
+				phrase.WriteRune(characters[i+1])
+				i++
+				continue
+			}
+
+			if characters[i] == '"' {
+				return phrase.String(), i + 1, nil
+			}
+
+			phrase.WriteRune(characters[i])
+		}
+
+		return "", 0, newQueryParseError(offsets[start], "unterminated phrase, missing closing quote")
+	}
+
+	readSlop := func(token *queryToken, start int) int {
+		// start is the index after the closing quote.
+		if start >= len(characters) || characters[start] != '~' {
+			return start
+		}
+
+		end := start + 1
+
+		for end < len(characters) && !isQueryDelimiter(characters[end]) {
+			end++
+		}
+
+		token.Slop = string(characters[start+1 : end])
+		token.HasSlop = true
+
+		return end
+	}
+
+	readRegexp := func(start int) (string, int, error) {
+		// start is the index of the opening slash.
+		var pattern strings.Builder
+
+		for i := start + 1; i < len(characters); i++ {
+			if characters[i] == '\\' && i+1 < len(characters) && characters[i+1] == '/' {
+				pattern.WriteRune('/')
+				i++
+				continue
+			}
+
+			if characters[i] == '\\' && i+1 < len(characters) {
+				pattern.WriteRune(characters[i])
+				pattern.WriteRune(characters[i+1])
+				i++
+				continue
+			}
+
+			if characters[i] == '/' {
+				if i+1 < len(characters) && !isQueryDelimiter(characters[i+1]) {
+					return "", 0, newQueryParseError(offsets[i+1], "unexpected character after regular expression")
+				}
+
+				return pattern.String(), i + 1, nil
+			}
+
+			pattern.WriteRune(characters[i])
+		}
+
+		return "", 0, newQueryParseError(offsets[start], "unterminated regular expression, missing closing slash")
+	}
+
+	for i := 0; i < len(characters); {
+		character := characters[i]
+
+		switch {
+		case unicode.IsSpace(character):
+			i++
+		case character == '(':
+			tokens = append(tokens, queryToken{Type: queryTokenLeftParenthesis, Value: "(", Position: offsets[i]})
+			i++
+		case character == ')':
+			tokens = append(tokens, queryToken{Type: queryTokenRightParenthesis, Value: ")", Position: offsets[i]})
+			i++
+		case character == '"':
+			phrase, next, err := readPhrase(i)
+
+			if err != nil {
+				return nil, err
+			}
+
+			token := queryToken{Type: queryTokenPhrase, Value: phrase, Position: offsets[i]}
+
+			i = readSlop(&token, next)
+			tokens = append(tokens, token)
+		case character == '/':
+			pattern, next, err := readRegexp(i)
+
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, queryToken{Type: queryTokenRegexp, Value: pattern, Position: offsets[i]})
+			i = next
+		case character == '-' && i+1 < len(characters) && !unicode.IsSpace(characters[i+1]) && characters[i+1] != ')':
+			tokens = append(tokens, queryToken{Type: queryTokenNot, Value: "-", Position: offsets[i]})
+			i++
+		default:
+			start := i
+
+			for i < len(characters) && !isQueryDelimiter(characters[i]) {
+				// A field followed by a regular expression (field:/pattern/).
+				if characters[i] == '/' && characters[i-1] == ':' && strings.Count(string(characters[start:i]), ":") == 1 {
+					break
+				}
+
+				i++
+			}
+
+			word := string(characters[start:i])
+
+			// A field followed by a phrase (field:"phrase").
+			if strings.HasSuffix(word, ":") && i < len(characters) && characters[i] == '"' {
+				phrase, next, err := readPhrase(i)
+
+				if err != nil {
+					return nil, err
+				}
+
+				token := queryToken{Type: queryTokenPhrase, Value: phrase, Field: strings.TrimSuffix(word, ":"), Position: offsets[start]}
+
+				i = readSlop(&token, next)
+				tokens = append(tokens, token)
+				continue
+			}
+
+			// A field followed by a regular expression (field:/pattern/).
+			if strings.HasSuffix(word, ":") && i < len(characters) && characters[i] == '/' {
+				pattern, next, err := readRegexp(i)
+
+				if err != nil {
+					return nil, err
+				}
+
+				tokens = append(tokens, queryToken{Type: queryTokenRegexp, Value: pattern, Field: strings.TrimSuffix(word, ":"), Position: offsets[start]})
+				i = next
+				continue
+			}
+
+			token := queryToken{Type: queryTokenWord, Value: word, Position: offsets[start]}
+
+			switch word {
+			case "AND":
+				token.Type = queryTokenAnd
+			case "OR":
+				token.Type = queryTokenOr
+			case "NOT":
+				token.Type = queryTokenNot
+			}
+
+			tokens = append(tokens, token)
+		}
+	}
+
+	return append(tokens, queryToken{Type: queryTokenEnd, Position: offsets[len(characters)]}), nil
+}
+
+// queryParser is a recursive descent parser for the query language.
+type queryParser struct {
+	tokens []queryToken
+	index  int
+}
+
+// peek returns the current token.
+func (parser *queryParser) peek() queryToken {
+	return parser.tokens[parser.index]
+}
+
+// next returns the current token and advances to the next token.
+func (parser *queryParser) next() queryToken {
+	token := parser.tokens[parser.index]
+
+	if token.Type != queryTokenEnd {
+		parser.index++
+	}
+
+	return token
+}
+
+// ParseQuery parses the query into a tree of query nodes.
+// Returns a *QueryParseError if the query is invalid.
+func ParseQuery(query string) (QueryNode, error) {
+	tokens, err := tokenizeQuery(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 1 {
+		return nil, newQueryParseError(0, "empty query")
+	}
+
+	parser := queryParser{tokens: tokens}
+
+	queryNode, err := parser.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token := parser.peek(); token.Type != queryTokenEnd {
+		if token.Type == queryTokenRightParenthesis {
+			return nil, newQueryParseError(token.Position, "unexpected closing parenthesis without an opening parenthesis")
+		}
+
+		return nil, newQueryParseError(token.Position, "unexpected %s", token)
+	}
+
+	if patternTerms := getQueryPatternTerms(queryNode); len(patternTerms) > MaxQueryPatternTerms {
+		return nil, newQueryParseError(getQueryNodePosition(patternTerms[MaxQueryPatternTerms]), "too many fuzzy, proximity, wildcard or regular expression terms, at most %d are allowed", MaxQueryPatternTerms)
+	}
+
+	return queryNode, nil
+}
+
+// getQueryPatternTerms returns the fuzzy, proximity, wildcard and regular expression terms of the query.
+func getQueryPatternTerms(queryNode QueryNode) []QueryNode {
+	switch queryNode := queryNode.(type) {
+	case *AndQueryNode:
+		var patternTerms []QueryNode
+
+		for _, child := range queryNode.Children {
+			patternTerms = append(patternTerms, getQueryPatternTerms(child)...)
+		}
+
+		return patternTerms
+	case *OrQueryNode:
+		var patternTerms []QueryNode
+
+		for _, child := range queryNode.Children {
+			patternTerms = append(patternTerms, getQueryPatternTerms(child)...)
+		}
+
+		return patternTerms
+	case *NotQueryNode:
+		return getQueryPatternTerms(queryNode.Child)
+	case *FuzzyQueryNode, *ProximityQueryNode, *WildcardQueryNode, *RegexpQueryNode:
+		return []QueryNode{queryNode}
+	default:
+		return nil
+	}
+}
+
+// getQueryNodePosition returns the position of a fuzzy, proximity, wildcard or regular expression term.
+func getQueryNodePosition(queryNode QueryNode) int {
+	switch queryNode := queryNode.(type) {
+	case *FuzzyQueryNode:
+		return queryNode.Position
+	case *ProximityQueryNode:
+		return queryNode.Position
+	case *WildcardQueryNode:
+		return queryNode.Position
+	case *RegexpQueryNode:
+		return queryNode.Position
+	default:
+		return 0
+	}
+}
+
+// parseOr parses: and { "OR" and }
+func (parser *queryParser) parseOr() (QueryNode, error) {
+	queryNode, err := parser.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	children := []QueryNode{queryNode}
+
+	for parser.peek().Type == queryTokenOr {
+		parser.next()
+
+		queryNode, err := parser.parseAnd()
+
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, queryNode)
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+
+	return &OrQueryNode{Children: children}, nil
+}
+
+// parseAnd parses: not { [ "AND" ] not }
+func (parser *queryParser) parseAnd() (QueryNode, error) {
+	queryNode, err := parser.parseNot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	children := []QueryNode{queryNode}
+
+	for {
+		switch parser.peek().Type {
+		case queryTokenAnd:
+			parser.next()
+		case queryTokenWord, queryTokenPhrase, queryTokenRegexp, queryTokenLeftParenthesis, queryTokenNot:
+			// Implicit AND.
+		default:
+			if len(children) == 1 {
+				return children[0], nil
+			}
+
+			return &AndQueryNode{Children: children}, nil
+		}
+
+		queryNode, err := parser.parseNot()
+
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, queryNode)
+	}
+}
+
+// parseNot parses: ( "NOT" | "-" ) not | primary
+func (parser *queryParser) parseNot() (QueryNode, error) {
+	if parser.peek().Type == queryTokenNot {
+		parser.next()
+
+		queryNode, err := parser.parseNot()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &NotQueryNode{Child: queryNode}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+// parsePrimary parses: "(" or ")" | term
+func (parser *queryParser) parsePrimary() (QueryNode, error) {
+	token := parser.next()
+
+	switch token.Type {
+	case queryTokenLeftParenthesis:
+		if parser.peek().Type == queryTokenRightParenthesis {
+			return nil, newQueryParseError(token.Position, "empty parentheses")
+		}
+
+		queryNode, err := parser.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if closingToken := parser.next(); closingToken.Type != queryTokenRightParenthesis {
+			return nil, newQueryParseError(token.Position, "missing closing parenthesis")
+		}
+
+		return queryNode, nil
+	case queryTokenWord, queryTokenPhrase, queryTokenRegexp:
+		return parseQueryTerm(token)
+	case queryTokenRightParenthesis:
+		return nil, newQueryParseError(token.Position, "unexpected closing parenthesis, expected a term")
+	case queryTokenEnd:
+		return nil, newQueryParseError(token.Position, "unexpected end of query, expected a term")
+	default:
+		return nil, newQueryParseError(token.Position, "unexpected %s, expected a term", token)
+	}
+}
+
+// isQueryTextField returns true if the field accepts words and phrases.
+func isQueryTextField(field string) bool {
+	for _, queryTextField := range QueryTextFields {
+		if queryTextField == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseQuerySearchField returns the search field without the keyword suffix.
+// Returns false if the field does not accept the fuzzy, proximity, wildcard and regular expression modes.
+func parseQuerySearchField(field string) (string, bool, bool) {
+	searchField := strings.TrimSuffix(field, QueryKeywordFieldSuffix)
+	isKeyword := searchField != field
+
+	if searchField == "" && !isKeyword {
+		return "", false, true
+	}
+
+	// The body and attachment contents are too long to be matched as a whole.
+	if isKeyword && (searchField == QueryFieldBody || searchField == QueryFieldContent) {
+		return "", false, false
+	}
+
+	for _, querySearchField := range QuerySearchFields {
+		if querySearchField == searchField {
+			return searchField, isKeyword, true
+		}
+	}
+
+	return "", false, false
+}
+
+// parseQueryTerm parses a word, phrase or regular expression token into a query node.
+func parseQueryTerm(token queryToken) (QueryNode, error) {
+	if token.Type == queryTokenRegexp {
+		return parseQueryRegexp(token)
+	}
+
+	if token.Type == queryTokenPhrase {
+		field := strings.ToLower(token.Field)
+
+		if token.HasSlop {
+			return parseQueryProximity(field, token)
+		}
+
+		if field == "" {
+			return &TermQueryNode{Value: token.Value, IsPhrase: true, Position: token.Position}, nil
+		}
+
+		if field == QueryFieldCoding {
+			return parseQueryCoding(token.Value, token.Position, token.Position)
+		}
+
+		if !isQueryTextField(field) {
+			return nil, newQueryParseError(token.Position, "field \"%s\" does not accept a phrase", token.Field)
+		}
+
+		return &TermQueryNode{Field: field, Value: token.Value, IsPhrase: true, Position: token.Position}, nil
+	}
+
+	separatorIndex := strings.Index(token.Value, ":")
+
+	if separatorIndex == -1 {
+		if isQueryPatternWord(token.Value) {
+			return parseQueryPatternWord("", false, token.Value, token.Position, token.Position)
+		}
+
+		return &TermQueryNode{Value: token.Value, Position: token.Position}, nil
+	}
+
+	if separatorIndex == 0 {
+		return nil, newQueryParseError(token.Position, "missing field name before \":\"")
+	}
+
+	field := strings.ToLower(token.Value[:separatorIndex])
+	value := token.Value[separatorIndex+1:]
+	valuePosition := token.Position + separatorIndex + 1
+
+	if value == "" {
+		return nil, newQueryParseError(valuePosition, "missing value for field \"%s\"", field)
+	}
+
+	if searchField, isKeyword, ok := parseQuerySearchField(field); ok && (isKeyword || isQueryPatternWord(value)) {
+		if !isQueryPatternWord(value) {
+			return nil, newQueryParseError(token.Position, "field \"%s\" only accepts wildcards and regular expressions", field)
+		}
+
+		return parseQueryPatternWord(searchField, isKeyword, value, valuePosition, token.Position)
+	}
+
+	switch {
+	case isQueryTextField(field):
+		return &TermQueryNode{Field: field, Value: value, Position: token.Position}, nil
+	case field == QueryFieldBookmarked:
+		switch strings.ToLower(value) {
+		case "true", "yes":
+			return &TermQueryNode{Field: field, Value: "true", Position: token.Position}, nil
+		case "false", "no":
+			return &TermQueryNode{Field: field, Value: "false", Position: token.Position}, nil
+		default:
+			return nil, newQueryParseError(valuePosition, "invalid value \"%s\" for bookmarked, expected true or false", value)
+		}
+	case field == QueryFieldHas:
+		switch strings.ToLower(value) {
+		case "attachment", "attachments":
+			return &ExistsQueryNode{Value: QueryFieldAttachment, Position: token.Position}, nil
+		default:
+			return nil, newQueryParseError(valuePosition, "invalid value \"%s\" for has, expected attachment", value)
+		}
+	case field == QueryFieldEntity:
+		return parseQueryEntity(value, valuePosition, token.Position)
+	case field == QueryFieldCoding:
+		return parseQueryCoding(value, valuePosition, token.Position)
+	case field == QueryFieldDate:
+		return parseQueryRange(field, value, valuePosition, parseQueryDate)
+	case field == QueryFieldSize:
+		return parseQueryRange(field, value, valuePosition, parseQuerySize)
+	default:
+		return nil, newQueryParseError(token.Position, "unknown field \"%s\"", token.Value[:separatorIndex])
+	}
+}
+
+// parseQueryEntity parses the value of the entity field (type or type=value).
+func parseQueryEntity(value string, valuePosition int, position int) (QueryNode, error) {
+	entityType := value
+	entityValue := ""
+
+	if separatorIndex := strings.Index(value, "="); separatorIndex >= 0 {
+		entityType = value[:separatorIndex]
+		entityValue = value[separatorIndex+1:]
+
+		if entityValue == "" {
+			return nil, newQueryParseError(valuePosition+separatorIndex+1, "missing entity value after \"=\"")
+		}
+	}
+
+	entityType = strings.ToLower(entityType)
+
+	if !isEntityType(entityType) {
+		return nil, newQueryParseError(valuePosition, "invalid entity type \"%s\", expected one of: %s", entityType, strings.Join(EntityTypes, ", "))
+	}
+
+	return &EntityQueryNode{Type: entityType, Value: NormalizeEntityValue(entityType, entityValue), Position: position}, nil
+}
+
+// parseQueryCoding parses the value of the coding field (field or field=value).
+// The field and value are resolved against the coding schema when the query is compiled.
+func parseQueryCoding(value string, valuePosition int, position int) (QueryNode, error) {
+	codingField := value
+	codingValue := ""
+
+	if separatorIndex := strings.Index(value, "="); separatorIndex >= 0 {
+		codingField = value[:separatorIndex]
+		codingValue = strings.TrimSpace(value[separatorIndex+1:])
+
+		if codingValue == "" {
+			return nil, newQueryParseError(valuePosition+separatorIndex+1, "missing coding value after \"=\"")
+		}
+	}
+
+	codingField = strings.TrimSpace(codingField)
+
+	if codingField == "" {
+		return nil, newQueryParseError(valuePosition, "missing coding field")
+	}
+
+	return &CodingQueryNode{Field: codingField, Value: codingValue, Position: position}, nil
+}
+
+// getQueryFuzzySuffixIndex returns the index of the fuzzy suffix (~ or ~N) of the word, -1 if there is none.
+func getQueryFuzzySuffixIndex(word string) int {
+	index := strings.LastIndex(word, "~")
+
+	if index < 1 {
+		return -1
+	}
+
+	for _, character := range word[index+1:] {
+		if character < '0' || character > '9' {
+			return -1
+		}
+	}
+
+	return index
+}
+
+// isQueryPatternWord returns true if the word is a fuzzy or wildcard term.
+func isQueryPatternWord(word string) bool {
+	return strings.ContainsAny(word, "*?") || getQueryFuzzySuffixIndex(word) != -1
+}
+
+// parseQueryPatternWord parses a fuzzy (word~N) or wildcard (wo*d) term.
+func parseQueryPatternWord(field string, isKeyword bool, value string, valuePosition int, position int) (QueryNode, error) {
+	if fuzzySuffixIndex := getQueryFuzzySuffixIndex(value); fuzzySuffixIndex != -1 {
+		word := value[:fuzzySuffixIndex]
+		edits := value[fuzzySuffixIndex+1:]
+
+		if isKeyword {
+			return nil, newQueryParseError(valuePosition, "fuzzy terms are not supported on whole field values")
+		}
+
+		if strings.ContainsAny(word, "*?") {
+			return nil, newQueryParseError(valuePosition, "fuzzy terms can not contain wildcards")
+		}
+
+		if utf8.RuneCountInString(word) < MinQueryFuzzyTermLength {
+			return nil, newQueryParseError(valuePosition, "fuzzy terms need at least %d characters", MinQueryFuzzyTermLength)
+		}
+
+		fuzzyQueryNode := &FuzzyQueryNode{Field: field, Value: word, Fuzziness: "AUTO", Position: position}
+
+		if edits != "" {
+			distance, err := strconv.Atoi(edits)
+
+			if err != nil || distance < 1 || distance > MaxQueryFuzzyEdits {
+				return nil, newQueryParseError(valuePosition+fuzzySuffixIndex, "invalid fuzzy distance \"%s\", expected 1 to %d edits", edits, MaxQueryFuzzyEdits)
+			}
+
+			fuzzyQueryNode.Fuzziness = edits
+		}
+
+		return fuzzyQueryNode, nil
+	}
+
+	wildcardIndex := strings.IndexAny(value, "*?")
+	wildcards := 0
+	literals := 0
+
+	for _, character := range value {
+		if character == '*' || character == '?' {
+			wildcards++
+		} else {
+			literals++
+		}
+	}
+
+	if wildcards > MaxQueryWildcards {
+		return nil, newQueryParseError(valuePosition, "too many wildcards, at most %d are allowed", MaxQueryWildcards)
+	}
+
+	if isKeyword {
+		// Field values are short, so a leading wildcard is allowed as long as something is matched literally.
+		if literals < MinQueryWildcardPrefix {
+			return nil, newQueryParseError(valuePosition, "wildcard terms need at least %d characters besides the wildcards", MinQueryWildcardPrefix)
+		}
+	} else if utf8.RuneCountInString(value[:wildcardIndex]) < MinQueryWildcardPrefix {
+		return nil, newQueryParseError(valuePosition, "wildcard terms need at least %d characters before the first wildcard", MinQueryWildcardPrefix)
+	}
+
+	return &WildcardQueryNode{Field: field, Value: value, IsKeyword: isKeyword, Position: position}, nil
+}
+
+// parseQueryProximity parses a phrase followed by a distance ("a b"~5).
+func parseQueryProximity(field string, token queryToken) (QueryNode, error) {
+	if field != "" {
+		if _, isKeyword, ok := parseQuerySearchField(field); !ok || isKeyword {
+			return nil, newQueryParseError(token.Position, "field \"%s\" does not accept a proximity search", token.Field)
+		}
+	}
+
+	slop, err := strconv.Atoi(token.Slop)
+
+	if err != nil || slop < 0 || slop > MaxQueryProximity {
+		return nil, newQueryParseError(token.Position, "invalid proximity \"%s\", expected a distance between 0 and %d", token.Slop, MaxQueryProximity)
+	}
+
+	if len(strings.Fields(token.Value)) < 2 {
+		return nil, newQueryParseError(token.Position, "proximity searches need at least two words")
+	}
+
+	return &ProximityQueryNode{Field: field, Value: token.Value, Slop: slop, Position: token.Position}, nil
+}
+
+// parseQueryRegexp parses a regular expression (/pattern/ or field:/pattern/).
+func parseQueryRegexp(token queryToken) (QueryNode, error) {
+	field, isKeyword, ok := parseQuerySearchField(strings.ToLower(token.Field))
+
+	if !ok {
+		return nil, newQueryParseError(token.Position, "field \"%s\" does not accept a regular expression", token.Field)
+	}
+
+	if token.Value == "" {
+		return nil, newQueryParseError(token.Position, "empty regular expression")
+	}
+
+	if len(token.Value) > MaxQueryRegexpLength {
+		return nil, newQueryParseError(token.Position, "regular expression is too long, at most %d characters are allowed", MaxQueryRegexpLength)
+	}
+
+	// Words are matched from the start of the index, a leading wildcard would scan every word.
+	if !isKeyword && strings.HasPrefix(token.Value, ".") {
+		return nil, newQueryParseError(token.Position, "regular expressions can not start with \".\", this would scan every word")
+	}
+
+	parsedRegexp, err := syntax.Parse(token.Value, syntax.Perl)
+
+	if err != nil {
+		return nil, newQueryParseError(token.Position, "invalid regular expression: %s", err)
+	}
+
+	if hasQueryRegexpAssertion(parsedRegexp) {
+		return nil, newQueryParseError(token.Position, "regular expressions always match the whole word or value, anchors (^, $ and \\b) are not supported")
+	}
+
+	return &RegexpQueryNode{Field: field, Pattern: token.Value, IsKeyword: isKeyword, Position: token.Position}, nil
+}
+
+// hasQueryRegexpAssertion returns true if the regular expression contains anchors, which Elasticsearch does not support.
+func hasQueryRegexpAssertion(parsedRegexp *syntax.Regexp) bool {
+	switch parsedRegexp.Op {
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	}
+
+	for _, subRegexp := range parsedRegexp.Sub {
+		if hasQueryRegexpAssertion(subRegexp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// queryRangeValueParser parses a range value into the inclusive start and exclusive end it covers.
+type queryRangeValueParser func(value string, position int) (int64, int64, error)
+
+// parseQueryRange parses comparisons (>, >=, <, <=), ranges (from..to) and single values of a range field.
+func parseQueryRange(field string, value string, position int, parseValue queryRangeValueParser) (QueryNode, error) {
+	rangeQueryNode := &RangeQueryNode{Field: field, Position: position}
+
+	for _, operator := range []string{">=", "<=", ">", "<"} {
+		if !strings.HasPrefix(value, operator) {
+			continue
+		}
+
+		start, end, err := parseValue(value[len(operator):], position+len(operator))
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch operator {
+		case ">=":
+			rangeQueryNode.From, rangeQueryNode.HasFrom = start, true
+		case ">":
+			rangeQueryNode.From, rangeQueryNode.HasFrom = end, true
+		case "<=":
+			rangeQueryNode.To, rangeQueryNode.HasTo = end, true
+		case "<":
+			rangeQueryNode.To, rangeQueryNode.HasTo = start, true
+		}
+
+		return rangeQueryNode, nil
+	}
+
+	if separatorIndex := strings.Index(value, ".."); separatorIndex != -1 {
+		fromValue := value[:separatorIndex]
+		toValue := value[separatorIndex+2:]
+
+		if fromValue == "" && toValue == "" {
+			return nil, newQueryParseError(position, "range of %s needs at least one bound", field)
+		}
+
+		if fromValue != "" {
+			start, _, err := parseValue(fromValue, position)
+
+			if err != nil {
+				return nil, err
+			}
+
+			rangeQueryNode.From, rangeQueryNode.HasFrom = start, true
+		}
+
+		if toValue != "" {
+			_, end, err := parseValue(toValue, position+separatorIndex+2)
+
+			if err != nil {
+				return nil, err
+			}
+
+			rangeQueryNode.To, rangeQueryNode.HasTo = end, true
+		}
+
+		if rangeQueryNode.HasFrom && rangeQueryNode.HasTo && rangeQueryNode.From >= rangeQueryNode.To {
+			return nil, newQueryParseError(position, "range of %s ends before it starts", field)
+		}
+
+		return rangeQueryNode, nil
+	}
+
+	start, end, err := parseValue(value, position)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rangeQueryNode.From, rangeQueryNode.HasFrom = start, true
+	rangeQueryNode.To, rangeQueryNode.HasTo = end, true
+
+	return rangeQueryNode, nil
+}
+
+// queryDateLayouts defines the accepted date layouts and the period each layout covers.
+var queryDateLayouts = []struct {
+	Layout string
+	Add    func(time.Time) time.Time
+}{
+	{"2006-01-02T15:04:05", func(date time.Time) time.Time { return date.Add(time.Second) }},
+	{"2006-01-02T15:04", func(date time.Time) time.Time { return date.Add(time.Minute) }},
+	{"2006-01-02", func(date time.Time) time.Time { return date.AddDate(0, 0, 1) }},
+	{"2006-01", func(date time.Time) time.Time { return date.AddDate(0, 1, 0) }},
+	{"2006", func(date time.Time) time.Time { return date.AddDate(1, 0, 0) }},
+}
+
+// parseQueryDate parses a date into the unix timestamps of the period it covers.
+func parseQueryDate(value string, position int) (int64, int64, error) {
+	for _, queryDateLayout := range queryDateLayouts {
+		if len(value) != len(queryDateLayout.Layout) {
+			continue
+		}
+
+		date, err := time.ParseInLocation(queryDateLayout.Layout, value, time.UTC)
+
+		if err != nil {
+			continue
+		}
+
+		return date.Unix(), queryDateLayout.Add(date).Unix(), nil
+	}
+
+	return 0, 0, newQueryParseError(position, "invalid date \"%s\", expected YYYY, YYYY-MM, YYYY-MM-DD or YYYY-MM-DDTHH:MM[:SS]", value)
+}
+
+// querySizeUnits defines the accepted size units.
+var querySizeUnits = []struct {
+	Suffix     string
+	Multiplier float64
+}{
+	{"gb", 1 << 30},
+	{"mb", 1 << 20},
+	{"kb", 1 << 10},
+	{"g", 1 << 30},
+	{"m", 1 << 20},
+	{"k", 1 << 10},
+	{"b", 1},
+}
+
+// parseQuerySize parses a size (for example 10KB or 1.5MB) into bytes.
+func parseQuerySize(value string, position int) (int64, int64, error) {
+	number := strings.ToLower(value)
+	multiplier := 1.0
+
+	for _, querySizeUnit := range querySizeUnits {
+		if strings.HasSuffix(number, querySizeUnit.Suffix) {
+			number = strings.TrimSuffix(number, querySizeUnit.Suffix)
+			multiplier = querySizeUnit.Multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+
+	if err != nil || size < 0 || math.IsInf(size, 0) || math.IsNaN(size) {
+		return 0, 0, newQueryParseError(position, "invalid size \"%s\", expected a number with an optional unit (B, KB, MB, GB)", value)
+	}
+
+	bytes := int64(size * multiplier)
+
+	return bytes, bytes + 1, nil
+}
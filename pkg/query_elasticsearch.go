@@ -0,0 +1,528 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"strings"
+)
+
+// QueryResolver resolves query fields which are not stored in the Elasticsearch index.
+type QueryResolver interface {
+	// GetTagMessageUUIDs returns the UUIDs of the messages with the tag.
+	GetTagMessageUUIDs(tag string) ([]string, error)
+	// GetBookmarkedMessageUUIDs returns the UUIDs of the bookmarked messages.
+	GetBookmarkedMessageUUIDs() ([]string, error)
+	// GetFolderUUIDs returns the UUIDs of the folders with the name.
+	GetFolderUUIDs(folder string) ([]string, error)
+	// GetEvidenceUUIDs returns the UUIDs of the evidence items matching the UUID, file name, file hash or custodian.
+	GetEvidenceUUIDs(evidence string) ([]string, error)
+	// GetEntityMessageUUIDs returns the UUIDs of the messages with the entity, any value of the type if the value is empty.
+	GetEntityMessageUUIDs(entityType string, value string) ([]string, error)
+	// GetCodingMessageUUIDs returns the UUIDs of the messages with the coded value, any value if the value is empty.
+	GetCodingMessageUUIDs(field string, value string) ([]string, error)
+}
+
+// DatabaseQueryResolver resolves query fields from the database.
+type DatabaseQueryResolver struct {
+	ProjectUUID string
+	Database    *pgx.Conn
+}
+
+// NewDatabaseQueryResolver creates a new DatabaseQueryResolver for the project.
+func NewDatabaseQueryResolver(projectUUID string, database *pgx.Conn) *DatabaseQueryResolver {
+	return &DatabaseQueryResolver{
+		ProjectUUID: projectUUID,
+		Database:    database,
+	}
+}
+
+// queryStrings returns the strings returned by the SQL query.
+func (databaseQueryResolver *DatabaseQueryResolver) queryStrings(sql string, arguments ...interface{}) ([]string, error) {
+	rows, err := databaseQueryResolver.Database.Query(context.Background(), sql, arguments...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var values []string
+
+	for rows.Next() {
+		var value string
+
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+// GetTagMessageUUIDs returns the UUIDs of the messages with the tag.
+func (databaseQueryResolver *DatabaseQueryResolver) GetTagMessageUUIDs(tag string) ([]string, error) {
+	return databaseQueryResolver.queryStrings("SELECT message_uuid FROM tags WHERE LOWER(tag) = LOWER($1) AND project_uuid = $2", tag, databaseQueryResolver.ProjectUUID)
+}
+
+// GetBookmarkedMessageUUIDs returns the UUIDs of the bookmarked messages.
+func (databaseQueryResolver *DatabaseQueryResolver) GetBookmarkedMessageUUIDs() ([]string, error) {
+	bookmarks, err := core.GetBookmarksByProject(databaseQueryResolver.ProjectUUID, databaseQueryResolver.Database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var messageUUIDs []string
+
+	for _, bookmark := range bookmarks {
+		messageUUIDs = append(messageUUIDs, bookmark.UUID)
+	}
+
+	return messageUUIDs, nil
+}
+
+// GetFolderUUIDs returns the UUIDs of the folders with the name.
+func (databaseQueryResolver *DatabaseQueryResolver) GetFolderUUIDs(folder string) ([]string, error) {
+	return databaseQueryResolver.queryStrings("SELECT folder_uuid FROM tree_nodes WHERE LOWER(title) = LOWER($1) AND project_uuid = $2", folder, databaseQueryResolver.ProjectUUID)
+}
+
+// GetEvidenceUUIDs returns the UUIDs of the evidence items matching the UUID, file name, file hash or custodian.
+func (databaseQueryResolver *DatabaseQueryResolver) GetEvidenceUUIDs(evidence string) ([]string, error) {
+	evidenceItems, err := GetEvidenceItemsByProject(databaseQueryResolver.ProjectUUID, databaseQueryResolver.Database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var evidenceUUIDs []string
+
+	for _, evidenceItem := range evidenceItems {
+		if evidenceItem.UUID == evidence ||
+			strings.EqualFold(evidenceItem.FileName, evidence) ||
+			strings.EqualFold(evidenceItem.FileHash, evidence) ||
+			strings.EqualFold(evidenceItem.Custodian, evidence) {
+			evidenceUUIDs = append(evidenceUUIDs, evidenceItem.UUID)
+		}
+	}
+
+	return evidenceUUIDs, nil
+}
+
+// GetEntityMessageUUIDs returns the UUIDs of the messages with the entity, any value of the type if the value is empty.
+// Returns at most one more than MaxQueryMessageUUIDs, a type without a value can match nearly every message.
+func (databaseQueryResolver *DatabaseQueryResolver) GetEntityMessageUUIDs(entityType string, value string) ([]string, error) {
+	return databaseQueryResolver.queryStrings("SELECT DISTINCT message_uuid FROM entities WHERE entity_type = $1 AND ($2 = '' OR value = $2) AND project_uuid = $3 LIMIT $4", entityType, value, databaseQueryResolver.ProjectUUID, MaxQueryMessageUUIDs+1)
+}
+
+// GetCodingMessageUUIDs returns the UUIDs of the messages with the coded value, any value if the value is empty.
+func (databaseQueryResolver *DatabaseQueryResolver) GetCodingMessageUUIDs(field string, value string) ([]string, error) {
+	return GetCodingMessageUUIDs(field, value, databaseQueryResolver.ProjectUUID, databaseQueryResolver.Database)
+}
+
+// queryFieldElasticsearchFields maps the query text fields to the Elasticsearch fields.
+var queryFieldElasticsearchFields = map[string]string{
+	QueryFieldFrom:       MessageFieldFrom,
+	QueryFieldTo:         MessageFieldTo,
+	QueryFieldCC:         MessageFieldCC,
+	QueryFieldSubject:    MessageFieldSubject,
+	QueryFieldBody:       MessageFieldBody,
+	QueryFieldAttachment: MessageFieldAttachmentFileName,
+	QueryFieldContent:    MessageFieldAttachmentContent,
+}
+
+// queryFieldKeywordElasticsearchFields maps the query search fields to the Elasticsearch fields of the whole value.
+var queryFieldKeywordElasticsearchFields = map[string]string{
+	QueryFieldFrom:       MessageFieldFromKeyword,
+	QueryFieldTo:         MessageFieldToKeyword,
+	QueryFieldCC:         MessageFieldCCKeyword,
+	QueryFieldSubject:    MessageFieldSubjectKeyword,
+	QueryFieldAttachment: MessageFieldAttachmentFileNameKeyword,
+}
+
+// MaxQueryMessageUUIDs is the maximum number of messages a filter resolved from the database (tag, bookmark, entity or
+// coding) can match. These filters are sent to Elasticsearch as a list of message UUIDs, which Elasticsearch limits to
+// the default index.max_terms_count.
+const MaxQueryMessageUUIDs = 65536
+
+// errTooManyQueryMessages is returned when a filter resolved from the database matches too many messages.
+var errTooManyQueryMessages = fmt.Errorf("the filter matches more than %d messages, narrow it down", MaxQueryMessageUUIDs)
+
+// Constants defining the Elasticsearch guard rails of the fuzzy and regular expression queries.
+const (
+	QueryFuzzyPrefixLength           = 1
+	QueryFuzzyMaxExpansions          = 50
+	QueryRegexpMaxDeterminizedStates = 2000
+)
+
+// NewMessageQuery parses the query and compiles it to an Elasticsearch query on the messages of the project.
+// Returns a *QueryParseError if the query is invalid.
+func NewMessageQuery(query string, projectUUID string, database *pgx.Conn) (map[string]interface{}, error) {
+	queryNode, err := ParseQuery(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	elasticsearchQuery, err := CompileQuery(queryNode, NewDatabaseQueryResolver(projectUUID, database))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewProjectQuery(elasticsearchQuery, projectUUID), nil
+}
+
+// CompileQuery compiles the parsed query to the Elasticsearch query DSL.
+func CompileQuery(queryNode QueryNode, queryResolver QueryResolver) (map[string]interface{}, error) {
+	switch queryNode := queryNode.(type) {
+	case *AndQueryNode:
+		var must []interface{}
+		var mustNot []interface{}
+
+		for _, child := range queryNode.Children {
+			// Negated children are excluded directly instead of wrapping them in another bool query.
+			if notQueryNode, ok := child.(*NotQueryNode); ok {
+				compiledChild, err := CompileQuery(notQueryNode.Child, queryResolver)
+
+				if err != nil {
+					return nil, err
+				}
+
+				mustNot = append(mustNot, compiledChild)
+				continue
+			}
+
+			compiledChild, err := CompileQuery(child, queryResolver)
+
+			if err != nil {
+				return nil, err
+			}
+
+			must = append(must, compiledChild)
+		}
+
+		boolQuery := map[string]interface{}{}
+
+		if len(must) > 0 {
+			boolQuery["must"] = must
+		}
+
+		if len(mustNot) > 0 {
+			boolQuery["must_not"] = mustNot
+		}
+
+		return map[string]interface{}{"bool": boolQuery}, nil
+	case *OrQueryNode:
+		var should []interface{}
+
+		for _, child := range queryNode.Children {
+			compiledChild, err := CompileQuery(child, queryResolver)
+
+			if err != nil {
+				return nil, err
+			}
+
+			should = append(should, compiledChild)
+		}
+
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               should,
+				"minimum_should_match": 1,
+			},
+		}, nil
+	case *NotQueryNode:
+		compiledChild, err := CompileQuery(queryNode.Child, queryResolver)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must_not": []interface{}{compiledChild},
+			},
+		}, nil
+	case *TermQueryNode:
+		return compileTermQuery(queryNode, queryResolver)
+	case *RangeQueryNode:
+		elasticsearchField := MessageFieldDate
+
+		if queryNode.Field == QueryFieldSize {
+			elasticsearchField = MessageFieldSize
+		}
+
+		rangeQuery := map[string]interface{}{}
+
+		if queryNode.HasFrom {
+			rangeQuery["gte"] = queryNode.From
+		}
+
+		if queryNode.HasTo {
+			rangeQuery["lt"] = queryNode.To
+		}
+
+		return map[string]interface{}{
+			"range": map[string]interface{}{
+				elasticsearchField: rangeQuery,
+			},
+		}, nil
+	case *FuzzyQueryNode:
+		if queryNode.Field == "" {
+			return map[string]interface{}{
+				"multi_match": map[string]interface{}{
+					"query":          queryNode.Value,
+					"fields":         MessageTextFields,
+					"fuzziness":      queryNode.Fuzziness,
+					"prefix_length":  QueryFuzzyPrefixLength,
+					"max_expansions": QueryFuzzyMaxExpansions,
+				},
+			}, nil
+		}
+
+		return map[string]interface{}{
+			"match": map[string]interface{}{
+				queryFieldElasticsearchFields[queryNode.Field]: map[string]interface{}{
+					"query":          queryNode.Value,
+					"operator":       "and",
+					"fuzziness":      queryNode.Fuzziness,
+					"prefix_length":  QueryFuzzyPrefixLength,
+					"max_expansions": QueryFuzzyMaxExpansions,
+				},
+			},
+		}, nil
+	case *ProximityQueryNode:
+		if queryNode.Field == "" {
+			return map[string]interface{}{
+				"multi_match": map[string]interface{}{
+					"query":  queryNode.Value,
+					"fields": MessageTextFields,
+					"type":   "phrase",
+					"slop":   queryNode.Slop,
+				},
+			}, nil
+		}
+
+		return map[string]interface{}{
+			"match_phrase": map[string]interface{}{
+				queryFieldElasticsearchFields[queryNode.Field]: map[string]interface{}{
+					"query": queryNode.Value,
+					"slop":  queryNode.Slop,
+				},
+			},
+		}, nil
+	case *WildcardQueryNode:
+		return newPatternQuery("wildcard", getPatternQueryFields(queryNode.Field, queryNode.IsKeyword), map[string]interface{}{
+			"value":            queryNode.Value,
+			"case_insensitive": true,
+		}), nil
+	case *RegexpQueryNode:
+		return newPatternQuery("regexp", getPatternQueryFields(queryNode.Field, queryNode.IsKeyword), map[string]interface{}{
+			"value":                   queryNode.Pattern,
+			"flags":                   "NONE",
+			"case_insensitive":        true,
+			"max_determinized_states": QueryRegexpMaxDeterminizedStates,
+		}), nil
+	case *ExistsQueryNode:
+		return map[string]interface{}{
+			"exists": map[string]interface{}{
+				"field": MessageFieldAttachmentFileName,
+			},
+		}, nil
+	case *EntityQueryNode:
+		messageUUIDs, err := queryResolver.GetEntityMessageUUIDs(queryNode.Type, queryNode.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if queryNode.Value == "" && len(messageUUIDs) > MaxQueryMessageUUIDs {
+			return nil, newQueryParseError(queryNode.Position, "entity:%s matches more than %d messages, search for a value with entity:%s=value", queryNode.Type, MaxQueryMessageUUIDs, queryNode.Type)
+		}
+
+		return compileMessageUUIDsQuery(messageUUIDs, queryNode.Position)
+	case *CodingQueryNode:
+		messageUUIDs, err := queryResolver.GetCodingMessageUUIDs(queryNode.Field, queryNode.Value)
+
+		var queryParseError *QueryParseError
+
+		// The field and value can only be checked against the coding schema of the project.
+		if errors.As(err, &queryParseError) {
+			queryParseError.Position = queryNode.Position
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return compileMessageUUIDsQuery(messageUUIDs, queryNode.Position)
+	default:
+		return nil, fmt.Errorf("unsupported query node: %T", queryNode)
+	}
+}
+
+// compileTermQuery compiles a (fielded) word or phrase.
+func compileTermQuery(termQueryNode *TermQueryNode, queryResolver QueryResolver) (map[string]interface{}, error) {
+	switch termQueryNode.Field {
+	case "":
+		multiMatchQuery := map[string]interface{}{
+			"query":  termQueryNode.Value,
+			"fields": MessageTextFields,
+		}
+
+		if termQueryNode.IsPhrase {
+			multiMatchQuery["type"] = "phrase"
+		}
+
+		return map[string]interface{}{"multi_match": multiMatchQuery}, nil
+	case QueryFieldTag:
+		messageUUIDs, err := queryResolver.GetTagMessageUUIDs(termQueryNode.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return compileMessageUUIDsQuery(messageUUIDs, termQueryNode.Position)
+	case QueryFieldBookmarked:
+		messageUUIDs, err := queryResolver.GetBookmarkedMessageUUIDs()
+
+		if err != nil {
+			return nil, err
+		}
+
+		bookmarkedQuery, err := compileMessageUUIDsQuery(messageUUIDs, termQueryNode.Position)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if termQueryNode.Value == "false" {
+			return map[string]interface{}{
+				"bool": map[string]interface{}{
+					"must_not": []interface{}{bookmarkedQuery},
+				},
+			}, nil
+		}
+
+		return bookmarkedQuery, nil
+	case QueryFieldFolder:
+		folderUUIDs, err := queryResolver.GetFolderUUIDs(termQueryNode.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return newTermsQuery(MessageFieldFolderUUID, folderUUIDs), nil
+	case QueryFieldEvidence:
+		evidenceUUIDs, err := queryResolver.GetEvidenceUUIDs(termQueryNode.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return newTermsQuery(MessageFieldEvidenceUUID, evidenceUUIDs), nil
+	}
+
+	elasticsearchField, ok := queryFieldElasticsearchFields[termQueryNode.Field]
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported query field: %s", termQueryNode.Field)
+	}
+
+	if termQueryNode.IsPhrase {
+		return map[string]interface{}{
+			"match_phrase": map[string]interface{}{
+				elasticsearchField: termQueryNode.Value,
+			},
+		}, nil
+	}
+
+	return map[string]interface{}{
+		"match": map[string]interface{}{
+			elasticsearchField: map[string]interface{}{
+				"query":    termQueryNode.Value,
+				"operator": "and",
+			},
+		},
+	}, nil
+}
+
+// getPatternQueryFields returns the Elasticsearch fields searched by a wildcard or regular expression.
+func getPatternQueryFields(field string, isKeyword bool) []string {
+	if field == "" {
+		return MessageTextFields
+	}
+
+	if isKeyword {
+		return []string{queryFieldKeywordElasticsearchFields[field]}
+	}
+
+	return []string{queryFieldElasticsearchFields[field]}
+}
+
+// newPatternQuery creates a wildcard or regexp query on each of the fields.
+func newPatternQuery(queryType string, fields []string, parameters map[string]interface{}) map[string]interface{} {
+	var should []interface{}
+
+	for _, field := range fields {
+		should = append(should, map[string]interface{}{
+			queryType: map[string]interface{}{
+				field: parameters,
+			},
+		})
+	}
+
+	if len(should) == 1 {
+		return should[0].(map[string]interface{})
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+// newMessageUUIDsQuery creates a terms query on the message UUIDs, matching nothing if there are none.
+// Returns errTooManyQueryMessages if there are more than MaxQueryMessageUUIDs.
+func newMessageUUIDsQuery(messageUUIDs []string) (map[string]interface{}, error) {
+	if len(messageUUIDs) > MaxQueryMessageUUIDs {
+		return nil, errTooManyQueryMessages
+	}
+
+	return newTermsQuery(MessageFieldUUID, messageUUIDs), nil
+}
+
+// compileMessageUUIDsQuery creates a terms query on the message UUIDs of a query filter.
+// Returns a *QueryParseError at the position of the filter if it matches too many messages.
+func compileMessageUUIDsQuery(messageUUIDs []string, position int) (map[string]interface{}, error) {
+	elasticsearchQuery, err := newMessageUUIDsQuery(messageUUIDs)
+
+	if err != nil {
+		return nil, newQueryParseError(position, "%s", err)
+	}
+
+	return elasticsearchQuery, nil
+}
+
+// newTermsQuery creates a terms query, matching nothing if there are no values.
+func newTermsQuery(field string, values []string) map[string]interface{} {
+	if len(values) == 0 {
+		return map[string]interface{}{"match_none": map[string]interface{}{}}
+	}
+
+	return map[string]interface{}{
+		"terms": map[string]interface{}{
+			field: values,
+		},
+	}
+}
@@ -0,0 +1,580 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubQueryResolver resolves the query fields from fixed values.
+type stubQueryResolver struct {
+	Tags         map[string][]string
+	Bookmarked   []string
+	Folders      map[string][]string
+	Evidence     map[string][]string
+	Entities     map[string][]string
+	Codings      map[string][]string
+	CodingErrors map[string]error
+}
+
+// GetTagMessageUUIDs returns the UUIDs of the messages with the tag.
+func (stubQueryResolver *stubQueryResolver) GetTagMessageUUIDs(tag string) ([]string, error) {
+	return stubQueryResolver.Tags[tag], nil
+}
+
+// GetBookmarkedMessageUUIDs returns the UUIDs of the bookmarked messages.
+func (stubQueryResolver *stubQueryResolver) GetBookmarkedMessageUUIDs() ([]string, error) {
+	return stubQueryResolver.Bookmarked, nil
+}
+
+// GetFolderUUIDs returns the UUIDs of the folders with the name.
+func (stubQueryResolver *stubQueryResolver) GetFolderUUIDs(folder string) ([]string, error) {
+	return stubQueryResolver.Folders[folder], nil
+}
+
+// GetEvidenceUUIDs returns the UUIDs of the evidence items.
+func (stubQueryResolver *stubQueryResolver) GetEvidenceUUIDs(evidence string) ([]string, error) {
+	return stubQueryResolver.Evidence[evidence], nil
+}
+
+// GetEntityMessageUUIDs returns the UUIDs of the messages with the entity (type or type=value).
+func (stubQueryResolver *stubQueryResolver) GetEntityMessageUUIDs(entityType string, value string) ([]string, error) {
+	if value == "" {
+		return stubQueryResolver.Entities[entityType], nil
+	}
+
+	return stubQueryResolver.Entities[entityType+"="+value], nil
+}
+
+// GetCodingMessageUUIDs returns the UUIDs of the messages with the coded value (field or field=value).
+func (stubQueryResolver *stubQueryResolver) GetCodingMessageUUIDs(field string, value string) ([]string, error) {
+	key := field
+
+	if value != "" {
+		key += "=" + value
+	}
+
+	if err, ok := stubQueryResolver.CodingErrors[key]; ok {
+		return nil, err
+	}
+
+	return stubQueryResolver.Codings[key], nil
+}
+
+// newTestMessageUUIDs returns the given number of message UUIDs.
+func newTestMessageUUIDs(count int) []string {
+	messageUUIDs := make([]string, count)
+
+	for i := range messageUUIDs {
+		messageUUIDs[i] = fmt.Sprintf("message-%d", i)
+	}
+
+	return messageUUIDs
+}
+
+func TestParseQuery(t *testing.T) {
+	date := func(year int, month time.Month, day int) int64 {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix()
+	}
+
+	tests := []struct {
+		Name     string
+		Query    string
+		Expected QueryNode
+	}{
+		{
+			Name:     "word",
+			Query:    "invoice",
+			Expected: &TermQueryNode{Value: "invoice", Position: 0},
+		},
+		{
+			Name:  "implicit and",
+			Query: "invoice payment",
+			Expected: &AndQueryNode{Children: []QueryNode{
+				&TermQueryNode{Value: "invoice", Position: 0},
+				&TermQueryNode{Value: "payment", Position: 8},
+			}},
+		},
+		{
+			Name:  "and binds tighter than or",
+			Query: "a OR b AND c",
+			Expected: &OrQueryNode{Children: []QueryNode{
+				&TermQueryNode{Value: "a", Position: 0},
+				&AndQueryNode{Children: []QueryNode{
+					&TermQueryNode{Value: "b", Position: 5},
+					&TermQueryNode{Value: "c", Position: 11},
+				}},
+			}},
+		},
+		{
+			Name:  "implicit and binds tighter than or",
+			Query: "a b OR c",
+			Expected: &OrQueryNode{Children: []QueryNode{
+				&AndQueryNode{Children: []QueryNode{
+					&TermQueryNode{Value: "a", Position: 0},
+					&TermQueryNode{Value: "b", Position: 2},
+				}},
+				&TermQueryNode{Value: "c", Position: 7},
+			}},
+		},
+		{
+			Name:  "parentheses",
+			Query: "(a OR b) c",
+			Expected: &AndQueryNode{Children: []QueryNode{
+				&OrQueryNode{Children: []QueryNode{
+					&TermQueryNode{Value: "a", Position: 1},
+					&TermQueryNode{Value: "b", Position: 6},
+				}},
+				&TermQueryNode{Value: "c", Position: 9},
+			}},
+		},
+		{
+			Name:  "nested parentheses",
+			Query: "a AND ((b OR c) OR d)",
+			Expected: &AndQueryNode{Children: []QueryNode{
+				&TermQueryNode{Value: "a", Position: 0},
+				&OrQueryNode{Children: []QueryNode{
+					&OrQueryNode{Children: []QueryNode{
+						&TermQueryNode{Value: "b", Position: 8},
+						&TermQueryNode{Value: "c", Position: 13},
+					}},
+					&TermQueryNode{Value: "d", Position: 19},
+				}},
+			}},
+		},
+		{
+			Name:  "not",
+			Query: "invoice NOT tag:reviewed",
+			Expected: &AndQueryNode{Children: []QueryNode{
+				&TermQueryNode{Value: "invoice", Position: 0},
+				&NotQueryNode{Child: &TermQueryNode{Field: QueryFieldTag, Value: "reviewed", Position: 12}},
+			}},
+		},
+		{
+			Name:  "minus",
+			Query: "invoice -folder:\"Deleted Items\"",
+			Expected: &AndQueryNode{Children: []QueryNode{
+				&TermQueryNode{Value: "invoice", Position: 0},
+				&NotQueryNode{Child: &TermQueryNode{Field: QueryFieldFolder, Value: "Deleted Items", IsPhrase: true, Position: 9}},
+			}},
+		},
+		{
+			Name:     "double not",
+			Query:    "NOT -a",
+			Expected: &NotQueryNode{Child: &NotQueryNode{Child: &TermQueryNode{Value: "a", Position: 5}}},
+		},
+		{
+			Name:  "not binds tighter than and",
+			Query: "NOT a b",
+			Expected: &AndQueryNode{Children: []QueryNode{
+				&NotQueryNode{Child: &TermQueryNode{Value: "a", Position: 4}},
+				&TermQueryNode{Value: "b", Position: 6},
+			}},
+		},
+		{
+			Name:  "lowercase operators are words",
+			Query: "cats and dogs",
+			Expected: &AndQueryNode{Children: []QueryNode{
+				&TermQueryNode{Value: "cats", Position: 0},
+				&TermQueryNode{Value: "and", Position: 5},
+				&TermQueryNode{Value: "dogs", Position: 9},
+			}},
+		},
+		{
+			Name:     "phrase",
+			Query:    "\"wire transfer\"",
+			Expected: &TermQueryNode{Value: "wire transfer", IsPhrase: true, Position: 0},
+		},
+		{
+			Name:     "phrase with escaped quote",
+			Query:    `"say \"hi\""`,
+			Expected: &TermQueryNode{Value: `say "hi"`, IsPhrase: true, Position: 0},
+		},
+		{
+			Name:     "field phrase",
+			Query:    "subject:\"quarterly results\"",
+			Expected: &TermQueryNode{Field: QueryFieldSubject, Value: "quarterly results", IsPhrase: true, Position: 0},
+		},
+		{
+			Name:     "field value",
+			Query:    "from:alice",
+			Expected: &TermQueryNode{Field: QueryFieldFrom, Value: "alice", Position: 0},
+		},
+		{
+			Name:     "field name is case-insensitive",
+			Query:    "FROM:alice",
+			Expected: &TermQueryNode{Field: QueryFieldFrom, Value: "alice", Position: 0},
+		},
+		{
+			Name:     "bookmarked",
+			Query:    "bookmarked:no",
+			Expected: &TermQueryNode{Field: QueryFieldBookmarked, Value: "false", Position: 0},
+		},
+		{
+			Name:     "has attachment",
+			Query:    "has:attachments",
+			Expected: &ExistsQueryNode{Value: QueryFieldAttachment, Position: 0},
+		},
+		{
+			Name:     "entity type",
+			Query:    "entity:bitcoin",
+			Expected: &EntityQueryNode{Type: "bitcoin", Position: 0},
+		},
+		{
+			Name:     "coding value",
+			Query:    "coding:responsive=yes",
+			Expected: &CodingQueryNode{Field: "responsive", Value: "yes", Position: 0},
+		},
+		{
+			Name:     "coding phrase",
+			Query:    "coding:\"privilege type=attorney client\"",
+			Expected: &CodingQueryNode{Field: "privilege type", Value: "attorney client", Position: 0},
+		},
+		{
+			Name:     "date year",
+			Query:    "date:2021",
+			Expected: &RangeQueryNode{Field: QueryFieldDate, From: date(2021, 1, 1), HasFrom: true, To: date(2022, 1, 1), HasTo: true, Position: 5},
+		},
+		{
+			Name:     "date range",
+			Query:    "date:2021-01-01..2021-06-30",
+			Expected: &RangeQueryNode{Field: QueryFieldDate, From: date(2021, 1, 1), HasFrom: true, To: date(2021, 7, 1), HasTo: true, Position: 5},
+		},
+		{
+			Name:     "date comparison",
+			Query:    "date:>2021-01",
+			Expected: &RangeQueryNode{Field: QueryFieldDate, From: date(2021, 2, 1), HasFrom: true, Position: 5},
+		},
+		{
+			Name:     "size comparison",
+			Query:    "size:>=5MB",
+			Expected: &RangeQueryNode{Field: QueryFieldSize, From: 5 * 1024 * 1024, HasFrom: true, Position: 5},
+		},
+		{
+			Name:     "fuzzy",
+			Query:    "invoice~2",
+			Expected: &FuzzyQueryNode{Value: "invoice", Fuzziness: "2", Position: 0},
+		},
+		{
+			Name:     "proximity",
+			Query:    "\"invoice payment\"~5",
+			Expected: &ProximityQueryNode{Value: "invoice payment", Slop: 5, Position: 0},
+		},
+		{
+			Name:     "keyword wildcard",
+			Query:    "from.keyword:*@example.com",
+			Expected: &WildcardQueryNode{Field: QueryFieldFrom, Value: "*@example.com", IsKeyword: true, Position: 0},
+		},
+		{
+			Name:     "regular expression",
+			Query:    "subject:/inv[0-9]+/",
+			Expected: &RegexpQueryNode{Field: QueryFieldSubject, Pattern: "inv[0-9]+", Position: 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			queryNode, err := ParseQuery(test.Query)
+
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) returned an error: %s", test.Query, err)
+			}
+
+			if !reflect.DeepEqual(queryNode, test.Expected) {
+				t.Errorf("ParseQuery(%q) = %s, expected %s", test.Query, formatTestQueryNode(queryNode), formatTestQueryNode(test.Expected))
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		Name             string
+		Query            string
+		ExpectedPosition int
+		ExpectedMessage  string
+	}{
+		{Name: "empty query", Query: "   ", ExpectedPosition: 0, ExpectedMessage: "empty query"},
+		{Name: "unterminated phrase", Query: "invoice \"wire transfer", ExpectedPosition: 8, ExpectedMessage: "unterminated phrase"},
+		{Name: "unterminated regular expression", Query: "a /inv", ExpectedPosition: 2, ExpectedMessage: "unterminated regular expression"},
+		{Name: "missing closing parenthesis", Query: "a (b OR c", ExpectedPosition: 2, ExpectedMessage: "missing closing parenthesis"},
+		{Name: "unexpected closing parenthesis", Query: "a b)", ExpectedPosition: 3, ExpectedMessage: "without an opening parenthesis"},
+		{Name: "empty parentheses", Query: "a ()", ExpectedPosition: 2, ExpectedMessage: "empty parentheses"},
+		{Name: "dangling operator", Query: "a OR", ExpectedPosition: 4, ExpectedMessage: "unexpected end of query"},
+		{Name: "dangling not", Query: "a NOT", ExpectedPosition: 5, ExpectedMessage: "unexpected end of query"},
+		{Name: "leading operator", Query: "AND a", ExpectedPosition: 0, ExpectedMessage: "unexpected AND"},
+		{Name: "unknown field", Query: "a colour:red", ExpectedPosition: 2, ExpectedMessage: "unknown field \"colour\""},
+		{Name: "missing field name", Query: ":red", ExpectedPosition: 0, ExpectedMessage: "missing field name"},
+		{Name: "missing value", Query: "from:", ExpectedPosition: 5, ExpectedMessage: "missing value"},
+		{Name: "invalid bookmarked", Query: "bookmarked:maybe", ExpectedPosition: 11, ExpectedMessage: "invalid value \"maybe\" for bookmarked"},
+		{Name: "invalid entity type", Query: "entity:colour", ExpectedPosition: 7, ExpectedMessage: "invalid entity type"},
+		{Name: "missing entity value", Query: "entity:iban=", ExpectedPosition: 12, ExpectedMessage: "missing entity value"},
+		{Name: "missing coding value", Query: "coding:responsive=", ExpectedPosition: 18, ExpectedMessage: "missing coding value"},
+		{Name: "phrase on field without phrases", Query: "size:\"big\"", ExpectedPosition: 0, ExpectedMessage: "does not accept a phrase"},
+		{Name: "short fuzzy term", Query: "ab~", ExpectedPosition: 0, ExpectedMessage: "at least 3 characters"},
+		{Name: "reversed date range", Query: "date:2021..2020", ExpectedPosition: 5, ExpectedMessage: "ends before it starts"},
+		{Name: "position is a byte offset", Query: "café )", ExpectedPosition: 6, ExpectedMessage: "without an opening parenthesis"},
+		{Name: "too many pattern terms", Query: strings.Repeat("invoice~ ", MaxQueryPatternTerms) + "payment~", ExpectedPosition: MaxQueryPatternTerms * 9, ExpectedMessage: "too many"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			_, err := ParseQuery(test.Query)
+
+			var queryParseError *QueryParseError
+
+			if !errors.As(err, &queryParseError) {
+				t.Fatalf("ParseQuery(%q) returned %v, expected a *QueryParseError", test.Query, err)
+			}
+
+			if queryParseError.Position != test.ExpectedPosition {
+				t.Errorf("ParseQuery(%q) error position = %d, expected %d (%s)", test.Query, queryParseError.Position, test.ExpectedPosition, queryParseError.Message)
+			}
+
+			if !strings.Contains(queryParseError.Message, test.ExpectedMessage) {
+				t.Errorf("ParseQuery(%q) error = %q, expected it to contain %q", test.Query, queryParseError.Message, test.ExpectedMessage)
+			}
+		})
+	}
+}
+
+func TestCompileQuery(t *testing.T) {
+	queryResolver := &stubQueryResolver{
+		Tags:       map[string][]string{"hot": {"message-1", "message-2"}},
+		Bookmarked: []string{"message-3"},
+		Folders:    map[string][]string{"Inbox": {"folder-1"}},
+		Evidence:   map[string][]string{"alice": {"evidence-1"}},
+		Entities:   map[string][]string{"bitcoin": {"message-4"}, "iban=NL91ABNA0417164300": {"message-5"}},
+		Codings:    map[string][]string{"responsive=yes": {"message-6"}},
+	}
+
+	tests := []struct {
+		Name     string
+		Query    string
+		Expected string
+	}{
+		{
+			Name:     "word",
+			Query:    "invoice",
+			Expected: `{"multi_match":{"fields":["subject","body","from","to","cc","attachments.fileName","attachments.content"],"query":"invoice"}}`,
+		},
+		{
+			Name:     "phrase",
+			Query:    "\"wire transfer\"",
+			Expected: `{"multi_match":{"fields":["subject","body","from","to","cc","attachments.fileName","attachments.content"],"query":"wire transfer","type":"phrase"}}`,
+		},
+		{
+			Name:     "field value",
+			Query:    "from:alice",
+			Expected: `{"match":{"from":{"operator":"and","query":"alice"}}}`,
+		},
+		{
+			Name:     "field phrase",
+			Query:    "subject:\"quarterly results\"",
+			Expected: `{"match_phrase":{"subject":"quarterly results"}}`,
+		},
+		{
+			Name:     "and with not",
+			Query:    "from:alice -from:bob",
+			Expected: `{"bool":{"must":[{"match":{"from":{"operator":"and","query":"alice"}}}],"must_not":[{"match":{"from":{"operator":"and","query":"bob"}}}]}}`,
+		},
+		{
+			Name:     "or",
+			Query:    "from:alice OR from:bob",
+			Expected: `{"bool":{"minimum_should_match":1,"should":[{"match":{"from":{"operator":"and","query":"alice"}}},{"match":{"from":{"operator":"and","query":"bob"}}}]}}`,
+		},
+		{
+			Name:     "not",
+			Query:    "NOT from:bob",
+			Expected: `{"bool":{"must_not":[{"match":{"from":{"operator":"and","query":"bob"}}}]}}`,
+		},
+		{
+			Name:     "tag",
+			Query:    "tag:hot",
+			Expected: `{"terms":{"uuid.keyword":["message-1","message-2"]}}`,
+		},
+		{
+			Name:     "unknown tag",
+			Query:    "tag:cold",
+			Expected: `{"match_none":{}}`,
+		},
+		{
+			Name:     "bookmarked",
+			Query:    "bookmarked:true",
+			Expected: `{"terms":{"uuid.keyword":["message-3"]}}`,
+		},
+		{
+			Name:     "not bookmarked",
+			Query:    "bookmarked:false",
+			Expected: `{"bool":{"must_not":[{"terms":{"uuid.keyword":["message-3"]}}]}}`,
+		},
+		{
+			Name:     "folder",
+			Query:    "folder:Inbox",
+			Expected: `{"terms":{"folderUUID.keyword":["folder-1"]}}`,
+		},
+		{
+			Name:     "evidence",
+			Query:    "evidence:alice",
+			Expected: `{"terms":{"evidenceUUID.keyword":["evidence-1"]}}`,
+		},
+		{
+			Name:     "has attachment",
+			Query:    "has:attachment",
+			Expected: `{"exists":{"field":"attachments.fileName"}}`,
+		},
+		{
+			Name:     "entity type",
+			Query:    "entity:bitcoin",
+			Expected: `{"terms":{"uuid.keyword":["message-4"]}}`,
+		},
+		{
+			Name:     "entity value",
+			Query:    "entity:iban=NL91ABNA0417164300",
+			Expected: `{"terms":{"uuid.keyword":["message-5"]}}`,
+		},
+		{
+			Name:     "coding",
+			Query:    "coding:responsive=yes",
+			Expected: `{"terms":{"uuid.keyword":["message-6"]}}`,
+		},
+		{
+			Name:     "date range",
+			Query:    "date:2021-01-01..2021-01-31",
+			Expected: `{"range":{"date":{"gte":1609459200,"lt":1612137600}}}`,
+		},
+		{
+			Name:     "size comparison",
+			Query:    "size:<1KB",
+			Expected: `{"range":{"size":{"lt":1024}}}`,
+		},
+		{
+			Name:     "fuzzy",
+			Query:    "subject:invoice~",
+			Expected: `{"match":{"subject":{"fuzziness":"AUTO","max_expansions":50,"operator":"and","prefix_length":1,"query":"invoice"}}}`,
+		},
+		{
+			Name:     "proximity",
+			Query:    "body:\"invoice payment\"~5",
+			Expected: `{"match_phrase":{"body":{"query":"invoice payment","slop":5}}}`,
+		},
+		{
+			Name:     "keyword wildcard",
+			Query:    "from.keyword:*@example.com",
+			Expected: `{"wildcard":{"from.keyword":{"case_insensitive":true,"value":"*@example.com"}}}`,
+		},
+		{
+			Name:     "regular expression",
+			Query:    "subject:/inv[0-9]+/",
+			Expected: `{"regexp":{"subject":{"case_insensitive":true,"flags":"NONE","max_determinized_states":2000,"value":"inv[0-9]+"}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			queryNode, err := ParseQuery(test.Query)
+
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) returned an error: %s", test.Query, err)
+			}
+
+			elasticsearchQuery, err := CompileQuery(queryNode, queryResolver)
+
+			if err != nil {
+				t.Fatalf("CompileQuery(%q) returned an error: %s", test.Query, err)
+			}
+
+			elasticsearchQueryJSON, err := json.Marshal(elasticsearchQuery)
+
+			if err != nil {
+				t.Fatalf("Failed to encode the query: %s", err)
+			}
+
+			if string(elasticsearchQueryJSON) != test.Expected {
+				t.Errorf("CompileQuery(%q) =\n%s\nexpected\n%s", test.Query, elasticsearchQueryJSON, test.Expected)
+			}
+		})
+	}
+}
+
+func TestCompileQueryErrors(t *testing.T) {
+	queryResolver := &stubQueryResolver{
+		Tags:         map[string][]string{"everything": newTestMessageUUIDs(MaxQueryMessageUUIDs + 1)},
+		Bookmarked:   newTestMessageUUIDs(MaxQueryMessageUUIDs + 1),
+		Entities:     map[string][]string{"email": newTestMessageUUIDs(MaxQueryMessageUUIDs + 1)},
+		Codings:      map[string][]string{"reviewed": newTestMessageUUIDs(MaxQueryMessageUUIDs + 1)},
+		CodingErrors: map[string]error{"colour": newQueryParseError(0, "unknown coding field \"colour\"")},
+	}
+
+	tests := []struct {
+		Name             string
+		Query            string
+		ExpectedPosition int
+		ExpectedMessage  string
+	}{
+		{Name: "unknown coding field", Query: "invoice coding:colour", ExpectedPosition: 8, ExpectedMessage: "unknown coding field"},
+		{Name: "tag with too many messages", Query: "invoice tag:everything", ExpectedPosition: 8, ExpectedMessage: "more than"},
+		{Name: "bookmarked with too many messages", Query: "invoice -bookmarked:false", ExpectedPosition: 9, ExpectedMessage: "more than"},
+		{Name: "entity type with too many messages", Query: "invoice entity:email", ExpectedPosition: 8, ExpectedMessage: "search for a value with entity:email=value"},
+		{Name: "coding with too many messages", Query: "invoice OR coding:reviewed", ExpectedPosition: 11, ExpectedMessage: "more than"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			queryNode, err := ParseQuery(test.Query)
+
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) returned an error: %s", test.Query, err)
+			}
+
+			_, err = CompileQuery(queryNode, queryResolver)
+
+			var queryParseError *QueryParseError
+
+			if !errors.As(err, &queryParseError) {
+				t.Fatalf("CompileQuery(%q) returned %v, expected a *QueryParseError", test.Query, err)
+			}
+
+			if queryParseError.Position != test.ExpectedPosition {
+				t.Errorf("CompileQuery(%q) error position = %d, expected %d", test.Query, queryParseError.Position, test.ExpectedPosition)
+			}
+
+			if !strings.Contains(queryParseError.Message, test.ExpectedMessage) {
+				t.Errorf("CompileQuery(%q) error = %q, expected it to contain %q", test.Query, queryParseError.Message, test.ExpectedMessage)
+			}
+		})
+	}
+}
+
+// formatTestQueryNode formats the query node tree for test failures.
+func formatTestQueryNode(queryNode QueryNode) string {
+	switch queryNode := queryNode.(type) {
+	case *AndQueryNode:
+		var children []string
+
+		for _, child := range queryNode.Children {
+			children = append(children, formatTestQueryNode(child))
+		}
+
+		return fmt.Sprintf("AND(%s)", strings.Join(children, ", "))
+	case *OrQueryNode:
+		var children []string
+
+		for _, child := range queryNode.Children {
+			children = append(children, formatTestQueryNode(child))
+		}
+
+		return fmt.Sprintf("OR(%s)", strings.Join(children, ", "))
+	case *NotQueryNode:
+		return fmt.Sprintf("NOT(%s)", formatTestQueryNode(queryNode.Child))
+	default:
+		return fmt.Sprintf("%+v", queryNode)
+	}
+}
@@ -0,0 +1,122 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net/mail"
+	"strings"
+)
+
+// indexRecipientsBatchSize defines the amount of messages updated per update by query.
+const indexRecipientsBatchSize = 500
+
+// indexRecipientsScript sets the recipients of the message document.
+const indexRecipientsScript = `ctx._source.recipients = params.recipients.get(ctx._source.uuid);`
+
+// GetMessageRecipients returns the recipients (To, CC and BCC) of the message, each recipient once.
+// Recipients are lowercase email addresses, or the display name if the message has no address (PST display lists).
+func GetMessageRecipients(message core.Message) []string {
+	recipients := []string{}
+	isRecipient := make(map[string]bool)
+
+	for _, addressList := range []string{message.To, message.CC, message.BCC} {
+		for _, recipient := range getAddressListRecipients(addressList) {
+			if !isRecipient[recipient] {
+				isRecipient[recipient] = true
+				recipients = append(recipients, recipient)
+			}
+		}
+	}
+
+	return recipients
+}
+
+// getAddressListRecipients returns the recipients of an address header or a PST display list ("Alice; Bob").
+func getAddressListRecipients(addressList string) []string {
+	if strings.TrimSpace(addressList) == "" {
+		return nil
+	}
+
+	var recipients []string
+
+	if addresses, err := mail.ParseAddressList(addressList); err == nil {
+		for _, address := range addresses {
+			recipients = append(recipients, strings.ToLower(address.Address))
+		}
+
+		return recipients
+	}
+
+	for _, value := range strings.FieldsFunc(addressList, func(character rune) bool { return character == ';' }) {
+		if address := getHeaderAddress(value); address != "" {
+			recipients = append(recipients, address)
+		} else if name := strings.Trim(strings.TrimSpace(value), "\"'"); name != "" {
+			recipients = append(recipients, strings.ToLower(name))
+		}
+	}
+
+	return recipients
+}
+
+// IndexEvidenceRecipients adds the recipients of the messages in the evidence to their message documents,
+// so each recipient is a separate keyword (MessageFieldRecipients).
+func IndexEvidenceRecipients(projectUUID string, evidenceUUID string) error {
+	return indexRecipients(NewProjectQuery(map[string]interface{}{
+		"term": map[string]interface{}{MessageFieldEvidenceUUID: evidenceUUID},
+	}, projectUUID))
+}
+
+// IndexMissingRecipients adds the recipients to the message documents indexed before the recipients were indexed,
+// so the recipients facet also covers the existing evidence. Messages without any recipient are updated again on each run.
+func IndexMissingRecipients() error {
+	return indexRecipients(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must_not": map[string]interface{}{
+				"exists": map[string]interface{}{"field": MessageFieldRecipients},
+			},
+		},
+	})
+}
+
+// indexRecipients adds the recipients of the messages matching the query to their message documents.
+func indexRecipients(elasticsearchQuery map[string]interface{}) error {
+	recipients := make(map[string]interface{})
+
+	updateRecipients := func() error {
+		if len(recipients) == 0 {
+			return nil
+		}
+
+		var messageUUIDs []string
+
+		for messageUUID := range recipients {
+			messageUUIDs = append(messageUUIDs, messageUUID)
+		}
+
+		// Message UUIDs are unique across projects.
+		err := UpdateMessagesByQuery(newTermsQuery(MessageFieldUUID, messageUUIDs), indexRecipientsScript, map[string]interface{}{"recipients": recipients})
+
+		recipients = make(map[string]interface{})
+
+		return err
+	}
+
+	err := WalkMessages(context.Background(), elasticsearchQuery, func(message core.Message) error {
+		recipients[message.UUID] = GetMessageRecipients(message)
+
+		if len(recipients) < indexRecipientsBatchSize {
+			return nil
+		}
+
+		return updateRecipients()
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return updateRecipients()
+}
@@ -0,0 +1,803 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Constants defining the redaction types.
+const (
+	// RedactionTypeRange redacts the characters from Start (inclusive) to End (exclusive).
+	RedactionTypeRange = "range"
+	// RedactionTypeRegex redacts each match of the (RE2) pattern.
+	RedactionTypeRegex = "regex"
+)
+
+// Constants defining the redaction log actions.
+const (
+	RedactionLogActionCreated = "created"
+	RedactionLogActionDeleted = "deleted"
+)
+
+// MaxRedactionPatternLength defines the maximum length of a redaction pattern.
+const MaxRedactionPatternLength = 1000
+
+// redactionReasonRegexp matches the reason codes, which can't contain brackets so the marker stays readable.
+var redactionReasonRegexp = regexp.MustCompile(`^[^\[\]\p{Cc}\p{Co}]{1,64}$`)
+
+// Constants defining the characters delimiting the redaction markers in the text laid out in a PDF.
+// The private use characters are replaced in the text by redactPDFText, the reasons can't contain them.
+const (
+	pdfRedactionStart = "\ue000"
+	pdfRedactionEnd   = "\ue001"
+)
+
+// pdfRedactionReplacer replaces the marker delimiters in the text before redacting, keeping the character offsets.
+var pdfRedactionReplacer = strings.NewReplacer(pdfRedactionStart, "?", pdfRedactionEnd, "?")
+
+// Redaction represents text of the body or of an attachment which is removed from the produced messages.
+// The evidence itself is never changed, redactions are applied when producing the output.
+type Redaction struct {
+	UUID        string `json:"uuid"`
+	ProjectUUID string `json:"projectUUID"`
+	MessageUUID string `json:"messageUUID"`
+	// AttachmentUUID is the attachment of which the extracted text is redacted, the body is redacted if empty.
+	AttachmentUUID string `json:"attachmentUUID"`
+	Type           string `json:"type"`
+	// Start and End are character offsets in the body text or attachment text (range redactions).
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	Pattern string `json:"pattern"`
+	// Reason is the reason code shown in place of the redacted text, for example "Personal data".
+	Reason       string `json:"reason"`
+	CreatorID    string `json:"creatorID"`
+	CreationDate int    `json:"creationDate"`
+}
+
+// RedactionLogEntry represents a change to the redactions of a project.
+type RedactionLogEntry struct {
+	ID            int       `json:"id"`
+	ProjectUUID   string    `json:"projectUUID"`
+	MessageUUID   string    `json:"messageUUID"`
+	RedactionUUID string    `json:"redactionUUID"`
+	Action        string    `json:"action"`
+	UserID        string    `json:"userID"`
+	UserEmail     string    `json:"userEmail"`
+	Date          int       `json:"date"`
+	Redaction     Redaction `json:"redaction"`
+}
+
+// RedactedMessage represents the text of a message with the redactions applied.
+type RedactedMessage struct {
+	MessageUUID    string               `json:"messageUUID"`
+	Body           string               `json:"body"`
+	Attachments    []RedactedAttachment `json:"attachments"`
+	RedactionCount int                  `json:"redactionCount"`
+}
+
+// RedactedAttachment represents the extracted text of an attachment with the redactions applied.
+type RedactedAttachment struct {
+	AttachmentUUID string `json:"attachmentUUID"`
+	FileName       string `json:"fileName"`
+	Text           string `json:"text"`
+}
+
+// redactionRange represents the byte range of the text which is redacted.
+type redactionRange struct {
+	Start   int
+	End     int
+	Reasons []string
+}
+
+// handleMessageRedactions handles the redactions of a message, GET lists the redactions and POST adds one.
+func (server *Server) handleMessageRedactions() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to get message: %s", err)
+			http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+			return
+		}
+
+		if request.Method == "GET" {
+			redactions, err := GetRedactionsByMessage(message.UUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get redactions: %s", err)
+				http.Error(responseWriter, "Failed to get redactions.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&redactions); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			var redaction Redaction
+
+			if err := json.NewDecoder(request.Body).Decode(&redaction); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			redaction.UUID = core.NewUUID()
+			redaction.ProjectUUID = project.UUID
+			redaction.MessageUUID = message.UUID
+			redaction.CreatorID = user.Id
+			redaction.CreationDate = int(time.Now().Unix())
+
+			if err := server.ValidateRedaction(&redaction, message); err != nil {
+				Logger.Errorf("Invalid redaction: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid redaction: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			if err := redaction.Save(getUserEmail(user), server.Database); err != nil {
+				Logger.Errorf("Failed to save redaction: %s", err)
+				http.Error(responseWriter, "Failed to save redaction.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&redaction); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleRedaction handles the redaction endpoint, returning or deleting the redaction.
+func (server *Server) handleRedaction() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		redaction, err := GetRedactionByUUID(mux.Vars(request)["uuid"], project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to get redaction: %s", err)
+			http.Error(responseWriter, "Failed to get redaction.", http.StatusNotFound)
+			return
+		}
+
+		if request.Method == "GET" {
+			if err := json.NewEncoder(responseWriter).Encode(&redaction); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "DELETE" {
+			if err := redaction.Delete(user.Id, getUserEmail(user), server.Database); err != nil {
+				Logger.Errorf("Failed to delete redaction: %s", err)
+				http.Error(responseWriter, "Failed to delete redaction.", http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleRedactedMessage handles the redacted message endpoint, returning the body and attachment texts with the redactions applied.
+// Returns plain text instead of JSON with the "format=text" query parameter.
+func (server *Server) handleRedactedMessage() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			redactedMessage, err := NewRedactedMessage(message, redactMarkedText, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to redact message: %s", err)
+				http.Error(responseWriter, "Failed to redact message.", http.StatusInternalServerError)
+				return
+			}
+
+			if request.URL.Query().Get("format") == "text" {
+				responseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+				if _, err := responseWriter.Write([]byte(redactedMessage.String())); err != nil {
+					Logger.Errorf("Failed to write response: %s", err)
+					http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+					return
+				}
+
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&redactedMessage); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleRedactionLog handles the redaction log endpoint, optionally of a "messageUUID".
+func (server *Server) handleRedactionLog() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			redactionLog, err := GetRedactionLog(request.URL.Query().Get("messageUUID"), project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get redaction log: %s", err)
+				http.Error(responseWriter, "Failed to get redaction log.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&redactionLog); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// ValidateRedaction validates the redaction against the text of the message it redacts.
+func (server *Server) ValidateRedaction(redaction *Redaction, message core.Message) error {
+	redaction.Reason = strings.TrimSpace(redaction.Reason)
+
+	if !redactionReasonRegexp.MatchString(redaction.Reason) {
+		return errors.New("the reason must be 1 to 64 characters without brackets")
+	}
+
+	text := GetMessageBodyText(message)
+
+	if redaction.AttachmentUUID != "" {
+		isAttachment := false
+
+		for _, attachment := range message.Attachments {
+			if attachment.UUID == redaction.AttachmentUUID {
+				isAttachment = true
+				break
+			}
+		}
+
+		if !isAttachment {
+			return fmt.Errorf("the attachment %s is not an attachment of the message", redaction.AttachmentUUID)
+		}
+
+		attachmentText, err := GetAttachmentText(redaction.AttachmentUUID, redaction.ProjectUUID, server.Database)
+
+		if err != nil || attachmentText.Status != AttachmentTextStatusExtracted {
+			return fmt.Errorf("no text was extracted from the attachment %s", redaction.AttachmentUUID)
+		}
+
+		text = attachmentText.Text
+	}
+
+	switch redaction.Type {
+	case RedactionTypeRange:
+		redaction.Pattern = ""
+
+		if redaction.Start < 0 || redaction.End <= redaction.Start || redaction.End > utf8.RuneCountInString(text) {
+			return fmt.Errorf("the range must be within the %d characters of the text", utf8.RuneCountInString(text))
+		}
+	case RedactionTypeRegex:
+		redaction.Start = 0
+		redaction.End = 0
+
+		if redaction.Pattern == "" || len(redaction.Pattern) > MaxRedactionPatternLength {
+			return fmt.Errorf("the pattern must be 1 to %d characters", MaxRedactionPatternLength)
+		}
+
+		if _, err := regexp.Compile(redaction.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+	default:
+		return fmt.Errorf("unknown type \"%s\", expected %s or %s", redaction.Type, RedactionTypeRange, RedactionTypeRegex)
+	}
+
+	return nil
+}
+
+// GetMessageBodyText returns the plain text body of the message, converted from the HTML body if there is none.
+// Range redactions of the body are offsets in this text.
+func GetMessageBodyText(message core.Message) string {
+	if strings.TrimSpace(message.Body) == "" {
+		return HTMLToText(message.BodyHTML)
+	}
+
+	return message.Body
+}
+
+// FormatRedactionMarker returns the marker replacing redacted text.
+func FormatRedactionMarker(reason string) string {
+	return fmt.Sprintf("[REDACTED: %s]", reason)
+}
+
+// formatPDFRedactionMarker returns the marker replacing redacted text laid out by writeRedactedText.
+// The spaces are non-breaking so the marker is kept on one line.
+func formatPDFRedactionMarker(reason string) string {
+	return pdfRedactionStart + strings.ReplaceAll(FormatRedactionMarker(reason), " ", "\u00a0") + pdfRedactionEnd
+}
+
+// getRedactionRanges returns the sorted byte ranges of the text matched by the redactions.
+// Overlapping and adjacent ranges are merged, keeping the reasons of each.
+func getRedactionRanges(text string, redactions []Redaction) []redactionRange {
+	var redactionRanges []redactionRange
+
+	for _, redaction := range redactions {
+		switch redaction.Type {
+		case RedactionTypeRange:
+			start := getRuneByteOffset(text, redaction.Start)
+			end := getRuneByteOffset(text, redaction.End)
+
+			if start < end {
+				redactionRanges = append(redactionRanges, redactionRange{Start: start, End: end, Reasons: []string{redaction.Reason}})
+			}
+		case RedactionTypeRegex:
+			// The patterns are validated when saved.
+			pattern, err := regexp.Compile(redaction.Pattern)
+
+			if err != nil {
+				continue
+			}
+
+			for _, match := range pattern.FindAllStringIndex(text, -1) {
+				if match[0] < match[1] {
+					redactionRanges = append(redactionRanges, redactionRange{Start: match[0], End: match[1], Reasons: []string{redaction.Reason}})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(redactionRanges, func(i, j int) bool {
+		return redactionRanges[i].Start < redactionRanges[j].Start
+	})
+
+	var mergedRanges []redactionRange
+
+	for _, currentRange := range redactionRanges {
+		if len(mergedRanges) == 0 || currentRange.Start > mergedRanges[len(mergedRanges)-1].End {
+			mergedRanges = append(mergedRanges, currentRange)
+			continue
+		}
+
+		lastRange := &mergedRanges[len(mergedRanges)-1]
+
+		lastRange.End = maxInt(lastRange.End, currentRange.End)
+
+		for _, reason := range currentRange.Reasons {
+			isReason := false
+
+			for _, lastReason := range lastRange.Reasons {
+				if lastReason == reason {
+					isReason = true
+					break
+				}
+			}
+
+			if !isReason {
+				lastRange.Reasons = append(lastRange.Reasons, reason)
+			}
+		}
+	}
+
+	return mergedRanges
+}
+
+// getRuneByteOffset returns the byte offset of the character offset, the length of the text if the text is shorter.
+func getRuneByteOffset(text string, runeOffset int) int {
+	characters := 0
+
+	for byteOffset := range text {
+		if characters == runeOffset {
+			return byteOffset
+		}
+
+		characters++
+	}
+
+	return len(text)
+}
+
+// RedactText replaces the text matched by the redactions with the marker of the reasons.
+func RedactText(text string, redactions []Redaction, marker func(reason string) string) string {
+	var redactedText strings.Builder
+
+	offset := 0
+
+	for _, redactionRange := range getRedactionRanges(text, redactions) {
+		redactedText.WriteString(text[offset:redactionRange.Start])
+		redactedText.WriteString(marker(strings.Join(redactionRange.Reasons, ", ")))
+		offset = redactionRange.End
+	}
+
+	redactedText.WriteString(text[offset:])
+
+	return redactedText.String()
+}
+
+// getTargetRedactions returns the redactions of the attachment, or of the body if the attachment UUID is empty.
+func getTargetRedactions(redactions []Redaction, attachmentUUID string) []Redaction {
+	var targetRedactions []Redaction
+
+	for _, redaction := range redactions {
+		if redaction.AttachmentUUID == attachmentUUID {
+			targetRedactions = append(targetRedactions, redaction)
+		}
+	}
+
+	return targetRedactions
+}
+
+// RedactMessage returns the message with the body redactions applied, for the outputs created from the message itself
+// (the HTML report, the stream export and the rendered HTML). Range redactions are offsets in the plain text body,
+// so the HTML body of a redacted message is removed. Returns the message unchanged if the body has no redactions.
+func RedactMessage(message core.Message, redactions []Redaction) core.Message {
+	bodyRedactions := getTargetRedactions(redactions, "")
+
+	if len(bodyRedactions) == 0 {
+		return message
+	}
+
+	message.Body = redactMarkedText(GetMessageBodyText(message), bodyRedactions)
+	message.BodyHTML = ""
+
+	return message
+}
+
+// RedactMessages returns the messages with the body redactions applied (see RedactMessage).
+func RedactMessages(messages []core.Message, projectUUID string, database *pgx.Conn) ([]core.Message, error) {
+	messageUUIDs := make([]string, len(messages))
+
+	for i, message := range messages {
+		messageUUIDs[i] = message.UUID
+	}
+
+	redactionsByMessage, err := GetRedactionsByMessages(messageUUIDs, projectUUID, database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	redactedMessages := make([]core.Message, len(messages))
+
+	for i, message := range messages {
+		redactedMessages[i] = RedactMessage(message, redactionsByMessage[message.UUID])
+	}
+
+	return redactedMessages, nil
+}
+
+// NewRedactedMessage returns the body and the extracted attachment texts of the message with the redactions applied by the redact function
+// (redactMarkedText or redactPDFText).
+func NewRedactedMessage(message core.Message, redact func(text string, redactions []Redaction) string, projectUUID string, database *pgx.Conn) (RedactedMessage, error) {
+	redactions, err := GetRedactionsByMessage(message.UUID, projectUUID, database)
+
+	if err != nil {
+		return RedactedMessage{}, err
+	}
+
+	attachmentTexts, err := GetAttachmentTextsByMessage(message.UUID, projectUUID, database)
+
+	if err != nil {
+		return RedactedMessage{}, err
+	}
+
+	redactedMessage := RedactedMessage{
+		MessageUUID:    message.UUID,
+		Body:           redact(GetMessageBodyText(message), getTargetRedactions(redactions, "")),
+		Attachments:    []RedactedAttachment{},
+		RedactionCount: len(redactions),
+	}
+
+	for _, attachment := range message.Attachments {
+		attachmentText, ok := attachmentTexts[attachment.UUID]
+
+		if !ok {
+			continue
+		}
+
+		redactedMessage.Attachments = append(redactedMessage.Attachments, RedactedAttachment{
+			AttachmentUUID: attachment.UUID,
+			FileName:       attachment.FileName,
+			Text:           redact(attachmentText, getTargetRedactions(redactions, attachment.UUID)),
+		})
+	}
+
+	return redactedMessage, nil
+}
+
+// String returns the redacted body followed by the redacted attachment texts.
+func (redactedMessage *RedactedMessage) String() string {
+	var text strings.Builder
+
+	text.WriteString(redactedMessage.Body)
+
+	for _, redactedAttachment := range redactedMessage.Attachments {
+		text.WriteString(fmt.Sprintf("\n\n--- Attachment: %s ---\n\n", redactedAttachment.FileName))
+		text.WriteString(redactedAttachment.Text)
+	}
+
+	return text.String()
+}
+
+// redactMarkedText applies the redactions to the text, replacing the redacted text with the marker of the reasons.
+func redactMarkedText(text string, redactions []Redaction) string {
+	return RedactText(text, redactions, FormatRedactionMarker)
+}
+
+// redactPDFText applies the redactions to the text laid out by writeRedactedText.
+func redactPDFText(text string, redactions []Redaction) string {
+	return RedactText(pdfRedactionReplacer.Replace(text), redactions, formatPDFRedactionMarker)
+}
+
+// writeRedactedLine writes the line laid out by WrapPDFText, drawing the redaction markers as black boxes.
+func writeRedactedLine(page *PDFPage, x float64, y float64, font string, size float64, line string) {
+	for line != "" {
+		markerStart := strings.Index(line, pdfRedactionStart)
+
+		if markerStart == -1 {
+			page.Text(x, y, font, size, line)
+			return
+		}
+
+		if markerStart > 0 {
+			page.Text(x, y, font, size, line[:markerStart])
+			x += PDFTextWidth(font, size, line[:markerStart])
+		}
+
+		line = line[markerStart+len(pdfRedactionStart):]
+
+		markerEnd := strings.Index(line, pdfRedactionEnd)
+
+		if markerEnd == -1 {
+			markerEnd = len(line)
+		}
+
+		x += page.Redaction(x, y, font, size, strings.ReplaceAll(line[:markerEnd], "\u00a0", " "))
+
+		line = strings.TrimPrefix(line[markerEnd:], pdfRedactionEnd)
+	}
+}
+
+// Save saves the redaction and adds it to the redaction log.
+func (redaction *Redaction) Save(userEmail string, database *pgx.Conn) error {
+	redactionJSON, err := json.Marshal(redaction)
+
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+
+	batch.Queue(`
+		INSERT INTO redactions (uuid, project_uuid, message_uuid, attachment_uuid, type, start_offset, end_offset, pattern, reason, creator_id, creation_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		redaction.UUID, redaction.ProjectUUID, redaction.MessageUUID, redaction.AttachmentUUID, redaction.Type,
+		redaction.Start, redaction.End, redaction.Pattern, redaction.Reason, redaction.CreatorID, redaction.CreationDate,
+	)
+	batch.Queue(`
+		INSERT INTO redaction_log (project_uuid, message_uuid, redaction_uuid, action, user_id, user_email, log_date, redaction)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		redaction.ProjectUUID, redaction.MessageUUID, redaction.UUID, RedactionLogActionCreated, redaction.CreatorID, userEmail, redaction.CreationDate, string(redactionJSON),
+	)
+
+	batchResults := database.SendBatch(context.Background(), batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			_ = batchResults.Close()
+			return err
+		}
+	}
+
+	return batchResults.Close()
+}
+
+// Delete deletes the redaction and adds the deletion to the redaction log.
+func (redaction *Redaction) Delete(userID string, userEmail string, database *pgx.Conn) error {
+	redactionJSON, err := json.Marshal(redaction)
+
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+
+	batch.Queue("DELETE FROM redactions WHERE uuid = $1 AND project_uuid = $2", redaction.UUID, redaction.ProjectUUID)
+	batch.Queue(`
+		INSERT INTO redaction_log (project_uuid, message_uuid, redaction_uuid, action, user_id, user_email, log_date, redaction)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		redaction.ProjectUUID, redaction.MessageUUID, redaction.UUID, RedactionLogActionDeleted, userID, userEmail, int(time.Now().Unix()), string(redactionJSON),
+	)
+
+	batchResults := database.SendBatch(context.Background(), batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			_ = batchResults.Close()
+			return err
+		}
+	}
+
+	return batchResults.Close()
+}
+
+// redactionColumns defines the columns scanned by scanRedaction.
+const redactionColumns = "uuid, project_uuid, message_uuid, attachment_uuid, type, start_offset, end_offset, pattern, reason, creator_id, creation_date"
+
+// scanRedaction scans the redaction from the row.
+func scanRedaction(row pgx.Row) (Redaction, error) {
+	var redaction Redaction
+
+	if err := row.Scan(
+		&redaction.UUID,
+		&redaction.ProjectUUID,
+		&redaction.MessageUUID,
+		&redaction.AttachmentUUID,
+		&redaction.Type,
+		&redaction.Start,
+		&redaction.End,
+		&redaction.Pattern,
+		&redaction.Reason,
+		&redaction.CreatorID,
+		&redaction.CreationDate,
+	); err != nil {
+		return Redaction{}, err
+	}
+
+	return redaction, nil
+}
+
+// GetRedactionByUUID returns the redaction of the project.
+func GetRedactionByUUID(redactionUUID string, projectUUID string, database *pgx.Conn) (Redaction, error) {
+	return scanRedaction(database.QueryRow(context.Background(), "SELECT "+redactionColumns+" FROM redactions WHERE uuid = $1 AND project_uuid = $2", redactionUUID, projectUUID))
+}
+
+// GetRedactionsByMessage returns the redactions of the message, oldest first.
+func GetRedactionsByMessage(messageUUID string, projectUUID string, database *pgx.Conn) ([]Redaction, error) {
+	rows, err := database.Query(context.Background(), "SELECT "+redactionColumns+" FROM redactions WHERE message_uuid = $1 AND project_uuid = $2 ORDER BY creation_date, uuid", messageUUID, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	redactions := []Redaction{}
+
+	for rows.Next() {
+		redaction, err := scanRedaction(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		redactions = append(redactions, redaction)
+	}
+
+	return redactions, rows.Err()
+}
+
+// GetRedactionsByMessages returns the redactions of the messages by message UUID, messages without redactions are left out.
+func GetRedactionsByMessages(messageUUIDs []string, projectUUID string, database *pgx.Conn) (map[string][]Redaction, error) {
+	redactionsByMessage := make(map[string][]Redaction)
+
+	if len(messageUUIDs) == 0 {
+		return redactionsByMessage, nil
+	}
+
+	rows, err := database.Query(context.Background(), "SELECT "+redactionColumns+" FROM redactions WHERE message_uuid = ANY($1) AND project_uuid = $2 ORDER BY creation_date, uuid", messageUUIDs, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		redaction, err := scanRedaction(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		redactionsByMessage[redaction.MessageUUID] = append(redactionsByMessage[redaction.MessageUUID], redaction)
+	}
+
+	return redactionsByMessage, rows.Err()
+}
+
+// GetRedactionLog returns the redaction log of the project, optionally only of the message, oldest first.
+func GetRedactionLog(messageUUID string, projectUUID string, database *pgx.Conn) ([]RedactionLogEntry, error) {
+	rows, err := database.Query(context.Background(), `
+		SELECT id, project_uuid, message_uuid, redaction_uuid, action, user_id, user_email, log_date, redaction
+		FROM redaction_log WHERE project_uuid = $1 AND ($2 = '' OR message_uuid = $2) ORDER BY id`, projectUUID, messageUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	redactionLog := []RedactionLogEntry{}
+
+	for rows.Next() {
+		var redactionLogEntry RedactionLogEntry
+		var redaction string
+
+		if err := rows.Scan(
+			&redactionLogEntry.ID,
+			&redactionLogEntry.ProjectUUID,
+			&redactionLogEntry.MessageUUID,
+			&redactionLogEntry.RedactionUUID,
+			&redactionLogEntry.Action,
+			&redactionLogEntry.UserID,
+			&redactionLogEntry.UserEmail,
+			&redactionLogEntry.Date,
+			&redaction,
+		); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(redaction), &redactionLogEntry.Redaction); err != nil {
+			return nil, err
+		}
+
+		redactionLog = append(redactionLog, redactionLogEntry)
+	}
+
+	return redactionLog, rows.Err()
+}
@@ -4,11 +4,80 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	core "github.com/mooijtech/goforensics-core/pkg"
+	"html/template"
 	"net/http"
+	"os"
 )
 
-// handleReport handle the report endpoint.
+// HTMLReportSection represents a section added by the API to the HTML report created by the core.
+type HTMLReportSection struct {
+	Title string
+	Body  template.HTML
+}
+
+// NewHTMLReportSection creates a section by executing the template with the data.
+func NewHTMLReportSection(title string, bodyTemplate *template.Template, data interface{}) (HTMLReportSection, error) {
+	var body bytes.Buffer
+
+	if err := bodyTemplate.Execute(&body, data); err != nil {
+		return HTMLReportSection{}, err
+	}
+
+	return HTMLReportSection{
+		Title: title,
+		Body:  template.HTML(body.String()),
+	}, nil
+}
+
+// htmlReportSectionsTemplate defines the HTML of the sections added to the report.
+var htmlReportSectionsTemplate = template.Must(template.New("htmlReportSections").Parse(`
+{{range .}}
+<section>
+	<h2>{{.Title}}</h2>
+	{{.Body}}
+</section>
+{{end}}
+`))
+
+// AddHTMLReportSections adds the sections to the end of the body of the HTML report.
+func AddHTMLReportSections(reportPath string, sections []HTMLReportSection) error {
+	if len(sections) == 0 {
+		return nil
+	}
+
+	report, err := os.ReadFile(reportPath)
+
+	if err != nil {
+		return err
+	}
+
+	var sectionsHTML bytes.Buffer
+
+	if err := htmlReportSectionsTemplate.Execute(&sectionsHTML, sections); err != nil {
+		return err
+	}
+
+	bodyEnd := bytes.LastIndex(report, []byte("</body>"))
+
+	if bodyEnd == -1 {
+		bodyEnd = len(report)
+	}
+
+	var outputReport bytes.Buffer
+
+	outputReport.Write(report[:bodyEnd])
+	outputReport.Write(sectionsHTML.Bytes())
+	outputReport.Write(report[bodyEnd:])
+
+	return os.WriteFile(reportPath, outputReport.Bytes(), 0644)
+}
+
+// handleReport handle the report endpoint, the redactions of the bookmarked messages are applied.
+// Accepts an optional "searchTermReportUUIDs" list of the search term reports to add,
+// "includeNotes" to add the notes of the bookmarked messages and "privilegeLog" (a privilege log request) to add the privilege log.
 func (server *Server) handleReport() http.HandlerFunc {
 	return func(responseWriter http.ResponseWriter, request *http.Request) {
 		if request.Method == "POST" {
@@ -20,6 +89,20 @@ func (server *Server) handleReport() http.HandlerFunc {
 				return
 			}
 
+			var requestBody struct {
+				SearchTermReportUUIDs []string             `json:"searchTermReportUUIDs"`
+				IncludeNotes          bool                 `json:"includeNotes"`
+				PrivilegeLog          *PrivilegeLogRequest `json:"privilegeLog"`
+			}
+
+			if request.ContentLength != 0 {
+				if err := json.NewDecoder(request.Body).Decode(&requestBody); err != nil {
+					Logger.Errorf("Failed to decode request body: %s", err)
+					http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+					return
+				}
+			}
+
 			bookmarks, err := core.GetBookmarksByProject(project.UUID, server.Database)
 
 			if err != nil {
@@ -28,7 +111,73 @@ func (server *Server) handleReport() http.HandlerFunc {
 				return
 			}
 
-			outputPath, err := core.CreateHTMLReport(bookmarks, project)
+			var searchTermReports []SearchTermReport
+
+			for _, searchTermReportUUID := range requestBody.SearchTermReportUUIDs {
+				searchTermReport, err := GetSearchTermReportByUUID(searchTermReportUUID, project.UUID, server.Database)
+
+				if err != nil {
+					Logger.Errorf("Failed to get search term report: %s", err)
+					http.Error(responseWriter, "Failed to get search term report.", http.StatusBadRequest)
+					return
+				}
+
+				searchTermReports = append(searchTermReports, searchTermReport)
+			}
+
+			var reportSections []HTMLReportSection
+
+			for _, searchTermReport := range searchTermReports {
+				reportSection, err := searchTermReport.NewHTMLReportSection()
+
+				if err != nil {
+					Logger.Errorf("Failed to create search term report section: %s", err)
+					http.Error(responseWriter, "Failed to create search term report section.", http.StatusInternalServerError)
+					return
+				}
+
+				reportSections = append(reportSections, reportSection)
+			}
+
+			if requestBody.IncludeNotes {
+				reportSection, err := NewNotesHTMLReportSection(bookmarks, project.UUID, server.Database)
+
+				if err != nil {
+					Logger.Errorf("Failed to create notes report section: %s", err)
+					http.Error(responseWriter, "Failed to create notes report section.", http.StatusInternalServerError)
+					return
+				}
+
+				reportSections = append(reportSections, reportSection)
+			}
+
+			if requestBody.PrivilegeLog != nil {
+				privilegeLog, ok := server.newPrivilegeLog(request.Context(), responseWriter, *requestBody.PrivilegeLog, project.UUID)
+
+				if !ok {
+					return
+				}
+
+				reportSection, err := privilegeLog.NewHTMLReportSection()
+
+				if err != nil {
+					Logger.Errorf("Failed to create privilege log report section: %s", err)
+					http.Error(responseWriter, "Failed to create privilege log report section.", http.StatusInternalServerError)
+					return
+				}
+
+				reportSections = append(reportSections, reportSection)
+			}
+
+			redactedBookmarks, err := RedactMessages(bookmarks, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to redact bookmarks: %s", err)
+				http.Error(responseWriter, "Failed to redact bookmarks.", http.StatusInternalServerError)
+				return
+			}
+
+			outputPath, err := core.CreateHTMLReport(redactedBookmarks, project)
 
 			if err != nil {
 				Logger.Errorf("Failed to create HTML report: %s", err)
@@ -36,6 +185,12 @@ func (server *Server) handleReport() http.HandlerFunc {
 				return
 			}
 
+			if err := AddHTMLReportSections(outputPath, reportSections); err != nil {
+				Logger.Errorf("Failed to add HTML report sections: %s", err)
+				http.Error(responseWriter, "Failed to add HTML report sections.", http.StatusInternalServerError)
+				return
+			}
+
 			if _, err := responseWriter.Write([]byte(outputPath)); err != nil {
 				Logger.Errorf("Failed to write response: %s", err)
 				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
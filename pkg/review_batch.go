@@ -0,0 +1,934 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Constants defining the review batch statuses.
+const (
+	ReviewBatchStatusAvailable  = "available"
+	ReviewBatchStatusCheckedOut = "checked_out"
+	ReviewBatchStatusCompleted  = "completed"
+)
+
+// Constants defining the review batch defaults and limits.
+const (
+	DefaultReviewBatchName = "Batch"
+	DefaultReviewBatchSize = 100
+	MaxReviewBatchSize     = 5000
+	// MaxReviewBatchMessages is the maximum amount of messages batched at once.
+	MaxReviewBatchMessages = 100000
+)
+
+// errTooManyReviewBatchMessages stops walking the messages when there are too many to batch.
+var errTooManyReviewBatchMessages = fmt.Errorf("more than %d messages to batch, narrow the query", MaxReviewBatchMessages)
+
+// reviewBatchSourceFields defines the message fields read to group the messages by thread.
+var reviewBatchSourceFields = []string{"uuid", "messageID", "inReplyTo", "references", "conversationIndex"}
+
+// errReviewBatchCheckedOut is returned when checking out a batch checked out by another reviewer.
+var errReviewBatchCheckedOut = errors.New("review batch is checked out by another reviewer")
+
+// ReviewBatch represents a set of messages reviewed by one reviewer.
+// A reviewer checks out the batch, codes the messages and checks the batch in.
+type ReviewBatch struct {
+	UUID        string `json:"uuid"`
+	ProjectUUID string `json:"projectUUID"`
+	Name        string `json:"name"`
+	// Query is the query or tag the batch was created from.
+	Query          string `json:"query"`
+	AssigneeID     string `json:"assigneeID"`
+	Status         string `json:"status"`
+	CheckedOutBy   string `json:"checkedOutBy"`
+	CheckOutDate   int    `json:"checkOutDate"`
+	CheckInDate    int    `json:"checkInDate"`
+	CreatorID      string `json:"creatorID"`
+	CreationDate   int    `json:"creationDate"`
+	MessageCount   int    `json:"messageCount"`
+	ReviewedCount  int    `json:"reviewedCount"`
+	RemainingCount int    `json:"remainingCount"`
+	// Items are the messages of the batch, only returned by the review batch endpoint.
+	Items []ReviewBatchItem `json:"items,omitempty"`
+}
+
+// ReviewBatchItem represents a message of a review batch.
+// A message is reviewed when it is coded by the reviewer who checked out the batch.
+type ReviewBatchItem struct {
+	MessageUUID string `json:"messageUUID"`
+	Position    int    `json:"position"`
+	ReviewerID  string `json:"reviewerID"`
+	ReviewDate  int    `json:"reviewDate"`
+}
+
+// ReviewBatchRequest represents a request to create review batches from a query and/or tag.
+// Families are always kept together: the attachments of a message are part of its document, so they are one batch item.
+type ReviewBatchRequest struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	Tag   string `json:"tag"`
+	// BatchSize is the amount of messages per batch, threads which are kept together may exceed it.
+	BatchSize int `json:"batchSize"`
+	// KeepThreads puts the messages of a thread in the same batch.
+	KeepThreads bool `json:"keepThreads"`
+	// IncludeBatched also batches messages which are already in a review batch.
+	IncludeBatched bool `json:"includeBatched"`
+	// AssigneeIDs are the project members the batches are assigned to in turn, the batches are unassigned if empty.
+	AssigneeIDs []string `json:"assigneeIDs"`
+}
+
+// ReviewProgress represents the progress of the review of a project.
+type ReviewProgress struct {
+	BatchCount          int                `json:"batchCount"`
+	CompletedBatchCount int                `json:"completedBatchCount"`
+	MessageCount        int                `json:"messageCount"`
+	ReviewedCount       int                `json:"reviewedCount"`
+	RemainingCount      int                `json:"remainingCount"`
+	Reviewers           []ReviewerProgress `json:"reviewers"`
+}
+
+// ReviewerProgress represents the review progress of a reviewer.
+type ReviewerProgress struct {
+	ReviewerID        string `json:"reviewerID"`
+	AssignedBatches   int    `json:"assignedBatches"`
+	CheckedOutBatches int    `json:"checkedOutBatches"`
+	CompletedBatches  int    `json:"completedBatches"`
+	// ReviewedCount is the amount of messages reviewed by the reviewer (in any batch).
+	ReviewedCount int `json:"reviewedCount"`
+	// RemainingCount is the amount of messages not yet reviewed in the batches assigned to the reviewer.
+	RemainingCount int `json:"remainingCount"`
+	// ReviewedPerHour is the review rate between the first and last review, counted as at least one hour.
+	ReviewedPerHour  float64 `json:"reviewedPerHour"`
+	FirstReviewDate  int     `json:"firstReviewDate"`
+	LastReviewDate   int     `json:"lastReviewDate"`
+	ReviewedLastHour int     `json:"reviewedLastHour"`
+}
+
+// handleReviewBatches handles the review batches endpoint.
+// GET lists the batches, optionally of an "assignee" (user ID, "me" is the current user), POST creates batches.
+func (server *Server) handleReviewBatches() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		if request.Method == "GET" {
+			assigneeID := request.URL.Query().Get("assignee")
+
+			if assigneeID == "me" {
+				assigneeID = user.Id
+			}
+
+			reviewBatches, err := GetReviewBatchesByProject(project.UUID, assigneeID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get review batches: %s", err)
+				http.Error(responseWriter, "Failed to get review batches.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&reviewBatches); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			var reviewBatchRequest ReviewBatchRequest
+
+			if err := json.NewDecoder(request.Body).Decode(&reviewBatchRequest); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			if err := server.ValidateReviewBatchRequest(&reviewBatchRequest, project.UUID); err != nil {
+				Logger.Errorf("Invalid review batch request: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid review batch request: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			reviewBatches, err := server.NewReviewBatches(request.Context(), reviewBatchRequest, user.Id, project.UUID)
+
+			var queryParseError *QueryParseError
+
+			if errors.As(err, &queryParseError) {
+				Logger.Errorf("Failed to parse review batch query: %s", err)
+				writeQueryParseError(responseWriter, queryParseError)
+				return
+			} else if errors.Is(err, errTooManyReviewBatchMessages) || errors.Is(err, errTooManyQueryMessages) {
+				Logger.Errorf("Invalid review batch request: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid review batch request: %s.", err), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to create review batches: %s", err)
+				http.Error(responseWriter, "Failed to create review batches.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := SaveReviewBatches(reviewBatches, server.Database); err != nil {
+				Logger.Errorf("Failed to save review batches: %s", err)
+				http.Error(responseWriter, "Failed to save review batches.", http.StatusInternalServerError)
+				return
+			}
+
+			// The items are left out, the batches can be large.
+			for i := range reviewBatches {
+				reviewBatches[i].Items = nil
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&reviewBatches); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleReviewBatch handles the review batch endpoint, returning the batch with its items or deleting the batch.
+func (server *Server) handleReviewBatch() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		_, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		reviewBatch, err := GetReviewBatchByUUID(mux.Vars(request)["uuid"], project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to get review batch: %s", err)
+			http.Error(responseWriter, "Failed to get review batch.", http.StatusNotFound)
+			return
+		}
+
+		if request.Method == "GET" {
+			reviewBatch.Items, err = GetReviewBatchItems(reviewBatch.UUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get review batch items: %s", err)
+				http.Error(responseWriter, "Failed to get review batch items.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&reviewBatch); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "DELETE" {
+			if reviewBatch.Status == ReviewBatchStatusCheckedOut {
+				Logger.Errorf("Review batch is checked out.")
+				http.Error(responseWriter, "The review batch is checked out.", http.StatusConflict)
+				return
+			}
+
+			if err := DeleteReviewBatch(reviewBatch.UUID, project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to delete review batch: %s", err)
+				http.Error(responseWriter, "Failed to delete review batch.", http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleReviewBatchAction handles the review batch actions: "checkOut", "checkIn", "release" and "assign".
+// A batch can only be checked out by its assignee (or anyone if unassigned) and checked in by the same reviewer.
+// The creator of the batch can release it, checking it in for a reviewer who is no longer available.
+// The assign action accepts an "assigneeID", empty to unassign.
+func (server *Server) handleReviewBatchAction() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			user, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			reviewBatch, err := GetReviewBatchByUUID(mux.Vars(request)["uuid"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get review batch: %s", err)
+				http.Error(responseWriter, "Failed to get review batch.", http.StatusNotFound)
+				return
+			}
+
+			switch mux.Vars(request)["action"] {
+			case "checkOut":
+				if reviewBatch.AssigneeID != "" && reviewBatch.AssigneeID != user.Id {
+					Logger.Errorf("Review batch is assigned to another reviewer.")
+					http.Error(responseWriter, "The review batch is assigned to another reviewer.", http.StatusForbidden)
+					return
+				}
+
+				err = CheckOutReviewBatch(reviewBatch.UUID, user.Id, project.UUID, server.Database)
+			case "checkIn":
+				if reviewBatch.Status != ReviewBatchStatusCheckedOut || reviewBatch.CheckedOutBy != user.Id {
+					Logger.Errorf("Review batch is not checked out by this user.")
+					http.Error(responseWriter, "The review batch is not checked out by you.", http.StatusConflict)
+					return
+				}
+
+				err = CheckInReviewBatch(reviewBatch.UUID, project.UUID, server.Database)
+			case "release":
+				if reviewBatch.CreatorID != user.Id {
+					Logger.Errorf("User is not the creator of the review batch.")
+					http.Error(responseWriter, "Only the creator can release a review batch.", http.StatusForbidden)
+					return
+				}
+
+				if reviewBatch.Status != ReviewBatchStatusCheckedOut {
+					Logger.Errorf("Review batch is not checked out.")
+					http.Error(responseWriter, "The review batch is not checked out.", http.StatusConflict)
+					return
+				}
+
+				Logger.Infof("Releasing review batch %s checked out by %s", reviewBatch.UUID, reviewBatch.CheckedOutBy)
+
+				err = CheckInReviewBatch(reviewBatch.UUID, project.UUID, server.Database)
+			case "assign":
+				var requestBody struct {
+					AssigneeID string `json:"assigneeID"`
+				}
+
+				if err := json.NewDecoder(request.Body).Decode(&requestBody); err != nil {
+					Logger.Errorf("Failed to decode request body: %s", err)
+					http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+					return
+				}
+
+				if requestBody.AssigneeID != "" && !core.ProjectHasUser(project.UUID, requestBody.AssigneeID, server.Database) {
+					Logger.Errorf("Assignee is not a member of the project.")
+					http.Error(responseWriter, "The assignee is not a member of the project.", http.StatusBadRequest)
+					return
+				}
+
+				if reviewBatch.Status == ReviewBatchStatusCheckedOut && reviewBatch.CheckedOutBy != requestBody.AssigneeID {
+					Logger.Errorf("Review batch is checked out.")
+					http.Error(responseWriter, "The review batch is checked out.", http.StatusConflict)
+					return
+				}
+
+				err = AssignReviewBatch(reviewBatch.UUID, requestBody.AssigneeID, project.UUID, server.Database)
+			default:
+				Logger.Errorf("Unknown review batch action: %s", mux.Vars(request)["action"])
+				http.Error(responseWriter, "Unknown review batch action.", http.StatusNotFound)
+				return
+			}
+
+			if errors.Is(err, errReviewBatchCheckedOut) {
+				Logger.Errorf("Review batch is checked out.")
+				http.Error(responseWriter, "The review batch is checked out by another reviewer.", http.StatusConflict)
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to update review batch: %s", err)
+				http.Error(responseWriter, "Failed to update review batch.", http.StatusInternalServerError)
+				return
+			}
+
+			reviewBatch, err = GetReviewBatchByUUID(reviewBatch.UUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get review batch: %s", err)
+				http.Error(responseWriter, "Failed to get review batch.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&reviewBatch); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleReviewProgress handles the review progress endpoint, the dashboard of the review batches.
+func (server *Server) handleReviewProgress() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			reviewProgress, err := GetReviewProgress(project.UUID, time.Now(), server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get review progress: %s", err)
+				http.Error(responseWriter, "Failed to get review progress.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&reviewProgress); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// ValidateReviewBatchRequest validates the review batch request and sets the defaults.
+func (server *Server) ValidateReviewBatchRequest(reviewBatchRequest *ReviewBatchRequest, projectUUID string) error {
+	reviewBatchRequest.Name = strings.TrimSpace(reviewBatchRequest.Name)
+	reviewBatchRequest.Query = strings.TrimSpace(reviewBatchRequest.Query)
+
+	if reviewBatchRequest.Name == "" {
+		reviewBatchRequest.Name = DefaultReviewBatchName
+	}
+
+	if reviewBatchRequest.Query == "" && reviewBatchRequest.Tag == "" {
+		return errors.New("expected a query or tag")
+	}
+
+	if reviewBatchRequest.BatchSize == 0 {
+		reviewBatchRequest.BatchSize = DefaultReviewBatchSize
+	}
+
+	if reviewBatchRequest.BatchSize < 1 || reviewBatchRequest.BatchSize > MaxReviewBatchSize {
+		return fmt.Errorf("the batchSize must be between 1 and %d", MaxReviewBatchSize)
+	}
+
+	for _, assigneeID := range reviewBatchRequest.AssigneeIDs {
+		if !core.ProjectHasUser(projectUUID, assigneeID, server.Database) {
+			return fmt.Errorf("the assignee %s is not a member of the project", assigneeID)
+		}
+	}
+
+	return nil
+}
+
+// NewReviewBatches creates the review batches of the messages matching the query and tag, ordered by date.
+func (server *Server) NewReviewBatches(ctx context.Context, reviewBatchRequest ReviewBatchRequest, creatorID string, projectUUID string) ([]ReviewBatch, error) {
+	queryResolver := NewDatabaseQueryResolver(projectUUID, server.Database)
+
+	var queries []interface{}
+	var descriptions []string
+
+	if reviewBatchRequest.Query != "" {
+		queryNode, err := ParseQuery(reviewBatchRequest.Query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		elasticsearchQuery, err := CompileQuery(queryNode, queryResolver)
+
+		if err != nil {
+			return nil, err
+		}
+
+		queries = append(queries, elasticsearchQuery)
+		descriptions = append(descriptions, reviewBatchRequest.Query)
+	}
+
+	if reviewBatchRequest.Tag != "" {
+		tagMessageUUIDs, err := queryResolver.GetTagMessageUUIDs(reviewBatchRequest.Tag)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tagQuery, err := newMessageUUIDsQuery(tagMessageUUIDs)
+
+		if err != nil {
+			return nil, fmt.Errorf("tag \"%s\": %w", reviewBatchRequest.Tag, err)
+		}
+
+		queries = append(queries, tagQuery)
+		descriptions = append(descriptions, fmt.Sprintf("tag:\"%s\"", reviewBatchRequest.Tag))
+	}
+
+	isBatched := make(map[string]bool)
+
+	if !reviewBatchRequest.IncludeBatched {
+		batchedMessageUUIDs, err := queryResolver.queryStrings("SELECT message_uuid FROM review_batch_items WHERE project_uuid = $1", projectUUID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, batchedMessageUUID := range batchedMessageUUIDs {
+			isBatched[batchedMessageUUID] = true
+		}
+	}
+
+	var messages []core.Message
+
+	elasticsearchQuery := NewProjectQuery(map[string]interface{}{"bool": map[string]interface{}{"must": queries}}, projectUUID)
+
+	// Only the thread fields are read, there can be many messages.
+	err := WalkMessageFields(ctx, elasticsearchQuery, reviewBatchSourceFields, func(message core.Message) error {
+		if isBatched[message.UUID] {
+			return nil
+		}
+
+		if len(messages) == MaxReviewBatchMessages {
+			return errTooManyReviewBatchMessages
+		}
+
+		messages = append(messages, message)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var messageGroups [][]string
+
+	if reviewBatchRequest.KeepThreads {
+		messageGroups = GroupThreadMessages(messages)
+	} else {
+		for _, message := range messages {
+			messageGroups = append(messageGroups, []string{message.UUID})
+		}
+	}
+
+	var reviewBatches []ReviewBatch
+
+	creationDate := int(time.Now().Unix())
+
+	for _, messageGroup := range messageGroups {
+		// A new batch is started when the group doesn't fit, groups larger than the batch size get their own batch.
+		if len(reviewBatches) == 0 || (len(reviewBatches[len(reviewBatches)-1].Items) > 0 && len(reviewBatches[len(reviewBatches)-1].Items)+len(messageGroup) > reviewBatchRequest.BatchSize) {
+			reviewBatch := ReviewBatch{
+				UUID:         core.NewUUID(),
+				ProjectUUID:  projectUUID,
+				Name:         fmt.Sprintf("%s %03d", reviewBatchRequest.Name, len(reviewBatches)+1),
+				Query:        strings.Join(descriptions, " AND "),
+				Status:       ReviewBatchStatusAvailable,
+				CreatorID:    creatorID,
+				CreationDate: creationDate,
+			}
+
+			if len(reviewBatchRequest.AssigneeIDs) > 0 {
+				reviewBatch.AssigneeID = reviewBatchRequest.AssigneeIDs[len(reviewBatches)%len(reviewBatchRequest.AssigneeIDs)]
+			}
+
+			reviewBatches = append(reviewBatches, reviewBatch)
+		}
+
+		reviewBatch := &reviewBatches[len(reviewBatches)-1]
+
+		for _, messageUUID := range messageGroup {
+			reviewBatch.Items = append(reviewBatch.Items, ReviewBatchItem{MessageUUID: messageUUID, Position: len(reviewBatch.Items)})
+		}
+
+		reviewBatch.MessageCount = len(reviewBatch.Items)
+		reviewBatch.RemainingCount = len(reviewBatch.Items)
+	}
+
+	return reviewBatches, nil
+}
+
+// GroupThreadMessages groups the messages of the same thread, linked by Message-ID, In-Reply-To and References
+// or the Outlook conversation index. The groups and their messages keep the order of the messages.
+func GroupThreadMessages(messages []core.Message) [][]string {
+	parents := make(map[string]string)
+
+	var find func(key string) string
+
+	find = func(key string) string {
+		parent, ok := parents[key]
+
+		if !ok || parent == key {
+			parents[key] = key
+			return key
+		}
+
+		root := find(parent)
+		parents[key] = root
+
+		return root
+	}
+
+	union := func(key string, otherKey string) {
+		root := find(key)
+		otherRoot := find(otherKey)
+
+		if root != otherRoot {
+			parents[otherRoot] = root
+		}
+	}
+
+	for _, message := range messages {
+		messageKey := "uuid:" + message.UUID
+
+		find(messageKey)
+
+		if messageID := getMessageID(message); messageID != "" {
+			union(messageKey, "id:"+messageID)
+		}
+
+		for _, referenceID := range getMessageReferenceIDs(message) {
+			union(messageKey, "id:"+referenceID)
+		}
+
+		if conversationKey := getConversationKey(DecodeConversationIndex(message.ConversationIndex)); conversationKey != "" {
+			union(messageKey, "conversation:"+conversationKey)
+		}
+	}
+
+	var messageGroups [][]string
+
+	groupIndexes := make(map[string]int)
+
+	for _, message := range messages {
+		root := find("uuid:" + message.UUID)
+
+		groupIndex, ok := groupIndexes[root]
+
+		if !ok {
+			groupIndex = len(messageGroups)
+			groupIndexes[root] = groupIndex
+			messageGroups = append(messageGroups, nil)
+		}
+
+		messageGroups[groupIndex] = append(messageGroups[groupIndex], message.UUID)
+	}
+
+	return messageGroups
+}
+
+// SaveReviewBatches saves the new review batches and their items to the database.
+func SaveReviewBatches(reviewBatches []ReviewBatch, database *pgx.Conn) error {
+	batch := &pgx.Batch{}
+
+	for _, reviewBatch := range reviewBatches {
+		batch.Queue(`
+			INSERT INTO review_batches (uuid, project_uuid, name, query, assignee_id, status, checked_out_by, check_out_date, check_in_date, creator_id, creation_date)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			reviewBatch.UUID, reviewBatch.ProjectUUID, reviewBatch.Name, reviewBatch.Query, reviewBatch.AssigneeID, reviewBatch.Status,
+			reviewBatch.CheckedOutBy, reviewBatch.CheckOutDate, reviewBatch.CheckInDate, reviewBatch.CreatorID, reviewBatch.CreationDate,
+		)
+
+		for _, reviewBatchItem := range reviewBatch.Items {
+			batch.Queue(`
+				INSERT INTO review_batch_items (batch_uuid, project_uuid, message_uuid, position, reviewer_id, review_date)
+				VALUES ($1, $2, $3, $4, $5, $6)`,
+				reviewBatch.UUID, reviewBatch.ProjectUUID, reviewBatchItem.MessageUUID, reviewBatchItem.Position, reviewBatchItem.ReviewerID, reviewBatchItem.ReviewDate,
+			)
+		}
+	}
+
+	batchResults := database.SendBatch(context.Background(), batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			_ = batchResults.Close()
+			return err
+		}
+	}
+
+	return batchResults.Close()
+}
+
+// reviewBatchQuery selects the review batches with their message and reviewed counts.
+const reviewBatchQuery = `
+	SELECT review_batches.uuid, review_batches.project_uuid, review_batches.name, review_batches.query, review_batches.assignee_id,
+		review_batches.status, review_batches.checked_out_by, review_batches.check_out_date, review_batches.check_in_date,
+		review_batches.creator_id, review_batches.creation_date,
+		COUNT(review_batch_items.message_uuid), COUNT(review_batch_items.message_uuid) FILTER (WHERE review_batch_items.review_date > 0)
+	FROM review_batches
+	LEFT JOIN review_batch_items ON review_batch_items.batch_uuid = review_batches.uuid`
+
+// scanReviewBatch scans the review batch from the row.
+func scanReviewBatch(row pgx.Row) (ReviewBatch, error) {
+	var reviewBatch ReviewBatch
+
+	if err := row.Scan(
+		&reviewBatch.UUID,
+		&reviewBatch.ProjectUUID,
+		&reviewBatch.Name,
+		&reviewBatch.Query,
+		&reviewBatch.AssigneeID,
+		&reviewBatch.Status,
+		&reviewBatch.CheckedOutBy,
+		&reviewBatch.CheckOutDate,
+		&reviewBatch.CheckInDate,
+		&reviewBatch.CreatorID,
+		&reviewBatch.CreationDate,
+		&reviewBatch.MessageCount,
+		&reviewBatch.ReviewedCount,
+	); err != nil {
+		return ReviewBatch{}, err
+	}
+
+	reviewBatch.RemainingCount = reviewBatch.MessageCount - reviewBatch.ReviewedCount
+
+	return reviewBatch, nil
+}
+
+// GetReviewBatchByUUID returns the review batch of the project, without the items.
+func GetReviewBatchByUUID(reviewBatchUUID string, projectUUID string, database *pgx.Conn) (ReviewBatch, error) {
+	return scanReviewBatch(database.QueryRow(context.Background(), reviewBatchQuery+`
+		WHERE review_batches.uuid = $1 AND review_batches.project_uuid = $2
+		GROUP BY review_batches.uuid`, reviewBatchUUID, projectUUID))
+}
+
+// GetReviewBatchesByProject returns the review batches of the project, optionally only those of the assignee.
+func GetReviewBatchesByProject(projectUUID string, assigneeID string, database *pgx.Conn) ([]ReviewBatch, error) {
+	rows, err := database.Query(context.Background(), reviewBatchQuery+`
+		WHERE review_batches.project_uuid = $1 AND ($2 = '' OR review_batches.assignee_id = $2)
+		GROUP BY review_batches.uuid
+		ORDER BY review_batches.creation_date, review_batches.name`, projectUUID, assigneeID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reviewBatches := []ReviewBatch{}
+
+	for rows.Next() {
+		reviewBatch, err := scanReviewBatch(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reviewBatches = append(reviewBatches, reviewBatch)
+	}
+
+	return reviewBatches, rows.Err()
+}
+
+// GetReviewBatchItems returns the items of the review batch in order.
+func GetReviewBatchItems(reviewBatchUUID string, projectUUID string, database *pgx.Conn) ([]ReviewBatchItem, error) {
+	rows, err := database.Query(context.Background(), "SELECT message_uuid, position, reviewer_id, review_date FROM review_batch_items WHERE batch_uuid = $1 AND project_uuid = $2 ORDER BY position", reviewBatchUUID, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reviewBatchItems := []ReviewBatchItem{}
+
+	for rows.Next() {
+		var reviewBatchItem ReviewBatchItem
+
+		if err := rows.Scan(&reviewBatchItem.MessageUUID, &reviewBatchItem.Position, &reviewBatchItem.ReviewerID, &reviewBatchItem.ReviewDate); err != nil {
+			return nil, err
+		}
+
+		reviewBatchItems = append(reviewBatchItems, reviewBatchItem)
+	}
+
+	return reviewBatchItems, rows.Err()
+}
+
+// CheckOutReviewBatch checks out the review batch to the reviewer, assigning unassigned batches to the reviewer.
+// Returns errReviewBatchCheckedOut if another reviewer checked out the batch.
+func CheckOutReviewBatch(reviewBatchUUID string, reviewerID string, projectUUID string, database *pgx.Conn) error {
+	// The condition is checked by the update so two reviewers can't check out the same batch.
+	commandTag, err := database.Exec(context.Background(), `
+		UPDATE review_batches SET status = $1, checked_out_by = $2, check_out_date = $3, assignee_id = $2
+		WHERE uuid = $4 AND project_uuid = $5 AND (status != $1 OR checked_out_by = $2) AND (assignee_id = '' OR assignee_id = $2)`,
+		ReviewBatchStatusCheckedOut, reviewerID, int(time.Now().Unix()), reviewBatchUUID, projectUUID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		return errReviewBatchCheckedOut
+	}
+
+	return nil
+}
+
+// CheckInReviewBatch checks in the review batch, the batch is completed when all messages are reviewed.
+func CheckInReviewBatch(reviewBatchUUID string, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), `
+		UPDATE review_batches SET checked_out_by = '', check_in_date = $1,
+			status = CASE WHEN EXISTS (SELECT 1 FROM review_batch_items WHERE batch_uuid = $2 AND review_date = 0) THEN $3 ELSE $4 END
+		WHERE uuid = $2 AND project_uuid = $5`,
+		int(time.Now().Unix()), reviewBatchUUID, ReviewBatchStatusAvailable, ReviewBatchStatusCompleted, projectUUID,
+	)
+
+	return err
+}
+
+// AssignReviewBatch assigns the review batch to the reviewer, an empty assignee unassigns the batch.
+func AssignReviewBatch(reviewBatchUUID string, assigneeID string, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), "UPDATE review_batches SET assignee_id = $1 WHERE uuid = $2 AND project_uuid = $3", assigneeID, reviewBatchUUID, projectUUID)
+
+	return err
+}
+
+// DeleteReviewBatch deletes the review batch and its items, the codings are kept.
+func DeleteReviewBatch(reviewBatchUUID string, projectUUID string, database *pgx.Conn) error {
+	batch := &pgx.Batch{}
+
+	batch.Queue("DELETE FROM review_batch_items WHERE batch_uuid = $1 AND project_uuid = $2", reviewBatchUUID, projectUUID)
+	batch.Queue("DELETE FROM review_batches WHERE uuid = $1 AND project_uuid = $2", reviewBatchUUID, projectUUID)
+
+	batchResults := database.SendBatch(context.Background(), batch)
+
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			_ = batchResults.Close()
+			return err
+		}
+	}
+
+	return batchResults.Close()
+}
+
+// MarkReviewBatchItemsReviewed marks the messages as reviewed in the batches checked out by the reviewer.
+// Called when the reviewer codes messages, messages which are already reviewed keep their first review.
+func MarkReviewBatchItemsReviewed(messageUUIDs []string, reviewerID string, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), `
+		UPDATE review_batch_items SET reviewer_id = $1, review_date = $2
+		FROM review_batches
+		WHERE review_batch_items.batch_uuid = review_batches.uuid
+		AND review_batches.status = $3 AND review_batches.checked_out_by = $1
+		AND review_batch_items.project_uuid = $4 AND review_batch_items.message_uuid = ANY($5) AND review_batch_items.review_date = 0`,
+		reviewerID, int(time.Now().Unix()), ReviewBatchStatusCheckedOut, projectUUID, messageUUIDs,
+	)
+
+	return err
+}
+
+// GetReviewProgress returns the review progress of the project and its reviewers at the time.
+func GetReviewProgress(projectUUID string, now time.Time, database *pgx.Conn) (ReviewProgress, error) {
+	reviewProgress := ReviewProgress{
+		Reviewers: []ReviewerProgress{},
+	}
+
+	reviewBatches, err := GetReviewBatchesByProject(projectUUID, "", database)
+
+	if err != nil {
+		return ReviewProgress{}, err
+	}
+
+	reviewersByID := make(map[string]*ReviewerProgress)
+	var reviewerIDs []string
+
+	getReviewer := func(reviewerID string) *ReviewerProgress {
+		reviewerProgress, ok := reviewersByID[reviewerID]
+
+		if !ok {
+			reviewerProgress = &ReviewerProgress{ReviewerID: reviewerID}
+			reviewersByID[reviewerID] = reviewerProgress
+			reviewerIDs = append(reviewerIDs, reviewerID)
+		}
+
+		return reviewerProgress
+	}
+
+	for _, reviewBatch := range reviewBatches {
+		reviewProgress.BatchCount++
+		reviewProgress.MessageCount += reviewBatch.MessageCount
+		reviewProgress.ReviewedCount += reviewBatch.ReviewedCount
+		reviewProgress.RemainingCount += reviewBatch.RemainingCount
+
+		if reviewBatch.Status == ReviewBatchStatusCompleted {
+			reviewProgress.CompletedBatchCount++
+		}
+
+		if reviewBatch.AssigneeID == "" {
+			continue
+		}
+
+		reviewerProgress := getReviewer(reviewBatch.AssigneeID)
+
+		reviewerProgress.AssignedBatches++
+		reviewerProgress.RemainingCount += reviewBatch.RemainingCount
+
+		switch reviewBatch.Status {
+		case ReviewBatchStatusCheckedOut:
+			reviewerProgress.CheckedOutBatches++
+		case ReviewBatchStatusCompleted:
+			reviewerProgress.CompletedBatches++
+		}
+	}
+
+	rows, err := database.Query(context.Background(), `
+		SELECT reviewer_id, COUNT(*), MIN(review_date), MAX(review_date), COUNT(*) FILTER (WHERE review_date > $2)
+		FROM review_batch_items
+		WHERE project_uuid = $1 AND review_date > 0
+		GROUP BY reviewer_id`, projectUUID, int(now.Add(-time.Hour).Unix()))
+
+	if err != nil {
+		return ReviewProgress{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var reviewerID string
+		var reviewedCount int
+		var firstReviewDate int
+		var lastReviewDate int
+		var reviewedLastHour int
+
+		if err := rows.Scan(&reviewerID, &reviewedCount, &firstReviewDate, &lastReviewDate, &reviewedLastHour); err != nil {
+			return ReviewProgress{}, err
+		}
+
+		reviewerProgress := getReviewer(reviewerID)
+
+		reviewerProgress.ReviewedCount = reviewedCount
+		reviewerProgress.FirstReviewDate = firstReviewDate
+		reviewerProgress.LastReviewDate = lastReviewDate
+		reviewerProgress.ReviewedLastHour = reviewedLastHour
+		reviewerProgress.ReviewedPerHour = GetReviewRate(reviewedCount, firstReviewDate, lastReviewDate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return ReviewProgress{}, err
+	}
+
+	for _, reviewerID := range reviewerIDs {
+		reviewProgress.Reviewers = append(reviewProgress.Reviewers, *reviewersByID[reviewerID])
+	}
+
+	return reviewProgress, nil
+}
+
+// GetReviewRate returns the reviewed messages per hour between the first and last review (Unix seconds).
+// The period is at least an hour so a few quick reviews don't give an inflated rate.
+func GetReviewRate(reviewedCount int, firstReviewDate int, lastReviewDate int) float64 {
+	hours := float64(lastReviewDate-firstReviewDate) / 3600
+
+	if hours < 1 {
+		hours = 1
+	}
+
+	return float64(reviewedCount) / hours
+}
@@ -33,8 +33,53 @@ func (server *Server) Start() {
 	server.Router.Handle("/projects", server.handleProjects())
 	server.Router.Handle("/setProject", server.handleSetProject())
 	server.Router.Handle("/evidence", server.handleEvidence())
+	server.Router.Handle("/evidence/{evidenceUUID}/custodian", server.handleEvidenceCustodian())
 	server.Router.Handle("/tree", server.handleTree())
+	server.Router.Handle("/tree/{nodeUUID}/children", server.handleTreeNodeChildren())
 	server.Router.Handle("/search/{searchType}", server.handleSearch())
+	server.Router.Handle("/message/{messageUUID}/keywordInContext", server.handleKeywordInContext())
+	server.Router.Handle("/message/{messageUUID}/exhibit", server.handleMessageExhibit())
+	server.Router.Handle("/exhibits", server.handleExhibits())
+	server.Router.Handle("/message/{messageUUID}/thread", server.handleThread())
+	server.Router.Handle("/message/{messageUUID}/similar", server.handleSimilarMessages())
+	server.Router.Handle("/message/{messageUUID}/source", server.handleMessageSource())
+	server.Router.Handle("/message/{messageUUID}/mime", server.handleMessageMIME())
+	server.Router.Handle("/message/{messageUUID}/mime/{partPath}", server.handleMessageMIMEPart())
+	server.Router.Handle("/message/{messageUUID}/headerAnalysis", server.handleHeaderAnalysis())
+	server.Router.Handle("/message/{messageUUID}/dkim", server.handleMessageDKIM())
+	server.Router.Handle("/message/{messageUUID}/html", server.handleMessageHTML())
+	server.Router.Handle("/message/{messageUUID}/notes", server.handleMessageNotes())
+	server.Router.Handle("/notes", server.handleNotes())
+	server.Router.Handle("/notes/{uuid}", server.handleNote())
+	server.Router.Handle("/message/{messageUUID}/coding", server.handleMessageCoding())
+	server.Router.Handle("/message/{messageUUID}/codingHistory", server.handleMessageCodingHistory())
+	server.Router.Handle("/coding", server.handleCoding())
+	server.Router.Handle("/codingSchemas", server.handleCodingSchemas())
+	server.Router.Handle("/codingSchemas/{version}", server.handleCodingSchema())
+	server.Router.Handle("/reviewBatches", server.handleReviewBatches())
+	server.Router.Handle("/reviewBatches/{uuid}", server.handleReviewBatch())
+	server.Router.Handle("/reviewBatches/{uuid}/{action}", server.handleReviewBatchAction())
+	server.Router.Handle("/reviewProgress", server.handleReviewProgress())
+	server.Router.Handle("/privilegeLog", server.handlePrivilegeLog())
+	server.Router.Handle("/message/{messageUUID}/privilegeLog", server.handleMessagePrivilegeLog())
+	server.Router.Handle("/message/{messageUUID}/redactions", server.handleMessageRedactions())
+	server.Router.Handle("/message/{messageUUID}/redacted", server.handleRedactedMessage())
+	server.Router.Handle("/redactions/{uuid}", server.handleRedaction())
+	server.Router.Handle("/redactionLog", server.handleRedactionLog())
+	server.Router.Handle("/message/{messageUUID}/inline/{attachmentUUID}", server.handleMessageInlineAttachment())
+	server.Router.Handle("/remoteContent", server.handleRemoteContent())
+	server.Router.Handle("/dkim/verify", server.handleVerifyDKIM())
+	server.Router.Handle("/dkimKeys", server.handleDKIMKeys())
+	server.Router.Handle("/dkimKeys/{uuid}", server.handleDKIMKey())
+	server.Router.Handle("/savedSearches", server.handleSavedSearches())
+	server.Router.Handle("/savedSearches/{uuid}", server.handleSavedSearch())
+	server.Router.Handle("/savedSearches/{uuid}/run", server.handleRunSavedSearch())
+	server.Router.Handle("/savedSearchNotifications", server.handleSavedSearchNotifications())
+	server.Router.Handle("/searchTermReports", server.handleSearchTermReports())
+	server.Router.Handle("/searchTermReports/{uuid}", server.handleSearchTermReport())
+	server.Router.Handle("/attachmentTexts", server.handleAttachmentTexts())
+	server.Router.Handle("/attachment/{attachmentUUID}/text", server.handleAttachmentText())
+	server.Router.Handle("/entities", server.handleEntities())
 	server.Router.Handle("/bookmarks", server.handleBookmarks())
 	server.Router.Handle("/bookmark/{uuid}", server.handleBookmark())
 	server.Router.Handle("/tags", server.handleTags())
@@ -42,8 +87,16 @@ func (server *Server) Start() {
 	server.Router.Handle("/export", server.handleExport())
 	server.Router.Handle("/file/{fileName}", server.handleFile())
 	server.Router.Handle("/network", server.handleNetwork())
+	server.Router.Handle("/timeline", server.handleTimeline())
 	server.Router.HandleFunc("/loading", server.handleLoading())
 
+	// Evidence indexed before the recipients were indexed is updated in the background.
+	go func() {
+		if err := IndexMissingRecipients(); err != nil {
+			Logger.Errorf("Failed to index missing recipients: %s", err)
+		}
+	}()
+
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   AllowedOrigins,
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodDelete},
@@ -0,0 +1,599 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"github.com/r3labs/sse/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxSavedSearchNotificationHits defines the maximum amount of new hits listed in a saved search notification.
+const MaxSavedSearchNotificationHits = 100
+
+// SavedSearchNewHitsEvent defines the ServerSentEvents event type of saved search notifications.
+const SavedSearchNewHitsEvent = "newHits"
+
+// SavedSearch represents a named search query of a project.
+type SavedSearch struct {
+	UUID         string        `json:"uuid"`
+	ProjectUUID  string        `json:"projectUUID"`
+	UserID       string        `json:"userID"`
+	Name         string        `json:"name"`
+	Query        string        `json:"query"`
+	Filters      []FacetFilter `json:"filters"`
+	Sort         string        `json:"sort"`
+	Order        string        `json:"order"`
+	IsShared     bool          `json:"isShared"`
+	CreationDate int           `json:"creationDate"`
+	LastRunDate  int           `json:"lastRunDate"`
+	HitCount     int           `json:"hitCount"`
+}
+
+// SavedSearchHit represents a new hit of a saved search.
+type SavedSearchHit struct {
+	UUID    string `json:"uuid"`
+	Subject string `json:"subject"`
+	From    string `json:"from"`
+	Date    int    `json:"date"`
+}
+
+// SavedSearchNotification represents the notification sent when new evidence has hits for a saved search.
+type SavedSearchNotification struct {
+	SavedSearchUUID string           `json:"savedSearchUUID"`
+	Name            string           `json:"name"`
+	UserID          string           `json:"userID"`
+	EvidenceUUID    string           `json:"evidenceUUID"`
+	NewHitCount     int              `json:"newHitCount"`
+	NewHits         []SavedSearchHit `json:"newHits"`
+}
+
+// savedSearchStreamIDSuffix is added to the ServerSentEvents stream IDs of saved search notifications.
+const savedSearchStreamIDSuffix = "-saved-searches"
+
+// GetSavedSearchStreamID returns the ServerSentEvents stream ID of the saved search notifications of the user.
+// An empty user ID returns the stream of the saved searches shared with the project.
+func GetSavedSearchStreamID(projectUUID string, userID string) string {
+	if userID == "" {
+		return projectUUID + savedSearchStreamIDSuffix
+	}
+
+	return fmt.Sprintf("%s-%s%s", projectUUID, userID, savedSearchStreamIDSuffix)
+}
+
+// IsSavedSearchStreamID returns true if the ServerSentEvents stream contains saved search notifications.
+func IsSavedSearchStreamID(streamID string) bool {
+	return strings.HasSuffix(streamID, savedSearchStreamIDSuffix)
+}
+
+// handleSavedSearchNotifications handles the saved search notifications endpoint (ServerSentEvents).
+// Streams the notifications of the user's own saved searches (shared or not), or with shared=true of the shared saved searches.
+func (server *Server) handleSavedSearchNotifications() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			user, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			streamID := GetSavedSearchStreamID(project.UUID, user.Id)
+
+			if request.URL.Query().Get("shared") == "true" {
+				streamID = GetSavedSearchStreamID(project.UUID, "")
+			}
+
+			server.ServerSentEvents.CreateStream(streamID)
+
+			// The stream is chosen by the server, never by the client.
+			query := request.URL.Query()
+
+			query.Set("stream", streamID)
+			request.URL.RawQuery = query.Encode()
+
+			server.ServerSentEvents.ServeHTTP(responseWriter, request)
+		}
+	}
+}
+
+// handleSavedSearches handles the saved searches endpoint.
+func (server *Server) handleSavedSearches() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		if request.Method == "GET" {
+			// Get the saved searches of the user and the saved searches shared with the project.
+			savedSearches, err := GetSavedSearchesByProject(project.UUID, user.Id, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get saved searches by project: %s", err)
+				http.Error(responseWriter, "Failed to get saved searches by project.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&savedSearches); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			// Create a saved search.
+			var savedSearch SavedSearch
+
+			if err := json.NewDecoder(request.Body).Decode(&savedSearch); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			savedSearch.UUID = core.NewUUID()
+			savedSearch.ProjectUUID = project.UUID
+			savedSearch.UserID = user.Id
+			savedSearch.CreationDate = int(time.Now().Unix())
+			savedSearch.LastRunDate = 0
+			savedSearch.HitCount = 0
+
+			if err := savedSearch.Validate(); err != nil {
+				Logger.Errorf("Invalid saved search: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid saved search: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			if err := savedSearch.Save(server.Database); err != nil {
+				Logger.Errorf("Failed to save saved search: %s", err)
+				http.Error(responseWriter, "Failed to save saved search.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&savedSearch); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleSavedSearch handles the saved search endpoint.
+func (server *Server) handleSavedSearch() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		user, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		savedSearch, err := GetSavedSearchByUUID(mux.Vars(request)["uuid"], project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to get saved search: %s", err)
+			http.Error(responseWriter, "Failed to get saved search.", http.StatusNotFound)
+			return
+		}
+
+		if !savedSearch.IsVisibleTo(user.Id) {
+			Logger.Errorf("Saved search is not shared with this user.")
+			http.Error(responseWriter, "Failed to get saved search.", http.StatusNotFound)
+			return
+		}
+
+		if request.Method == "GET" {
+			if err := json.NewEncoder(responseWriter).Encode(&savedSearch); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			// Update the saved search, only the owner may change it.
+			if savedSearch.UserID != user.Id {
+				Logger.Errorf("User is not the owner of the saved search.")
+				http.Error(responseWriter, "Only the owner can change a saved search.", http.StatusForbidden)
+				return
+			}
+
+			var requestSavedSearch SavedSearch
+
+			if err := json.NewDecoder(request.Body).Decode(&requestSavedSearch); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			savedSearch.Name = requestSavedSearch.Name
+			savedSearch.Query = requestSavedSearch.Query
+			savedSearch.Filters = requestSavedSearch.Filters
+			savedSearch.Sort = requestSavedSearch.Sort
+			savedSearch.Order = requestSavedSearch.Order
+			savedSearch.IsShared = requestSavedSearch.IsShared
+
+			if err := savedSearch.Validate(); err != nil {
+				Logger.Errorf("Invalid saved search: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid saved search: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			if err := savedSearch.Save(server.Database); err != nil {
+				Logger.Errorf("Failed to save saved search: %s", err)
+				http.Error(responseWriter, "Failed to save saved search.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&savedSearch); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "DELETE" {
+			if savedSearch.UserID != user.Id {
+				Logger.Errorf("User is not the owner of the saved search.")
+				http.Error(responseWriter, "Only the owner can delete a saved search.", http.StatusForbidden)
+				return
+			}
+
+			if err := DeleteSavedSearch(savedSearch.UUID, project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to delete saved search: %s", err)
+				http.Error(responseWriter, "Failed to delete saved search.", http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleRunSavedSearch handles the run saved search endpoint.
+// Accepts the same pagination and facet parameters as the search endpoint, filters are added to the saved filters.
+func (server *Server) handleRunSavedSearch() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			user, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			savedSearch, err := GetSavedSearchByUUID(mux.Vars(request)["uuid"], project.UUID, server.Database)
+
+			if err != nil || !savedSearch.IsVisibleTo(user.Id) {
+				Logger.Errorf("Failed to get saved search: %v", err)
+				http.Error(responseWriter, "Failed to get saved search.", http.StatusNotFound)
+				return
+			}
+
+			requestBody := make(map[string]interface{})
+
+			if request.ContentLength != 0 {
+				if err := json.NewDecoder(request.Body).Decode(&requestBody); err != nil {
+					Logger.Errorf("Failed to decode request body: %s", err)
+					http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+					return
+				}
+			}
+
+			savedSearch.ApplyToRequestBody(requestBody)
+
+			elasticsearchQuery, err := NewMessageQuery(savedSearch.Query, project.UUID, server.Database)
+
+			var queryParseError *QueryParseError
+
+			if errors.As(err, &queryParseError) {
+				Logger.Errorf("Failed to parse saved search query: %s", err)
+				writeQueryParseError(responseWriter, queryParseError)
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to compile saved search query: %s", err)
+				http.Error(responseWriter, "Failed to compile saved search query.", http.StatusInternalServerError)
+				return
+			}
+
+			searchPage, err := server.SearchMessagesPage(request.Context(), elasticsearchQuery, requestBody, SearchSortRelevance, project.UUID)
+
+			var searchRequestError *SearchRequestError
+
+			if errors.As(err, &searchRequestError) {
+				Logger.Errorf("Invalid search parameters: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid search parameters: %s.", err), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to run saved search: %s", err)
+				http.Error(responseWriter, "Failed to run saved search.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := SetSavedSearchHitCount(savedSearch.UUID, searchPage.TotalHits, int(time.Now().Unix()), project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to set saved search hit count: %s", err)
+				http.Error(responseWriter, "Failed to set saved search hit count.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&searchPage); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// IsVisibleTo returns true if the user owns the saved search or it is shared with the project.
+func (savedSearch *SavedSearch) IsVisibleTo(userID string) bool {
+	return savedSearch.UserID == userID || savedSearch.IsShared
+}
+
+// ApplyToRequestBody adds the saved filters and sort order to the search request body.
+// The saved search always counts the exact amount of hits.
+func (savedSearch *SavedSearch) ApplyToRequestBody(requestBody map[string]interface{}) {
+	var filters []interface{}
+
+	for _, filter := range savedSearch.Filters {
+		filters = append(filters, map[string]interface{}{"facet": filter.Facet, "value": filter.Value})
+	}
+
+	if requestFilters, ok := requestBody["filters"].([]interface{}); ok {
+		filters = append(filters, requestFilters...)
+	}
+
+	if len(filters) > 0 {
+		requestBody["filters"] = filters
+	}
+
+	if savedSearch.Sort != "" {
+		requestBody["sort"] = savedSearch.Sort
+	}
+
+	if savedSearch.Order != "" {
+		requestBody["order"] = savedSearch.Order
+	}
+
+	requestBody["exactTotal"] = true
+}
+
+// Validate returns an error if the name, query, filters or sort order are invalid.
+func (savedSearch *SavedSearch) Validate() error {
+	if savedSearch.Name == "" {
+		return errors.New("missing name")
+	}
+
+	if _, err := ParseQuery(savedSearch.Query); err != nil {
+		return err
+	}
+
+	requestBody := make(map[string]interface{})
+
+	savedSearch.ApplyToRequestBody(requestBody)
+
+	if _, err := NewSearchPageRequest(requestBody, SearchSortRelevance); err != nil {
+		return err
+	}
+
+	if _, err := NewFacetRequest(requestBody); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Save saves the saved search to the database.
+func (savedSearch *SavedSearch) Save(database *pgx.Conn) error {
+	filters, err := json.Marshal(savedSearch.Filters)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Exec(context.Background(), `
+		INSERT INTO saved_searches (uuid, project_uuid, user_id, name, query, filters, sort, sort_order, is_shared, creation_date, last_run_date, hit_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (uuid) DO UPDATE SET
+			name = EXCLUDED.name,
+			query = EXCLUDED.query,
+			filters = EXCLUDED.filters,
+			sort = EXCLUDED.sort,
+			sort_order = EXCLUDED.sort_order,
+			is_shared = EXCLUDED.is_shared`,
+		savedSearch.UUID, savedSearch.ProjectUUID, savedSearch.UserID, savedSearch.Name, savedSearch.Query, string(filters),
+		savedSearch.Sort, savedSearch.Order, savedSearch.IsShared, savedSearch.CreationDate, savedSearch.LastRunDate, savedSearch.HitCount,
+	)
+
+	return err
+}
+
+// savedSearchColumns defines the columns scanned by scanSavedSearch.
+const savedSearchColumns = "uuid, project_uuid, user_id, name, query, filters, sort, sort_order, is_shared, creation_date, last_run_date, hit_count"
+
+// scanSavedSearch scans the saved search from the row.
+func scanSavedSearch(row pgx.Row) (SavedSearch, error) {
+	var savedSearch SavedSearch
+	var filters string
+
+	if err := row.Scan(
+		&savedSearch.UUID,
+		&savedSearch.ProjectUUID,
+		&savedSearch.UserID,
+		&savedSearch.Name,
+		&savedSearch.Query,
+		&filters,
+		&savedSearch.Sort,
+		&savedSearch.Order,
+		&savedSearch.IsShared,
+		&savedSearch.CreationDate,
+		&savedSearch.LastRunDate,
+		&savedSearch.HitCount,
+	); err != nil {
+		return SavedSearch{}, err
+	}
+
+	if err := json.Unmarshal([]byte(filters), &savedSearch.Filters); err != nil {
+		return SavedSearch{}, err
+	}
+
+	return savedSearch, nil
+}
+
+// GetSavedSearchByUUID returns the saved search of the project.
+func GetSavedSearchByUUID(savedSearchUUID string, projectUUID string, database *pgx.Conn) (SavedSearch, error) {
+	return scanSavedSearch(database.QueryRow(context.Background(), "SELECT "+savedSearchColumns+" FROM saved_searches WHERE uuid = $1 AND project_uuid = $2", savedSearchUUID, projectUUID))
+}
+
+// GetSavedSearchesByProject returns the saved searches of the user and the saved searches shared with the project.
+// Pass an empty user ID to get all saved searches of the project.
+func GetSavedSearchesByProject(projectUUID string, userID string, database *pgx.Conn) ([]SavedSearch, error) {
+	rows, err := database.Query(context.Background(), "SELECT "+savedSearchColumns+" FROM saved_searches WHERE project_uuid = $1 AND ($2 = '' OR user_id = $2 OR is_shared) ORDER BY name", projectUUID, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	savedSearches := []SavedSearch{}
+
+	for rows.Next() {
+		savedSearch, err := scanSavedSearch(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		savedSearches = append(savedSearches, savedSearch)
+	}
+
+	return savedSearches, rows.Err()
+}
+
+// SetSavedSearchHitCount sets the hit count and last run date of the saved search.
+func SetSavedSearchHitCount(savedSearchUUID string, hitCount int, lastRunDate int, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), "UPDATE saved_searches SET hit_count = $1, last_run_date = $2 WHERE uuid = $3 AND project_uuid = $4", hitCount, lastRunDate, savedSearchUUID, projectUUID)
+
+	return err
+}
+
+// DeleteSavedSearch deletes the saved search.
+func DeleteSavedSearch(savedSearchUUID string, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), "DELETE FROM saved_searches WHERE uuid = $1 AND project_uuid = $2", savedSearchUUID, projectUUID)
+
+	return err
+}
+
+// NotifySavedSearches re-evaluates the saved searches of the project against the newly parsed evidence.
+// A notification listing the new hits is published for each saved search with hits in the evidence, to the stream of
+// the owner and, for shared saved searches, also to the stream of the shared saved searches.
+// Saved searches which fail are logged and skipped.
+func (server *Server) NotifySavedSearches(project core.Project, evidenceUUID string) error {
+	savedSearches, err := GetSavedSearchesByProject(project.UUID, "", server.Database)
+
+	if err != nil {
+		return err
+	}
+
+	if len(savedSearches) == 0 {
+		return nil
+	}
+
+	// Make the newly indexed messages searchable.
+	refreshResponse, err := ElasticsearchClient.Indices.Refresh(ElasticsearchClient.Indices.Refresh.WithIndex(ElasticsearchIndex))
+
+	if err != nil {
+		return err
+	}
+
+	if err := refreshResponse.Body.Close(); err != nil {
+		Logger.Errorf("Failed to close response body: %s", err)
+	}
+
+	for _, savedSearch := range savedSearches {
+		requestBody := make(map[string]interface{})
+
+		savedSearch.ApplyToRequestBody(requestBody)
+
+		// Only the hits in the new evidence are new.
+		filters, _ := requestBody["filters"].([]interface{})
+
+		requestBody["filters"] = append(filters, map[string]interface{}{"facet": FacetEvidence, "value": evidenceUUID})
+		requestBody["pageSize"] = float64(MaxSavedSearchNotificationHits)
+
+		elasticsearchQuery, err := NewMessageQuery(savedSearch.Query, project.UUID, server.Database)
+
+		if err != nil {
+			Logger.Errorf("Failed to compile saved search query %s: %s", savedSearch.UUID, err)
+			continue
+		}
+
+		searchPage, err := server.SearchMessagesPage(context.Background(), elasticsearchQuery, requestBody, SearchSortDate, project.UUID)
+
+		if err != nil {
+			Logger.Errorf("Failed to run saved search %s: %s", savedSearch.UUID, err)
+			continue
+		}
+
+		if searchPage.TotalHits == 0 {
+			continue
+		}
+
+		notification := SavedSearchNotification{
+			SavedSearchUUID: savedSearch.UUID,
+			Name:            savedSearch.Name,
+			UserID:          savedSearch.UserID,
+			EvidenceUUID:    evidenceUUID,
+			NewHitCount:     searchPage.TotalHits,
+			NewHits:         []SavedSearchHit{},
+		}
+
+		for _, message := range searchPage.Messages {
+			notification.NewHits = append(notification.NewHits, SavedSearchHit{
+				UUID:    message.UUID,
+				Subject: message.Subject,
+				From:    message.From,
+				Date:    message.Date,
+			})
+		}
+
+		notificationJSON, err := json.Marshal(&notification)
+
+		if err != nil {
+			Logger.Errorf("Failed to encode saved search notification %s: %s", savedSearch.UUID, err)
+			continue
+		}
+
+		streamIDs := []string{GetSavedSearchStreamID(project.UUID, savedSearch.UserID)}
+
+		if savedSearch.IsShared {
+			streamIDs = append(streamIDs, GetSavedSearchStreamID(project.UUID, ""))
+		}
+
+		for _, streamID := range streamIDs {
+			server.ServerSentEvents.CreateStream(streamID)
+			server.ServerSentEvents.Publish(streamID, &sse.Event{
+				Event: []byte(SavedSearchNewHitsEvent),
+				Data:  notificationJSON,
+			})
+		}
+	}
+
+	return nil
+}
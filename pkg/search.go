@@ -4,7 +4,10 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	core "github.com/mooijtech/goforensics-core/pkg"
 	"net/http"
@@ -18,6 +21,8 @@ const (
 )
 
 // handleSearch handles the search endpoint.
+// The TREE, MESSAGE (messageUUIDs) and QUERY searches return a SearchPage, see NewSearchPageRequest for the pagination
+// parameters, NewFacetRequest for the facets and facet filters and NewHighlightRequest for the highlighted fragments.
 func (server *Server) handleSearch() http.HandlerFunc {
 	return func(responseWriter http.ResponseWriter, request *http.Request) {
 		if request.Method == "POST" {
@@ -39,6 +44,9 @@ func (server *Server) handleSearch() http.HandlerFunc {
 				return
 			}
 
+			var elasticsearchQuery map[string]interface{}
+			var defaultSort string
+
 			searchType := mux.Vars(request)["searchType"]
 
 			switch searchType {
@@ -52,9 +60,9 @@ func (server *Server) handleSearch() http.HandlerFunc {
 					return
 				}
 
-				var treeNodeUUIDs []string
+				var folderUUIDs []string
 
-				// Create the list of tree node UUIDs and walk the tree node children.
+				// Custodian and evidence group nodes select all their root folders.
 				for _, requestTreeNodeUUID := range requestTreeNodeUUIDs {
 					treeNodeUUID, ok := requestTreeNodeUUID.(string)
 
@@ -64,6 +72,25 @@ func (server *Server) handleSearch() http.HandlerFunc {
 						return
 					}
 
+					if IsTreeGroupNode(treeNodeUUID) {
+						groupRootTreeNodeUUIDs, err := GetTreeGroupNodeRootUUIDs(treeNodeUUID, project.UUID, server.Database)
+
+						if err != nil {
+							Logger.Errorf("Failed to get tree group node root UUIDs: %s", err)
+							http.Error(responseWriter, "Failed to get tree group node root UUIDs.", http.StatusInternalServerError)
+							return
+						}
+
+						folderUUIDs = append(folderUUIDs, groupRootTreeNodeUUIDs...)
+					} else {
+						folderUUIDs = append(folderUUIDs, treeNodeUUID)
+					}
+				}
+
+				var treeNodeUUIDs []string
+
+				// Create the list of tree node UUIDs and walk the tree node children.
+				for _, treeNodeUUID := range folderUUIDs {
 					treeNodeUUIDs = append(treeNodeUUIDs, treeNodeUUID)
 
 					treeNodeChildrenUUIDs, err := core.WalkTreeNodeChildrenUUIDs(treeNodeUUID, project.UUID, server.Database)
@@ -77,42 +104,53 @@ func (server *Server) handleSearch() http.HandlerFunc {
 					treeNodeUUIDs = append(treeNodeUUIDs, treeNodeChildrenUUIDs...)
 				}
 
-				messages, err := core.GetMessagesFromFolders(treeNodeUUIDs, project.UUID, server.Database)
-
-				if err != nil {
-					Logger.Errorf("Failed to perform search: %s", err)
-					http.Error(responseWriter, "Failed to perform search.", http.StatusInternalServerError)
-					return
-				}
-
-				if err := json.NewEncoder(responseWriter).Encode(&messages); err != nil {
-					Logger.Errorf("Failed to encode messages.")
-					http.Error(responseWriter, "Failed to encode messages.", http.StatusInternalServerError)
-					return
-				}
+				elasticsearchQuery = NewProjectQuery(newTermsQuery(MessageFieldFolderUUID, treeNodeUUIDs), project.UUID)
+				defaultSort = SearchSortDate
 			case SearchTypeMessage:
 				// Get a specific message.
-				messageUUID, ok := requestBody["messageUUID"].(string)
+				if messageUUID, ok := requestBody["messageUUID"].(string); ok {
+					message, err := core.GetMessageByUUID(messageUUID, project.UUID, server.Database)
+
+					if err != nil {
+						Logger.Errorf("Failed to get message: %s", err)
+						http.Error(responseWriter, "Failed to get message.", http.StatusInternalServerError)
+						return
+					}
+
+					if err := json.NewEncoder(responseWriter).Encode(message); err != nil {
+						Logger.Errorf("Failed to encode message: %s", err)
+						http.Error(responseWriter, "Failed to encode message.", http.StatusInternalServerError)
+						return
+					}
 
-				if !ok {
-					Logger.Errorf("Failed to get request messageUUID.")
-					http.Error(responseWriter, "Failed to get request messageUUID.", http.StatusBadRequest)
 					return
 				}
 
-				message, err := core.GetMessageByUUID(messageUUID, project.UUID, server.Database)
+				// Get a list of messages.
+				requestMessageUUIDs, ok := requestBody["messageUUIDs"].([]interface{})
 
-				if err != nil {
-					Logger.Errorf("Failed to get message: %s", err)
-					http.Error(responseWriter, "Failed to get message.", http.StatusInternalServerError)
+				if !ok || len(requestMessageUUIDs) == 0 {
+					Logger.Errorf("Failed to get request messageUUID or messageUUIDs.")
+					http.Error(responseWriter, "Failed to get request messageUUID or messageUUIDs.", http.StatusBadRequest)
 					return
 				}
 
-				if err := json.NewEncoder(responseWriter).Encode(message); err != nil {
-					Logger.Errorf("Failed to encode message: %s", err)
-					http.Error(responseWriter, "Failed to encode message.", http.StatusInternalServerError)
-					return
+				var messageUUIDs []string
+
+				for _, requestMessageUUID := range requestMessageUUIDs {
+					messageUUID, ok := requestMessageUUID.(string)
+
+					if !ok {
+						Logger.Errorf("Failed to get request messageUUID.")
+						http.Error(responseWriter, "Failed to get request messageUUID.", http.StatusBadRequest)
+						return
+					}
+
+					messageUUIDs = append(messageUUIDs, messageUUID)
 				}
+
+				elasticsearchQuery = NewProjectQuery(newTermsQuery(MessageFieldUUID, messageUUIDs), project.UUID)
+				defaultSort = SearchSortDate
 			case SearchTypeQuery:
 				// Search query.
 				query, ok := requestBody["query"].(string)
@@ -123,20 +161,162 @@ func (server *Server) handleSearch() http.HandlerFunc {
 					return
 				}
 
-				messages, err := core.GetMessagesFromQuery(query, project.UUID, server.Database)
+				elasticsearchQuery, err = NewMessageQuery(query, project.UUID, server.Database)
 
-				if err != nil {
-					Logger.Errorf("Failed to get messages from query: %s", err)
-					http.Error(responseWriter, "Failed to get messages from query.", http.StatusInternalServerError)
+				var queryParseError *QueryParseError
+
+				if errors.As(err, &queryParseError) {
+					Logger.Errorf("Failed to parse search query: %s", err)
+					writeQueryParseError(responseWriter, queryParseError)
+					return
+				} else if err != nil {
+					Logger.Errorf("Failed to compile search query: %s", err)
+					http.Error(responseWriter, "Failed to compile search query.", http.StatusInternalServerError)
 					return
 				}
 
-				if err := json.NewEncoder(responseWriter).Encode(messages); err != nil {
-					Logger.Errorf("Failed to write response: %s", err)
-					http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
-					return
+				defaultSort = SearchSortRelevance
+			default:
+				Logger.Errorf("Unknown search type: %s", searchType)
+				http.Error(responseWriter, "Unknown search type.", http.StatusBadRequest)
+				return
+			}
+
+			if IsNDJSONRequest(request) {
+				err := server.StreamMessages(request.Context(), responseWriter, elasticsearchQuery, requestBody, project.UUID)
+
+				var searchRequestError *SearchRequestError
+
+				if errors.As(err, &searchRequestError) {
+					Logger.Errorf("Invalid search parameters: %s", err)
+					http.Error(responseWriter, fmt.Sprintf("Invalid search parameters: %s.", err), http.StatusBadRequest)
+				} else if err != nil {
+					Logger.Errorf("Failed to stream search: %s", err)
+					http.Error(responseWriter, "Failed to stream search.", http.StatusInternalServerError)
 				}
+
+				return
+			}
+
+			searchPage, err := server.SearchMessagesPage(request.Context(), elasticsearchQuery, requestBody, defaultSort, project.UUID)
+
+			var searchRequestError *SearchRequestError
+
+			if errors.As(err, &searchRequestError) {
+				Logger.Errorf("Invalid search parameters: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid search parameters: %s.", err), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to perform search: %s", err)
+				http.Error(responseWriter, "Failed to perform search.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&searchPage); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
 			}
 		}
 	}
 }
+
+// SearchRequestError represents invalid pagination, facet or highlight parameters of a search request, or a query
+// rejected by Elasticsearch.
+type SearchRequestError struct {
+	Err error
+}
+
+// Error returns the error message.
+func (searchRequestError *SearchRequestError) Error() string {
+	return searchRequestError.Err.Error()
+}
+
+// SearchMessagesPage performs the search and returns the requested page of messages with the requested facets.
+// Returns a *SearchRequestError if the pagination, facet or highlight parameters in the request body are invalid or if
+// Elasticsearch rejects the query.
+func (server *Server) SearchMessagesPage(ctx context.Context, elasticsearchQuery map[string]interface{}, requestBody map[string]interface{}, defaultSort string, projectUUID string) (SearchPage, error) {
+	pageRequest, err := NewSearchPageRequest(requestBody, defaultSort)
+
+	if err != nil {
+		return SearchPage{}, &SearchRequestError{Err: err}
+	}
+
+	facetRequest, err := NewFacetRequest(requestBody)
+
+	if err != nil {
+		return SearchPage{}, &SearchRequestError{Err: err}
+	}
+
+	highlightRequest, err := NewHighlightRequest(requestBody)
+
+	if err != nil {
+		return SearchPage{}, &SearchRequestError{Err: err}
+	}
+
+	elasticsearchQuery, err = ApplyFacetFilters(elasticsearchQuery, facetRequest.Filters, NewDatabaseQueryResolver(projectUUID, server.Database))
+
+	if errors.Is(err, errTooManyQueryMessages) {
+		return SearchPage{}, &SearchRequestError{Err: err}
+	} else if err != nil {
+		return SearchPage{}, err
+	}
+
+	searchBody := NewSearchBody(elasticsearchQuery, pageRequest)
+
+	if highlightRequest.Enabled {
+		searchBody["highlight"] = NewHighlight(highlightRequest)
+	}
+
+	if len(facetRequest.Facets) > 0 {
+		facetAggregations, err := NewFacetAggregations(facetRequest, projectUUID, server.Database)
+
+		if errors.Is(err, errTooManyQueryMessages) {
+			return SearchPage{}, &SearchRequestError{Err: err}
+		} else if err != nil {
+			return SearchPage{}, err
+		}
+
+		searchBody["aggs"] = facetAggregations
+	}
+
+	searchResponse, err := SearchElasticsearch(ctx, searchBody)
+
+	var elasticsearchError *ElasticsearchError
+
+	if errors.As(err, &elasticsearchError) && elasticsearchError.IsQueryRejected() {
+		return SearchPage{}, &SearchRequestError{Err: fmt.Errorf("the query is too expensive or invalid (%s)", elasticsearchError.Reason)}
+	} else if err != nil {
+		return SearchPage{}, err
+	}
+
+	searchPage, err := NewSearchPage(searchResponse, pageRequest)
+
+	if err != nil {
+		return SearchPage{}, err
+	}
+
+	if highlightRequest.Enabled {
+		searchPage.Highlights = NewHighlights(searchResponse.Hits.Hits, searchPage.Messages)
+	}
+
+	if len(facetRequest.Facets) > 0 {
+		searchPage.Facets, err = NewFacets(searchResponse.Aggregations, facetRequest, projectUUID, server.Database)
+
+		if err != nil {
+			return SearchPage{}, err
+		}
+	}
+
+	return searchPage, nil
+}
+
+// writeQueryParseError writes the query parse error with its position so the dashboard can point at the mistake.
+func writeQueryParseError(responseWriter http.ResponseWriter, queryParseError *QueryParseError) {
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.WriteHeader(http.StatusBadRequest)
+
+	if err := json.NewEncoder(responseWriter).Encode(queryParseError); err != nil {
+		Logger.Errorf("Failed to encode query parse error: %s", err)
+	}
+}
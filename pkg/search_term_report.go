@@ -0,0 +1,622 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxSearchTerms defines the maximum amount of terms in a search term report.
+const MaxSearchTerms = 500
+
+// SearchTermReport represents the hits of a list of search terms (usually the agreed keyword list of a disclosure).
+type SearchTermReport struct {
+	UUID         string                `json:"uuid"`
+	ProjectUUID  string                `json:"projectUUID"`
+	Name         string                `json:"name"`
+	CreationDate int                   `json:"creationDate"`
+	Rows         []SearchTermReportRow `json:"rows"`
+	// TotalHits is the amount of messages matched by any of the terms.
+	TotalHits int `json:"totalHits"`
+	// TotalFamilyHits includes the attachments of the messages matched by any of the terms.
+	TotalFamilyHits int `json:"totalFamilyHits"`
+}
+
+// SearchTermReportRow represents the hits of a single search term.
+type SearchTermReportRow struct {
+	Term string `json:"term"`
+	// Hits is the amount of messages matched by the term.
+	Hits int `json:"hits"`
+	// FamilyHits includes the attachments of the matched messages (message plus attachments).
+	FamilyHits int `json:"familyHits"`
+	// UniqueHits is the amount of messages matched by this term and none of the other terms.
+	UniqueHits int                       `json:"uniqueHits"`
+	Custodians []SearchTermCustodianHits `json:"custodians"`
+}
+
+// SearchTermCustodianHits represents the hits of a search term for a custodian.
+type SearchTermCustodianHits struct {
+	Custodian string `json:"custodian"`
+	Hits      int    `json:"hits"`
+}
+
+// SearchTermError represents an invalid term of the search term list.
+type SearchTermError struct {
+	Term            string           `json:"term"`
+	Index           int              `json:"index"`
+	QueryParseError *QueryParseError `json:"queryParseError"`
+}
+
+// Error returns the error message.
+func (searchTermError *SearchTermError) Error() string {
+	return fmt.Sprintf("invalid search term %d (%s): %s", searchTermError.Index+1, searchTermError.Term, searchTermError.QueryParseError)
+}
+
+// handleSearchTermReports handles the search term reports endpoint.
+func (server *Server) handleSearchTermReports() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		_, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		if request.Method == "GET" {
+			searchTermReports, err := GetSearchTermReportsByProject(project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get search term reports by project: %s", err)
+				http.Error(responseWriter, "Failed to get search term reports by project.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&searchTermReports); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "POST" {
+			// Create a search term report from a list of terms (each term is a query).
+			var requestBody struct {
+				Name  string   `json:"name"`
+				Terms []string `json:"terms"`
+			}
+
+			if err := json.NewDecoder(request.Body).Decode(&requestBody); err != nil {
+				Logger.Errorf("Failed to decode request body: %s", err)
+				http.Error(responseWriter, "Failed to decode request body.", http.StatusBadRequest)
+				return
+			}
+
+			var terms []string
+
+			for _, term := range requestBody.Terms {
+				if strings.TrimSpace(term) != "" {
+					terms = append(terms, strings.TrimSpace(term))
+				}
+			}
+
+			if len(terms) == 0 || len(terms) > MaxSearchTerms {
+				Logger.Errorf("Invalid amount of search terms: %d", len(terms))
+				http.Error(responseWriter, fmt.Sprintf("Expected between 1 and %d search terms.", MaxSearchTerms), http.StatusBadRequest)
+				return
+			}
+
+			searchTermReport, err := NewSearchTermReport(request.Context(), terms, project.UUID, server.Database)
+
+			var searchTermError *SearchTermError
+
+			if errors.As(err, &searchTermError) {
+				Logger.Errorf("Failed to parse search term: %s", err)
+				responseWriter.Header().Set("Content-Type", "application/json")
+				responseWriter.WriteHeader(http.StatusBadRequest)
+
+				if err := json.NewEncoder(responseWriter).Encode(searchTermError); err != nil {
+					Logger.Errorf("Failed to encode search term error: %s", err)
+				}
+
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to create search term report: %s", err)
+				http.Error(responseWriter, "Failed to create search term report.", http.StatusInternalServerError)
+				return
+			}
+
+			searchTermReport.Name = requestBody.Name
+
+			if err := searchTermReport.Save(server.Database); err != nil {
+				Logger.Errorf("Failed to save search term report: %s", err)
+				http.Error(responseWriter, "Failed to save search term report.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&searchTermReport); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// handleSearchTermReport handles the search term report endpoint.
+// Returns CSV instead of JSON with the "format=csv" query parameter.
+func (server *Server) handleSearchTermReport() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		_, project, err := server.AuthenticateRequest(request)
+
+		if err != nil {
+			Logger.Errorf("Failed to authenticate request: %s", err)
+			http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+			return
+		}
+
+		searchTermReportUUID := mux.Vars(request)["uuid"]
+
+		if request.Method == "GET" {
+			searchTermReport, err := GetSearchTermReportByUUID(searchTermReportUUID, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get search term report: %s", err)
+				http.Error(responseWriter, "Failed to get search term report.", http.StatusNotFound)
+				return
+			}
+
+			if request.URL.Query().Get("format") == "csv" {
+				responseWriter.Header().Set("Content-Type", "text/csv")
+				responseWriter.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"search-term-report-%s.csv\"", searchTermReport.UUID))
+
+				if err := searchTermReport.WriteCSV(responseWriter); err != nil {
+					Logger.Errorf("Failed to write CSV: %s", err)
+					http.Error(responseWriter, "Failed to write CSV.", http.StatusInternalServerError)
+					return
+				}
+
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&searchTermReport); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		} else if request.Method == "DELETE" {
+			if err := DeleteSearchTermReport(searchTermReportUUID, project.UUID, server.Database); err != nil {
+				Logger.Errorf("Failed to delete search term report: %s", err)
+				http.Error(responseWriter, "Failed to delete search term report.", http.StatusInternalServerError)
+				return
+			}
+
+			if _, err := responseWriter.Write([]byte("{\"status\": \"OK\"}")); err != nil {
+				Logger.Errorf("Failed to write response: %s", err)
+				http.Error(responseWriter, "Failed to write response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// NewSearchTermReport runs the search terms and creates the search term report.
+// Returns a *SearchTermError if one of the terms is not a valid query.
+func NewSearchTermReport(ctx context.Context, terms []string, projectUUID string, database *pgx.Conn) (SearchTermReport, error) {
+	queryResolver := NewDatabaseQueryResolver(projectUUID, database)
+
+	var termQueries []interface{}
+
+	for i, term := range terms {
+		queryNode, err := ParseQuery(term)
+
+		var queryParseError *QueryParseError
+
+		if errors.As(err, &queryParseError) {
+			return SearchTermReport{}, &SearchTermError{Term: term, Index: i, QueryParseError: queryParseError}
+		} else if err != nil {
+			return SearchTermReport{}, err
+		}
+
+		termQuery, err := CompileQuery(queryNode, queryResolver)
+
+		if err != nil {
+			return SearchTermReport{}, err
+		}
+
+		termQueries = append(termQueries, termQuery)
+	}
+
+	// The hits of each term are counted with a filters aggregation.
+	termFilters := make(map[string]interface{})
+
+	for i, termQuery := range termQueries {
+		termFilters[strconv.Itoa(i)] = termQuery
+	}
+
+	// Attachments are counted by their UUIDs, there is one per attachment.
+	attachmentCountAggregation := map[string]interface{}{
+		"value_count": map[string]interface{}{"field": MessageFieldAttachmentUUID},
+	}
+
+	elasticsearchQuery := NewProjectQuery(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               termQueries,
+			"minimum_should_match": 1,
+		},
+	}, projectUUID)
+
+	searchResponse, err := SearchElasticsearch(ctx, map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+		"query":            elasticsearchQuery,
+		"aggs": map[string]interface{}{
+			"attachments": attachmentCountAggregation,
+			"terms": map[string]interface{}{
+				"filters": map[string]interface{}{"filters": termFilters},
+				"aggs": map[string]interface{}{
+					"attachments": attachmentCountAggregation,
+					"evidence": map[string]interface{}{
+						"terms": map[string]interface{}{
+							"field": MessageFieldEvidenceUUID,
+							"size":  10000,
+						},
+					},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return SearchTermReport{}, err
+	}
+
+	var attachmentsAggregation struct {
+		Value int `json:"value"`
+	}
+
+	var termsAggregation struct {
+		Buckets map[string]struct {
+			DocCount    int `json:"doc_count"`
+			Attachments struct {
+				Value int `json:"value"`
+			} `json:"attachments"`
+			Evidence struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					DocCount int    `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"evidence"`
+		} `json:"buckets"`
+	}
+
+	for name, aggregation := range map[string]interface{}{
+		"attachments": &attachmentsAggregation,
+		"terms":       &termsAggregation,
+	} {
+		if err := json.Unmarshal(searchResponse.Aggregations[name], aggregation); err != nil {
+			return SearchTermReport{}, err
+		}
+	}
+
+	uniqueHits, err := getSearchTermUniqueHits(ctx, termQueries, projectUUID)
+
+	if err != nil {
+		return SearchTermReport{}, err
+	}
+
+	evidenceItems, err := GetEvidenceItemsByProject(projectUUID, database)
+
+	if err != nil {
+		return SearchTermReport{}, err
+	}
+
+	evidenceCustodians := make(map[string]string)
+
+	for _, evidenceItem := range evidenceItems {
+		evidenceCustodians[evidenceItem.UUID] = evidenceItem.Custodian
+	}
+
+	searchTermReport := SearchTermReport{
+		UUID:            core.NewUUID(),
+		ProjectUUID:     projectUUID,
+		CreationDate:    int(time.Now().Unix()),
+		TotalHits:       searchResponse.Hits.Total.Value,
+		TotalFamilyHits: searchResponse.Hits.Total.Value + attachmentsAggregation.Value,
+	}
+
+	for i, term := range terms {
+		key := strconv.Itoa(i)
+		termBucket := termsAggregation.Buckets[key]
+
+		custodianHits := make(map[string]int)
+
+		for _, evidenceBucket := range termBucket.Evidence.Buckets {
+			custodian := evidenceCustodians[evidenceBucket.Key]
+
+			if custodian == "" {
+				custodian = UnassignedCustodianLabel
+			}
+
+			custodianHits[custodian] += evidenceBucket.DocCount
+		}
+
+		searchTermReportRow := SearchTermReportRow{
+			Term:       term,
+			Hits:       termBucket.DocCount,
+			FamilyHits: termBucket.DocCount + termBucket.Attachments.Value,
+			UniqueHits: uniqueHits[i],
+			Custodians: []SearchTermCustodianHits{},
+		}
+
+		for custodian, hits := range custodianHits {
+			searchTermReportRow.Custodians = append(searchTermReportRow.Custodians, SearchTermCustodianHits{Custodian: custodian, Hits: hits})
+		}
+
+		sort.Slice(searchTermReportRow.Custodians, func(i, j int) bool {
+			return searchTermReportRow.Custodians[i].Custodian < searchTermReportRow.Custodians[j].Custodian
+		})
+
+		searchTermReport.Rows = append(searchTermReport.Rows, searchTermReportRow)
+	}
+
+	return searchTermReport, nil
+}
+
+// getSearchTermUniqueHits returns the amount of messages matched by each term and none of the other terms.
+// The terms are named queries, so each hit lists the terms it matches. Excluding all other terms per term in a query
+// would need a clause per pair of terms, which exceeds the clause limit of Elasticsearch.
+func getSearchTermUniqueHits(ctx context.Context, termQueries []interface{}, projectUUID string) ([]int, error) {
+	var namedTermQueries []interface{}
+
+	for i, termQuery := range termQueries {
+		namedTermQueries = append(namedTermQueries, map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":  []interface{}{termQuery},
+				"_name": strconv.Itoa(i),
+			},
+		})
+	}
+
+	elasticsearchQuery := NewProjectQuery(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               namedTermQueries,
+			"minimum_should_match": 1,
+		},
+	}, projectUUID)
+
+	uniqueHits := make([]int, len(termQueries))
+
+	err := WalkHits(ctx, elasticsearchQuery, false, func(hit ElasticsearchHit) error {
+		if len(hit.MatchedQueries) != 1 {
+			return nil
+		}
+
+		index, err := strconv.Atoi(hit.MatchedQueries[0])
+
+		if err != nil || index < 0 || index >= len(uniqueHits) {
+			return fmt.Errorf("unexpected matched query: %s", hit.MatchedQueries[0])
+		}
+
+		uniqueHits[index]++
+
+		return nil
+	})
+
+	return uniqueHits, err
+}
+
+// GetCustodians returns the custodians with hits in the search term report.
+func (searchTermReport *SearchTermReport) GetCustodians() []string {
+	custodianSet := make(map[string]bool)
+
+	for _, row := range searchTermReport.Rows {
+		for _, custodianHits := range row.Custodians {
+			custodianSet[custodianHits.Custodian] = true
+		}
+	}
+
+	var custodians []string
+
+	for custodian := range custodianSet {
+		custodians = append(custodians, custodian)
+	}
+
+	sort.Strings(custodians)
+
+	return custodians
+}
+
+// GetCustodianHits returns the hits of the custodian in the row.
+func (row *SearchTermReportRow) GetCustodianHits(custodian string) int {
+	for _, custodianHits := range row.Custodians {
+		if custodianHits.Custodian == custodian {
+			return custodianHits.Hits
+		}
+	}
+
+	return 0
+}
+
+// WriteCSV writes the search term report as CSV with a column per custodian, cells which spreadsheet applications
+// would evaluate as formulas (such as negated terms) are escaped.
+func (searchTermReport *SearchTermReport) WriteCSV(writer io.Writer) error {
+	csvWriter := csv.NewWriter(writer)
+	custodians := searchTermReport.GetCustodians()
+
+	header := []string{"Term", "Hits", "Hits including family", "Unique hits"}
+
+	for _, custodian := range custodians {
+		header = append(header, fmt.Sprintf("Hits (%s)", custodian))
+	}
+
+	if err := csvWriter.Write(escapeCSVFormulas(header)); err != nil {
+		return err
+	}
+
+	for _, row := range searchTermReport.Rows {
+		record := []string{
+			row.Term,
+			strconv.Itoa(row.Hits),
+			strconv.Itoa(row.FamilyHits),
+			strconv.Itoa(row.UniqueHits),
+		}
+
+		for _, custodian := range custodians {
+			record = append(record, strconv.Itoa(row.GetCustodianHits(custodian)))
+		}
+
+		if err := csvWriter.Write(escapeCSVFormulas(record)); err != nil {
+			return err
+		}
+	}
+
+	total := []string{"Total (any term)", strconv.Itoa(searchTermReport.TotalHits), strconv.Itoa(searchTermReport.TotalFamilyHits), ""}
+
+	if err := csvWriter.Write(escapeCSVFormulas(total)); err != nil {
+		return err
+	}
+
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+// searchTermReportTemplate defines the HTML report section of a search term report.
+var searchTermReportTemplate = template.Must(template.New("searchTermReport").Parse(`
+<table>
+	<thead>
+		<tr>
+			<th>Term</th>
+			<th>Hits</th>
+			<th>Hits including family</th>
+			<th>Unique hits</th>
+			{{range .Custodians}}<th>{{.}}</th>{{end}}
+		</tr>
+	</thead>
+	<tbody>
+		{{range .Rows}}
+		<tr>
+			<td>{{.Term}}</td>
+			<td>{{.Hits}}</td>
+			<td>{{.FamilyHits}}</td>
+			<td>{{.UniqueHits}}</td>
+			{{$row := .}}{{range $.Custodians}}<td>{{$row.GetCustodianHits .}}</td>{{end}}
+		</tr>
+		{{end}}
+		<tr>
+			<th>Total (any term)</th>
+			<th>{{.Report.TotalHits}}</th>
+			<th>{{.Report.TotalFamilyHits}}</th>
+			<th></th>
+			{{range .Custodians}}<th></th>{{end}}
+		</tr>
+	</tbody>
+</table>
+`))
+
+// NewHTMLReportSection creates the section of the search term report in the HTML report.
+func (searchTermReport *SearchTermReport) NewHTMLReportSection() (HTMLReportSection, error) {
+	var rows []*SearchTermReportRow
+
+	for i := range searchTermReport.Rows {
+		rows = append(rows, &searchTermReport.Rows[i])
+	}
+
+	title := "Search term report"
+
+	if searchTermReport.Name != "" {
+		title = fmt.Sprintf("Search term report: %s", searchTermReport.Name)
+	}
+
+	return NewHTMLReportSection(title, searchTermReportTemplate, map[string]interface{}{
+		"Report":     searchTermReport,
+		"Rows":       rows,
+		"Custodians": searchTermReport.GetCustodians(),
+	})
+}
+
+// Save saves the search term report to the database.
+func (searchTermReport *SearchTermReport) Save(database *pgx.Conn) error {
+	report, err := json.Marshal(searchTermReport)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Exec(context.Background(), `
+		INSERT INTO search_term_reports (uuid, project_uuid, creation_date, report) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (uuid) DO UPDATE SET report = EXCLUDED.report`,
+		searchTermReport.UUID, searchTermReport.ProjectUUID, searchTermReport.CreationDate, string(report),
+	)
+
+	return err
+}
+
+// GetSearchTermReportByUUID returns the search term report of the project.
+func GetSearchTermReportByUUID(searchTermReportUUID string, projectUUID string, database *pgx.Conn) (SearchTermReport, error) {
+	var report string
+
+	if err := database.QueryRow(context.Background(), "SELECT report FROM search_term_reports WHERE uuid = $1 AND project_uuid = $2", searchTermReportUUID, projectUUID).Scan(&report); err != nil {
+		return SearchTermReport{}, err
+	}
+
+	var searchTermReport SearchTermReport
+
+	if err := json.Unmarshal([]byte(report), &searchTermReport); err != nil {
+		return SearchTermReport{}, err
+	}
+
+	return searchTermReport, nil
+}
+
+// GetSearchTermReportsByProject returns the search term reports of the project, newest first.
+func GetSearchTermReportsByProject(projectUUID string, database *pgx.Conn) ([]SearchTermReport, error) {
+	rows, err := database.Query(context.Background(), "SELECT report FROM search_term_reports WHERE project_uuid = $1 ORDER BY creation_date DESC", projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	searchTermReports := []SearchTermReport{}
+
+	for rows.Next() {
+		var report string
+
+		if err := rows.Scan(&report); err != nil {
+			return nil, err
+		}
+
+		var searchTermReport SearchTermReport
+
+		if err := json.Unmarshal([]byte(report), &searchTermReport); err != nil {
+			return nil, err
+		}
+
+		searchTermReports = append(searchTermReports, searchTermReport)
+	}
+
+	return searchTermReports, rows.Err()
+}
+
+// DeleteSearchTermReport deletes the search term report.
+func DeleteSearchTermReport(searchTermReportUUID string, projectUUID string, database *pgx.Conn) error {
+	_, err := database.Exec(context.Background(), "DELETE FROM search_term_reports WHERE uuid = $1 AND project_uuid = $2", searchTermReportUUID, projectUUID)
+
+	return err
+}
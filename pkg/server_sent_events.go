@@ -6,8 +6,15 @@ package api
 import "net/http"
 
 // handleLoading handles ServerSentEvents.
+// Saved search notifications are only served by the authenticated saved search notifications endpoint.
 func (server *Server) handleLoading() http.HandlerFunc {
 	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if IsSavedSearchStreamID(request.URL.Query().Get("stream")) {
+			Logger.Errorf("Refused saved search notifications stream on the loading endpoint.")
+			http.Error(responseWriter, "Failed to get stream.", http.StatusNotFound)
+			return
+		}
+
 		server.ServerSentEvents.ServeHTTP(responseWriter, request)
 	}
 }
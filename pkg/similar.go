@@ -0,0 +1,336 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net/http"
+	"strings"
+)
+
+// Constants defining the amount of similar messages.
+const (
+	DefaultSimilarMessagesSize = 20
+	MaxSimilarMessagesSize     = 100
+)
+
+// Constants defining the more like this parameters.
+const (
+	// similarMaxQueryTerms is the maximum amount of terms selected from the message.
+	similarMaxQueryTerms = 50
+	// similarMinimumShouldMatch is the share of the selected terms a similar message must contain.
+	similarMinimumShouldMatch = "30%"
+	// similarParticipantBoost is the score boost per shared sender or recipient.
+	similarParticipantBoost = 2
+)
+
+// SimilarFields defines the message fields compared by content.
+var SimilarFields = []string{
+	MessageFieldSubject,
+	MessageFieldBody,
+	MessageFieldAttachmentContent,
+}
+
+// SimilarRequest represents the parameters of a similar messages request.
+type SimilarRequest struct {
+	Size int
+	// Participants also scores messages by the shared senders and recipients.
+	Participants  bool
+	EvidenceUUIDs []string
+	From          int64
+	HasFrom       bool
+	To            int64
+	HasTo         bool
+}
+
+// SimilarMessage represents a message similar to the requested message.
+type SimilarMessage struct {
+	MessageUUID        string   `json:"messageUUID"`
+	EvidenceUUID       string   `json:"evidenceUUID"`
+	From               string   `json:"from"`
+	Subject            string   `json:"subject"`
+	Date               int      `json:"date"`
+	Score              float64  `json:"score"`
+	SharedParticipants []string `json:"sharedParticipants"`
+}
+
+// SimilarMessagesResponse represents the response of the similar messages endpoint.
+type SimilarMessagesResponse struct {
+	MessageUUID string           `json:"messageUUID"`
+	Messages    []SimilarMessage `json:"messages"`
+	// DuplicateCount is the amount of messages excluded for having the same sender, subject and body.
+	DuplicateCount int `json:"duplicateCount"`
+}
+
+// handleSimilarMessages handles the similar messages endpoint.
+// Accepts the "size", "participants" (true, false), "evidenceUUID" (repeatable), "from" and "to" (dates) query parameters.
+func (server *Server) handleSimilarMessages() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			similarRequest, err := NewSimilarRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Invalid similar messages request: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid similar messages request: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			similarMessagesResponse, err := GetSimilarMessages(request.Context(), message, similarRequest, project.UUID)
+
+			var searchRequestError *SearchRequestError
+
+			if errors.As(err, &searchRequestError) {
+				Logger.Errorf("Invalid similar messages request: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid similar messages request: %s.", err), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to get similar messages: %s", err)
+				http.Error(responseWriter, "Failed to get similar messages.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&similarMessagesResponse); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// NewSimilarRequest creates the SimilarRequest from the query parameters.
+// The dates accept the formats of the date: query field, "to" includes the whole period (for example the whole day).
+func NewSimilarRequest(request *http.Request) (SimilarRequest, error) {
+	queryParameters := request.URL.Query()
+
+	size, err := getQueryParameterInt(request, "size", DefaultSimilarMessagesSize, 1, MaxSimilarMessagesSize)
+
+	if err != nil {
+		return SimilarRequest{}, fmt.Errorf("the size must be a number between 1 and %d", MaxSimilarMessagesSize)
+	}
+
+	similarRequest := SimilarRequest{
+		Size:          size,
+		EvidenceUUIDs: queryParameters["evidenceUUID"],
+	}
+
+	switch strings.ToLower(queryParameters.Get("participants")) {
+	case "", "false":
+	case "true":
+		similarRequest.Participants = true
+	default:
+		return SimilarRequest{}, fmt.Errorf("participants must be true or false")
+	}
+
+	if from := queryParameters.Get("from"); from != "" {
+		similarRequest.From, _, err = parseQueryDate(from, 0)
+
+		if err != nil {
+			return SimilarRequest{}, fmt.Errorf("invalid from date: %s", from)
+		}
+
+		similarRequest.HasFrom = true
+	}
+
+	if to := queryParameters.Get("to"); to != "" {
+		_, similarRequest.To, err = parseQueryDate(to, 0)
+
+		if err != nil {
+			return SimilarRequest{}, fmt.Errorf("invalid to date: %s", to)
+		}
+
+		similarRequest.HasTo = true
+	}
+
+	return similarRequest, nil
+}
+
+// getMessageParticipants returns the lowercase email addresses of the sender and recipients of the message.
+func getMessageParticipants(message core.Message) []string {
+	var participants []string
+	seen := make(map[string]bool)
+
+	for _, field := range []string{message.From, message.To, message.CC} {
+		for _, participant := range entityEmailRegexp.FindAllString(field, -1) {
+			participant = strings.ToLower(participant)
+
+			if !seen[participant] {
+				seen[participant] = true
+				participants = append(participants, participant)
+			}
+		}
+	}
+
+	return participants
+}
+
+// getMessageContentHash returns the hash of the normalized sender, subject and body, equal for exact duplicates.
+func getMessageContentHash(message core.Message) [sha256.Size]byte {
+	return sha256.Sum256([]byte(strings.Join([]string{
+		strings.ToLower(strings.Join(strings.Fields(message.From), " ")),
+		strings.ToLower(strings.Join(strings.Fields(message.Subject), " ")),
+		strings.Join(strings.Fields(message.Body), " "),
+	}, "\x00")))
+}
+
+// GetSimilarMessages returns the messages of the project most similar to the message by content, and optionally by
+// participants. Exact duplicates (the same Message-ID or the same sender, subject and body) are excluded.
+// Returns a *SearchRequestError if Elasticsearch rejects the query.
+func GetSimilarMessages(ctx context.Context, message core.Message, similarRequest SimilarRequest, projectUUID string) (SimilarMessagesResponse, error) {
+	similarMessagesResponse := SimilarMessagesResponse{
+		MessageUUID: message.UUID,
+		Messages:    []SimilarMessage{},
+	}
+
+	participants := getMessageParticipants(message)
+
+	should := []interface{}{
+		map[string]interface{}{
+			"more_like_this": map[string]interface{}{
+				"fields": SimilarFields,
+				// The text is used instead of the indexed document, the document ID is not the message UUID.
+				"like":                 []string{message.Subject, message.Body},
+				"max_query_terms":      similarMaxQueryTerms,
+				"min_term_freq":        1,
+				"min_doc_freq":         2,
+				"minimum_should_match": similarMinimumShouldMatch,
+			},
+		},
+	}
+
+	if similarRequest.Participants {
+		for _, participant := range participants {
+			should = append(should, map[string]interface{}{
+				"multi_match": map[string]interface{}{
+					"query":  participant,
+					"type":   "phrase",
+					"fields": []string{MessageFieldFrom, MessageFieldTo, MessageFieldCC},
+					"boost":  similarParticipantBoost,
+				},
+			})
+		}
+	}
+
+	mustNot := []interface{}{
+		newTermsQuery(MessageFieldUUID, []string{message.UUID}),
+	}
+
+	if messageIDs := GetMessageIDs(message.MessageID); len(messageIDs) > 0 {
+		mustNot = append(mustNot, newTermsQuery(MessageFieldMessageID, []string{messageIDs[0], "<" + messageIDs[0] + ">"}))
+	}
+
+	var filters []interface{}
+
+	if len(similarRequest.EvidenceUUIDs) > 0 {
+		filters = append(filters, newTermsQuery(MessageFieldEvidenceUUID, similarRequest.EvidenceUUIDs))
+	}
+
+	if similarRequest.HasFrom || similarRequest.HasTo {
+		dateRange := make(map[string]interface{})
+
+		if similarRequest.HasFrom {
+			dateRange["gte"] = similarRequest.From
+		}
+
+		if similarRequest.HasTo {
+			dateRange["lt"] = similarRequest.To
+		}
+
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{MessageFieldDate: dateRange},
+		})
+	}
+
+	// The participants only add to the score, a similar message must match the content.
+	elasticsearchQuery := NewProjectQuery(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must":     []interface{}{should[0]},
+			"should":   should[1:],
+			"must_not": mustNot,
+			"filter":   filters,
+		},
+	}, projectUUID)
+
+	// Request more messages so the page is still full after removing the duplicates with another Message-ID.
+	searchResponse, err := SearchElasticsearch(ctx, map[string]interface{}{
+		"query":   elasticsearchQuery,
+		"size":    similarRequest.Size * 2,
+		"_source": map[string]interface{}{"excludes": []string{MessageFieldAttachmentContent}},
+	})
+
+	var elasticsearchError *ElasticsearchError
+
+	if errors.As(err, &elasticsearchError) && elasticsearchError.IsQueryRejected() {
+		return similarMessagesResponse, &SearchRequestError{Err: fmt.Errorf("the message can not be compared (%s)", elasticsearchError.Reason)}
+	} else if err != nil {
+		return similarMessagesResponse, err
+	}
+
+	messages, err := GetMessagesFromHits(searchResponse.Hits.Hits)
+
+	if err != nil {
+		return similarMessagesResponse, err
+	}
+
+	contentHash := getMessageContentHash(message)
+
+	isParticipant := make(map[string]bool)
+
+	for _, participant := range participants {
+		isParticipant[participant] = true
+	}
+
+	for i, similarMessage := range messages {
+		if getMessageContentHash(similarMessage) == contentHash {
+			similarMessagesResponse.DuplicateCount++
+			continue
+		}
+
+		if len(similarMessagesResponse.Messages) == similarRequest.Size {
+			continue
+		}
+
+		sharedParticipants := []string{}
+
+		for _, participant := range getMessageParticipants(similarMessage) {
+			if isParticipant[participant] {
+				sharedParticipants = append(sharedParticipants, participant)
+			}
+		}
+
+		similarMessagesResponse.Messages = append(similarMessagesResponse.Messages, SimilarMessage{
+			MessageUUID:        similarMessage.UUID,
+			EvidenceUUID:       similarMessage.EvidenceUUID,
+			From:               similarMessage.From,
+			Subject:            similarMessage.Subject,
+			Date:               similarMessage.Date,
+			Score:              searchResponse.Hits.Hits[i].Score,
+			SharedParticipants: sharedParticipants,
+		})
+	}
+
+	return similarMessagesResponse, nil
+}
@@ -0,0 +1,256 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NDJSONContentType is the content type of streamed search results, one message per line.
+const NDJSONContentType = "application/x-ndjson"
+
+// Constants defining the streamed search.
+const (
+	// streamPageSize is the amount of messages read from Elasticsearch and written before flushing.
+	streamPageSize = 1000
+	// streamKeepAlive is how long Elasticsearch keeps the point in time open between pages.
+	streamKeepAlive = "1m"
+	// streamCloseTimeout is the time to close the point in time after the client disconnected.
+	streamCloseTimeout = 10 * time.Second
+)
+
+// StreamError represents the last line of a stream which failed after the results were partially written.
+type StreamError struct {
+	Error string `json:"error"`
+}
+
+// IsNDJSONRequest returns true if the client accepts streamed (NDJSON) search results.
+func IsNDJSONRequest(request *http.Request) bool {
+	for _, accept := range strings.Split(request.Header.Get("Accept"), ",") {
+		if strings.TrimSpace(strings.Split(accept, ";")[0]) == NDJSONContentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StreamMessages writes every message matching the query as a line of JSON, in index order, redacted messages
+// have their body redactions applied.
+// Reads the messages page by page from an Elasticsearch point in time so memory use does not depend on the amount
+// of messages. Stops when the client disconnects.
+// Returns a *SearchRequestError if the facet filters are invalid or Elasticsearch rejects the query. Errors after
+// the first message was written are logged and written as a StreamError line, the status can no longer change.
+func (server *Server) StreamMessages(ctx context.Context, responseWriter http.ResponseWriter, elasticsearchQuery map[string]interface{}, requestBody map[string]interface{}, projectUUID string) error {
+	facetRequest, err := NewFacetRequest(requestBody)
+
+	if err != nil {
+		return &SearchRequestError{Err: err}
+	}
+
+	elasticsearchQuery, err = ApplyFacetFilters(elasticsearchQuery, facetRequest.Filters, NewDatabaseQueryResolver(projectUUID, server.Database))
+
+	if errors.Is(err, errTooManyQueryMessages) {
+		return &SearchRequestError{Err: err}
+	} else if err != nil {
+		return err
+	}
+
+	pointInTimeID, err := OpenPointInTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// The request context is cancelled when the client disconnects, the point in time must still be closed.
+	defer func() {
+		closeContext, cancel := context.WithTimeout(context.Background(), streamCloseTimeout)
+		defer cancel()
+
+		if err := ClosePointInTime(closeContext, pointInTimeID); err != nil {
+			Logger.Errorf("Failed to close point in time: %s", err)
+		}
+	}()
+
+	flusher, _ := responseWriter.(http.Flusher)
+	encoder := json.NewEncoder(responseWriter)
+	isStreaming := false
+	messageCount := 0
+
+	var searchAfter []interface{}
+
+	for {
+		if ctx.Err() != nil {
+			Logger.Infof("Client disconnected after %d streamed messages", messageCount)
+			return nil
+		}
+
+		searchBody := map[string]interface{}{
+			"query": elasticsearchQuery,
+			"size":  streamPageSize,
+			"pit":   map[string]interface{}{"id": pointInTimeID, "keep_alive": streamKeepAlive},
+			// The shard document order is the cheapest sort for reading every hit.
+			"sort":             []interface{}{map[string]interface{}{"_shard_doc": "asc"}},
+			"track_total_hits": false,
+			"_source":          map[string]interface{}{"excludes": []string{MessageFieldAttachmentContent}},
+		}
+
+		if searchAfter != nil {
+			searchBody["search_after"] = searchAfter
+		}
+
+		searchResponse, err := SearchElasticsearch(ctx, searchBody)
+
+		if err != nil && ctx.Err() != nil {
+			Logger.Infof("Client disconnected after %d streamed messages", messageCount)
+			return nil
+		}
+
+		if err != nil && !isStreaming {
+			var elasticsearchError *ElasticsearchError
+
+			if errors.As(err, &elasticsearchError) && elasticsearchError.IsQueryRejected() {
+				return &SearchRequestError{Err: fmt.Errorf("the query is too expensive or invalid (%s)", elasticsearchError.Reason)}
+			}
+
+			return err
+		}
+
+		if !isStreaming {
+			responseWriter.Header().Set("Content-Type", NDJSONContentType)
+			responseWriter.Header().Set("X-Content-Type-Options", "nosniff")
+			responseWriter.WriteHeader(http.StatusOK)
+			isStreaming = true
+		}
+
+		if err == nil {
+			err = server.writeStreamMessages(encoder, searchResponse.Hits.Hits, projectUUID)
+		}
+
+		if err != nil {
+			if ctx.Err() == nil {
+				Logger.Errorf("Failed to stream messages: %s", err)
+
+				if err := encoder.Encode(&StreamError{Error: "Failed to stream messages."}); err != nil {
+					Logger.Errorf("Failed to write stream error: %s", err)
+				}
+			}
+
+			return nil
+		}
+
+		messageCount += len(searchResponse.Hits.Hits)
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(searchResponse.Hits.Hits) < streamPageSize {
+			return nil
+		}
+
+		if searchResponse.PointInTimeID != "" {
+			pointInTimeID = searchResponse.PointInTimeID
+		}
+
+		searchAfter = searchResponse.Hits.Hits[len(searchResponse.Hits.Hits)-1].Sort
+	}
+}
+
+// writeStreamMessages writes the messages of the hits as lines of JSON, with the body redactions applied.
+func (server *Server) writeStreamMessages(encoder *json.Encoder, hits []ElasticsearchHit, projectUUID string) error {
+	messages, err := GetMessagesFromHits(hits)
+
+	if err != nil {
+		return err
+	}
+
+	messages, err = RedactMessages(messages, projectUUID, server.Database)
+
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		if err := encoder.Encode(&message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OpenPointInTime opens a point in time on the message index, so pages are read from a consistent view.
+func OpenPointInTime(ctx context.Context) (string, error) {
+	response, err := ElasticsearchClient.OpenPointInTime(
+		[]string{ElasticsearchIndex},
+		streamKeepAlive,
+		ElasticsearchClient.OpenPointInTime.WithContext(ctx),
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		err := response.Body.Close()
+
+		if err != nil {
+			Logger.Errorf("Failed to close response body: %s", err)
+		}
+	}()
+
+	if response.IsError() {
+		return "", NewElasticsearchError(response.StatusCode, response.Body)
+	}
+
+	var pointInTime struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&pointInTime); err != nil {
+		return "", err
+	}
+
+	return pointInTime.ID, nil
+}
+
+// ClosePointInTime closes the point in time so Elasticsearch releases the resources.
+func ClosePointInTime(ctx context.Context, pointInTimeID string) error {
+	var requestBody bytes.Buffer
+
+	if err := json.NewEncoder(&requestBody).Encode(map[string]interface{}{"id": pointInTimeID}); err != nil {
+		return err
+	}
+
+	response, err := ElasticsearchClient.ClosePointInTime(
+		ElasticsearchClient.ClosePointInTime.WithContext(ctx),
+		ElasticsearchClient.ClosePointInTime.WithBody(&requestBody),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := response.Body.Close()
+
+		if err != nil {
+			Logger.Errorf("Failed to close response body: %s", err)
+		}
+	}()
+
+	if response.IsError() {
+		return NewElasticsearchError(response.StatusCode, response.Body)
+	}
+
+	return nil
+}
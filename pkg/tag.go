@@ -4,7 +4,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"github.com/jackc/pgx/v4"
 	core "github.com/mooijtech/goforensics-core/pkg"
 	"net/http"
 )
@@ -62,3 +64,29 @@ func (server *Server) handleTags() http.HandlerFunc {
 		}
 	}
 }
+
+// GetTagsByProject returns the tags of the project with the UUIDs of the tagged messages.
+func GetTagsByProject(projectUUID string, database *pgx.Conn) (map[string][]string, error) {
+	rows, err := database.Query(context.Background(), "SELECT tag, message_uuid FROM tags WHERE project_uuid = $1", projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+
+	for rows.Next() {
+		var tag string
+		var messageUUID string
+
+		if err := rows.Scan(&tag, &messageUUID); err != nil {
+			return nil, err
+		}
+
+		tags[tag] = append(tags[tag], messageUUID)
+	}
+
+	return tags, rows.Err()
+}
@@ -0,0 +1,719 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Constants defining the limits of the text extraction.
+const (
+	// MaxTextExtractionFileSize is the maximum size of an attachment to extract text from.
+	MaxTextExtractionFileSize = 100 << 20
+	// MaxExtractedTextSize is the maximum size of the extracted text, longer text is truncated.
+	MaxExtractedTextSize = 10 << 20
+	// maxTextExtractionEntrySize is the maximum uncompressed size of a file inside a DOCX, XLSX, PPTX or ODT archive.
+	maxTextExtractionEntrySize = 200 << 20
+)
+
+// Constants defining the file types text can be extracted from.
+const (
+	TextFileTypePDF  = "PDF"
+	TextFileTypeDOCX = "DOCX"
+	TextFileTypeXLSX = "XLSX"
+	TextFileTypePPTX = "PPTX"
+	TextFileTypeODF  = "ODF"
+	TextFileTypeRTF  = "RTF"
+	TextFileTypeText = "TEXT"
+)
+
+// Errors returned by ExtractText.
+var (
+	ErrTextExtractionUnsupported = errors.New("unsupported file type")
+	ErrTextExtractionEncrypted   = errors.New("the file is encrypted")
+	ErrTextExtractionTooLarge    = errors.New("the file is too large")
+)
+
+// textFileTypeExtensions maps the file extensions to the file types.
+var textFileTypeExtensions = map[string]string{
+	".pdf":  TextFileTypePDF,
+	".docx": TextFileTypeDOCX,
+	".docm": TextFileTypeDOCX,
+	".dotx": TextFileTypeDOCX,
+	".xlsx": TextFileTypeXLSX,
+	".xlsm": TextFileTypeXLSX,
+	".pptx": TextFileTypePPTX,
+	".pptm": TextFileTypePPTX,
+	".odt":  TextFileTypeODF,
+	".ods":  TextFileTypeODF,
+	".odp":  TextFileTypeODF,
+	".rtf":  TextFileTypeRTF,
+	".txt":  TextFileTypeText,
+	".csv":  TextFileTypeText,
+	".log":  TextFileTypeText,
+}
+
+// textFileTypeMimeTypes maps the MIME types to the file types.
+var textFileTypeMimeTypes = map[string]string{
+	"application/pdf": TextFileTypePDF,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   TextFileTypeDOCX,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         TextFileTypeXLSX,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": TextFileTypePPTX,
+	"application/vnd.oasis.opendocument.text":                                   TextFileTypeODF,
+	"application/vnd.oasis.opendocument.spreadsheet":                            TextFileTypeODF,
+	"application/vnd.oasis.opendocument.presentation":                           TextFileTypeODF,
+	"application/rtf": TextFileTypeRTF,
+	"text/rtf":        TextFileTypeRTF,
+	"text/plain":      TextFileTypeText,
+	"text/csv":        TextFileTypeText,
+}
+
+// compoundFileSignature is the signature of OLE compound files, Office stores encrypted DOCX, XLSX and PPTX files in one.
+var compoundFileSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// GetTextFileType returns the file type by file name, MIME type or content.
+// Returns an empty string if text can not be extracted from the file.
+func GetTextFileType(fileName string, mimeType string, data []byte) string {
+	if fileType, ok := textFileTypeExtensions[strings.ToLower(path.Ext(fileName))]; ok {
+		return fileType
+	}
+
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+
+	if fileType, ok := textFileTypeMimeTypes[mimeType]; ok {
+		return fileType
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return TextFileTypePDF
+	case bytes.HasPrefix(data, []byte("{\\rtf")):
+		return TextFileTypeRTF
+	}
+
+	return ""
+}
+
+// ExtractText extracts the text of a PDF, DOCX, XLSX, PPTX, ODT (ODS, ODP), RTF or plain text file.
+// Returns ErrTextExtractionUnsupported, ErrTextExtractionEncrypted or ErrTextExtractionTooLarge if no text can be extracted.
+func ExtractText(fileName string, mimeType string, data []byte) (text string, err error) {
+	fileType := GetTextFileType(fileName, mimeType, data)
+
+	if fileType == "" {
+		return "", ErrTextExtractionUnsupported
+	}
+
+	if len(data) > MaxTextExtractionFileSize {
+		return "", ErrTextExtractionTooLarge
+	}
+
+	// Attachments come from untrusted evidence, a malformed file must not take down the API.
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			text = ""
+			err = fmt.Errorf("failed to parse %s: %v", fileType, recovered)
+		}
+	}()
+
+	switch fileType {
+	case TextFileTypePDF:
+		text, err = extractPDFText(data)
+	case TextFileTypeDOCX, TextFileTypeXLSX, TextFileTypePPTX:
+		if bytes.HasPrefix(data, compoundFileSignature) {
+			return "", ErrTextExtractionEncrypted
+		}
+
+		text, err = extractOfficeOpenXMLText(fileType, data)
+	case TextFileTypeODF:
+		text, err = extractOpenDocumentText(data)
+	case TextFileTypeRTF:
+		text, err = extractRTFText(data)
+	case TextFileTypeText:
+		text = decodeText(data)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return truncateText(strings.TrimSpace(text), MaxExtractedTextSize), nil
+}
+
+// truncateText truncates the text to the maximum size without splitting a character.
+func truncateText(text string, maxSize int) string {
+	if len(text) <= maxSize {
+		return text
+	}
+
+	end := maxSize
+
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+
+	return text[:end]
+}
+
+// decodeText decodes plain text, UTF-16 with a byte order mark, UTF-8 or Windows-1252.
+func decodeText(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return strings.ToValidUTF8(string(data[3:]), "�")
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true)
+	case utf8.Valid(data):
+		return string(data)
+	default:
+		return decodeWindows1252(data)
+	}
+}
+
+// decodeUTF16 decodes UTF-16 text.
+func decodeUTF16(data []byte, isBigEndian bool) string {
+	codeUnits := make([]uint16, len(data)/2)
+
+	for i := range codeUnits {
+		if isBigEndian {
+			codeUnits[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			codeUnits[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+
+	return string(utf16.Decode(codeUnits))
+}
+
+// windows1252Characters maps the bytes 0x80 to 0x9F of Windows-1252, the other bytes match Latin-1.
+var windows1252Characters = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+// decodeWindows1252Byte decodes a Windows-1252 byte.
+func decodeWindows1252Byte(character byte) rune {
+	if character >= 0x80 && character <= 0x9F {
+		return windows1252Characters[character-0x80]
+	}
+
+	return rune(character)
+}
+
+// decodeWindows1252 decodes Windows-1252 text.
+func decodeWindows1252(data []byte) string {
+	var text strings.Builder
+
+	for _, character := range data {
+		text.WriteRune(decodeWindows1252Byte(character))
+	}
+
+	return text.String()
+}
+
+// readZipFile reads a file in the archive, limited to maxTextExtractionEntrySize (against zip bombs).
+func readZipFile(zipFile *zip.File) ([]byte, error) {
+	reader, err := zipFile.Open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := reader.Close(); err != nil {
+			Logger.Errorf("Failed to close zip file: %s", err)
+		}
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxTextExtractionEntrySize+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxTextExtractionEntrySize {
+		return nil, ErrTextExtractionTooLarge
+	}
+
+	return data, nil
+}
+
+// getZipFiles returns the files in the archive matching the directory and prefix, in numeric order (slide2 before slide10).
+func getZipFiles(zipReader *zip.Reader, directory string, prefix string) []*zip.File {
+	var zipFiles []*zip.File
+
+	for _, zipFile := range zipReader.File {
+		if path.Dir(zipFile.Name) == directory && strings.HasPrefix(path.Base(zipFile.Name), prefix) && strings.HasSuffix(zipFile.Name, ".xml") {
+			zipFiles = append(zipFiles, zipFile)
+		}
+	}
+
+	getNumber := func(zipFile *zip.File) int {
+		number, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path.Base(zipFile.Name), prefix), ".xml"))
+
+		return number
+	}
+
+	sort.Slice(zipFiles, func(i, j int) bool {
+		return getNumber(zipFiles[i]) < getNumber(zipFiles[j])
+	})
+
+	return zipFiles
+}
+
+// xmlTextRules defines which text of an XML document is extracted.
+type xmlTextRules struct {
+	// TextElements contains the elements the text is extracted from, all text is extracted if nil.
+	TextElements map[string]bool
+	// Separators maps elements to the text they are replaced by (tabs and line breaks).
+	Separators map[string]string
+	// Paragraphs contains the elements which end with a line break.
+	Paragraphs map[string]bool
+}
+
+// extractXMLText extracts the text of an XML document by the rules, elements are matched by local name.
+func extractXMLText(data []byte, rules xmlTextRules, text *strings.Builder) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	textElementDepth := 0
+
+	for {
+		token, err := decoder.Token()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if rules.TextElements[token.Name.Local] {
+				textElementDepth++
+			}
+
+			if separator, ok := rules.Separators[token.Name.Local]; ok {
+				text.WriteString(separator)
+			}
+		case xml.EndElement:
+			if rules.TextElements[token.Name.Local] {
+				textElementDepth--
+			}
+
+			if rules.Paragraphs[token.Name.Local] {
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if rules.TextElements == nil || textElementDepth > 0 {
+				text.Write(token)
+			}
+		}
+
+		if text.Len() > MaxExtractedTextSize {
+			return nil
+		}
+	}
+}
+
+// Rules of the Office Open XML documents.
+var (
+	wordTextRules = xmlTextRules{
+		TextElements: map[string]bool{"t": true},
+		Separators:   map[string]string{"tab": "\t", "br": "\n", "cr": "\n"},
+		Paragraphs:   map[string]bool{"p": true},
+	}
+	presentationTextRules = xmlTextRules{
+		TextElements: map[string]bool{"t": true},
+		Separators:   map[string]string{"br": "\n"},
+		Paragraphs:   map[string]bool{"p": true},
+	}
+	openDocumentTextRules = xmlTextRules{
+		// The styles before the body do not contain text.
+		TextElements: map[string]bool{"body": true},
+		Separators:   map[string]string{"s": " ", "tab": "\t", "line-break": "\n"},
+		Paragraphs:   map[string]bool{"p": true, "h": true, "table-cell": true},
+	}
+)
+
+// extractOfficeOpenXMLText extracts the text of a DOCX, XLSX or PPTX file.
+func extractOfficeOpenXMLText(fileType string, data []byte) (string, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+
+	switch fileType {
+	case TextFileTypeDOCX:
+		// The document followed by the headers, footers, footnotes and comments.
+		var zipFiles []*zip.File
+
+		for _, prefix := range []string{"document", "header", "footer", "footnotes", "endnotes", "comments"} {
+			zipFiles = append(zipFiles, getZipFiles(zipReader, "word", prefix)...)
+		}
+
+		for _, zipFile := range zipFiles {
+			if err := extractZipXMLText(zipFile, wordTextRules, &text); err != nil {
+				return "", err
+			}
+		}
+	case TextFileTypePPTX:
+		zipFiles := getZipFiles(zipReader, "ppt/slides", "slide")
+		zipFiles = append(zipFiles, getZipFiles(zipReader, "ppt/notesSlides", "notesSlide")...)
+
+		for _, zipFile := range zipFiles {
+			if err := extractZipXMLText(zipFile, presentationTextRules, &text); err != nil {
+				return "", err
+			}
+
+			text.WriteString("\n")
+		}
+	case TextFileTypeXLSX:
+		if err := extractSpreadsheetText(zipReader, &text); err != nil {
+			return "", err
+		}
+	}
+
+	return text.String(), nil
+}
+
+// extractZipXMLText extracts the text of an XML file in the archive.
+func extractZipXMLText(zipFile *zip.File, rules xmlTextRules, text *strings.Builder) error {
+	data, err := readZipFile(zipFile)
+
+	if err != nil {
+		return err
+	}
+
+	return extractXMLText(data, rules, text)
+}
+
+// extractSpreadsheetText extracts the cells of each worksheet of a XLSX file, one row per line.
+func extractSpreadsheetText(zipReader *zip.Reader, text *strings.Builder) error {
+	var sharedStrings []string
+
+	for _, zipFile := range zipReader.File {
+		if zipFile.Name != "xl/sharedStrings.xml" {
+			continue
+		}
+
+		data, err := readZipFile(zipFile)
+
+		if err != nil {
+			return err
+		}
+
+		var sharedStringTable struct {
+			Items []struct {
+				Text string `xml:"t"`
+				Runs []struct {
+					Text string `xml:"t"`
+				} `xml:"r"`
+			} `xml:"si"`
+		}
+
+		if err := xml.Unmarshal(data, &sharedStringTable); err != nil {
+			return err
+		}
+
+		for _, item := range sharedStringTable.Items {
+			value := item.Text
+
+			// Rich text is split into runs.
+			for _, run := range item.Runs {
+				value += run.Text
+			}
+
+			sharedStrings = append(sharedStrings, value)
+		}
+	}
+
+	for _, zipFile := range getZipFiles(zipReader, "xl/worksheets", "sheet") {
+		data, err := readZipFile(zipFile)
+
+		if err != nil {
+			return err
+		}
+
+		var worksheet struct {
+			Rows []struct {
+				Cells []struct {
+					Type        string `xml:"t,attr"`
+					Value       string `xml:"v"`
+					InlineValue string `xml:"is>t"`
+				} `xml:"c"`
+			} `xml:"sheetData>row"`
+		}
+
+		if err := xml.Unmarshal(data, &worksheet); err != nil {
+			return err
+		}
+
+		for _, row := range worksheet.Rows {
+			var values []string
+
+			for _, cell := range row.Cells {
+				value := cell.Value
+
+				switch cell.Type {
+				case "s":
+					index, err := strconv.Atoi(cell.Value)
+
+					if err == nil && index >= 0 && index < len(sharedStrings) {
+						value = sharedStrings[index]
+					}
+				case "inlineStr":
+					value = cell.InlineValue
+				}
+
+				if value != "" {
+					values = append(values, value)
+				}
+			}
+
+			if len(values) > 0 {
+				text.WriteString(strings.Join(values, "\t"))
+				text.WriteString("\n")
+			}
+
+			if text.Len() > MaxExtractedTextSize {
+				return nil
+			}
+		}
+
+		text.WriteString("\n")
+	}
+
+	return nil
+}
+
+// extractOpenDocumentText extracts the text of an ODT, ODS or ODP file.
+func extractOpenDocumentText(data []byte) (string, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+
+	if err != nil {
+		return "", err
+	}
+
+	var contentFile *zip.File
+
+	for _, zipFile := range zipReader.File {
+		switch zipFile.Name {
+		case "META-INF/manifest.xml":
+			manifest, err := readZipFile(zipFile)
+
+			if err != nil {
+				return "", err
+			}
+
+			// Encrypted files are listed with their encryption data in the manifest.
+			if bytes.Contains(manifest, []byte("encryption-data")) {
+				return "", ErrTextExtractionEncrypted
+			}
+		case "content.xml":
+			contentFile = zipFile
+		}
+	}
+
+	if contentFile == nil {
+		return "", errors.New("missing content.xml")
+	}
+
+	content, err := readZipFile(contentFile)
+
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+
+	if err := extractXMLText(content, openDocumentTextRules, &text); err != nil {
+		return "", err
+	}
+
+	return text.String(), nil
+}
+
+// rtfSkippedDestinations defines the RTF destinations which do not contain document text.
+var rtfSkippedDestinations = map[string]bool{
+	"fonttbl": true, "colortbl": true, "stylesheet": true, "info": true, "pict": true, "object": true,
+	"themedata": true, "colorschememapping": true, "datastore": true, "latentstyles": true, "listtable": true,
+	"listoverridetable": true, "rsidtbl": true, "generator": true, "xmlnstbl": true, "mmathPr": true,
+	"filetbl": true, "revtbl": true, "pgdsctbl": true, "bkmkstart": true, "bkmkend": true, "fldinst": true,
+}
+
+// rtfGroupState represents the state of an RTF group.
+type rtfGroupState struct {
+	IsSkipped bool
+	// UnicodeSkip is the amount of fallback characters after a \u character.
+	UnicodeSkip int
+}
+
+// extractRTFText extracts the text of a RTF document.
+func extractRTFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("{\\rtf")) {
+		return "", errors.New("missing RTF header")
+	}
+
+	var text strings.Builder
+
+	state := rtfGroupState{UnicodeSkip: 1}
+	var stack []rtfGroupState
+	// Fallback characters still to skip after a \u character.
+	skipCharacters := 0
+
+	writeRune := func(character rune) {
+		if skipCharacters > 0 {
+			skipCharacters--
+			return
+		}
+
+		if !state.IsSkipped {
+			text.WriteRune(character)
+		}
+	}
+
+	for i := 0; i < len(data) && text.Len() <= MaxExtractedTextSize; {
+		character := data[i]
+
+		switch character {
+		case '{':
+			stack = append(stack, state)
+			skipCharacters = 0
+			i++
+		case '}':
+			if len(stack) > 0 {
+				state = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+
+			skipCharacters = 0
+			i++
+		case '\r', '\n':
+			i++
+		case '\\':
+			i++
+
+			if i >= len(data) {
+				break
+			}
+
+			next := data[i]
+
+			switch {
+			case next == '\\' || next == '{' || next == '}':
+				writeRune(rune(next))
+				i++
+			case next == '~':
+				writeRune(' ')
+				i++
+			case next == '_':
+				writeRune('-')
+				i++
+			case next == '*':
+				// Ignorable destination unknown to the reader.
+				state.IsSkipped = true
+				i++
+			case next == '\'':
+				if i+2 < len(data) {
+					value, err := strconv.ParseUint(string(data[i+1:i+3]), 16, 8)
+
+					if err == nil {
+						writeRune(decodeWindows1252Byte(byte(value)))
+					}
+				}
+
+				i += 3
+			case next == '\r' || next == '\n':
+				writeRune('\n')
+				i++
+			case (next >= 'a' && next <= 'z') || (next >= 'A' && next <= 'Z'):
+				start := i
+
+				for i < len(data) && ((data[i] >= 'a' && data[i] <= 'z') || (data[i] >= 'A' && data[i] <= 'Z')) {
+					i++
+				}
+
+				word := string(data[start:i])
+				parameterStart := i
+
+				if i < len(data) && data[i] == '-' {
+					i++
+				}
+
+				for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+					i++
+				}
+
+				parameter, hasParameter := 0, i > parameterStart
+
+				if hasParameter {
+					parameter, _ = strconv.Atoi(string(data[parameterStart:i]))
+				}
+
+				// A space delimits the control word and is not part of the text.
+				if i < len(data) && data[i] == ' ' {
+					i++
+				}
+
+				switch {
+				case rtfSkippedDestinations[word]:
+					state.IsSkipped = true
+				case word == "par" || word == "line" || word == "row" || word == "sect" || word == "page":
+					writeRune('\n')
+				case word == "tab" || word == "cell":
+					writeRune('\t')
+				case word == "emdash":
+					writeRune('—')
+				case word == "endash":
+					writeRune('–')
+				case word == "lquote":
+					writeRune('‘')
+				case word == "rquote":
+					writeRune('’')
+				case word == "ldblquote":
+					writeRune('“')
+				case word == "rdblquote":
+					writeRune('”')
+				case word == "bullet":
+					writeRune('•')
+				case word == "uc" && hasParameter:
+					state.UnicodeSkip = parameter
+				case word == "u" && hasParameter:
+					if parameter < 0 {
+						parameter += 65536
+					}
+
+					skipCharacters = 0
+					writeRune(rune(parameter))
+					skipCharacters = state.UnicodeSkip
+				case word == "bin" && hasParameter:
+					// Binary data is skipped.
+					if parameter > 0 {
+						i += parameter
+					}
+				}
+			default:
+				i++
+			}
+		default:
+			writeRune(decodeWindows1252Byte(character))
+			i++
+		}
+	}
+
+	return text.String(), nil
+}
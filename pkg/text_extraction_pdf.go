@@ -0,0 +1,1114 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// The PDF text extraction reads every object of the file instead of following the cross-reference table, so damaged
+// files and files with incremental updates are still readable. Text is extracted from the content streams of the pages
+// (and the forms they use), decoded by the ToUnicode maps of the fonts or as Windows-1252 otherwise.
+// Scanned documents contain images instead of text, OCR is not supported.
+
+// Constants defining the PDF extraction limits.
+const (
+	maxPDFDecodedStreamSize = 100 << 20
+	maxPDFFormDepth         = 5
+	maxPDFPageTreeDepth     = 64
+)
+
+// pdfName represents a PDF name (/Name).
+type pdfName string
+
+// pdfString represents a PDF string, the bytes are encoded by the font.
+type pdfString []byte
+
+// pdfKeyword represents a PDF keyword or content stream operator.
+type pdfKeyword string
+
+// pdfReference represents an indirect reference (1 0 R).
+type pdfReference struct {
+	Number     int
+	Generation int
+}
+
+// pdfDictionary represents a PDF dictionary.
+type pdfDictionary map[pdfName]interface{}
+
+// pdfStream represents a PDF stream with its (encoded) data.
+type pdfStream struct {
+	Dictionary pdfDictionary
+	Data       []byte
+}
+
+// pdfLexer reads PDF tokens (the syntax is shared by the file and the content streams).
+type pdfLexer struct {
+	data     []byte
+	position int
+}
+
+// isPDFWhitespace returns true if the byte is PDF whitespace.
+func isPDFWhitespace(character byte) bool {
+	return character == ' ' || character == '\n' || character == '\r' || character == '\t' || character == '\f' || character == 0
+}
+
+// isPDFDelimiter returns true if the byte is a PDF delimiter.
+func isPDFDelimiter(character byte) bool {
+	return strings.IndexByte("()<>[]{}/%", character) != -1
+}
+
+// skipWhitespace skips whitespace and comments.
+func (lexer *pdfLexer) skipWhitespace() {
+	for lexer.position < len(lexer.data) {
+		character := lexer.data[lexer.position]
+
+		if isPDFWhitespace(character) {
+			lexer.position++
+		} else if character == '%' {
+			for lexer.position < len(lexer.data) && lexer.data[lexer.position] != '\n' && lexer.data[lexer.position] != '\r' {
+				lexer.position++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+// pdfDictionaryStart, pdfDictionaryEnd, pdfArrayStart and pdfArrayEnd are the structural tokens.
+const (
+	pdfDictionaryStart = pdfKeyword("<<")
+	pdfDictionaryEnd   = pdfKeyword(">>")
+	pdfArrayStart      = pdfKeyword("[")
+	pdfArrayEnd        = pdfKeyword("]")
+)
+
+// errPDFEnd is returned when there are no more tokens.
+var errPDFEnd = errors.New("unexpected end of PDF data")
+
+// nextToken returns the next token: a float64, pdfName, pdfString, pdfKeyword or bool (nil for null).
+func (lexer *pdfLexer) nextToken() (interface{}, error) {
+	lexer.skipWhitespace()
+
+	if lexer.position >= len(lexer.data) {
+		return nil, errPDFEnd
+	}
+
+	character := lexer.data[lexer.position]
+
+	switch {
+	case character == '<' && lexer.position+1 < len(lexer.data) && lexer.data[lexer.position+1] == '<':
+		lexer.position += 2
+		return pdfDictionaryStart, nil
+	case character == '>' && lexer.position+1 < len(lexer.data) && lexer.data[lexer.position+1] == '>':
+		lexer.position += 2
+		return pdfDictionaryEnd, nil
+	case character == '[' || character == ']' || character == '{' || character == '}':
+		lexer.position++
+		return pdfKeyword(character), nil
+	case character == '/':
+		return lexer.readName(), nil
+	case character == '(':
+		return lexer.readLiteralString(), nil
+	case character == '<':
+		return lexer.readHexString(), nil
+	case character == '+' || character == '-' || character == '.' || (character >= '0' && character <= '9'):
+		start := lexer.position
+
+		for lexer.position < len(lexer.data) && !isPDFWhitespace(lexer.data[lexer.position]) && !isPDFDelimiter(lexer.data[lexer.position]) {
+			lexer.position++
+		}
+
+		number, err := strconv.ParseFloat(string(lexer.data[start:lexer.position]), 64)
+
+		if err != nil {
+			// Malformed numbers (for example "--1") are read as zero like most PDF readers.
+			return 0.0, nil
+		}
+
+		return number, nil
+	default:
+		start := lexer.position
+
+		for lexer.position < len(lexer.data) && !isPDFWhitespace(lexer.data[lexer.position]) && !isPDFDelimiter(lexer.data[lexer.position]) {
+			lexer.position++
+		}
+
+		if lexer.position == start {
+			// Unbalanced delimiter, skip it.
+			lexer.position++
+			return pdfKeyword(character), nil
+		}
+
+		keyword := string(lexer.data[start:lexer.position])
+
+		switch keyword {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+
+		return pdfKeyword(keyword), nil
+	}
+}
+
+// readName reads a name, decoding #xx escapes.
+func (lexer *pdfLexer) readName() pdfName {
+	lexer.position++
+
+	var name []byte
+
+	for lexer.position < len(lexer.data) && !isPDFWhitespace(lexer.data[lexer.position]) && !isPDFDelimiter(lexer.data[lexer.position]) {
+		character := lexer.data[lexer.position]
+
+		if character == '#' && lexer.position+2 < len(lexer.data) {
+			if value, err := strconv.ParseUint(string(lexer.data[lexer.position+1:lexer.position+3]), 16, 8); err == nil {
+				name = append(name, byte(value))
+				lexer.position += 3
+				continue
+			}
+		}
+
+		name = append(name, character)
+		lexer.position++
+	}
+
+	return pdfName(name)
+}
+
+// readLiteralString reads a (literal string) with nested parentheses and escapes.
+func (lexer *pdfLexer) readLiteralString() pdfString {
+	lexer.position++
+
+	var value []byte
+
+	depth := 1
+
+	for lexer.position < len(lexer.data) {
+		character := lexer.data[lexer.position]
+		lexer.position++
+
+		switch character {
+		case '(':
+			depth++
+		case ')':
+			depth--
+
+			if depth == 0 {
+				return value
+			}
+		case '\\':
+			if lexer.position >= len(lexer.data) {
+				return value
+			}
+
+			escaped := lexer.data[lexer.position]
+			lexer.position++
+
+			switch escaped {
+			case 'n':
+				value = append(value, '\n')
+			case 'r':
+				value = append(value, '\r')
+			case 't':
+				value = append(value, '\t')
+			case 'b':
+				value = append(value, '\b')
+			case 'f':
+				value = append(value, '\f')
+			case '\r':
+				// Line continuation.
+				if lexer.position < len(lexer.data) && lexer.data[lexer.position] == '\n' {
+					lexer.position++
+				}
+			case '\n':
+				// Line continuation.
+			default:
+				if escaped >= '0' && escaped <= '7' {
+					octal := int(escaped - '0')
+
+					for i := 0; i < 2 && lexer.position < len(lexer.data) && lexer.data[lexer.position] >= '0' && lexer.data[lexer.position] <= '7'; i++ {
+						octal = octal*8 + int(lexer.data[lexer.position]-'0')
+						lexer.position++
+					}
+
+					value = append(value, byte(octal))
+				} else {
+					value = append(value, escaped)
+				}
+			}
+
+			continue
+		}
+
+		value = append(value, character)
+	}
+
+	return value
+}
+
+// readHexString reads a <hex string>.
+func (lexer *pdfLexer) readHexString() pdfString {
+	lexer.position++
+
+	var digits []byte
+
+	for lexer.position < len(lexer.data) && lexer.data[lexer.position] != '>' {
+		character := lexer.data[lexer.position]
+
+		if (character >= '0' && character <= '9') || (character >= 'a' && character <= 'f') || (character >= 'A' && character <= 'F') {
+			digits = append(digits, character)
+		}
+
+		lexer.position++
+	}
+
+	lexer.position++
+
+	// An odd number of digits is padded with zero.
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	value := make([]byte, len(digits)/2)
+
+	if _, err := hex.Decode(value, digits); err != nil {
+		return nil
+	}
+
+	return value
+}
+
+// readObject reads a value: tokens combined into dictionaries, arrays and references.
+func (lexer *pdfLexer) readObject() (interface{}, error) {
+	token, err := lexer.nextToken()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lexer.readObjectFromToken(token, 0)
+}
+
+// readObjectFromToken reads the value starting with the token.
+func (lexer *pdfLexer) readObjectFromToken(token interface{}, depth int) (interface{}, error) {
+	if depth > 100 {
+		return nil, errors.New("PDF object nesting is too deep")
+	}
+
+	switch token {
+	case pdfDictionaryStart:
+		dictionary := pdfDictionary{}
+
+		for {
+			keyToken, err := lexer.nextToken()
+
+			if err != nil {
+				return dictionary, err
+			}
+
+			if keyToken == pdfDictionaryEnd {
+				return dictionary, nil
+			}
+
+			key, ok := keyToken.(pdfName)
+
+			if !ok {
+				// Invalid key, skip it.
+				continue
+			}
+
+			valueToken, err := lexer.nextToken()
+
+			if err != nil {
+				return dictionary, err
+			}
+
+			if valueToken == pdfDictionaryEnd {
+				return dictionary, nil
+			}
+
+			value, err := lexer.readObjectFromToken(valueToken, depth+1)
+
+			if err != nil {
+				return dictionary, err
+			}
+
+			dictionary[key] = value
+		}
+	case pdfArrayStart:
+		var array []interface{}
+
+		for {
+			elementToken, err := lexer.nextToken()
+
+			if err != nil {
+				return array, err
+			}
+
+			if elementToken == pdfArrayEnd {
+				return array, nil
+			}
+
+			element, err := lexer.readObjectFromToken(elementToken, depth+1)
+
+			if err != nil {
+				return array, err
+			}
+
+			array = append(array, element)
+		}
+	}
+
+	// A number followed by a number and R is a reference.
+	if number, ok := token.(float64); ok {
+		position := lexer.position
+		generationToken, err := lexer.nextToken()
+
+		if generation, isNumber := generationToken.(float64); err == nil && isNumber {
+			if referenceToken, err := lexer.nextToken(); err == nil && referenceToken == pdfKeyword("R") {
+				return pdfReference{Number: int(number), Generation: int(generation)}, nil
+			}
+		}
+
+		lexer.position = position
+	}
+
+	return token, nil
+}
+
+// pdfDocument represents the objects of a PDF file.
+type pdfDocument struct {
+	objects  map[int]interface{}
+	trailers []pdfDictionary
+	// fontDecoders caches the decoders by font object.
+	fontDecoders map[pdfReference]*pdfFontDecoder
+}
+
+// pdfObjectRegexp matches the start of an indirect object.
+var pdfObjectRegexp = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// pdfTrailerRegexp matches the start of a trailer dictionary.
+var pdfTrailerRegexp = regexp.MustCompile(`trailer\s*<<`)
+
+// newPDFDocument reads the objects of the PDF file.
+func newPDFDocument(data []byte) *pdfDocument {
+	document := &pdfDocument{
+		objects:      make(map[int]interface{}),
+		fontDecoders: make(map[pdfReference]*pdfFontDecoder),
+	}
+
+	var objectStreams []*pdfStream
+
+	for _, match := range pdfObjectRegexp.FindAllSubmatchIndex(data, -1) {
+		number, err := strconv.Atoi(string(data[match[2]:match[3]]))
+
+		if err != nil {
+			continue
+		}
+
+		lexer := &pdfLexer{data: data, position: match[1]}
+		object, err := lexer.readObject()
+
+		if err != nil && object == nil {
+			continue
+		}
+
+		if dictionary, ok := object.(pdfDictionary); ok {
+			if stream := readPDFStream(dictionary, lexer); stream != nil {
+				object = stream
+
+				switch dictionary["Type"] {
+				case pdfName("ObjStm"):
+					objectStreams = append(objectStreams, stream)
+				case pdfName("XRef"):
+					document.trailers = append(document.trailers, dictionary)
+				}
+			}
+		}
+
+		// Later definitions (incremental updates) replace earlier ones.
+		document.objects[number] = object
+	}
+
+	for _, match := range pdfTrailerRegexp.FindAllIndex(data, -1) {
+		lexer := &pdfLexer{data: data, position: match[1] - 2}
+
+		if trailer, err := lexer.readObject(); err == nil {
+			if dictionary, ok := trailer.(pdfDictionary); ok {
+				document.trailers = append(document.trailers, dictionary)
+			}
+		}
+	}
+
+	for _, objectStream := range objectStreams {
+		document.readObjectStream(objectStream)
+	}
+
+	return document
+}
+
+// readPDFStream reads the stream data following the stream dictionary, returns nil if the object is not a stream.
+func readPDFStream(dictionary pdfDictionary, lexer *pdfLexer) *pdfStream {
+	lexer.skipWhitespace()
+
+	if !bytes.HasPrefix(lexer.data[lexer.position:], []byte("stream")) {
+		return nil
+	}
+
+	start := lexer.position + len("stream")
+
+	// The keyword is followed by CRLF or LF.
+	if start < len(lexer.data) && lexer.data[start] == '\r' {
+		start++
+	}
+
+	if start < len(lexer.data) && lexer.data[start] == '\n' {
+		start++
+	}
+
+	// Prefer the length in the dictionary, it may be wrong in damaged files.
+	if length, ok := dictionary["Length"].(float64); ok && length >= 0 && start+int(length) <= len(lexer.data) {
+		end := start + int(length)
+		after := bytes.TrimLeft(lexer.data[end:minInt(end+32, len(lexer.data))], " \r\n\t")
+
+		if bytes.HasPrefix(after, []byte("endstream")) {
+			return &pdfStream{Dictionary: dictionary, Data: lexer.data[start:end]}
+		}
+	}
+
+	end := bytes.Index(lexer.data[start:], []byte("endstream"))
+
+	if end == -1 {
+		return &pdfStream{Dictionary: dictionary, Data: lexer.data[start:]}
+	}
+
+	return &pdfStream{Dictionary: dictionary, Data: bytes.TrimRight(lexer.data[start:start+end], "\r\n")}
+}
+
+// minInt returns the smallest integer.
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// readObjectStream reads the objects compressed in an object stream (PDF 1.5), objects outside of it take precedence.
+func (document *pdfDocument) readObjectStream(objectStream *pdfStream) {
+	data, err := document.decodeStream(objectStream)
+
+	if err != nil {
+		return
+	}
+
+	count, _ := document.resolve(objectStream.Dictionary["N"]).(float64)
+	first, _ := document.resolve(objectStream.Dictionary["First"]).(float64)
+
+	if first < 0 || int(first) > len(data) {
+		return
+	}
+
+	header := &pdfLexer{data: data[:int(first)]}
+
+	for i := 0; i < int(count); i++ {
+		numberToken, err := header.nextToken()
+
+		if err != nil {
+			return
+		}
+
+		offsetToken, err := header.nextToken()
+
+		if err != nil {
+			return
+		}
+
+		number, numberOK := numberToken.(float64)
+		offset, offsetOK := offsetToken.(float64)
+
+		if !numberOK || !offsetOK || int(first+offset) >= len(data) {
+			continue
+		}
+
+		if _, exists := document.objects[int(number)]; exists {
+			continue
+		}
+
+		lexer := &pdfLexer{data: data, position: int(first + offset)}
+
+		if object, err := lexer.readObject(); err == nil {
+			document.objects[int(number)] = object
+		}
+	}
+}
+
+// resolve returns the object of a reference, other values are returned as is.
+func (document *pdfDocument) resolve(value interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		reference, ok := value.(pdfReference)
+
+		if !ok {
+			return value
+		}
+
+		value = document.objects[reference.Number]
+	}
+
+	return nil
+}
+
+// resolveDictionary returns the dictionary of the value (or of the stream), nil if it is not a dictionary.
+func (document *pdfDocument) resolveDictionary(value interface{}) pdfDictionary {
+	switch value := document.resolve(value).(type) {
+	case pdfDictionary:
+		return value
+	case *pdfStream:
+		return value.Dictionary
+	}
+
+	return nil
+}
+
+// isEncrypted returns true if the trailer references an encryption dictionary.
+func (document *pdfDocument) isEncrypted() bool {
+	for _, trailer := range document.trailers {
+		if _, ok := trailer["Encrypt"]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeStream decodes the stream data by its filters.
+func (document *pdfDocument) decodeStream(stream *pdfStream) ([]byte, error) {
+	data := stream.Data
+
+	var filters []interface{}
+
+	switch filter := document.resolve(stream.Dictionary["Filter"]).(type) {
+	case pdfName:
+		filters = []interface{}{filter}
+	case []interface{}:
+		filters = filter
+	}
+
+	for _, filter := range filters {
+		var err error
+
+		switch document.resolve(filter) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			data, err = decodeFlate(data)
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			lexer := &pdfLexer{data: append(append([]byte("<"), data...), '>')}
+			data = lexer.readHexString()
+		case pdfName("ASCII85Decode"), pdfName("A85"):
+			data, err = decodeASCII85(data)
+		default:
+			return nil, errors.New("unsupported PDF filter")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// decodeFlate decompresses zlib data, returning what could be decompressed from truncated streams.
+func decodeFlate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxPDFDecodedStreamSize))
+
+	if err != nil && len(decoded) == 0 {
+		return nil, err
+	}
+
+	return decoded, nil
+}
+
+// decodeASCII85 decodes ASCII base-85 data ending with ~>.
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+
+	if end := bytes.Index(data, []byte("~>")); end != -1 {
+		data = data[:end]
+	}
+
+	decoded := make([]byte, 4*len(data)/5+4)
+	decodedLength, _, err := ascii85.Decode(decoded, data, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decoded[:decodedLength], nil
+}
+
+// getPages returns the page dictionaries in order.
+func (document *pdfDocument) getPages() []pdfDictionary {
+	var pages []pdfDictionary
+
+	visited := make(map[pdfReference]bool)
+
+	var walk func(node interface{}, depth int)
+
+	walk = func(node interface{}, depth int) {
+		if depth > maxPDFPageTreeDepth {
+			return
+		}
+
+		// Guard against cycles in damaged page trees.
+		if reference, ok := node.(pdfReference); ok {
+			if visited[reference] {
+				return
+			}
+
+			visited[reference] = true
+		}
+
+		dictionary := document.resolveDictionary(node)
+
+		if dictionary == nil {
+			return
+		}
+
+		if kids, ok := document.resolve(dictionary["Kids"]).([]interface{}); ok {
+			for _, kid := range kids {
+				walk(kid, depth+1)
+			}
+		} else if dictionary["Type"] == pdfName("Page") || dictionary["Contents"] != nil {
+			pages = append(pages, dictionary)
+		}
+	}
+
+	for i := len(document.trailers) - 1; i >= 0 && len(pages) == 0; i-- {
+		if catalog := document.resolveDictionary(document.trailers[i]["Root"]); catalog != nil {
+			walk(catalog["Pages"], 0)
+		}
+	}
+
+	if len(pages) > 0 {
+		return pages
+	}
+
+	// The page tree is damaged, use every page object in object order.
+	var numbers []int
+
+	for number, object := range document.objects {
+		if dictionary, ok := object.(pdfDictionary); ok && dictionary["Type"] == pdfName("Page") {
+			numbers = append(numbers, number)
+		}
+	}
+
+	sort.Ints(numbers)
+
+	for _, number := range numbers {
+		pages = append(pages, document.objects[number].(pdfDictionary))
+	}
+
+	return pages
+}
+
+// getPageResources returns the resources of the page, inherited from the page tree if the page has none.
+func (document *pdfDocument) getPageResources(page pdfDictionary) pdfDictionary {
+	node := page
+
+	for i := 0; i < maxPDFPageTreeDepth && node != nil; i++ {
+		if resources := document.resolveDictionary(node["Resources"]); resources != nil {
+			return resources
+		}
+
+		node = document.resolveDictionary(node["Parent"])
+	}
+
+	return pdfDictionary{}
+}
+
+// getContents returns the decoded content of the page (Contents may be a stream or an array of streams).
+func (document *pdfDocument) getContents(page pdfDictionary) []byte {
+	var contents []interface{}
+
+	switch value := document.resolve(page["Contents"]).(type) {
+	case *pdfStream:
+		contents = []interface{}{value}
+	case []interface{}:
+		contents = value
+	}
+
+	var data []byte
+
+	for _, content := range contents {
+		if stream, ok := document.resolve(content).(*pdfStream); ok {
+			if decoded, err := document.decodeStream(stream); err == nil {
+				data = append(data, decoded...)
+				data = append(data, '\n')
+			}
+		}
+	}
+
+	return data
+}
+
+// pdfCodeRange maps a range of character codes to unicode.
+type pdfCodeRange struct {
+	Low         uint32
+	High        uint32
+	Destination []rune
+	// Destinations is set for ranges mapped to an array of strings.
+	Destinations [][]rune
+}
+
+// pdfFontDecoder decodes the character codes of a font to text.
+type pdfFontDecoder struct {
+	// CodeLength is the length of the character codes in bytes (1 or 2).
+	CodeLength int
+	Characters map[uint32][]rune
+	Ranges     []pdfCodeRange
+	// HasUnicodeMap is false if the font has no ToUnicode map, the codes are decoded as Windows-1252.
+	HasUnicodeMap bool
+}
+
+// getFontDecoder returns the decoder of the font.
+func (document *pdfDocument) getFontDecoder(font interface{}) *pdfFontDecoder {
+	reference, isReference := font.(pdfReference)
+
+	if decoder, ok := document.fontDecoders[reference]; isReference && ok {
+		return decoder
+	}
+
+	decoder := &pdfFontDecoder{CodeLength: 1, Characters: make(map[uint32][]rune)}
+
+	if fontDictionary := document.resolveDictionary(font); fontDictionary != nil {
+		if fontDictionary["Subtype"] == pdfName("Type0") {
+			decoder.CodeLength = 2
+		}
+
+		if toUnicode, ok := document.resolve(fontDictionary["ToUnicode"]).(*pdfStream); ok {
+			if data, err := document.decodeStream(toUnicode); err == nil {
+				decoder.readUnicodeMap(data)
+			}
+		}
+	}
+
+	if isReference {
+		document.fontDecoders[reference] = decoder
+	}
+
+	return decoder
+}
+
+// readUnicodeMap reads the bfchar and bfrange mappings of a ToUnicode CMap.
+func (decoder *pdfFontDecoder) readUnicodeMap(data []byte) {
+	lexer := &pdfLexer{data: data}
+
+	var operands []interface{}
+
+	for {
+		token, err := lexer.readObject()
+
+		if err != nil {
+			return
+		}
+
+		keyword, ok := token.(pdfKeyword)
+
+		if !ok {
+			operands = append(operands, token)
+			continue
+		}
+
+		switch keyword {
+		case "endcodespacerange":
+			if len(operands) > 0 {
+				if low, ok := operands[0].(pdfString); ok {
+					decoder.CodeLength = len(low)
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				source, sourceOK := operands[i].(pdfString)
+				destination, destinationOK := operands[i+1].(pdfString)
+
+				if sourceOK && destinationOK {
+					decoder.Characters[getPDFCode(source)] = decodeUTF16BE(destination)
+					decoder.HasUnicodeMap = true
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, lowOK := operands[i].(pdfString)
+				high, highOK := operands[i+1].(pdfString)
+
+				if !lowOK || !highOK {
+					continue
+				}
+
+				codeRange := pdfCodeRange{Low: getPDFCode(low), High: getPDFCode(high)}
+
+				switch destination := operands[i+2].(type) {
+				case pdfString:
+					codeRange.Destination = decodeUTF16BE(destination)
+				case []interface{}:
+					for _, element := range destination {
+						if value, ok := element.(pdfString); ok {
+							codeRange.Destinations = append(codeRange.Destinations, decodeUTF16BE(value))
+						}
+					}
+				}
+
+				decoder.Ranges = append(decoder.Ranges, codeRange)
+				decoder.HasUnicodeMap = true
+			}
+		}
+
+		operands = nil
+	}
+}
+
+// getPDFCode returns the character code of the bytes.
+func getPDFCode(value []byte) uint32 {
+	var code uint32
+
+	for _, character := range value {
+		code = code<<8 | uint32(character)
+	}
+
+	return code
+}
+
+// decodeUTF16BE decodes the UTF-16BE destination of a CMap.
+func decodeUTF16BE(value []byte) []rune {
+	codeUnits := make([]uint16, len(value)/2)
+
+	for i := range codeUnits {
+		codeUnits[i] = uint16(value[2*i])<<8 | uint16(value[2*i+1])
+	}
+
+	return utf16.Decode(codeUnits)
+}
+
+// decode decodes a string shown with the font.
+func (decoder *pdfFontDecoder) decode(value pdfString, text *strings.Builder) {
+	if !decoder.HasUnicodeMap {
+		if decoder.CodeLength == 1 {
+			for _, character := range value {
+				text.WriteRune(decodeWindows1252Byte(character))
+			}
+		}
+
+		// Two byte codes without a map are glyph identifiers, these can not be decoded to text.
+		return
+	}
+
+	for i := 0; i+decoder.CodeLength <= len(value); i += decoder.CodeLength {
+		code := getPDFCode(value[i : i+decoder.CodeLength])
+
+		if characters, ok := decoder.Characters[code]; ok {
+			text.WriteString(string(characters))
+			continue
+		}
+
+		for _, codeRange := range decoder.Ranges {
+			if code < codeRange.Low || code > codeRange.High {
+				continue
+			}
+
+			offset := code - codeRange.Low
+
+			if codeRange.Destinations != nil {
+				if int(offset) < len(codeRange.Destinations) {
+					text.WriteString(string(codeRange.Destinations[offset]))
+				}
+			} else if len(codeRange.Destination) > 0 {
+				// The last character is incremented through the range.
+				characters := append([]rune{}, codeRange.Destination...)
+				characters[len(characters)-1] += rune(offset)
+				text.WriteString(string(characters))
+			}
+
+			break
+		}
+	}
+}
+
+// extractPDFText extracts the text of the pages of a PDF file.
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.Contains(data[:minInt(1024, len(data))], []byte("%PDF-")) {
+		return "", errors.New("missing PDF header")
+	}
+
+	document := newPDFDocument(data)
+
+	if document.isEncrypted() {
+		return "", ErrTextExtractionEncrypted
+	}
+
+	var text strings.Builder
+
+	for _, page := range document.getPages() {
+		document.extractContentText(document.getContents(page), document.getPageResources(page), 0, &text)
+		text.WriteString("\n\n")
+
+		if text.Len() > MaxExtractedTextSize {
+			break
+		}
+	}
+
+	return text.String(), nil
+}
+
+// extractContentText interprets the text operators of a content stream.
+func (document *pdfDocument) extractContentText(content []byte, resources pdfDictionary, depth int, text *strings.Builder) {
+	fonts := document.resolveDictionary(resources["Font"])
+	xObjects := document.resolveDictionary(resources["XObject"])
+	decoder := &pdfFontDecoder{CodeLength: 1}
+	lexer := &pdfLexer{data: content}
+	lastY := 0.0
+
+	var operands []interface{}
+
+	for text.Len() <= MaxExtractedTextSize {
+		token, err := lexer.readObject()
+
+		if err != nil {
+			return
+		}
+
+		operator, ok := token.(pdfKeyword)
+
+		if !ok {
+			operands = append(operands, token)
+			continue
+		}
+
+		switch operator {
+		case "Tf":
+			if len(operands) >= 2 {
+				if fontName, ok := operands[len(operands)-2].(pdfName); ok && fonts != nil {
+					decoder = document.getFontDecoder(fonts[fontName])
+				}
+			}
+		case "Tj":
+			if len(operands) > 0 {
+				if value, ok := operands[len(operands)-1].(pdfString); ok {
+					decoder.decode(value, text)
+				}
+			}
+		case "'", "\"":
+			text.WriteString("\n")
+
+			if len(operands) > 0 {
+				if value, ok := operands[len(operands)-1].(pdfString); ok {
+					decoder.decode(value, text)
+				}
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				if array, ok := operands[len(operands)-1].([]interface{}); ok {
+					for _, element := range array {
+						switch element := element.(type) {
+						case pdfString:
+							decoder.decode(element, text)
+						case float64:
+							// A large negative adjustment (in thousandths of the font size) is a word gap.
+							if element < -200 {
+								text.WriteString(" ")
+							}
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if y, ok := operands[len(operands)-1].(float64); ok && y != 0 {
+					text.WriteString("\n")
+				} else {
+					text.WriteString(" ")
+				}
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				if y, ok := operands[len(operands)-1].(float64); ok {
+					if y != lastY {
+						text.WriteString("\n")
+					} else {
+						text.WriteString(" ")
+					}
+
+					lastY = y
+				}
+			}
+		case "T*":
+			text.WriteString("\n")
+		case "ET":
+			text.WriteString("\n")
+		case "Do":
+			// Forms are content streams with their own resources.
+			if len(operands) > 0 && xObjects != nil && depth < maxPDFFormDepth {
+				if name, ok := operands[len(operands)-1].(pdfName); ok {
+					if form, ok := document.resolve(xObjects[name]).(*pdfStream); ok && form.Dictionary["Subtype"] == pdfName("Form") {
+						if formContent, err := document.decodeStream(form); err == nil {
+							formResources := document.resolveDictionary(form.Dictionary["Resources"])
+
+							if formResources == nil {
+								formResources = resources
+							}
+
+							document.extractContentText(formContent, formResources, depth+1, text)
+						}
+					}
+				}
+			}
+		case "BI":
+			// Skip the inline image data, it is binary.
+			end := bytes.Index(content[lexer.position:], []byte("EI"))
+
+			for end != -1 {
+				position := lexer.position + end
+
+				if position > 0 && isPDFWhitespace(content[position-1]) && (position+2 >= len(content) || isPDFWhitespace(content[position+2])) {
+					break
+				}
+
+				next := bytes.Index(content[position+2:], []byte("EI"))
+
+				if next == -1 {
+					end = -1
+				} else {
+					end += 2 + next
+				}
+			}
+
+			if end == -1 {
+				return
+			}
+
+			lexer.position += end + 2
+		}
+
+		operands = nil
+	}
+}
@@ -0,0 +1,630 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	core "github.com/mooijtech/goforensics-core/pkg"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Constants defining the limits of thread reconstruction.
+const (
+	// MaxThreadMessages is the maximum amount of messages in a thread, larger threads are truncated.
+	MaxThreadMessages = 1000
+	// maxThreadSearches is the maximum amount of searches following the references of the thread.
+	maxThreadSearches = 10
+	// maxThreadSearchIDs is the maximum amount of message IDs searched per search.
+	maxThreadSearchIDs = 100
+	// maxThreadSearchHits is the maximum amount of search hits read to reconstruct a thread. A common conversation
+	// topic (such as "Meeting") matches many messages which are not in the thread.
+	maxThreadSearchHits = 20000
+)
+
+// errThreadTruncated stops reading the thread search hits when the thread is truncated.
+var errThreadTruncated = errors.New("the thread is truncated")
+
+// Constants defining how a message relates to its parent in the thread.
+const (
+	ThreadMessageTypeOriginal = "ORIGINAL"
+	ThreadMessageTypeReply    = "REPLY"
+	ThreadMessageTypeForward  = "FORWARD"
+)
+
+// Constants defining why a message is inclusive.
+const (
+	// InclusiveReasonLast is used when no message replies to or forwards the message.
+	InclusiveReasonLast = "LAST"
+	// InclusiveReasonText is used when no reply or forward contains the text of the message.
+	InclusiveReasonText = "TEXT"
+	// InclusiveReasonAttachments is used when no reply or forward contains the attachments of the message.
+	InclusiveReasonAttachments = "ATTACHMENTS"
+)
+
+// Constants defining the structure of the Outlook conversation index.
+// The header identifies the conversation, every reply or forward adds a child block.
+const (
+	conversationIndexHeaderSize     = 22
+	conversationIndexChildBlockSize = 5
+)
+
+// messageIDRegexp matches the message IDs in the Message-ID, In-Reply-To and References headers.
+var messageIDRegexp = regexp.MustCompile(`<([^<>\s]+)>`)
+
+// replySubjectPrefixes and forwardSubjectPrefixes are the (localized) subject prefixes of replies and forwards.
+var (
+	replySubjectPrefixes   = []string{"re", "aw", "sv", "antw", "vs", "ref", "rif"}
+	forwardSubjectPrefixes = []string{"fw", "fwd", "wg", "tr", "doorst", "rv", "vl", "enc"}
+)
+
+// Thread represents a reconstructed conversation.
+type Thread struct {
+	// Key identifies the thread by the conversation index or the message ID of the first message.
+	Key                   string        `json:"key"`
+	Topic                 string        `json:"topic"`
+	MessageCount          int           `json:"messageCount"`
+	InclusiveMessageUUIDs []string      `json:"inclusiveMessageUUIDs"`
+	Truncated             bool          `json:"truncated"`
+	Roots                 []*ThreadNode `json:"roots"`
+}
+
+// ThreadNode represents a message in the thread.
+type ThreadNode struct {
+	MessageUUID string `json:"messageUUID"`
+	// DuplicateUUIDs contains the copies of the message (with the same message ID) in other evidence or folders.
+	DuplicateUUIDs  []string      `json:"duplicateUUIDs"`
+	MessageID       string        `json:"messageID"`
+	From            string        `json:"from"`
+	Subject         string        `json:"subject"`
+	Date            int           `json:"date"`
+	Type            string        `json:"type"`
+	IsInclusive     bool          `json:"isInclusive"`
+	InclusiveReason string        `json:"inclusiveReason,omitempty"`
+	Children        []*ThreadNode `json:"children"`
+
+	message           core.Message
+	conversationIndex []byte
+	parent            *ThreadNode
+}
+
+// handleThread handles the thread endpoint, returning the conversation of the message.
+func (server *Server) handleThread() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			message, err := core.GetMessageByUUID(mux.Vars(request)["messageUUID"], project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get message: %s", err)
+				http.Error(responseWriter, "Failed to get message.", http.StatusNotFound)
+				return
+			}
+
+			threadMessages, truncated, err := GetThreadMessages(request.Context(), message, project.UUID)
+
+			if err != nil {
+				Logger.Errorf("Failed to get thread messages: %s", err)
+				http.Error(responseWriter, "Failed to get thread messages.", http.StatusInternalServerError)
+				return
+			}
+
+			thread := NewThread(threadMessages)
+			thread.Truncated = truncated
+
+			if err := json.NewEncoder(responseWriter).Encode(&thread); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// GetMessageIDs returns the message IDs (without angle brackets) in the header value.
+func GetMessageIDs(headerValue string) []string {
+	var messageIDs []string
+
+	for _, match := range messageIDRegexp.FindAllStringSubmatch(headerValue, -1) {
+		messageIDs = append(messageIDs, match[1])
+	}
+
+	// Some clients omit the angle brackets.
+	if len(messageIDs) == 0 {
+		for _, field := range strings.Fields(headerValue) {
+			if strings.Contains(field, "@") {
+				messageIDs = append(messageIDs, strings.Trim(field, "<>,"))
+			}
+		}
+	}
+
+	return messageIDs
+}
+
+// getMessageID returns the message ID of the message.
+func getMessageID(message core.Message) string {
+	if messageIDs := GetMessageIDs(message.MessageID); len(messageIDs) > 0 {
+		return messageIDs[0]
+	}
+
+	return ""
+}
+
+// getMessageReferenceIDs returns the message IDs the message refers to, the direct parent is last.
+func getMessageReferenceIDs(message core.Message) []string {
+	referenceIDs := GetMessageIDs(message.References)
+
+	if inReplyToIDs := GetMessageIDs(message.InReplyTo); len(inReplyToIDs) > 0 {
+		referenceIDs = append(referenceIDs, inReplyToIDs[0])
+	}
+
+	return referenceIDs
+}
+
+// DecodeConversationIndex decodes the (hex or base64 encoded) Outlook conversation index.
+// Returns nil if the conversation index is invalid.
+func DecodeConversationIndex(conversationIndex string) []byte {
+	conversationIndex = strings.TrimSpace(conversationIndex)
+
+	decoded, err := hex.DecodeString(conversationIndex)
+
+	if err != nil {
+		decoded, err = base64.StdEncoding.DecodeString(conversationIndex)
+	}
+
+	if err != nil || len(decoded) < conversationIndexHeaderSize || (len(decoded)-conversationIndexHeaderSize)%conversationIndexChildBlockSize != 0 {
+		return nil
+	}
+
+	return decoded
+}
+
+// getConversationKey returns the key of the conversation (the hex encoded header of the conversation index).
+func getConversationKey(conversationIndex []byte) string {
+	if conversationIndex == nil {
+		return ""
+	}
+
+	return hex.EncodeToString(conversationIndex[:conversationIndexHeaderSize])
+}
+
+// GetThreadMessages returns the messages in the thread of the message, across all evidence of the project.
+// Follows the Message-ID, In-Reply-To and References headers and the Outlook conversation index.
+// Returns true if the thread is truncated at MaxThreadMessages, or if it could not be followed to the end
+// (after maxThreadSearches searches or maxThreadSearchHits hits).
+func GetThreadMessages(ctx context.Context, message core.Message, projectUUID string) ([]core.Message, bool, error) {
+	threadMessages := map[string]core.Message{message.UUID: message}
+	messageIDs := make(map[string]bool)
+	conversationKeys := make(map[string]bool)
+	searchedMessageIDs := make(map[string]bool)
+	searchedTopics := make(map[string]bool)
+	searchHitCount := 0
+
+	addMessageIDs := func(message core.Message) {
+		if messageID := getMessageID(message); messageID != "" {
+			messageIDs[messageID] = true
+		}
+
+		for _, referenceID := range getMessageReferenceIDs(message) {
+			messageIDs[referenceID] = true
+		}
+
+		if conversationKey := getConversationKey(DecodeConversationIndex(message.ConversationIndex)); conversationKey != "" {
+			conversationKeys[conversationKey] = true
+		}
+	}
+
+	// isThreadMessage verifies the search result, the topic and references searches match more than the thread.
+	isThreadMessage := func(message core.Message) bool {
+		if conversationKeys[getConversationKey(DecodeConversationIndex(message.ConversationIndex))] {
+			return true
+		}
+
+		if messageIDs[getMessageID(message)] {
+			return true
+		}
+
+		for _, referenceID := range getMessageReferenceIDs(message) {
+			if messageIDs[referenceID] {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	addMessageIDs(message)
+
+	for i := 0; i < maxThreadSearches; i++ {
+		var searchIDs []string
+		var topics []string
+
+		for messageID := range messageIDs {
+			if !searchedMessageIDs[messageID] && len(searchIDs) < maxThreadSearchIDs {
+				searchIDs = append(searchIDs, messageID)
+				searchedMessageIDs[messageID] = true
+			}
+		}
+
+		for _, threadMessage := range threadMessages {
+			if topic := threadMessage.ConversationTopic; topic != "" && threadMessage.ConversationIndex != "" && !searchedTopics[topic] {
+				topics = append(topics, topic)
+				searchedTopics[topic] = true
+			}
+		}
+
+		if len(searchIDs) == 0 && len(topics) == 0 {
+			return getThreadMessageList(threadMessages), false, nil
+		}
+
+		// The message IDs and the topics are searched separately, so the many messages sharing a topic can't push the
+		// messages found by their IDs out of the hits.
+		var threadQueries []map[string]interface{}
+
+		if len(searchIDs) > 0 {
+			threadQueries = append(threadQueries, newThreadQuery(searchIDs, nil))
+		}
+
+		if len(topics) > 0 {
+			threadQueries = append(threadQueries, newThreadQuery(nil, topics))
+		}
+
+		for _, threadQuery := range threadQueries {
+			err := WalkMessages(ctx, NewProjectQuery(threadQuery, projectUUID), func(hitMessage core.Message) error {
+				searchHitCount++
+
+				if searchHitCount > maxThreadSearchHits {
+					return errThreadTruncated
+				}
+
+				if _, ok := threadMessages[hitMessage.UUID]; ok || !isThreadMessage(hitMessage) {
+					return nil
+				}
+
+				if len(threadMessages) == MaxThreadMessages {
+					return errThreadTruncated
+				}
+
+				threadMessages[hitMessage.UUID] = hitMessage
+				addMessageIDs(hitMessage)
+
+				return nil
+			})
+
+			if errors.Is(err, errThreadTruncated) {
+				return getThreadMessageList(threadMessages), true, nil
+			} else if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	// The thread is truncated if there are references left which were not followed.
+	isTruncated := false
+
+	for messageID := range messageIDs {
+		if !searchedMessageIDs[messageID] {
+			isTruncated = true
+		}
+	}
+
+	for _, threadMessage := range threadMessages {
+		if topic := threadMessage.ConversationTopic; topic != "" && threadMessage.ConversationIndex != "" && !searchedTopics[topic] {
+			isTruncated = true
+		}
+	}
+
+	return getThreadMessageList(threadMessages), isTruncated, nil
+}
+
+// newThreadQuery creates the Elasticsearch query matching messages by message ID, references or conversation topic.
+func newThreadQuery(messageIDs []string, topics []string) map[string]interface{} {
+	var should []interface{}
+
+	if len(messageIDs) > 0 {
+		// The message ID headers are stored with angle brackets.
+		headerValues := make([]string, 0, len(messageIDs)*2)
+
+		for _, messageID := range messageIDs {
+			headerValues = append(headerValues, messageID, "<"+messageID+">")
+		}
+
+		should = append(should, newTermsQuery(MessageFieldMessageID, headerValues), newTermsQuery(MessageFieldInReplyTo, headerValues))
+
+		// The References header can be longer than the keyword field, match the analyzed message IDs.
+		for _, messageID := range messageIDs {
+			should = append(should, map[string]interface{}{
+				"match_phrase": map[string]interface{}{MessageFieldReferences: messageID},
+			})
+		}
+	}
+
+	if len(topics) > 0 {
+		should = append(should, newTermsQuery(MessageFieldConversationTopic, topics))
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+// getThreadMessageList returns the thread messages sorted by date.
+func getThreadMessageList(threadMessages map[string]core.Message) []core.Message {
+	messages := make([]core.Message, 0, len(threadMessages))
+
+	for _, message := range threadMessages {
+		messages = append(messages, message)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].Date != messages[j].Date {
+			return messages[i].Date < messages[j].Date
+		}
+
+		return messages[i].UUID < messages[j].UUID
+	})
+
+	return messages
+}
+
+// NewThread builds the thread tree from the messages (sorted by date).
+// Copies of a message (by message ID) are merged into a single node.
+func NewThread(messages []core.Message) Thread {
+	var nodes []*ThreadNode
+	nodesByMessageID := make(map[string]*ThreadNode)
+
+	for _, message := range messages {
+		messageID := getMessageID(message)
+
+		if node, ok := nodesByMessageID[messageID]; ok && messageID != "" {
+			node.DuplicateUUIDs = append(node.DuplicateUUIDs, message.UUID)
+			continue
+		}
+
+		node := &ThreadNode{
+			MessageUUID:       message.UUID,
+			DuplicateUUIDs:    []string{},
+			MessageID:         messageID,
+			From:              message.From,
+			Subject:           message.Subject,
+			Date:              message.Date,
+			Type:              GetThreadMessageType(message.Subject),
+			Children:          []*ThreadNode{},
+			message:           message,
+			conversationIndex: DecodeConversationIndex(message.ConversationIndex),
+		}
+
+		nodes = append(nodes, node)
+
+		if messageID != "" {
+			nodesByMessageID[messageID] = node
+		}
+	}
+
+	thread := Thread{
+		MessageCount:          len(messages),
+		InclusiveMessageUUIDs: []string{},
+		Roots:                 []*ThreadNode{},
+	}
+
+	for _, node := range nodes {
+		if parent := getThreadNodeParent(node, nodes, nodesByMessageID); parent != nil {
+			node.parent = parent
+			parent.Children = append(parent.Children, node)
+		} else {
+			thread.Roots = append(thread.Roots, node)
+		}
+	}
+
+	for _, node := range nodes {
+		setThreadNodeInclusive(node)
+
+		if node.IsInclusive {
+			thread.InclusiveMessageUUIDs = append(thread.InclusiveMessageUUIDs, node.MessageUUID)
+		}
+	}
+
+	if len(thread.Roots) > 0 {
+		root := thread.Roots[0]
+
+		thread.Key = root.MessageID
+		thread.Topic = root.message.ConversationTopic
+
+		if root.conversationIndex != nil {
+			thread.Key = getConversationKey(root.conversationIndex)
+		} else if thread.Key == "" {
+			thread.Key = root.MessageUUID
+		}
+
+		if thread.Topic == "" {
+			thread.Topic = GetThreadTopic(root.Subject)
+		}
+	}
+
+	return thread
+}
+
+// getThreadNodeParent returns the parent of the node by the references or the conversation index.
+// Returns nil if the parent is not in the thread or would create a cycle.
+func getThreadNodeParent(node *ThreadNode, nodes []*ThreadNode, nodesByMessageID map[string]*ThreadNode) *ThreadNode {
+	isValidParent := func(parent *ThreadNode) bool {
+		for ancestor := parent; ancestor != nil; ancestor = ancestor.parent {
+			if ancestor == node {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	referenceIDs := getMessageReferenceIDs(node.message)
+
+	// The direct parent is last, earlier references are used when the parent is missing.
+	for i := len(referenceIDs) - 1; i >= 0; i-- {
+		if parent, ok := nodesByMessageID[referenceIDs[i]]; ok && isValidParent(parent) {
+			return parent
+		}
+	}
+
+	// The conversation index of the parent is the longest prefix of the conversation index.
+	if node.conversationIndex == nil {
+		return nil
+	}
+
+	var parent *ThreadNode
+
+	for _, candidate := range nodes {
+		if len(candidate.conversationIndex) >= len(node.conversationIndex) || !bytes.HasPrefix(node.conversationIndex, candidate.conversationIndex) {
+			continue
+		}
+
+		if (parent == nil || len(candidate.conversationIndex) > len(parent.conversationIndex)) && isValidParent(candidate) {
+			parent = candidate
+		}
+	}
+
+	return parent
+}
+
+// setThreadNodeInclusive marks the node as inclusive if no reply or forward contains the text and attachments.
+func setThreadNodeInclusive(node *ThreadNode) {
+	if len(node.Children) == 0 {
+		node.IsInclusive = true
+		node.InclusiveReason = InclusiveReasonLast
+		return
+	}
+
+	text := normalizeThreadText(node.message.Body)
+	isTextIncluded := false
+	isAttachmentsIncluded := false
+
+	for _, child := range node.Children {
+		if strings.Contains(normalizeThreadText(child.message.Body), text) {
+			isTextIncluded = true
+
+			if hasThreadAttachments(child.message, node.message) {
+				isAttachmentsIncluded = true
+				break
+			}
+		}
+	}
+
+	switch {
+	case !isTextIncluded:
+		node.IsInclusive = true
+		node.InclusiveReason = InclusiveReasonText
+	case !isAttachmentsIncluded:
+		// Replies usually drop the attachments of the message.
+		node.IsInclusive = true
+		node.InclusiveReason = InclusiveReasonAttachments
+	}
+}
+
+// hasThreadAttachments returns true if the message has the attachments (by file name and size) of the parent.
+func hasThreadAttachments(message core.Message, parent core.Message) bool {
+	attachments := make(map[string]int)
+
+	for _, attachment := range message.Attachments {
+		attachments[strings.ToLower(attachment.FileName)] = attachment.Size
+	}
+
+	for _, attachment := range parent.Attachments {
+		// Inline images are not kept by every client.
+		if attachment.ContentID != "" {
+			continue
+		}
+
+		if size, ok := attachments[strings.ToLower(attachment.FileName)]; !ok || size != attachment.Size {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeThreadText removes the quote markers, whitespace and case so quoted text can be compared.
+func normalizeThreadText(text string) string {
+	var words []string
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimLeft(line, "> \t")
+
+		words = append(words, strings.Fields(strings.ToLower(line))...)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// getSubjectPrefix returns the lowercase reply or forward prefix of the subject and the remaining subject.
+func getSubjectPrefix(subject string) (string, string) {
+	subject = strings.TrimSpace(subject)
+	colonIndex := strings.Index(subject, ":")
+
+	if colonIndex <= 0 || colonIndex > 8 {
+		return "", subject
+	}
+
+	// Some clients number the prefix, for example "Re[2]:".
+	prefix := strings.ToLower(strings.TrimSpace(subject[:colonIndex]))
+
+	if bracketIndex := strings.Index(prefix, "["); bracketIndex > 0 {
+		prefix = prefix[:bracketIndex]
+	}
+
+	for _, subjectPrefix := range append(replySubjectPrefixes, forwardSubjectPrefixes...) {
+		if prefix == subjectPrefix {
+			return prefix, strings.TrimSpace(subject[colonIndex+1:])
+		}
+	}
+
+	return "", subject
+}
+
+// GetThreadMessageType returns whether the message is a reply or forward by the subject prefix.
+func GetThreadMessageType(subject string) string {
+	prefix, _ := getSubjectPrefix(subject)
+
+	for _, forwardPrefix := range forwardSubjectPrefixes {
+		if prefix == forwardPrefix {
+			return ThreadMessageTypeForward
+		}
+	}
+
+	if prefix != "" {
+		return ThreadMessageTypeReply
+	}
+
+	return ThreadMessageTypeOriginal
+}
+
+// GetThreadTopic returns the subject without the reply and forward prefixes.
+func GetThreadTopic(subject string) string {
+	for {
+		prefix, remainingSubject := getSubjectPrefix(subject)
+
+		if prefix == "" {
+			return remainingSubject
+		}
+
+		subject = remainingSubject
+	}
+}
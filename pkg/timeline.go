@@ -0,0 +1,511 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Constants defining the timeline intervals.
+const (
+	TimelineIntervalHour  = "hour"
+	TimelineIntervalDay   = "day"
+	TimelineIntervalWeek  = "week"
+	TimelineIntervalMonth = "month"
+)
+
+// Constants defining the timeline modes.
+const (
+	TimelineModeHistogram = "histogram"
+	TimelineModeHeatmap   = "heatmap"
+)
+
+// Constants defining the timeline defaults and limits.
+const (
+	DefaultTimelineInterval = TimelineIntervalDay
+	DefaultTimelineTimezone = "UTC"
+	// MaxTimelineBuckets is the maximum amount of buckets, a larger interval is required for longer periods.
+	MaxTimelineBuckets = 10000
+	// DefaultBusinessHoursStart and DefaultBusinessHoursEnd define the business hours of the heatmap (end exclusive).
+	DefaultBusinessHoursStart = 9
+	DefaultBusinessHoursEnd   = 18
+)
+
+// timelineIntervals maps the timeline intervals to the (approximate) interval in seconds and the bucket date format.
+var timelineIntervals = map[string]struct {
+	Seconds int64
+	Format  string
+}{
+	TimelineIntervalHour:  {Seconds: 3600, Format: "yyyy-MM-dd'T'HH:00"},
+	TimelineIntervalDay:   {Seconds: 86400, Format: "yyyy-MM-dd"},
+	TimelineIntervalWeek:  {Seconds: 604800, Format: "yyyy-MM-dd"},
+	TimelineIntervalMonth: {Seconds: 2629746, Format: "yyyy-MM"},
+}
+
+// HeatmapDays defines the rows of the heatmap (ISO 8601 order).
+var HeatmapDays = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+// dateMillisecondsScript returns the date of the message in milliseconds, skipping messages without a date.
+// Dates are stored as unix timestamps (seconds), Elasticsearch expects milliseconds.
+const dateMillisecondsScript = `
+	if (doc['` + MessageFieldDate + `'].size() == 0) { return null; }
+	return doc['` + MessageFieldDate + `'].value * 1000L;
+`
+
+// heatmapScript returns the day of the week (1 is Monday) times 100 plus the hour of the day in the timezone.
+// Messages without a date are skipped.
+const heatmapScript = `
+	if (doc['` + MessageFieldDate + `'].size() == 0) { return null; }
+	ZonedDateTime date = Instant.ofEpochSecond(doc['` + MessageFieldDate + `'].value).atZone(ZoneId.of(params.timezone));
+	return date.getDayOfWeek().getValue() * 100 + date.getHour();
+`
+
+// TimelineRequest represents the parameters of a timeline request.
+type TimelineRequest struct {
+	Mode               string
+	Interval           string
+	Timezone           string
+	Senders            []string
+	Recipients         []string
+	Tags               []string
+	Query              string
+	BusinessHoursStart int
+	BusinessHoursEnd   int
+}
+
+// TimelineBucket represents the amount of messages in a period.
+type TimelineBucket struct {
+	Date string `json:"date"`
+	// Start is the unix timestamp of the start of the period.
+	Start int64 `json:"start"`
+	Count int   `json:"count"`
+}
+
+// Timeline represents the message counts bucketed by interval.
+type Timeline struct {
+	Interval string           `json:"interval"`
+	Timezone string           `json:"timezone"`
+	Total    int              `json:"total"`
+	Buckets  []TimelineBucket `json:"buckets"`
+}
+
+// Heatmap represents the message counts by day of the week and hour of the day.
+type Heatmap struct {
+	Timezone string   `json:"timezone"`
+	Total    int      `json:"total"`
+	Days     []string `json:"days"`
+	// Counts contains a row of 24 hours per day of Days.
+	Counts             [7][24]int `json:"counts"`
+	BusinessHoursStart int        `json:"businessHoursStart"`
+	BusinessHoursEnd   int        `json:"businessHoursEnd"`
+	// AfterHoursCount is the amount of messages on weekdays outside business hours.
+	AfterHoursCount int `json:"afterHoursCount"`
+	WeekendCount    int `json:"weekendCount"`
+}
+
+// handleTimeline handles the timeline endpoint.
+// Accepts the "mode" (histogram, heatmap), "interval" (hour, day, week, month), "timezone" (IANA name),
+// "sender", "recipient", "tag" (repeatable), "query", "businessHoursStart" and "businessHoursEnd" query parameters.
+func (server *Server) handleTimeline() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			timelineRequest, err := NewTimelineRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Invalid timeline request: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid timeline request: %s.", err), http.StatusBadRequest)
+				return
+			}
+
+			elasticsearchQuery, err := NewTimelineQuery(timelineRequest, project.UUID, server.Database)
+
+			var queryParseError *QueryParseError
+			var timelineError *TimelineError
+
+			if errors.As(err, &queryParseError) {
+				Logger.Errorf("Failed to parse timeline query: %s", err)
+				writeQueryParseError(responseWriter, queryParseError)
+				return
+			} else if errors.As(err, &timelineError) {
+				Logger.Errorf("Invalid timeline request: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid timeline request: %s.", err), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to compile timeline query: %s", err)
+				http.Error(responseWriter, "Failed to compile timeline query.", http.StatusInternalServerError)
+				return
+			}
+
+			var response interface{}
+
+			if timelineRequest.Mode == TimelineModeHeatmap {
+				response, err = NewHeatmap(request.Context(), elasticsearchQuery, timelineRequest)
+			} else {
+				response, err = NewTimeline(request.Context(), elasticsearchQuery, timelineRequest)
+			}
+
+			if errors.As(err, &timelineError) {
+				Logger.Errorf("Invalid timeline request: %s", err)
+				http.Error(responseWriter, fmt.Sprintf("Invalid timeline request: %s.", err), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				Logger.Errorf("Failed to get timeline: %s", err)
+				http.Error(responseWriter, "Failed to get timeline.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(response); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// TimelineError represents a timeline request which can not be answered, such as too many buckets.
+type TimelineError struct {
+	Message string
+}
+
+// Error returns the error message.
+func (timelineError *TimelineError) Error() string {
+	return timelineError.Message
+}
+
+// NewTimelineRequest creates the TimelineRequest from the query parameters.
+func NewTimelineRequest(request *http.Request) (TimelineRequest, error) {
+	queryParameters := request.URL.Query()
+
+	timelineRequest := TimelineRequest{
+		Mode:       strings.ToLower(queryParameters.Get("mode")),
+		Interval:   strings.ToLower(queryParameters.Get("interval")),
+		Timezone:   queryParameters.Get("timezone"),
+		Senders:    queryParameters["sender"],
+		Recipients: queryParameters["recipient"],
+		Tags:       queryParameters["tag"],
+		Query:      strings.TrimSpace(queryParameters.Get("query")),
+	}
+
+	switch timelineRequest.Mode {
+	case "":
+		timelineRequest.Mode = TimelineModeHistogram
+	case TimelineModeHistogram, TimelineModeHeatmap:
+	default:
+		return TimelineRequest{}, fmt.Errorf("the mode must be %s or %s", TimelineModeHistogram, TimelineModeHeatmap)
+	}
+
+	if timelineRequest.Interval == "" {
+		timelineRequest.Interval = DefaultTimelineInterval
+	}
+
+	if _, ok := timelineIntervals[timelineRequest.Interval]; !ok {
+		return TimelineRequest{}, fmt.Errorf("the interval must be hour, day, week or month")
+	}
+
+	if timelineRequest.Timezone == "" {
+		timelineRequest.Timezone = DefaultTimelineTimezone
+	}
+
+	// The local timezone of the server is not meaningful to the client.
+	if _, err := time.LoadLocation(timelineRequest.Timezone); err != nil || timelineRequest.Timezone == "Local" {
+		return TimelineRequest{}, fmt.Errorf("unknown timezone \"%s\", expected an IANA timezone such as Europe/Amsterdam", timelineRequest.Timezone)
+	}
+
+	var err error
+
+	timelineRequest.BusinessHoursStart, err = getQueryParameterInt(request, "businessHoursStart", DefaultBusinessHoursStart, 0, 23)
+
+	if err != nil {
+		return TimelineRequest{}, fmt.Errorf("the businessHoursStart must be an hour between 0 and 23")
+	}
+
+	timelineRequest.BusinessHoursEnd, err = getQueryParameterInt(request, "businessHoursEnd", DefaultBusinessHoursEnd, 1, 24)
+
+	if err != nil || timelineRequest.BusinessHoursEnd <= timelineRequest.BusinessHoursStart {
+		return TimelineRequest{}, fmt.Errorf("the businessHoursEnd must be an hour after businessHoursStart and at most 24")
+	}
+
+	return timelineRequest, nil
+}
+
+// NewTimelineQuery creates the Elasticsearch query of the messages in the timeline.
+// Values of the same filter are combined with OR, different filters with AND.
+// Returns a *QueryParseError if the query is invalid or a *TimelineError if the tags are on too many messages.
+func NewTimelineQuery(timelineRequest TimelineRequest, projectUUID string, database *pgx.Conn) (map[string]interface{}, error) {
+	query := map[string]interface{}{"match_all": map[string]interface{}{}}
+
+	if timelineRequest.Query != "" {
+		queryNode, err := ParseQuery(timelineRequest.Query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		query, err = CompileQuery(queryNode, NewDatabaseQueryResolver(projectUUID, database))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var filters []interface{}
+
+	newShouldQuery := func(queries []interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should":               queries,
+				"minimum_should_match": 1,
+			},
+		}
+	}
+
+	if len(timelineRequest.Senders) > 0 {
+		var senderQueries []interface{}
+
+		for _, sender := range timelineRequest.Senders {
+			senderQueries = append(senderQueries, map[string]interface{}{
+				"match_phrase": map[string]interface{}{MessageFieldFrom: sender},
+			})
+		}
+
+		filters = append(filters, newShouldQuery(senderQueries))
+	}
+
+	if len(timelineRequest.Recipients) > 0 {
+		var recipientQueries []interface{}
+
+		for _, recipient := range timelineRequest.Recipients {
+			recipientQueries = append(recipientQueries,
+				map[string]interface{}{"match_phrase": map[string]interface{}{MessageFieldTo: recipient}},
+				map[string]interface{}{"match_phrase": map[string]interface{}{MessageFieldCC: recipient}},
+			)
+		}
+
+		filters = append(filters, newShouldQuery(recipientQueries))
+	}
+
+	if len(timelineRequest.Tags) > 0 {
+		queryResolver := NewDatabaseQueryResolver(projectUUID, database)
+
+		var tagMessageUUIDs []string
+
+		isTagMessage := make(map[string]bool)
+
+		for _, tag := range timelineRequest.Tags {
+			messageUUIDs, err := queryResolver.GetTagMessageUUIDs(tag)
+
+			if err != nil {
+				return nil, err
+			}
+
+			// Messages can have several of the tags.
+			for _, messageUUID := range messageUUIDs {
+				if !isTagMessage[messageUUID] {
+					isTagMessage[messageUUID] = true
+					tagMessageUUIDs = append(tagMessageUUIDs, messageUUID)
+				}
+			}
+		}
+
+		tagQuery, err := newMessageUUIDsQuery(tagMessageUUIDs)
+
+		if err != nil {
+			return nil, &TimelineError{Message: fmt.Sprintf("tags: %s", err)}
+		}
+
+		filters = append(filters, tagQuery)
+	}
+
+	return NewProjectQuery(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must":   []interface{}{query},
+			"filter": filters,
+		},
+	}, projectUUID), nil
+}
+
+// elasticsearchStatsAggregation represents the response of an Elasticsearch min or max aggregation.
+type elasticsearchStatsAggregation struct {
+	Value *float64 `json:"value"`
+}
+
+// elasticsearchBucketAggregation represents the response of an Elasticsearch bucket aggregation.
+type elasticsearchBucketAggregation struct {
+	Buckets []struct {
+		Key         json.Number `json:"key"`
+		KeyAsString string      `json:"key_as_string"`
+		DocCount    int         `json:"doc_count"`
+	} `json:"buckets"`
+}
+
+// NewTimeline returns the message counts bucketed by the interval in the timezone.
+// Returns a *TimelineError if the period of the messages requires too many buckets for the interval.
+func NewTimeline(ctx context.Context, elasticsearchQuery map[string]interface{}, timelineRequest TimelineRequest) (Timeline, error) {
+	timeline := Timeline{
+		Interval: timelineRequest.Interval,
+		Timezone: timelineRequest.Timezone,
+		Buckets:  []TimelineBucket{},
+	}
+
+	// Empty buckets are returned between the first and last message, check the amount of buckets first.
+	searchResponse, err := SearchElasticsearch(ctx, map[string]interface{}{
+		"query":            elasticsearchQuery,
+		"size":             0,
+		"track_total_hits": true,
+		"aggregations": map[string]interface{}{
+			"first": map[string]interface{}{"min": map[string]interface{}{"field": MessageFieldDate}},
+			"last":  map[string]interface{}{"max": map[string]interface{}{"field": MessageFieldDate}},
+		},
+	})
+
+	if err != nil {
+		return timeline, err
+	}
+
+	var first elasticsearchStatsAggregation
+	var last elasticsearchStatsAggregation
+
+	if err := json.Unmarshal(searchResponse.Aggregations["first"], &first); err != nil {
+		return timeline, err
+	}
+
+	if err := json.Unmarshal(searchResponse.Aggregations["last"], &last); err != nil {
+		return timeline, err
+	}
+
+	timeline.Total = searchResponse.Hits.Total.Value
+
+	if first.Value == nil || last.Value == nil {
+		return timeline, nil
+	}
+
+	interval := timelineIntervals[timelineRequest.Interval]
+
+	if bucketCount := (int64(*last.Value)-int64(*first.Value))/interval.Seconds + 1; bucketCount > MaxTimelineBuckets {
+		return timeline, &TimelineError{
+			Message: fmt.Sprintf("the messages span %d %ss, use a larger interval or narrow the query (at most %d buckets)", bucketCount, timelineRequest.Interval, MaxTimelineBuckets),
+		}
+	}
+
+	searchResponse, err = SearchElasticsearch(ctx, map[string]interface{}{
+		"query": elasticsearchQuery,
+		"size":  0,
+		"aggregations": map[string]interface{}{
+			"timeline": map[string]interface{}{
+				"date_histogram": map[string]interface{}{
+					"script":            map[string]interface{}{"source": dateMillisecondsScript},
+					"calendar_interval": timelineRequest.Interval,
+					"time_zone":         timelineRequest.Timezone,
+					"format":            interval.Format,
+					"min_doc_count":     0,
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return timeline, err
+	}
+
+	var timelineAggregation elasticsearchBucketAggregation
+
+	if err := json.Unmarshal(searchResponse.Aggregations["timeline"], &timelineAggregation); err != nil {
+		return timeline, err
+	}
+
+	for _, bucket := range timelineAggregation.Buckets {
+		start, err := bucket.Key.Int64()
+
+		if err != nil {
+			return timeline, err
+		}
+
+		timeline.Buckets = append(timeline.Buckets, TimelineBucket{
+			Date:  bucket.KeyAsString,
+			Start: start / 1000,
+			Count: bucket.DocCount,
+		})
+	}
+
+	return timeline, nil
+}
+
+// NewHeatmap returns the message counts by day of the week and hour of the day in the timezone.
+func NewHeatmap(ctx context.Context, elasticsearchQuery map[string]interface{}, timelineRequest TimelineRequest) (Heatmap, error) {
+	heatmap := Heatmap{
+		Timezone:           timelineRequest.Timezone,
+		Days:               HeatmapDays,
+		BusinessHoursStart: timelineRequest.BusinessHoursStart,
+		BusinessHoursEnd:   timelineRequest.BusinessHoursEnd,
+	}
+
+	searchResponse, err := SearchElasticsearch(ctx, map[string]interface{}{
+		"query": elasticsearchQuery,
+		"size":  0,
+		"aggregations": map[string]interface{}{
+			"heatmap": map[string]interface{}{
+				"terms": map[string]interface{}{
+					"script": map[string]interface{}{
+						"source": heatmapScript,
+						"params": map[string]interface{}{"timezone": timelineRequest.Timezone},
+					},
+					"size": 7 * 24,
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return heatmap, err
+	}
+
+	var heatmapAggregation elasticsearchBucketAggregation
+
+	if err := json.Unmarshal(searchResponse.Aggregations["heatmap"], &heatmapAggregation); err != nil {
+		return heatmap, err
+	}
+
+	for _, bucket := range heatmapAggregation.Buckets {
+		key, err := bucket.Key.Int64()
+
+		if err != nil {
+			return heatmap, err
+		}
+
+		day := int(key/100) - 1
+		hour := int(key % 100)
+
+		if day < 0 || day > 6 || hour < 0 || hour > 23 {
+			return heatmap, fmt.Errorf("invalid heatmap bucket: %d", key)
+		}
+
+		heatmap.Counts[day][hour] = bucket.DocCount
+		heatmap.Total += bucket.DocCount
+
+		switch {
+		case day >= 5:
+			heatmap.WeekendCount += bucket.DocCount
+		case hour < timelineRequest.BusinessHoursStart || hour >= timelineRequest.BusinessHoursEnd:
+			heatmap.AfterHoursCount += bucket.DocCount
+		}
+	}
+
+	return heatmap, nil
+}
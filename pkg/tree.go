@@ -4,12 +4,48 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
 	core "github.com/mooijtech/goforensics-core/pkg"
 	"net/http"
+	"sort"
+	"strings"
 )
 
+// Constants defining the synthetic tree group nodes.
+// Group nodes are prefixed so they can be passed to the SearchTypeTree search like any other tree node.
+const (
+	TreeNodeTypeCustodian    = "CUSTODIAN"
+	TreeNodeTypeEvidence     = "EVIDENCE"
+	CustodianTreeNodePrefix  = "custodian:"
+	EvidenceTreeNodePrefix   = "evidence:"
+	UnassignedCustodianLabel = "Unassigned"
+)
+
+// CustodianTreeNode represents the synthetic tree node grouping the evidence items of a custodian.
+type CustodianTreeNode struct {
+	Value    string             `json:"value"`
+	Label    string             `json:"label"`
+	Type     string             `json:"type"`
+	Children []EvidenceTreeNode `json:"children"`
+}
+
+// EvidenceTreeNode represents the synthetic tree node grouping the folders of an evidence item.
+type EvidenceTreeNode struct {
+	Value     string             `json:"value"`
+	Label     string             `json:"label"`
+	Type      string             `json:"type"`
+	FileName  string             `json:"fileName"`
+	FileHash  string             `json:"fileHash"`
+	ParseDate int                `json:"parseDate"`
+	Children  []core.TreeNodeDTO `json:"children"`
+}
+
 // handleTree handles the tree endpoint.
+// The folder trees are grouped by custodian and evidence item.
 func (server *Server) handleTree() http.HandlerFunc {
 	return func(responseWriter http.ResponseWriter, request *http.Request) {
 		if request.Method == "GET" {
@@ -35,14 +71,29 @@ func (server *Server) handleTree() http.HandlerFunc {
 				return
 			}
 
-			var treeNodeDTOs []core.TreeNodeDTO
+			var rootTreeNodeUUIDs []string
+
+			for _, rootTreeNode := range rootTreeNodes {
+				rootTreeNodeUUIDs = append(rootTreeNodeUUIDs, rootTreeNode.FolderUUID)
+			}
+
+			rootTreeNodeEvidenceUUIDs, err := GetTreeNodeEvidenceUUIDs(rootTreeNodeUUIDs, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get tree node evidence UUIDs: %s", err)
+				http.Error(responseWriter, "Failed to get tree node evidence UUIDs.", http.StatusInternalServerError)
+				return
+			}
+
+			// Walk the folder trees of each evidence item.
+			evidenceTreeNodeDTOs := make(map[string][]core.TreeNodeDTO)
 
-			for i, treeNodeRoot := range rootTreeNodes {
-				treeNodeDTOs = append(treeNodeDTOs, core.TreeNodeDTO{
+			for _, treeNodeRoot := range rootTreeNodes {
+				treeNodeDTO := core.TreeNodeDTO{
 					Value:    treeNodeRoot.FolderUUID,
 					Label:    treeNodeRoot.Title,
 					Children: []core.TreeNodeDTO{},
-				})
+				}
 
 				treeNodes, err := core.WalkTreeNodeChildren(treeNodeRoot.FolderUUID, project.UUID, server.Database)
 
@@ -52,12 +103,240 @@ func (server *Server) handleTree() http.HandlerFunc {
 					return
 				}
 
-				for _, treeNode := range treeNodes {
-					treeNodeDTOs[i].Children = append(treeNodeDTOs[i].Children, treeNode)
+				treeNodeDTO.Children = append(treeNodeDTO.Children, treeNodes...)
+
+				evidenceUUID := rootTreeNodeEvidenceUUIDs[treeNodeRoot.FolderUUID]
+				evidenceTreeNodeDTOs[evidenceUUID] = append(evidenceTreeNodeDTOs[evidenceUUID], treeNodeDTO)
+			}
+
+			evidenceItems, err := GetEvidenceItemsByProject(project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get evidence items by project: %s", err)
+				http.Error(responseWriter, "Failed to get evidence items by project.", http.StatusInternalServerError)
+				return
+			}
+
+			// Group the evidence items by custodian.
+			var custodianTreeNodes []CustodianTreeNode
+
+			custodianIndexes := make(map[string]int)
+
+			addEvidenceTreeNode := func(custodian string, evidenceTreeNode EvidenceTreeNode) {
+				custodianIndex, ok := custodianIndexes[custodian]
+
+				if !ok {
+					custodianLabel := custodian
+
+					if custodianLabel == "" {
+						custodianLabel = UnassignedCustodianLabel
+					}
+
+					custodianTreeNodes = append(custodianTreeNodes, CustodianTreeNode{
+						Value:    CustodianTreeNodePrefix + custodian,
+						Label:    custodianLabel,
+						Type:     TreeNodeTypeCustodian,
+						Children: []EvidenceTreeNode{},
+					})
+
+					custodianIndex = len(custodianTreeNodes) - 1
+					custodianIndexes[custodian] = custodianIndex
+				}
+
+				custodianTreeNodes[custodianIndex].Children = append(custodianTreeNodes[custodianIndex].Children, evidenceTreeNode)
+			}
+
+			for _, evidenceItem := range evidenceItems {
+				treeNodeDTOs, ok := evidenceTreeNodeDTOs[evidenceItem.UUID]
+
+				if !ok {
+					// The evidence item has no folders (yet).
+					treeNodeDTOs = []core.TreeNodeDTO{}
+				}
+
+				delete(evidenceTreeNodeDTOs, evidenceItem.UUID)
+
+				addEvidenceTreeNode(evidenceItem.Custodian, EvidenceTreeNode{
+					Value:     EvidenceTreeNodePrefix + evidenceItem.UUID,
+					Label:     evidenceItem.FileName,
+					Type:      TreeNodeTypeEvidence,
+					FileName:  evidenceItem.FileName,
+					FileHash:  evidenceItem.FileHash,
+					ParseDate: evidenceItem.ParseDate,
+					Children:  treeNodeDTOs,
+				})
+			}
+
+			// Folders which could not be matched to an evidence item of this project are still returned.
+			for evidenceUUID, treeNodeDTOs := range evidenceTreeNodeDTOs {
+				addEvidenceTreeNode("", EvidenceTreeNode{
+					Value:    EvidenceTreeNodePrefix + evidenceUUID,
+					Label:    evidenceUUID,
+					Type:     TreeNodeTypeEvidence,
+					Children: treeNodeDTOs,
+				})
+			}
+
+			sort.SliceStable(custodianTreeNodes, func(i, j int) bool {
+				return custodianTreeNodes[i].Label < custodianTreeNodes[j].Label
+			})
+
+			if err := json.NewEncoder(responseWriter).Encode(&custodianTreeNodes); err != nil {
+				Logger.Errorf("Failed to encode response: %s", err)
+				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+// GetTreeNodeEvidenceUUIDs returns the evidence UUID of each of the specified tree nodes.
+func GetTreeNodeEvidenceUUIDs(folderUUIDs []string, projectUUID string, database *pgx.Conn) (map[string]string, error) {
+	rows, err := database.Query(context.Background(), "SELECT folder_uuid, evidence_uuid FROM tree_nodes WHERE folder_uuid = ANY($1) AND project_uuid = $2", folderUUIDs, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	evidenceUUIDs := make(map[string]string)
+
+	for rows.Next() {
+		var folderUUID string
+		var evidenceUUID string
+
+		if err := rows.Scan(&folderUUID, &evidenceUUID); err != nil {
+			return nil, err
+		}
+
+		evidenceUUIDs[folderUUID] = evidenceUUID
+	}
+
+	return evidenceUUIDs, rows.Err()
+}
+
+// IsTreeGroupNode returns true if the tree node UUID refers to a synthetic custodian or evidence tree node.
+func IsTreeGroupNode(treeNodeUUID string) bool {
+	return strings.HasPrefix(treeNodeUUID, CustodianTreeNodePrefix) || strings.HasPrefix(treeNodeUUID, EvidenceTreeNodePrefix)
+}
+
+// GetTreeGroupNodeRootUUIDs returns the root folder UUIDs belonging to the synthetic custodian or evidence tree node.
+func GetTreeGroupNodeRootUUIDs(treeNodeUUID string, projectUUID string, database *pgx.Conn) ([]string, error) {
+	evidenceUUIDs := make(map[string]bool)
+
+	if strings.HasPrefix(treeNodeUUID, EvidenceTreeNodePrefix) {
+		evidenceUUIDs[strings.TrimPrefix(treeNodeUUID, EvidenceTreeNodePrefix)] = true
+	} else if strings.HasPrefix(treeNodeUUID, CustodianTreeNodePrefix) {
+		custodian := strings.TrimPrefix(treeNodeUUID, CustodianTreeNodePrefix)
+
+		evidenceItems, err := GetEvidenceItemsByProject(projectUUID, database)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, evidenceItem := range evidenceItems {
+			if evidenceItem.Custodian == custodian {
+				evidenceUUIDs[evidenceItem.UUID] = true
+			}
+		}
+	} else {
+		return nil, fmt.Errorf("tree node is not a group node: %s", treeNodeUUID)
+	}
+
+	rootTreeNodes, err := core.GetRootTreeNodes(projectUUID, database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rootTreeNodeUUIDs []string
+
+	for _, rootTreeNode := range rootTreeNodes {
+		rootTreeNodeUUIDs = append(rootTreeNodeUUIDs, rootTreeNode.FolderUUID)
+	}
+
+	rootTreeNodeEvidenceUUIDs, err := GetTreeNodeEvidenceUUIDs(rootTreeNodeUUIDs, projectUUID, database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var groupRootTreeNodeUUIDs []string
+
+	for _, rootTreeNodeUUID := range rootTreeNodeUUIDs {
+		if evidenceUUIDs[rootTreeNodeEvidenceUUIDs[rootTreeNodeUUID]] {
+			groupRootTreeNodeUUIDs = append(groupRootTreeNodeUUIDs, rootTreeNodeUUID)
+		}
+	}
+
+	return groupRootTreeNodeUUIDs, nil
+}
+
+// RootTreeNodeUUID is the node UUID used to request the root tree nodes from the tree children endpoint.
+const RootTreeNodeUUID = "root"
+
+// TreeNode represents a single tree node (folder) returned by the lazy-loading tree endpoint.
+type TreeNode struct {
+	FolderUUID             string `json:"folderUUID"`
+	Title                  string `json:"title"`
+	EvidenceUUID           string `json:"evidenceUUID"`
+	EvidenceFileName       string `json:"evidenceFileName"`
+	HasChildren            bool   `json:"hasChildren"`
+	MessageCount           int    `json:"messageCount"`
+	DescendantMessageCount int    `json:"descendantMessageCount"`
+	AttachmentCount        int    `json:"attachmentCount"`
+}
+
+// handleTreeNodeChildren handles the tree node children endpoint.
+// Returns one level of the tree at a time so large folder hierarchies can be loaded lazily.
+func (server *Server) handleTreeNodeChildren() http.HandlerFunc {
+	return func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			_, project, err := server.AuthenticateRequest(request)
+
+			if err != nil {
+				Logger.Errorf("Failed to authenticate request: %s", err)
+				http.Error(responseWriter, "Failed to authenticate request.", http.StatusUnauthorized)
+				return
+			}
+
+			nodeUUID := mux.Vars(request)["nodeUUID"]
+
+			var folderUUIDs []string
+
+			if nodeUUID == RootTreeNodeUUID {
+				rootTreeNodes, err := core.GetRootTreeNodes(project.UUID, server.Database)
+
+				if err != nil {
+					Logger.Errorf("Failed to get root tree nodes by project UUID: %s", err)
+					http.Error(responseWriter, "Failed to get root tree nodes by project UUID.", http.StatusInternalServerError)
+					return
+				}
+
+				for _, rootTreeNode := range rootTreeNodes {
+					folderUUIDs = append(folderUUIDs, rootTreeNode.FolderUUID)
+				}
+			} else {
+				folderUUIDs, err = GetTreeNodeChildrenUUIDs(nodeUUID, project.UUID, server.Database)
+
+				if err != nil {
+					Logger.Errorf("Failed to get tree node children UUIDs: %s", err)
+					http.Error(responseWriter, "Failed to get tree node children UUIDs.", http.StatusInternalServerError)
+					return
 				}
 			}
 
-			if err := json.NewEncoder(responseWriter).Encode(&treeNodeDTOs); err != nil {
+			treeNodes, err := GetTreeNodesByUUIDs(folderUUIDs, project.UUID, server.Database)
+
+			if err != nil {
+				Logger.Errorf("Failed to get tree nodes: %s", err)
+				http.Error(responseWriter, "Failed to get tree nodes.", http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(responseWriter).Encode(&treeNodes); err != nil {
 				Logger.Errorf("Failed to encode response: %s", err)
 				http.Error(responseWriter, "Failed to encode response.", http.StatusInternalServerError)
 				return
@@ -65,3 +344,148 @@ func (server *Server) handleTree() http.HandlerFunc {
 		}
 	}
 }
+
+// GetTreeNodeChildrenUUIDs returns the folder UUIDs of the direct children of the specified tree node.
+func GetTreeNodeChildrenUUIDs(folderUUID string, projectUUID string, database *pgx.Conn) ([]string, error) {
+	rows, err := database.Query(context.Background(), "SELECT folder_uuid FROM tree_nodes WHERE parent_folder_uuid = $1 AND project_uuid = $2", folderUUID, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var folderUUIDs []string
+
+	for rows.Next() {
+		var childFolderUUID string
+
+		if err := rows.Scan(&childFolderUUID); err != nil {
+			return nil, err
+		}
+
+		folderUUIDs = append(folderUUIDs, childFolderUUID)
+	}
+
+	return folderUUIDs, rows.Err()
+}
+
+// GetTreeNodesByUUIDs returns the tree nodes with their message and attachment counts.
+// The descendant message count includes the messages of the tree node itself.
+func GetTreeNodesByUUIDs(folderUUIDs []string, projectUUID string, database *pgx.Conn) ([]TreeNode, error) {
+	treeNodes := []TreeNode{}
+
+	if len(folderUUIDs) == 0 {
+		return treeNodes, nil
+	}
+
+	rows, err := database.Query(context.Background(), `
+		WITH RECURSIVE descendants AS (
+			SELECT folder_uuid AS root_folder_uuid, folder_uuid FROM tree_nodes
+			WHERE folder_uuid = ANY($1) AND project_uuid = $2
+			UNION ALL
+			SELECT descendants.root_folder_uuid, tree_nodes.folder_uuid FROM tree_nodes
+			JOIN descendants ON tree_nodes.parent_folder_uuid = descendants.folder_uuid
+			WHERE tree_nodes.project_uuid = $2
+		)
+		SELECT
+			tree_nodes.folder_uuid,
+			tree_nodes.title,
+			tree_nodes.evidence_uuid,
+			COALESCE(evidence.file_name, ''),
+			EXISTS (SELECT 1 FROM tree_nodes AS children WHERE children.parent_folder_uuid = tree_nodes.folder_uuid AND children.project_uuid = tree_nodes.project_uuid),
+			(SELECT COUNT(*) FROM messages WHERE messages.folder_uuid = tree_nodes.folder_uuid),
+			(SELECT COUNT(*) FROM messages JOIN descendants ON messages.folder_uuid = descendants.folder_uuid WHERE descendants.root_folder_uuid = tree_nodes.folder_uuid),
+			(SELECT COUNT(*) FROM attachments JOIN messages ON attachments.message_uuid = messages.uuid WHERE messages.folder_uuid = tree_nodes.folder_uuid)
+		FROM tree_nodes
+		LEFT JOIN evidence ON evidence.uuid = tree_nodes.evidence_uuid
+		WHERE tree_nodes.folder_uuid = ANY($1) AND tree_nodes.project_uuid = $2
+		ORDER BY tree_nodes.title`, folderUUIDs, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var treeNode TreeNode
+
+		if err := rows.Scan(
+			&treeNode.FolderUUID,
+			&treeNode.Title,
+			&treeNode.EvidenceUUID,
+			&treeNode.EvidenceFileName,
+			&treeNode.HasChildren,
+			&treeNode.MessageCount,
+			&treeNode.DescendantMessageCount,
+			&treeNode.AttachmentCount,
+		); err != nil {
+			return nil, err
+		}
+
+		treeNodes = append(treeNodes, treeNode)
+	}
+
+	return treeNodes, rows.Err()
+}
+
+// GetTreeNodeTitles returns the title of each of the specified tree nodes.
+func GetTreeNodeTitles(folderUUIDs []string, projectUUID string, database *pgx.Conn) (map[string]string, error) {
+	rows, err := database.Query(context.Background(), "SELECT folder_uuid, title FROM tree_nodes WHERE folder_uuid = ANY($1) AND project_uuid = $2", folderUUIDs, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	titles := make(map[string]string)
+
+	for rows.Next() {
+		var folderUUID string
+		var title string
+
+		if err := rows.Scan(&folderUUID, &title); err != nil {
+			return nil, err
+		}
+
+		titles[folderUUID] = title
+	}
+
+	return titles, rows.Err()
+}
+
+// GetTreeNodePath returns the titles of the tree node and its ancestors, from the root folder to the tree node.
+func GetTreeNodePath(folderUUID string, projectUUID string, database *pgx.Conn) ([]string, error) {
+	rows, err := database.Query(context.Background(), `
+		WITH RECURSIVE ancestors AS (
+			SELECT folder_uuid, parent_folder_uuid, title, 0 AS depth FROM tree_nodes
+			WHERE folder_uuid = $1 AND project_uuid = $2
+			UNION ALL
+			SELECT tree_nodes.folder_uuid, tree_nodes.parent_folder_uuid, tree_nodes.title, ancestors.depth + 1 FROM tree_nodes
+			JOIN ancestors ON tree_nodes.folder_uuid = ancestors.parent_folder_uuid
+			WHERE tree_nodes.project_uuid = $2 AND ancestors.depth < 256
+		)
+		SELECT title FROM ancestors ORDER BY depth DESC`, folderUUID, projectUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var titles []string
+
+	for rows.Next() {
+		var title string
+
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+
+		titles = append(titles, title)
+	}
+
+	return titles, rows.Err()
+}
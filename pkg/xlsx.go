@@ -0,0 +1,174 @@
+// Package api
+// This file is part of Go Forensics (https://www.goforensics.io/)
+// Copyright (C) 2022 Marten Mooij (https://www.mooijtech.com/)
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Constants defining the limits of Excel.
+const (
+	XLSXMaxCellLength      = 32767
+	XLSXMaxSheetNameLength = 31
+)
+
+// xlsxSheetNameReplacer replaces the characters which are not allowed in sheet names.
+var xlsxSheetNameReplacer = strings.NewReplacer("[", "(", "]", ")", ":", "-", "*", "-", "?", "-", "/", "-", "\\", "-")
+
+// xlsxStaticFiles defines the parts of the workbook which don't depend on the sheet.
+// Style 1 is the bold header, style 2 is wrapped text aligned to the top.
+var xlsxStaticFiles = []struct {
+	Name    string
+	Content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0" applyAlignment="1"><alignment vertical="top" wrapText="1"/></xf></cellXfs></styleSheet>`},
+}
+
+// WriteXLSX writes the rows as an Excel workbook with a single sheet.
+// The first row is the header, which is bold and frozen. All cells are written as text.
+// The column widths are in characters, columns without a width use the default width.
+func WriteXLSX(writer io.Writer, sheetName string, rows [][]string, columnWidths []float64) error {
+	zipWriter := zip.NewWriter(writer)
+
+	for _, staticFile := range xlsxStaticFiles {
+		if err := writeXLSXFile(zipWriter, staticFile.Name, []byte(staticFile.Content)); err != nil {
+			return err
+		}
+	}
+
+	var workbook bytes.Buffer
+
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+	writeXLSXText(&workbook, GetXLSXSheetName(sheetName))
+	workbook.WriteString(`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+
+	if err := writeXLSXFile(zipWriter, "xl/workbook.xml", workbook.Bytes()); err != nil {
+		return err
+	}
+
+	var sheet bytes.Buffer
+
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+
+	if len(columnWidths) > 0 {
+		sheet.WriteString("<cols>")
+
+		for i, columnWidth := range columnWidths {
+			if columnWidth > 0 {
+				sheet.WriteString(fmt.Sprintf(`<col min="%d" max="%d" width="%.2f" customWidth="1"/>`, i+1, i+1, columnWidth))
+			}
+		}
+
+		sheet.WriteString("</cols>")
+	}
+
+	sheet.WriteString("<sheetData>")
+
+	for i, row := range rows {
+		sheet.WriteString(fmt.Sprintf(`<row r="%d">`, i+1))
+
+		style := 2
+
+		if i == 0 {
+			style = 1
+		}
+
+		for j, cell := range row {
+			if cell == "" {
+				continue
+			}
+
+			sheet.WriteString(fmt.Sprintf(`<c r="%s%d" s="%d" t="inlineStr"><is><t xml:space="preserve">`, GetXLSXColumnName(j), i+1, style))
+			writeXLSXText(&sheet, truncateXLSXCell(cell))
+			sheet.WriteString("</t></is></c>")
+		}
+
+		sheet.WriteString("</row>")
+	}
+
+	sheet.WriteString("</sheetData></worksheet>")
+
+	if err := writeXLSXFile(zipWriter, "xl/worksheets/sheet1.xml", sheet.Bytes()); err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// writeXLSXFile writes the file to the workbook.
+func writeXLSXFile(zipWriter *zip.Writer, name string, content []byte) error {
+	fileWriter, err := zipWriter.Create(name)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fileWriter.Write(content)
+
+	return err
+}
+
+// writeXLSXText writes the escaped text, characters which are not allowed in XML are replaced.
+func writeXLSXText(buffer *bytes.Buffer, text string) {
+	// EscapeText only fails if the writer fails, which a buffer doesn't.
+	_ = xml.EscapeText(buffer, []byte(text))
+}
+
+// truncateXLSXCell truncates the text to the maximum length of a cell.
+func truncateXLSXCell(text string) string {
+	if len(text) <= XLSXMaxCellLength {
+		return text
+	}
+
+	// Excel counts UTF-16 code units, counting bytes stays within the limit.
+	end := XLSXMaxCellLength
+
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+
+	return text[:end]
+}
+
+// GetXLSXColumnName returns the name of the column (0 is "A", 26 is "AA").
+func GetXLSXColumnName(column int) string {
+	name := ""
+
+	for column >= 0 {
+		name = string(rune('A'+column%26)) + name
+		column = column/26 - 1
+	}
+
+	return name
+}
+
+// GetXLSXSheetName returns the sheet name without the characters Excel doesn't allow, truncated to the maximum length.
+func GetXLSXSheetName(sheetName string) string {
+	sheetName = strings.Trim(xlsxSheetNameReplacer.Replace(sheetName), "'")
+
+	if sheetName == "" {
+		return "Sheet1"
+	}
+
+	if utf8.RuneCountInString(sheetName) > XLSXMaxSheetNameLength {
+		sheetName = string([]rune(sheetName)[:XLSXMaxSheetNameLength])
+	}
+
+	return sheetName
+}